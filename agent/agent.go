@@ -0,0 +1,489 @@
+// Package agent is a small read-only SNMP v1/v2c agent serving a table of
+// variables that is replaced as a whole on each update
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	rlog "tsm/log"
+
+	g "github.com/gosnmp/gosnmp"
+)
+
+// system group OIDs
+const (
+	SysDescr    = "1.3.6.1.2.1.1.1.0"
+	SysObjectID = "1.3.6.1.2.1.1.2.0"
+	SysUpTime   = "1.3.6.1.2.1.1.3.0"
+	SysName     = "1.3.6.1.2.1.1.5.0"
+)
+
+const (
+	// maxBulkVarbinds caps the size of a GetBulk response
+	maxBulkVarbinds = 100
+	// maxMsgSize is the largest response sent, larger ones return tooBig
+	maxMsgSize = 65000
+)
+
+// Variable is one OID served by the agent
+type Variable struct {
+	Oid   string
+	Type  g.Asn1BER
+	Value interface{}
+}
+
+type entry struct {
+	key []uint32
+	v   Variable
+}
+
+// Agent answers Get, GetNext and GetBulk requests from its variables
+type Agent struct {
+	listen    string
+	community string
+
+	// MaxAge withdraws the updated variables if Update is not called again
+	// within it, leaving only the system group, 0 for no limit
+	MaxAge time.Duration
+
+	mutex    sync.RWMutex
+	system   []entry
+	all      []entry
+	updated  time.Time
+	started  time.Time
+	dropRate float64
+	delay    time.Duration
+	rng      *rand.Rand
+
+	conn net.PacketConn
+	wg   sync.WaitGroup
+}
+
+// NewAgent constructor
+func NewAgent(listen, community string) *Agent {
+	return &Agent{
+		listen:    listen,
+		community: community,
+		started:   time.Now(),
+		rng:       rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// SetImpairment makes the agent ignore a fraction dropRate of requests and
+// answer the rest after delay, to simulate a poor network or busy device
+func (ag *Agent) SetImpairment(dropRate float64, delay time.Duration) {
+
+	ag.mutex.Lock()
+	defer ag.mutex.Unlock()
+
+	ag.dropRate = dropRate
+	ag.delay = delay
+}
+
+// impaired reports whether to drop a request, and how long to delay it if not
+func (ag *Agent) impaired() (bool, time.Duration) {
+
+	ag.mutex.Lock()
+	defer ag.mutex.Unlock()
+
+	if ag.dropRate > 0 && ag.rng.Float64() < ag.dropRate {
+		return true, 0
+	}
+	return false, ag.delay
+}
+
+// SetSystem sets the system group values, which are always served. The
+// variables from the last Update are kept.
+func (ag *Agent) SetSystem(descr, objectID, name string) {
+
+	vars := []Variable{
+		{Oid: SysDescr, Type: g.OctetString, Value: descr},
+		{Oid: SysObjectID, Type: g.ObjectIdentifier, Value: "." + strings.Trim(objectID, ".")},
+		{Oid: SysUpTime, Type: g.TimeTicks, Value: uint32(0)},
+		{Oid: SysName, Type: g.OctetString, Value: name},
+	}
+
+	ag.mutex.Lock()
+	defer ag.mutex.Unlock()
+
+	merged := vars
+	for _, e := range ag.all {
+		switch e.v.Oid {
+		case SysDescr, SysObjectID, SysUpTime, SysName:
+		default:
+			merged = append(merged, e.v)
+		}
+	}
+	ag.system = sortEntries(vars)
+	ag.all = sortEntries(merged)
+}
+
+// setAccess replaces the community and MaxAge of a running agent
+func (ag *Agent) setAccess(community string, maxAge time.Duration) {
+
+	ag.mutex.Lock()
+	defer ag.mutex.Unlock()
+
+	ag.community = community
+	ag.MaxAge = maxAge
+}
+
+// Update replaces the served variables, the system group is kept
+func (ag *Agent) Update(vars []Variable) {
+
+	ag.mutex.RLock()
+	system := ag.system
+	ag.mutex.RUnlock()
+
+	merged := make([]Variable, 0, len(system)+len(vars))
+	for _, e := range system {
+		merged = append(merged, e.v)
+	}
+	merged = append(merged, vars...)
+	all := sortEntries(merged)
+
+	ag.mutex.Lock()
+	defer ag.mutex.Unlock()
+
+	ag.all = all
+	ag.updated = time.Now()
+}
+
+// view returns the variables currently served
+func (ag *Agent) view() []entry {
+
+	ag.mutex.RLock()
+	defer ag.mutex.RUnlock()
+
+	if ag.MaxAge > 0 && time.Since(ag.updated) > ag.MaxAge {
+		return ag.system
+	}
+	return ag.all
+}
+
+// Start listens on the agent address and serves requests until Close
+func (ag *Agent) Start() error {
+
+	conn, err := net.ListenPacket("udp", ag.listen)
+	if err != nil {
+		return fmt.Errorf("agent listen on %s: %w", ag.listen, err)
+	}
+	ag.conn = conn
+	rlog.NoticeMsg("snmp agent listening on %s", conn.LocalAddr().String())
+
+	ag.wg.Add(1)
+	go ag.serve()
+
+	return nil
+}
+
+// Addr returns the address the agent is listening on
+func (ag *Agent) Addr() net.Addr {
+	if ag.conn == nil {
+		return nil
+	}
+	return ag.conn.LocalAddr()
+}
+
+// Close stops the agent
+func (ag *Agent) Close() error {
+
+	if ag.conn == nil {
+		return nil
+	}
+	err := ag.conn.Close()
+	ag.wg.Wait()
+	ag.conn = nil
+
+	return err
+}
+
+func (ag *Agent) serve() {
+
+	defer ag.wg.Done()
+
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := ag.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			rlog.WarningMsg("agent read: %s", err.Error())
+			continue
+		}
+
+		drop, delay := ag.impaired()
+		if drop {
+			rlog.DebugMsg("agent request from %s dropped", addr.String())
+			continue
+		}
+
+		resp, err := ag.handle(buf[:n], addr)
+		if err != nil {
+			rlog.DebugMsg("agent request from %s: %s", addr.String(), err.Error())
+			continue
+		}
+		if delay > 0 {
+			ag.wg.Add(1)
+			time.AfterFunc(delay, func() {
+				defer ag.wg.Done()
+				ag.reply(resp, addr)
+			})
+			continue
+		}
+		ag.reply(resp, addr)
+	}
+}
+
+func (ag *Agent) reply(resp []byte, addr net.Addr) {
+	if _, err := ag.conn.WriteTo(resp, addr); err != nil && !errors.Is(err, net.ErrClosed) {
+		rlog.WarningMsg("agent reply to %s: %s", addr.String(), err.Error())
+	}
+}
+
+// handle decodes a request and returns the encoded response
+func (ag *Agent) handle(msg []byte, addr net.Addr) ([]byte, error) {
+
+	decoder := &g.GoSNMP{Version: g.Version2c}
+	req, err := decoder.SnmpDecodePacket(msg)
+	if err != nil {
+		return nil, err
+	}
+	if req.Version == g.Version3 {
+		return nil, errors.New("SNMPv3 is not supported")
+	}
+	ag.mutex.RLock()
+	community := ag.community
+	ag.mutex.RUnlock()
+	if community != "" && req.Community != community {
+		rlog.Keyed("agent-community").WarningMsg("agent request from %s ignored, wrong community %q", addr.String(), req.Community)
+		return nil, errors.New("wrong community")
+	}
+
+	resp := &g.SnmpPacket{
+		Version:   req.Version,
+		Community: req.Community,
+		PDUType:   g.GetResponse,
+		RequestID: req.RequestID,
+	}
+
+	view := ag.view()
+	switch req.PDUType {
+	case g.GetRequest:
+		ag.get(view, req, resp)
+	case g.GetNextRequest:
+		ag.getNext(view, req, resp)
+	case g.GetBulkRequest:
+		if req.Version == g.Version1 {
+			return nil, errors.New("GetBulk in an SNMPv1 request")
+		}
+		ag.getBulk(view, req, resp)
+	case g.SetRequest:
+		resp.Error = g.NotWritable
+		if req.Version == g.Version1 {
+			resp.Error = g.NoSuchName
+		}
+		resp.ErrorIndex = 1
+		resp.Variables = req.Variables
+	default:
+		return nil, fmt.Errorf("unexpected PDU type %v", req.PDUType)
+	}
+
+	out, err := resp.MarshalMsg()
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > maxMsgSize {
+		resp.Error = g.TooBig
+		resp.ErrorIndex = 0
+		resp.Variables = nil
+		return resp.MarshalMsg()
+	}
+
+	return out, nil
+}
+
+// fail sets a v1 error response, which returns the request variables
+func fail(req, resp *g.SnmpPacket, status g.SNMPError, ndx int) {
+	resp.Error = status
+	resp.ErrorIndex = uint8(ndx + 1)
+	resp.Variables = req.Variables
+}
+
+func (ag *Agent) get(view []entry, req, resp *g.SnmpPacket) {
+
+	for ndx, pdu := range req.Variables {
+		key, err := parseOid(pdu.Name)
+		var found *entry
+		if err == nil {
+			found = exact(view, key)
+		}
+		if found == nil {
+			if req.Version == g.Version1 {
+				fail(req, resp, g.NoSuchName, ndx)
+				return
+			}
+			resp.Variables = append(resp.Variables, g.SnmpPDU{Name: pdu.Name, Type: g.NoSuchObject})
+			continue
+		}
+		resp.Variables = append(resp.Variables, ag.pdu(found))
+	}
+}
+
+func (ag *Agent) getNext(view []entry, req, resp *g.SnmpPacket) {
+
+	for ndx, pdu := range req.Variables {
+		key, err := parseOid(pdu.Name)
+		var found *entry
+		if err == nil {
+			found = next(view, key)
+		}
+		if found == nil {
+			if req.Version == g.Version1 {
+				fail(req, resp, g.NoSuchName, ndx)
+				return
+			}
+			resp.Variables = append(resp.Variables, g.SnmpPDU{Name: pdu.Name, Type: g.EndOfMibView})
+			continue
+		}
+		resp.Variables = append(resp.Variables, ag.pdu(found))
+	}
+}
+
+func (ag *Agent) getBulk(view []entry, req, resp *g.SnmpPacket) {
+
+	nonRepeaters := int(req.NonRepeaters)
+	if nonRepeaters > len(req.Variables) {
+		nonRepeaters = len(req.Variables)
+	}
+
+	// the non-repeaters are a GetNext of each
+	first := *req
+	first.Variables = req.Variables[:nonRepeaters]
+	ag.getNext(view, &first, resp)
+
+	repeaters := req.Variables[nonRepeaters:]
+	cursors := make([][]uint32, len(repeaters))
+	for ndx, pdu := range repeaters {
+		cursors[ndx], _ = parseOid(pdu.Name)
+	}
+
+	for rep := 0; rep < int(req.MaxRepetitions) && len(repeaters) > 0; rep++ {
+		ended := 0
+		for ndx, pdu := range repeaters {
+			if len(resp.Variables) >= maxBulkVarbinds {
+				return
+			}
+			var found *entry
+			if cursors[ndx] != nil {
+				found = next(view, cursors[ndx])
+			}
+			if found == nil {
+				resp.Variables = append(resp.Variables, g.SnmpPDU{Name: pdu.Name, Type: g.EndOfMibView})
+				cursors[ndx] = nil
+				ended++
+				continue
+			}
+			resp.Variables = append(resp.Variables, ag.pdu(found))
+			cursors[ndx] = found.key
+		}
+		if ended == len(repeaters) {
+			return
+		}
+	}
+}
+
+// pdu converts an entry to a response varbind, filling in the uptime
+func (ag *Agent) pdu(e *entry) g.SnmpPDU {
+
+	v := e.v
+	if v.Oid == SysUpTime {
+		v.Value = uint32(time.Since(ag.started) / (10 * time.Millisecond))
+	}
+	return g.SnmpPDU{Name: "." + v.Oid, Type: v.Type, Value: v.Value}
+}
+
+// exact returns the entry for key, or nil
+func exact(view []entry, key []uint32) *entry {
+	ndx := sort.Search(len(view), func(i int) bool { return compareOids(view[i].key, key) >= 0 })
+	if ndx < len(view) && compareOids(view[ndx].key, key) == 0 {
+		return &view[ndx]
+	}
+	return nil
+}
+
+// next returns the first entry after key in lexicographic order, or nil
+func next(view []entry, key []uint32) *entry {
+	ndx := sort.Search(len(view), func(i int) bool { return compareOids(view[i].key, key) > 0 })
+	if ndx < len(view) {
+		return &view[ndx]
+	}
+	return nil
+}
+
+// sortEntries parses and orders vars by OID, dropping unparsable or duplicate OIDs
+func sortEntries(vars []Variable) []entry {
+
+	entries := make([]entry, 0, len(vars))
+	for _, v := range vars {
+		v.Oid = strings.Trim(v.Oid, ".")
+		key, err := parseOid(v.Oid)
+		if err != nil {
+			rlog.WarningMsg("agent: %s", err.Error())
+			continue
+		}
+		entries = append(entries, entry{key: key, v: v})
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return compareOids(entries[i].key, entries[j].key) < 0 })
+
+	deduped := entries[:0]
+	for ndx, e := range entries {
+		if ndx > 0 && compareOids(e.key, deduped[len(deduped)-1].key) == 0 {
+			continue
+		}
+		deduped = append(deduped, e)
+	}
+
+	return deduped
+}
+
+// parseOid converts a dotted OID to its sub-identifiers
+func parseOid(oid string) ([]uint32, error) {
+
+	oid = strings.Trim(oid, ".")
+	if oid == "" {
+		return nil, errors.New("empty oid")
+	}
+	parts := strings.Split(oid, ".")
+	key := make([]uint32, len(parts))
+	for ndx, part := range parts {
+		val, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid oid %q", oid)
+		}
+		key[ndx] = uint32(val)
+	}
+	return key, nil
+}
+
+// compareOids orders OIDs lexicographically by sub-identifier
+func compareOids(a, b []uint32) int {
+	for ndx := 0; ndx < len(a) && ndx < len(b); ndx++ {
+		if a[ndx] != b[ndx] {
+			if a[ndx] < b[ndx] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(a) - len(b)
+}
@@ -0,0 +1,196 @@
+package agent
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"tsm/config"
+
+	g "github.com/gosnmp/gosnmp"
+)
+
+// Exporter serves the latest scan through an Agent so that other managers
+// never query the device directly. The scan is served at the device's own
+// OIDs, and every channel, including the derived ones, is served under the
+// configured enterprise subtree:
+//
+//	<enterprise>.1.1.0        scan time, RFC3339
+//	<enterprise>.1.2.0        scan time, unix seconds
+//	<enterprise>.1.3.0        station, NET.STA.LOC
+//	<enterprise>.2.1.1.<n>    channel index
+//	<enterprise>.2.1.2.<n>    chancode
+//	<enterprise>.2.1.3.<n>    label
+//	<enterprise>.2.1.4.<n>    units
+//	<enterprise>.2.1.5.<n>    value as text
+//	<enterprise>.2.1.6.<n>    scaled value x 1000, integer
+//
+// It is a cmd.DerivedProcessor that contributes no derived channels.
+type Exporter struct {
+	cfg   *config.TSMConfig
+	host  string
+	agent *Agent
+	owner bool // closes the agent, a shared one once it was handed over
+}
+
+// NewExporter constructor, starts the agent. If prev, the exporter being
+// replaced, listens on the same address its agent is shared, serving for
+// prev until Resume hands it over.
+func NewExporter(cfg *config.TSMConfig, host string, prev *Exporter) (*Exporter, error) {
+
+	ex := &Exporter{cfg: cfg, host: host}
+	if prev != nil && prev.agent.listen == cfg.Agent.Listen {
+		ex.agent = prev.agent
+		return ex, nil
+	}
+
+	ex.agent = NewAgent(cfg.Agent.Listen, cfg.Agent.Community)
+	ex.agent.MaxAge = ex.maxAge()
+	ex.setSystem()
+	if err := ex.agent.Start(); err != nil {
+		return nil, err
+	}
+	ex.owner = true
+
+	return ex, nil
+}
+
+// Resume takes over the agent shared with prev, the exporter this replaces,
+// applying the new agent settings to it
+func (ex *Exporter) Resume(prev interface{}) {
+
+	old, ok := prev.(*Exporter)
+	if !ok || old.agent != ex.agent || ex.owner {
+		return
+	}
+	old.owner, ex.owner = false, true
+	ex.agent.setAccess(ex.cfg.Agent.Community, ex.maxAge())
+	ex.setSystem()
+}
+
+func (ex *Exporter) maxAge() time.Duration {
+	if ex.cfg.Agent.MaxAge == nil {
+		return 0
+	}
+	return time.Duration(*ex.cfg.Agent.MaxAge * float64(time.Second))
+}
+
+func (ex *Exporter) setSystem() {
+	ex.agent.SetSystem(
+		fmt.Sprintf("tsm proxy for %s", ex.host),
+		ex.cfg.Agent.Enterprise,
+		fmt.Sprintf("%s.%s.%s", ex.cfg.General.Net, ex.cfg.General.Sta, ex.cfg.General.Loc))
+}
+
+// Process does nothing, the table is built once the derived channels are known
+func (ex *Exporter) Process(ts time.Time, scan *map[string]string) {}
+
+// ProcessDerived replaces the served table with the scan and derived channels
+func (ex *Exporter) ProcessDerived(ts time.Time, scan *map[string]string, derived []config.DerivedChannel) {
+
+	ent := ex.cfg.Agent.Enterprise
+	vars := make([]Variable, 0, 7*len(*scan)+6*len(derived)+3)
+
+	for oid, resstr := range *scan {
+		oidinfo, ok := ex.cfg.OidInfoFor(oid)
+		if ok && oidinfo.Type == "string" {
+			vars = append(vars, Variable{Oid: oid, Type: g.OctetString, Value: resstr})
+			continue
+		}
+		vars = append(vars, rawVariable(oid, resstr))
+	}
+
+	ts = ts.UTC()
+	vars = append(vars,
+		Variable{Oid: ent + ".1.1.0", Type: g.OctetString, Value: ts.Format(time.RFC3339)},
+		Variable{Oid: ent + ".1.2.0", Type: g.Gauge32, Value: uint32(ts.Unix())},
+		Variable{Oid: ent + ".1.3.0", Type: g.OctetString, Value: fmt.Sprintf("%s.%s.%s",
+			ex.cfg.General.Net, ex.cfg.General.Sta, ex.cfg.General.Loc)},
+	)
+
+	ndx := 0
+	column := func(col int) string {
+		return fmt.Sprintf("%s.2.1.%d.%d", ent, col, ndx)
+	}
+	addRow := func(chancode, label, units, text string, value float64) {
+		ndx++
+		vars = append(vars,
+			Variable{Oid: column(1), Type: g.Integer, Value: ndx},
+			Variable{Oid: column(2), Type: g.OctetString, Value: chancode},
+			Variable{Oid: column(3), Type: g.OctetString, Value: label},
+			Variable{Oid: column(4), Type: g.OctetString, Value: units},
+			Variable{Oid: column(5), Type: g.OctetString, Value: text},
+			Variable{Oid: column(6), Type: g.Integer, Value: milli(value)},
+		)
+	}
+
+	_, oidInfos, _ := ex.cfg.DataOidsInfo()
+	for _, oidinfo := range oidInfos {
+		resstr, ok := (*scan)[oidinfo.Oid]
+		if !ok {
+			continue
+		}
+		value, _ := strconv.ParseFloat(resstr, 64)
+		if oidinfo.Type == "number" {
+			value *= oidinfo.Scaling
+		}
+		text := strings.TrimSpace(oidinfo.ValueString(resstr))
+		addRow(oidinfo.Chancode, oidinfo.Label, oidinfo.Units, text, value)
+	}
+	for _, dc := range derived {
+		addRow(dc.Chancode, dc.Label, dc.Units, dc.ValueString(), dc.Value)
+	}
+
+	ex.agent.Update(vars)
+}
+
+// rawVariable serves a device value with the smallest SNMP type that holds it
+func rawVariable(oid, resstr string) Variable {
+
+	val, err := strconv.ParseInt(resstr, 10, 64)
+	if err != nil {
+		if uval, uerr := strconv.ParseUint(resstr, 10, 64); uerr == nil {
+			return Variable{Oid: oid, Type: g.Counter64, Value: uval}
+		}
+		return Variable{Oid: oid, Type: g.OctetString, Value: resstr}
+	}
+	switch {
+	case val >= math.MinInt32 && val <= math.MaxInt32:
+		return Variable{Oid: oid, Type: g.Integer, Value: int(val)}
+	case val > 0 && val <= math.MaxUint32:
+		return Variable{Oid: oid, Type: g.Gauge32, Value: uint32(val)}
+	case val > 0:
+		return Variable{Oid: oid, Type: g.Counter64, Value: uint64(val)}
+	}
+	return Variable{Oid: oid, Type: g.OctetString, Value: resstr}
+}
+
+// milli scales value by 1000 and clamps it to an Integer32
+func milli(value float64) int {
+	if math.IsNaN(value) {
+		return 0
+	}
+	value = math.Round(value * 1000)
+	if value > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	if value < math.MinInt32 {
+		return math.MinInt32
+	}
+	return int(value)
+}
+
+// Derived returns nothing, the exporter only serves the scan
+func (ex *Exporter) Derived() []config.DerivedChannel {
+	return nil
+}
+
+// Close stops the agent, unless it was handed over to another exporter
+func (ex *Exporter) Close() error {
+	if !ex.owner {
+		return nil
+	}
+	return ex.agent.Close()
+}
@@ -0,0 +1,49 @@
+package battery
+
+// chemistry holds the per cell characteristics used by the estimator
+type chemistry struct {
+	// ocv is the resting open circuit voltage per cell at 25 deg C for
+	// SOC 0, 10, ... 100 percent
+	ocv              [11]float64
+	tempCoeff        float64 // per cell volts per deg C
+	chargeEfficiency float64
+}
+
+var chemistries = map[string]chemistry{
+	"flooded": {
+		ocv:              [11]float64{1.885, 1.918, 1.943, 1.973, 1.997, 2.017, 2.040, 2.060, 2.077, 2.097, 2.117},
+		tempCoeff:        0.0002,
+		chargeEfficiency: 0.85,
+	},
+	"agm": {
+		ocv:              [11]float64{1.900, 1.925, 1.958, 1.983, 2.008, 2.033, 2.058, 2.083, 2.108, 2.125, 2.142},
+		tempCoeff:        0.0002,
+		chargeEfficiency: 0.90,
+	},
+	"gel": {
+		ocv:              [11]float64{1.917, 1.950, 1.975, 2.000, 2.025, 2.050, 2.075, 2.092, 2.108, 2.125, 2.142},
+		tempCoeff:        0.0002,
+		chargeEfficiency: 0.90,
+	},
+	"lifepo4": {
+		ocv:              [11]float64{2.500, 3.000, 3.200, 3.220, 3.250, 3.260, 3.280, 3.300, 3.320, 3.350, 3.400},
+		tempCoeff:        0.0,
+		chargeEfficiency: 0.99,
+	},
+}
+
+// socFromVoltage interpolates the state of charge in percent from a resting
+// per cell voltage already corrected to 25 deg C
+func (chem *chemistry) socFromVoltage(cellVolts float64) float64 {
+
+	if cellVolts <= chem.ocv[0] {
+		return 0
+	}
+	for ndx := 1; ndx < len(chem.ocv); ndx++ {
+		if cellVolts <= chem.ocv[ndx] {
+			lo, hi := chem.ocv[ndx-1], chem.ocv[ndx]
+			return 10 * (float64(ndx-1) + (cellVolts-lo)/(hi-lo))
+		}
+	}
+	return 100
+}
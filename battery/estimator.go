@@ -0,0 +1,300 @@
+// Package battery estimates battery state of charge, days of autonomy and
+// capacity health from the polled voltage, temperature and current channels
+package battery
+
+import (
+	"math"
+	"time"
+
+	"tsm/config"
+	rlog "tsm/log"
+	"tsm/statefile"
+)
+
+const (
+	// referenceTemp is the temperature the OCV curves are given at
+	referenceTemp float64 = 25
+	// loadAverageTau is the time constant of the average load current
+	loadAverageTau = 24 * time.Hour
+	// minHealthSwing is the smallest SOC change in percent between two rest
+	// anchors that is used to estimate the effective capacity
+	minHealthSwing float64 = 20
+	// healthWeight is the weight of each new capacity estimate in the health average
+	healthWeight float64 = 0.2
+	// maxAutonomy caps the reported days of autonomy when there is no load
+	maxAutonomy float64 = 999
+	// saveInterval is how often the estimator state is saved
+	saveInterval = 60 * time.Second
+)
+
+// estimate is the estimator state kept between polls and restarts
+type estimate struct {
+	Valid   bool
+	SOC     float64 // percent
+	Health  float64 // effective capacity as percent of rated capacity
+	AvgLoad float64 // amps
+	LastTS  time.Time
+
+	RestSince   time.Time
+	AnchorValid bool
+	AnchorSOC   float64
+	CountedAh   float64 // net amp-hours coulomb counted since the last anchor
+}
+
+// Estimator combines a temperature compensated voltage curve with coulomb
+// counting. The voltage curve is only trusted after the battery has rested,
+// at which point the coulomb counted SOC is re-anchored to it and the charge
+// moved since the previous anchor gives an estimate of effective capacity.
+type Estimator struct {
+	cfg      *config.TSMConfig
+	bcfg     *config.BatteryConfig
+	chem     chemistry
+	maxGap   time.Duration
+	restTime time.Duration
+
+	est      estimate
+	lastSave time.Time
+}
+
+// NewEstimator constructor. The estimate is restored from the state file if configured.
+func NewEstimator(cfg *config.TSMConfig) (*Estimator, error) {
+
+	bcfg := &cfg.Battery
+	chem := chemistries[bcfg.Chemistry]
+	if bcfg.TempCoeff != 0 {
+		chem.tempCoeff = bcfg.TempCoeff
+	}
+	if bcfg.ChargeEfficiency != 0 {
+		chem.chargeEfficiency = bcfg.ChargeEfficiency
+	}
+
+	est := &Estimator{
+		cfg:      cfg,
+		bcfg:     bcfg,
+		chem:     chem,
+		maxGap:   time.Duration(bcfg.MaxGap * float64(time.Second)),
+		restTime: time.Duration(bcfg.RestTime * float64(time.Second)),
+		est:      estimate{Health: 100},
+	}
+
+	if bcfg.StateFile != "" {
+		found, err := statefile.Load(bcfg.StateFile, &est.est)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			rlog.NoticeMsg("battery: restored SOC %.1f%%, health %.1f%% from %s",
+				est.est.SOC, est.est.Health, bcfg.StateFile)
+		}
+	}
+
+	return est, nil
+}
+
+// Resume takes over the estimate of the estimator this one replaces, which
+// is newer than the one in the state file
+func (e *Estimator) Resume(prev interface{}) {
+
+	if old, ok := prev.(*Estimator); ok {
+		e.est = old.est
+		e.lastSave = old.lastSave
+	}
+}
+
+// readings gets the battery voltage, temperature, net current and load current from scan
+func (e *Estimator) readings(scan *map[string]string) (volts, temp, net, load float64, err error) {
+
+	if volts, err = e.cfg.ScaledValue(e.bcfg.Voltage, scan); err != nil {
+		return
+	}
+	temp = referenceTemp
+	if e.bcfg.Temperature != "" {
+		if temp, err = e.cfg.ScaledValue(e.bcfg.Temperature, scan); err != nil {
+			return
+		}
+	}
+
+	if e.bcfg.Current != "" {
+		if net, err = e.cfg.ScaledValue(e.bcfg.Current, scan); err != nil {
+			return
+		}
+		load = math.Max(-net, 0)
+		return
+	}
+
+	var charge float64
+	if e.bcfg.ChargeCurrent != "" {
+		if charge, err = e.cfg.ScaledValue(e.bcfg.ChargeCurrent, scan); err != nil {
+			return
+		}
+	}
+	if e.bcfg.LoadCurrent != "" {
+		if load, err = e.cfg.ScaledValue(e.bcfg.LoadCurrent, scan); err != nil {
+			return
+		}
+	}
+	net = charge - load
+
+	return
+}
+
+// voltageSOC returns the state of charge from the temperature compensated voltage curve
+func (e *Estimator) voltageSOC(volts, temp float64) float64 {
+	cellVolts := volts/float64(e.bcfg.Cells) - e.chem.tempCoeff*(temp-referenceTemp)
+	return e.chem.socFromVoltage(cellVolts)
+}
+
+// Process updates the estimate with a new scan
+func (e *Estimator) Process(ts time.Time, scan *map[string]string) {
+
+	volts, temp, net, load, err := e.readings(scan)
+	if err != nil {
+		rlog.WarningMsg("battery: %s", err.Error())
+		e.est.RestSince = time.Time{}
+		return
+	}
+	vSOC := e.voltageSOC(volts, temp)
+
+	if !e.est.Valid {
+		// the battery may be charging or loaded, so the voltage SOC is only
+		// a starting point, not an anchor for the health estimate
+		rlog.NoticeMsg("battery: initial SOC %.1f%% from voltage %.2f V at %.1f deg C", vSOC, volts, temp)
+		e.est.Valid = true
+		e.est.SOC = vSOC
+		e.est.LastTS = ts
+		e.est.AnchorValid = false
+		e.est.CountedAh = 0
+		return
+	}
+
+	dt := ts.Sub(e.est.LastTS)
+	if dt <= 0 {
+		return
+	}
+	e.est.LastTS = ts
+
+	if dt > e.maxGap {
+		rlog.WarningMsg("battery: %.0f sec gap, SOC not updated across it", dt.Seconds())
+		e.est.RestSince = time.Time{}
+	} else {
+		ah := net * dt.Hours()
+		if ah > 0 {
+			ah *= e.chem.chargeEfficiency
+		}
+		e.est.CountedAh += ah
+		e.est.SOC = clamp(e.est.SOC+100*ah/e.effectiveCapacity(), 0, 100)
+
+		alpha := math.Min(dt.Seconds()/loadAverageTau.Seconds(), 1)
+		e.est.AvgLoad += alpha * (load - e.est.AvgLoad)
+	}
+
+	if math.Abs(net) < e.bcfg.RestCurrent {
+		if e.est.RestSince.IsZero() {
+			e.est.RestSince = ts
+		}
+		if ts.Sub(e.est.RestSince) >= e.restTime {
+			e.anchor(ts, vSOC)
+		}
+	} else {
+		e.est.RestSince = time.Time{}
+	}
+
+	if ts.Sub(e.lastSave) >= saveInterval {
+		e.save()
+		e.lastSave = ts
+	}
+}
+
+// anchor resets the coulomb counted SOC to the rested voltage SOC. If enough
+// charge has moved since the previous anchor it is used to update the health.
+func (e *Estimator) anchor(ts time.Time, vSOC float64) {
+
+	swing := vSOC - e.est.AnchorSOC
+	if e.est.AnchorValid && math.Abs(swing) >= minHealthSwing && swing*e.est.CountedAh > 0 {
+		capacity := math.Abs(e.est.CountedAh) / (math.Abs(swing) / 100)
+		health := clamp(100*capacity/e.bcfg.Capacity, 0, 120)
+		e.est.Health += healthWeight * (health - e.est.Health)
+		rlog.InfoMsg("battery: %.1f Ah moved over %.1f%% SOC, effective capacity %.0f Ah, health now %.1f%%",
+			e.est.CountedAh, swing, capacity, e.est.Health)
+	}
+
+	if math.Abs(e.est.SOC-vSOC) >= 5 {
+		rlog.InfoMsg("battery: re-anchoring SOC from %.1f%% to rested voltage SOC %.1f%%", e.est.SOC, vSOC)
+	}
+	e.est.SOC = vSOC
+	e.est.AnchorValid = true
+	e.est.AnchorSOC = vSOC
+	e.est.CountedAh = 0
+	e.est.RestSince = ts
+}
+
+// effectiveCapacity is the rated capacity derated by the health estimate
+func (e *Estimator) effectiveCapacity() float64 {
+	return e.bcfg.Capacity * e.est.Health / 100
+}
+
+// autonomy estimates the days the usable charge will supply the average load
+func (e *Estimator) autonomy() float64 {
+
+	usable := math.Max(e.est.SOC-e.bcfg.MinSOC, 0) / 100 * e.effectiveCapacity()
+	daily := e.est.AvgLoad * 24
+	if daily <= 0 || usable/daily > maxAutonomy {
+		return maxAutonomy
+	}
+	return usable / daily
+}
+
+// Derived returns SOC, days of autonomy and health as output channels
+func (e *Estimator) Derived() []config.DerivedChannel {
+
+	if !e.est.Valid {
+		return nil
+	}
+
+	derived := make([]config.DerivedChannel, 0, 3)
+	if e.bcfg.SOCChancode != "" {
+		derived = append(derived, config.DerivedChannel{
+			Chancode: e.bcfg.SOCChancode,
+			Label:    "Battery state of charge",
+			Units:    "%",
+			Value:    e.est.SOC,
+		})
+	}
+	if e.bcfg.AutonomyChancode != "" {
+		derived = append(derived, config.DerivedChannel{
+			Chancode: e.bcfg.AutonomyChancode,
+			Label:    "Battery days of autonomy",
+			Units:    "days",
+			Value:    e.autonomy(),
+		})
+	}
+	if e.bcfg.HealthChancode != "" {
+		derived = append(derived, config.DerivedChannel{
+			Chancode: e.bcfg.HealthChancode,
+			Label:    "Battery health",
+			Units:    "%",
+			Value:    e.est.Health,
+		})
+	}
+	return derived
+}
+
+func (e *Estimator) save() {
+
+	if e.bcfg.StateFile == "" {
+		return
+	}
+	if err := statefile.Save(e.bcfg.StateFile, &e.est); err != nil {
+		rlog.ErrMsg("battery: could not save state to %s: %s", e.bcfg.StateFile, err.Error())
+	}
+}
+
+// Close saves the estimator state
+func (e *Estimator) Close() error {
+	e.save()
+	return nil
+}
+
+func clamp(val, lo, hi float64) float64 {
+	return math.Max(lo, math.Min(hi, val))
+}
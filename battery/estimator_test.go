@@ -0,0 +1,69 @@
+package battery
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tsm/config"
+)
+
+func TestFirstScanNotAnchor(t *testing.T) {
+
+	cfg := config.NewConfig()
+	cfg.Oids.DeviceGroups = []config.DeviceInfo{{
+		ModelGroup: "TS-MPPT",
+		Measurements: []config.OidInfo{
+			{Oid: "1.3.6.1.4.1.33333.2.38.0", Label: "Battery voltage", Units: "volts", Type: "number", Scaling: 0.005493164},
+			{Oid: "1.3.6.1.4.1.33333.2.43.0", Label: "Charge current", Units: "amps", Type: "number", Scaling: 0.002441406},
+		},
+	}}
+	cfg.SetModel("TS-MPPT")
+	cfg.Battery.Enabled = true
+	cfg.Battery.Chemistry = "agm"
+	cfg.Battery.Capacity = 100
+	cfg.Battery.Cells = 6
+	cfg.Battery.Voltage = "1.3.6.1.4.1.33333.2.38.0"
+	cfg.Battery.Current = "1.3.6.1.4.1.33333.2.43.0"
+	cfg.Battery.RestTime = 60
+	cfg.Battery.StateFile = filepath.Join(t.TempDir(), "battery.json")
+	if err := cfg.Battery.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	est, err := NewEstimator(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// volts and net amps as raw values of the test config's scaling
+	scan := func(volts, amps float64) *map[string]string {
+		return &map[string]string{
+			"1.3.6.1.4.1.33333.2.38.0": fmt.Sprintf("%.0f", volts/0.005493164),
+			"1.3.6.1.4.1.33333.2.43.0": fmt.Sprintf("%.0f", amps/0.002441406),
+		}
+	}
+	ts := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// started under a heavy load, the voltage reads low
+	est.Process(ts, scan(11.9, -10))
+	if !est.est.Valid || est.est.AnchorValid {
+		t.Fatalf("first scan: valid %v, anchor valid %v, want valid without an anchor", est.est.Valid, est.est.AnchorValid)
+	}
+
+	// charge, then rest long enough to anchor
+	for ndx := 1; ndx <= 60; ndx++ {
+		ts = ts.Add(time.Minute)
+		est.Process(ts, scan(13.5, 20))
+	}
+	for ndx := 0; ndx < 3; ndx++ {
+		ts = ts.Add(time.Minute)
+		est.Process(ts, scan(12.8, 0))
+	}
+	if !est.est.AnchorValid {
+		t.Fatal("no anchor after resting")
+	}
+	if est.est.Health != 100 {
+		t.Errorf("health %.1f%% after the first rested anchor, want it unchanged at 100%%", est.est.Health)
+	}
+}
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	cmdpkg "tsm/cmd"
+	"tsm/config"
+	"tsm/mib"
+
+	"github.com/pelletier/go-toml"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// version is set at build time with -ldflags "-X main.version=v1.3"
+var version = "dev"
+
+// newRootCmd builds the command tree, storing the flags in appCfg
+func newRootCmd(appCfg *appConfig) *cobra.Command {
+
+	root := &cobra.Command{
+		Use:   "tsm",
+		Short: "Monitor Morningstar TriStar charge controllers over SNMP",
+		Long: `tsm polls Morningstar TriStar charge controllers through an EMC-1 bridge
+over SNMP, showing their status or writing a record every sample interval.
+
+The config file tsm.toml is read from the current directory, $HOME/dev/tsm or
+$HOME/etc unless given with --config. Config values can be overridden with
+TSM_ environment variables, such as TSM_GENERAL_STA for sta in [general].`,
+		Version:       version,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+
+	viper.SetEnvPrefix("tsm")
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	viper.AutomaticEnv()
+
+	pf := root.PersistentFlags()
+	pf.BoolVarP(&appCfg.debug, "debug", "d", false, "enable debug logging")
+	pf.StringVarP(&appCfg.cfgFile, "config", "c", "", "TSM config file")
+	pf.StringP("user", "u", appCfg.runAsUser, "user to run as, also TSM_USER")
+	pf.String("community", appCfg.community, "SNMP read community, also community in the config or TSM_COMMUNITY")
+	pf.StringVar(&appCfg.logSinks, "log", "", "log to syslog, stderr and/or file=<path>, comma separated, instead of the config")
+	pf.StringVar(&appCfg.logFormat, "logformat", "text", "stderr and file log format, text or json")
+	pf.String("net", "", "network code, overrides net in [general]")
+	pf.String("sta", "", "station code, overrides sta in [general]")
+	pf.String("loc", "", "location code, overrides loc in [general]")
+	viper.BindPFlag("user", pf.Lookup("user"))
+	viper.BindPFlag("community", pf.Lookup("community"))
+	viper.BindPFlag("general.net", pf.Lookup("net"))
+	viper.BindPFlag("general.sta", pf.Lookup("sta"))
+	viper.BindPFlag("general.loc", pf.Lookup("loc"))
+
+	root.PersistentPreRun = func(cmd *cobra.Command, args []string) {
+		appCfg.runAsUser = viper.GetString("user")
+	}
+
+	root.AddCommand(
+		newStatusCmd(appCfg),
+		newPollCmd(appCfg),
+		newDaemonCmd(appCfg),
+		newHistoryCmd(appCfg),
+		newTrapsCmd(appCfg),
+		newWalkCmd(appCfg),
+		newGetCmd(appCfg),
+		newDiscoverCmd(appCfg),
+		newSimulateCmd(appCfg),
+		newConfigCmd(appCfg),
+		newVersionCmd(),
+	)
+
+	return root
+}
+
+// runDevice runs a command that talks to the device at args[0], passing
+// the command service host, name and the rest of args
+func (c *appConfig) runDevice(name string, args []string) error {
+
+	host, port, err := formatHostPort(args[0])
+	if err != nil {
+		return err
+	}
+	c.host, c.port, c.cmd = host, port, name
+	c.args = append([]string{args[0], name}, args[1:]...)
+	// the host on the command line overrides host in the config, also when
+	// the daemon reloads it
+	viper.Set("host", net.JoinHostPort(host, port))
+
+	return run(c)
+}
+
+// runPolling runs poll or daemon, whose host may be left to host in the
+// config when only the interval is given
+func (c *appConfig) runPolling(name string, args []string) error {
+
+	if len(args) == 2 {
+		return c.runDevice(name, args)
+	}
+	c.cmd = name
+	c.args = append([]string{"", name}, args...)
+
+	return run(c)
+}
+
+// addModelFlags adds the flags to give the model instead of detecting it
+func addModelFlags(cmd *cobra.Command, appCfg *appConfig) {
+	cmd.Flags().StringVar(&appCfg.model, "model", "", "use this model or model group instead of detecting it")
+	cmd.Flags().BoolVar(&appCfg.redetect, "redetect", false, "detect the model even if it is in the model cache")
+}
+
+// addSessionFlags adds the flags to record or replay an SNMP session
+func addSessionFlags(cmd *cobra.Command, appCfg *appConfig) {
+	cmd.Flags().StringVar(&appCfg.record, "record", "", "record every query and response to this file")
+	cmd.Flags().StringVar(&appCfg.replay, "replay", "", "replay a recorded session from this file instead of querying host")
+	cmd.Flags().Float64Var(&appCfg.speed, "speed", 1, "replay speed, divide the poll interval by the same factor")
+}
+
+func newStatusCmd(appCfg *appConfig) *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "status <host[:port]>",
+		Short: "Show the controller status, refreshed every second",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return appCfg.runDevice("status", args)
+		},
+	}
+	addModelFlags(cmd, appCfg)
+	addSessionFlags(cmd, appCfg)
+	return cmd
+}
+
+func newPollCmd(appCfg *appConfig) *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "poll [host[:port]] <interval>",
+		Short: "Poll the controller, writing a record every interval seconds",
+		Long: `Poll the controller every interval seconds, 1 to 60, writing a record at each
+whole multiple of the interval to stdout or the outputs in [output]. Without
+host the controller is host in the config.`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return appCfg.runPolling("poll", args)
+		},
+	}
+	addModelFlags(cmd, appCfg)
+	addSessionFlags(cmd, appCfg)
+	return cmd
+}
+
+func newDaemonCmd(appCfg *appConfig) *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "daemon [host[:port]] <interval>",
+		Short: "Poll the controller as a long running service",
+		Long: `Poll like the poll command, also rereading the config and reopening the
+outputs on SIGHUP and logging a status summary on SIGUSR1. The config is also
+reread when a config file changes, keeping the current one if the new one is
+invalid. A change of host, community or SNMP version in the config reconnects;
+a host on the command line overrides the one in the config.`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return appCfg.runPolling("daemon", args)
+		},
+	}
+	cmd.Flags().StringVar(&appCfg.pidfile, "pidfile", "", "write the daemon pid to this file")
+	cmd.Flags().BoolVar(&appCfg.detach, "detach", false, "run the daemon in the background")
+	cmd.Flags().BoolVar(&appCfg.watch, "watch", true, "reload the config when a config file changes")
+	addModelFlags(cmd, appCfg)
+	addSessionFlags(cmd, appCfg)
+	return cmd
+}
+
+func newHistoryCmd(appCfg *appConfig) *cobra.Command {
+
+	return &cobra.Command{
+		Use:   "history <channel> [from] [to] [text|csv|json]",
+		Short: "Show the stored values of a channel",
+		Long: `Show the values of a channel, by OID, chancode or label, stored by poll.
+from and to are RFC3339 times, YYYY-MM-DD[THH:MM:SS] or durations before now
+such as 6h, and default to the last day.`,
+		Args: cobra.RangeArgs(1, 4),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			appCfg.cmd = "history"
+			appCfg.args = append([]string{"history"}, args...)
+			return run(appCfg)
+		},
+	}
+}
+
+func newTrapsCmd(appCfg *appConfig) *cobra.Command {
+
+	return &cobra.Command{
+		Use:   "traps <host[:port]>",
+		Short: "Receive traps and informs sent by the controller",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return appCfg.runDevice("traps", args)
+		},
+	}
+}
+
+func newWalkCmd(appCfg *appConfig) *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "walk <host[:port]> [oid]",
+		Short: "List the OIDs the controller has under oid",
+		Long: `Walk the controller from oid, an OID, chancode or label, by default the
+Morningstar subtree 1.3.6.1.4.1.33333, printing the SNMP type and raw value of
+each OID, and the label and decoded value of those in the config.`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return appCfg.runDevice("walk", args)
+		},
+	}
+	cmd.Flags().BoolVar(&appCfg.snippets, "snippet", false, "also print tsm.toml entries for the OIDs found")
+	addModelFlags(cmd, appCfg)
+	return cmd
+}
+
+func newGetCmd(appCfg *appConfig) *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "get <host[:port]> <oid|chancode|label>...",
+		Short: "Query the controller for single OIDs",
+		Long: `Query the controller for each OID, or chancode or label in the config for the
+controller's model, printing the SNMP type and raw value, and the label and
+decoded value of those in the config.`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return appCfg.runDevice("get", args)
+		},
+	}
+	cmd.Flags().BoolVar(&appCfg.snippets, "snippet", false, "also print tsm.toml entries for the OIDs found")
+	addModelFlags(cmd, appCfg)
+	return cmd
+}
+
+func newDiscoverCmd(appCfg *appConfig) *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "discover <network>",
+		Short: "Find the EMC-1s on a network",
+		Long: `Probe every host on network, such as 10.0.0.0/24, for an EMC-1, listing the
+address, EMC-1 serial number and firmware, and the model and serial number of
+the controller behind it. With --write the devices found are added to the
+inventory at the end of the config file.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			appCfg.cmd = "discover"
+			appCfg.args = append([]string{"discover"}, args...)
+			return run(appCfg)
+		},
+	}
+	cmd.Flags().StringVar(&appCfg.port, "port", "161", "SNMP port to probe")
+	cmd.Flags().IntVar(&appCfg.workers, "workers", cmdpkg.DefaultDiscoverWorkers, "number of hosts to probe at once")
+	cmd.Flags().DurationVar(&appCfg.timeout, "timeout", time.Second, "how long to wait for each host to answer")
+	cmd.Flags().BoolVar(&appCfg.inventory, "write", false, "add the devices found to the inventory in the config file")
+	return cmd
+}
+
+func newSimulateCmd(appCfg *appConfig) *cobra.Command {
+
+	return &cobra.Command{
+		Use:   "simulate <model> [listen]",
+		Short: "Serve a simulated controller for testing and training",
+		Long: `Serve a simulated controller of model, a model name such as TS-MPPT-60 or a
+model group, on listen (default 127.0.0.1:1161), set up by [simulate].`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			appCfg.cmd = "simulate"
+			appCfg.simModel = args[0]
+			appCfg.simListen = "127.0.0.1:1161"
+			if len(args) > 1 {
+				appCfg.simListen = args[1]
+			}
+			appCfg.args = append([]string{"simulate"}, args...)
+			return run(appCfg)
+		},
+	}
+}
+
+func newConfigCmd(appCfg *appConfig) *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Check and show the config",
+	}
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "validate",
+			Short: "Check the config file",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				tsmCfg, err := loadConfig(appCfg.cfgFile)
+				if err != nil {
+					return err
+				}
+				if tsmCfg.Notify.Enabled && !tsmCfg.Events.Enabled && tsmCfg.Notify.HasEventRules() {
+					fmt.Fprintln(cmd.ErrOrStderr(), "warning: notify rules with event = true only fire for tsm traps unless [events] enabled = true")
+				}
+				fmt.Printf("%s: OK\n", configSources())
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "show",
+			Short: "Show the config with the flag and environment overrides applied",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				if _, err := loadConfig(appCfg.cfgFile); err != nil {
+					return err
+				}
+				tree, err := toml.TreeFromMap(viper.AllSettings())
+				if err != nil {
+					return err
+				}
+				fmt.Printf("# %s\n%s", configSources(), tree.String())
+				return nil
+			},
+		},
+		newImportMibCmd(),
+	)
+
+	return cmd
+}
+
+func newImportMibCmd() *cobra.Command {
+
+	var output string
+	cmd := &cobra.Command{
+		Use:   "import-mib <mib file>...",
+		Short: "Generate device groups from SMIv2 MIB files",
+		Long: `Read the objects defined in SMIv2 MIB files, such as the Morningstar MIBs, and
+write a devicegroups array for the [oids] section of tsm.toml, with a group
+for each node the scalar objects are defined under. Give the MIBs the
+objects' OIDs are defined in, such as the enterprise MIB, as well.
+
+Enumerations become maps, integers with bits named in their description, one
+per line such as "bit 3: RTS open", become bitmaps, and the scaling is taken
+from DISPLAY-HINTs and descriptions such as "n * 180 * 2^-15". BITS objects
+are left out. Check the group OID, fill in the model list and chancodes, and
+trim the OIDs not wanted.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			modules, err := mib.ParseFiles(args...)
+			if err != nil {
+				return err
+			}
+			groups, err := mib.DeviceGroups(modules)
+			if err != nil {
+				return err
+			}
+			if len(groups) == 0 {
+				return fmt.Errorf("no scalar objects found in %s", strings.Join(args, ", "))
+			}
+
+			var buf bytes.Buffer
+			fmt.Fprintf(&buf, "# generated by tsm config import-mib from %s\n", strings.Join(args, ", "))
+			fmt.Fprintf(&buf, "# check groupoid, fill in modellist and chancodes, and trim the OIDs not wanted\n")
+			if err := config.WriteDeviceGroups(&buf, groups); err != nil {
+				return err
+			}
+			if output != "" {
+				return os.WriteFile(output, buf.Bytes(), 0644)
+			}
+			_, err = os.Stdout.Write(buf.Bytes())
+			return err
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "", "write the device groups to this file instead of stdout")
+	return cmd
+}
+
+func newVersionCmd() *cobra.Command {
+
+	return &cobra.Command{
+		Use:   "version",
+		Short: "Show the tsm version",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			fmt.Printf("tsm %s %s %s/%s\n", version, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+		},
+	}
+}
+
+// legacyCmds are the commands that used to follow the host
+var legacyCmds = []string{"poll", "daemon", "status", "traps", "walk", "get"}
+
+// legacyArgs converts the old command line, tsm [-flag ...] <host> <cmd>
+// [args], with single dash long flags, to tsm <cmd> [--flag ...] <host> [args]
+func legacyArgs(root *cobra.Command, args []string) []string {
+
+	// flags of every command, by long and short name, and whether they take a value
+	takesValue := make(map[string]bool)
+	addFlag := func(f *pflag.Flag) {
+		takesValue[f.Name] = f.Value.Type() != "bool"
+		if f.Shorthand != "" {
+			takesValue[f.Shorthand] = f.Value.Type() != "bool"
+		}
+	}
+	root.PersistentFlags().VisitAll(addFlag)
+	for _, sub := range root.Commands() {
+		sub.Flags().VisitAll(addFlag)
+	}
+
+	newArgs := make([]string, len(args))
+	copy(newArgs, args)
+
+	var positional []int
+	for ndx := 0; ndx < len(newArgs); ndx++ {
+		arg := newArgs[ndx]
+		if arg == "--" {
+			break
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			positional = append(positional, ndx)
+			continue
+		}
+		name := strings.TrimLeft(arg, "-")
+		hasValue := strings.Contains(name, "=")
+		name = strings.SplitN(name, "=", 2)[0]
+		if !strings.HasPrefix(arg, "--") && len(name) > 1 {
+			if _, ok := takesValue[name]; ok {
+				newArgs[ndx] = "-" + arg
+			}
+		}
+		if takesValue[name] && !hasValue {
+			ndx++
+		}
+	}
+
+	if len(positional) < 2 {
+		return newArgs
+	}
+	first, second := newArgs[positional[0]], newArgs[positional[1]]
+	if sub, _, err := root.Find([]string{first}); err == nil && sub != root {
+		return newArgs
+	}
+	// the command goes first, so cobra parses the flags before the host as
+	// the command's own flags
+	for _, name := range legacyCmds {
+		if second == name {
+			rest := append(newArgs[:positional[1]:positional[1]], newArgs[positional[1]+1:]...)
+			return append([]string{name}, rest...)
+		}
+	}
+	return newArgs
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLegacyArgs(t *testing.T) {
+
+	tests := []struct {
+		args string
+		want string
+	}{
+		{"10.0.0.5 poll 10", "poll 10.0.0.5 10"},
+		{"-d -c tsm.toml 10.0.0.5:1161 status", "status -d -c tsm.toml 10.0.0.5:1161"},
+		{"-pidfile /run/tsm.pid -detach 10.0.0.5 daemon 10", "daemon --pidfile /run/tsm.pid --detach 10.0.0.5 10"},
+		{"-log=stderr -u nrts 10.0.0.5 traps", "traps --log=stderr -u nrts 10.0.0.5"},
+		{"poll 10.0.0.5 10", "poll 10.0.0.5 10"},
+		{"--sta XYZ poll 10.0.0.5 10", "--sta XYZ poll 10.0.0.5 10"},
+		{"history BV 6h", "history BV 6h"},
+		{"config show", "config show"},
+		{"-snippet 10.0.0.5 walk 1.3.6.1.4.1.33333.2", "walk --snippet 10.0.0.5 1.3.6.1.4.1.33333.2"},
+		{"10.0.0.5", "10.0.0.5"},
+	}
+
+	root := newRootCmd(&appConfig{runAsUser: "nrts", community: "public"})
+	for _, tt := range tests {
+		got := legacyArgs(root, strings.Fields(tt.args))
+		if want := strings.Fields(tt.want); !reflect.DeepEqual(got, want) {
+			t.Errorf("legacyArgs(%s) = %v, want %v", tt.args, got, want)
+		}
+	}
+}
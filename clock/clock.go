@@ -0,0 +1,156 @@
+// Package clock lets code that waits on the time of day be driven by a fake
+// clock in tests
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and creates timers
+type Clock interface {
+	Now() time.Time
+	NewTimer(time.Duration) Timer
+}
+
+// Timer is the part of time.Timer used by a Clock
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// New returns the real clock
+func New() Clock {
+	return realClock{}
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct {
+	timer *time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.timer.C
+}
+
+func (t realTimer) Stop() bool {
+	return t.timer.Stop()
+}
+
+// Fake is a Clock that only moves when advanced. With AutoAdvance set, a
+// new timer moves the clock straight to its deadline, so a single goroutine
+// waiting on timers runs through simulated time without delay.
+type Fake struct {
+	AutoAdvance bool
+
+	mutex  sync.Mutex
+	cond   *sync.Cond
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// NewFake returns a fake clock set to start
+func NewFake(start time.Time) *Fake {
+	f := &Fake{now: start}
+	f.cond = sync.NewCond(&f.mutex)
+	return f
+}
+
+// Now returns the fake time
+func (f *Fake) Now() time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.now
+}
+
+// NewTimer returns a timer that fires when the fake time reaches now+d
+func (f *Fake) NewTimer(d time.Duration) Timer {
+
+	f.mutex.Lock()
+	t := &fakeTimer{clock: f, deadline: f.now.Add(d), c: make(chan time.Time, 1)}
+	f.timers = append(f.timers, t)
+	auto := f.AutoAdvance
+	f.cond.Broadcast()
+	f.mutex.Unlock()
+
+	if auto {
+		f.Set(t.deadline)
+	}
+	return t
+}
+
+// Advance moves the fake time on by d, firing the timers that are due
+func (f *Fake) Advance(d time.Duration) {
+	f.Set(f.Now().Add(d))
+}
+
+// Set moves the fake time to t, firing the timers that are due. The time
+// never moves backwards.
+func (f *Fake) Set(t time.Time) {
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if t.After(f.now) {
+		f.now = t
+	}
+	sort.SliceStable(f.timers, func(i, j int) bool {
+		return f.timers[i].deadline.Before(f.timers[j].deadline)
+	})
+	for len(f.timers) > 0 && !f.timers[0].deadline.After(f.now) {
+		f.timers[0].c <- f.now
+		f.timers = f.timers[1:]
+	}
+}
+
+// Pending returns the number of timers that have not fired or been stopped
+func (f *Fake) Pending() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return len(f.timers)
+}
+
+// WaitForTimers blocks until n timers are pending, so a test can advance
+// the clock once another goroutine is waiting on it
+func (f *Fake) WaitForTimers(n int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for len(f.timers) < n {
+		f.cond.Wait()
+	}
+}
+
+type fakeTimer struct {
+	clock    *Fake
+	deadline time.Time
+	c        chan time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+
+	f := t.clock
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for ndx, timer := range f.timers {
+		if timer == t {
+			f.timers = append(f.timers[:ndx], f.timers[ndx+1:]...)
+			return true
+		}
+	}
+	return false
+}
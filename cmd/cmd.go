@@ -18,14 +18,15 @@ package cmd
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"os"
 	"os/signal"
 	"sync"
 	"syscall"
 	"time"
+	"tsm/clock"
 	"tsm/config"
+	"tsm/events"
 	rlog "tsm/log"
 )
 
@@ -45,8 +46,30 @@ type cmdService struct {
 	TSMCfg      *config.TSMConfig
 	snmpService SNMPService
 	serializer  TSMSerializer
+	store       ScanStore
+	output      RecordWriter
+	processors  []ScanProcessor
+	trapService TrapService
+	bus         *events.Bus
+	simulator   Simulator
+	clock       clock.Clock
+	snippets    bool
+
+	newSession      func() SNMPService
+	discoverWorkers int
+	inventoryPath   string
+
+	model          string
+	modelCachePath string
+	redetect       bool
+
+	loadConfig   func() (*config.TSMConfig, error)
+	buildOptions func(*config.TSMConfig) ([]Option, error)
+	watcher      ConfigWatcher
 }
 
+// SNMPService queries the device, or replays a recorded session. GetScan
+// returns io.EOF when there will be no more scans.
 type SNMPService interface {
 	InitAndConnect(string, string, string) error
 	QueryOids(*[]string) (time.Time, map[string]string, error)
@@ -55,9 +78,79 @@ type SNMPService interface {
 	Close()
 }
 
+// SNMPExplorer is implemented by SNMP services that can walk the device and
+// report the SNMP type of each value, for the walk and get commands
+type SNMPExplorer interface {
+	Walk(string, func(oid, kind, value string) error) error
+	Get([]string, func(oid, kind, value string) error) error
+}
+
+// RatePoller is implemented by SNMP services whose internal polling loop can
+// poll some OIDs less often than the sample interval
+type RatePoller interface {
+	SetPollRates(map[string]time.Duration)
+}
+
+// ScanProcessor consumes the scans output by Poll and may add derived
+// channels to the output record
+type ScanProcessor interface {
+	Process(time.Time, *map[string]string)
+	Derived() []config.DerivedChannel
+	Close() error
+}
+
+// DerivedProcessor is implemented by ScanProcessors that also need the
+// channels derived by every processor for the same scan
+type DerivedProcessor interface {
+	ProcessDerived(time.Time, *map[string]string, []config.DerivedChannel)
+}
+
+// ScanStore keeps a local history of the scans handled by Poll
+type ScanStore interface {
+	Append(time.Time, string, string, *map[string]string, []config.DerivedChannel) error
+	Query(time.Time, time.Time, func(time.Time, string, string, *map[string]string, []config.DerivedChannel) error) error
+	Close() error
+}
+
+// RecordWriter delivers the records output by Poll to downstream consumers
+type RecordWriter interface {
+	WriteRecord(string) error
+	Close() error
+}
+
+// Resumer is implemented by optional features that take over the state or
+// resources of the instance they replace when the daemon reloads. Resume is
+// called with each instance being replaced and ignores those of other types.
+type Resumer interface {
+	Resume(prev interface{})
+}
+
+// VersionSetter is implemented by SNMP services that can connect with an
+// SNMP version other than 2c
+type VersionSetter interface {
+	SetVersion(string) error
+}
+
+// ConfigWatcher reports the path of a config file when it changes
+type ConfigWatcher interface {
+	Changes() <-chan string
+}
+
+// TrapService receives traps and informs until the context is done
+type TrapService interface {
+	ListenTraps(context.Context, func(events.Trap)) error
+}
+
 type TSMCmdService interface {
 	Status() error
 	Poll() error
+	History() error
+	Traps() error
+	Daemon() error
+	Simulate() error
+	Walk() error
+	Get() error
+	Discover() error
 	// MBQuery() error
 }
 
@@ -88,9 +181,10 @@ func NewTSMCmdService(
 	args []string,
 	snmpSvc SNMPService,
 	tsmCfg *config.TSMConfig,
-	serial TSMSerializer) TSMCmdService {
+	serial TSMSerializer,
+	opts ...Option) TSMCmdService {
 
-	return &cmdService{
+	c := &cmdService{
 		Host:        host,
 		Port:        port,
 		Community:   community,
@@ -98,8 +192,142 @@ func NewTSMCmdService(
 		args:        args,
 		TSMCfg:      tsmCfg,
 		serializer:  serial,
+		clock:       clock.New(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// Option enables an optional feature of the command service
+type Option func(*cmdService)
+
+// WithStore records polled scans in a local history
+func WithStore(store ScanStore) Option {
+	return func(c *cmdService) {
+		c.store = store
+	}
+}
+
+// WithOutput sends poll records to out instead of stdout
+func WithOutput(out RecordWriter) Option {
+	return func(c *cmdService) {
+		c.output = out
+	}
+}
+
+// WithTraps receives traps with svc for the traps command
+func WithTraps(svc TrapService) Option {
+	return func(c *cmdService) {
+		c.trapService = svc
+	}
+}
+
+// WithEvents sets the event bus, received traps are published on it
+func WithEvents(bus *events.Bus) Option {
+	return func(c *cmdService) {
+		c.bus = bus
 	}
+}
 
+// WithClock times the poll loop with clk instead of the real clock
+func WithClock(clk clock.Clock) Option {
+	return func(c *cmdService) {
+		c.clock = clk
+	}
+}
+
+// WithSimulator serves the simulated device sim for the simulate command
+func WithSimulator(sim Simulator) Option {
+	return func(c *cmdService) {
+		c.simulator = sim
+	}
+}
+
+// WithSnippets has walk and get also print tsm.toml entries for the OIDs found
+func WithSnippets() Option {
+	return func(c *cmdService) {
+		c.snippets = true
+	}
+}
+
+// WithSessions has discover probe up to workers hosts at once, each with a
+// new SNMP service from newSession
+func WithSessions(newSession func() SNMPService, workers int) Option {
+	return func(c *cmdService) {
+		c.newSession = newSession
+		c.discoverWorkers = workers
+	}
+}
+
+// WithInventory has discover add the devices found to the inventory in the
+// config file at path
+func WithInventory(path string) Option {
+	return func(c *cmdService) {
+		c.inventoryPath = path
+	}
+}
+
+// WithModel skips detecting the model and uses model, a model or model group
+// in the config
+func WithModel(model string) Option {
+	return func(c *cmdService) {
+		c.model = model
+	}
+}
+
+// WithModelCache saves the detected model and serial number of each host in
+// the state file at path and uses them instead of detecting the model again.
+// With redetect the model is detected and saved even if it is cached.
+func WithModelCache(path string, redetect bool) Option {
+	return func(c *cmdService) {
+		c.modelCachePath = path
+		c.redetect = redetect
+	}
+}
+
+// WithProcessors passes each polled scan through procs
+func WithProcessors(procs ...ScanProcessor) Option {
+	return func(c *cmdService) {
+		c.processors = append(c.processors, procs...)
+	}
+}
+
+// WithReload lets the daemon reread the config with load and recreate the
+// optional features from it with build on SIGHUP
+func WithReload(load func() (*config.TSMConfig, error), build func(*config.TSMConfig) ([]Option, error)) Option {
+	return func(c *cmdService) {
+		c.loadConfig = load
+		c.buildOptions = build
+	}
+}
+
+// WithConfigWatcher has the daemon reload the config when w reports a
+// change, as on SIGHUP, but keeping the current config and outputs
+// untouched if the new config is invalid
+func WithConfigWatcher(w ConfigWatcher) Option {
+	return func(c *cmdService) {
+		c.watcher = w
+	}
+}
+
+// closeOptional closes the optional features so they save their state and
+// flush their output
+func (c *cmdService) closeOptional() {
+
+	c.closeProcessors()
+	if c.store != nil {
+		if err := c.store.Close(); err != nil {
+			rlog.ErrMsg(err.Error())
+		}
+	}
+	if c.output != nil {
+		if err := c.output.Close(); err != nil {
+			rlog.ErrMsg(err.Error())
+		}
+	}
 }
 
 func init() {
@@ -152,42 +380,3 @@ func setupSignals(sigs ...os.Signal) chan bool {
 
 	return done
 }
-
-// queryForModel queies the device to see which model OID is provides a response to
-// If OID reporesenting the correct model will trigger a string response containing
-// the specific Model name string
-func (c *cmdService) queryForModel() (string, string, error) {
-
-	var (
-		model      string
-		modelGroup string
-	)
-
-	err := c.snmpService.InitAndConnect(c.Host, c.Port, c.Community)
-	if err != nil {
-		return "", "", err
-	}
-	defer c.snmpService.Close()
-
-	modelGroupOids, modelMap := c.TSMCfg.ModelInfo()
-
-	_, results, err := c.snmpService.QueryOids(modelGroupOids)
-	if err != nil {
-		return "", "", err
-	}
-
-	for _, modinfo := range results {
-		if modinfo != "0" {
-			model = modinfo
-			modelGroup = (*modelMap)[model]
-		}
-	}
-	if model == "" {
-		return "", "", errors.New(fmt.Sprintf("Model not found in Model Group OID list [%v]\n", modelGroupOids))
-	}
-
-	c.TSMCfg.SetModel(modelGroup)
-	rlog.NoticeMsg(fmt.Sprintf("Controller identified as model: %s", modelGroup))
-
-	return model, modelGroup, nil
-}
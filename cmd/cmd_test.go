@@ -0,0 +1,224 @@
+package cmd
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"tsm/clock"
+	"tsm/config"
+	"tsm/config/configtest"
+	"tsm/snmp/snmptest"
+	"tsm/statefile"
+)
+
+// newTestService returns a cmdService for host 127.0.0.1 using the fake
+// SNMP service and the test config
+func newTestService(args []string, svc *snmptest.Service, opts ...Option) *cmdService {
+	return NewTSMCmdService("127.0.0.1", "161", "public", args, svc, configtest.NewConfig(), nil, opts...).(*cmdService)
+}
+
+// recordWriter collects the records written by Poll
+type recordWriter struct {
+	records []string
+	closed  bool
+}
+
+func (w *recordWriter) WriteRecord(rec string) error {
+	w.records = append(w.records, rec)
+	return nil
+}
+
+func (w *recordWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+// storedScan is one scan appended to a scanStore
+type storedScan struct {
+	ts      time.Time
+	quality string
+}
+
+// scanStore collects the scans stored by Poll
+type scanStore struct {
+	scans  []storedScan
+	closed bool
+}
+
+func (s *scanStore) Append(ts time.Time, model, quality string, scan *map[string]string, derived []config.DerivedChannel) error {
+	s.scans = append(s.scans, storedScan{ts: ts.UTC(), quality: quality})
+	return nil
+}
+
+func (s *scanStore) Query(from, to time.Time, fn func(time.Time, string, string, *map[string]string, []config.DerivedChannel) error) error {
+	return nil
+}
+
+func (s *scanStore) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestQueryForModel(t *testing.T) {
+
+	svc := snmptest.NewService(map[string]string{
+		configtest.MPPTGroupOid: "TS-MPPT-60",
+	})
+	c := newTestService([]string{"127.0.0.1", "status"}, svc)
+
+	model, group, err := c.queryForModel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if model != "TS-MPPT-60" || group != "TS-MPPT" {
+		t.Errorf("queryForModel = %s, %s, want TS-MPPT-60, TS-MPPT", model, group)
+	}
+	want := [][]string{{configtest.MPPTGroupOid, configtest.PWMGroupOid}}
+	if !reflect.DeepEqual(svc.Queries, want) {
+		t.Errorf("queries = %v, want %v", svc.Queries, want)
+	}
+	if svc.Connects != 1 || svc.Closes != 1 {
+		t.Errorf("connects %d, closes %d, want 1 each", svc.Connects, svc.Closes)
+	}
+}
+
+func TestQueryForModelNotFound(t *testing.T) {
+
+	svc := snmptest.NewService(nil)
+	c := newTestService([]string{"127.0.0.1", "status"}, svc)
+
+	if _, _, err := c.queryForModel(); err == nil {
+		t.Error("queryForModel found a model on a device without one")
+	}
+
+	svc.QueryErr = errors.New("request timeout")
+	if _, _, err := c.queryForModel(); err != svc.QueryErr {
+		t.Errorf("queryForModel error = %v, want %v", err, svc.QueryErr)
+	}
+}
+
+func TestQueryForModelProbe(t *testing.T) {
+
+	const probeOid = "1.3.6.1.4.1.33333.5.3.0"
+	svc := snmptest.NewService(map[string]string{probeOid: "v1.1"})
+	c := newTestService([]string{"127.0.0.1", "status"}, svc)
+	c.TSMCfg.Oids.DeviceGroups = append(c.TSMCfg.Oids.DeviceGroups,
+		config.DeviceInfo{ModelGroup: "SS-MPPT", Modellist: []string{"SS-MPPT-15L"}, ProbeOid: probeOid})
+	// the current model is global, leave it on a group of the test config
+	defer c.TSMCfg.SetModel("TS-MPPT")
+
+	model, group, err := c.queryForModel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if model != "SS-MPPT-15L" || group != "SS-MPPT" {
+		t.Errorf("queryForModel = %s, %s, want SS-MPPT-15L, SS-MPPT", model, group)
+	}
+	want := [][]string{{configtest.MPPTGroupOid, configtest.PWMGroupOid}, {probeOid}}
+	if !reflect.DeepEqual(svc.Queries, want) {
+		t.Errorf("queries = %v, want %v", svc.Queries, want)
+	}
+}
+
+func TestQueryForModelAmbiguous(t *testing.T) {
+
+	svc := snmptest.NewService(map[string]string{
+		configtest.MPPTGroupOid: "TS-MPPT-60",
+		configtest.PWMGroupOid:  "TS-45",
+	})
+	c := newTestService([]string{"127.0.0.1", "status"}, svc)
+
+	_, _, err := c.queryForModel()
+	if err == nil || !strings.Contains(err.Error(), "several model groups") {
+		t.Errorf("queryForModel error = %v, want several model groups", err)
+	}
+}
+
+func TestQueryForModelOverride(t *testing.T) {
+
+	svc := snmptest.NewService(nil)
+	c := newTestService([]string{"127.0.0.1", "status"}, svc, WithModel("TS-45"))
+	defer c.TSMCfg.SetModel("TS-MPPT")
+
+	model, group, err := c.queryForModel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if model != "TS-45" || group != "TS-PWM" {
+		t.Errorf("queryForModel = %s, %s, want TS-45, TS-PWM", model, group)
+	}
+	if svc.Connects != 0 || len(svc.Queries) != 0 {
+		t.Errorf("device queried with the model given: connects %d, queries %v", svc.Connects, svc.Queries)
+	}
+
+	c.model = "TS-80"
+	if _, _, err := c.queryForModel(); err == nil {
+		t.Error("queryForModel accepted a model not in the config")
+	}
+}
+
+func TestQueryForModelCache(t *testing.T) {
+
+	const serialOid = "1.3.6.1.4.1.33333.2.2.0"
+	path := filepath.Join(t.TempDir(), "tsm-model.json")
+	detected := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(detected)
+
+	svc := snmptest.NewService(map[string]string{
+		configtest.MPPTGroupOid: "TS-MPPT-60",
+		serialOid:               "MPPT1234",
+	})
+	c := newTestService([]string{"127.0.0.1", "poll", "10"}, svc,
+		WithModelCache(path, false), WithClock(clk))
+	c.TSMCfg.Oids.DeviceGroups[0].Static = append(c.TSMCfg.Oids.DeviceGroups[0].Static,
+		config.OidInfo{Oid: serialOid, Label: "Serial number", Type: "string", Scaling: 1})
+
+	if _, _, err := c.queryForModel(); err != nil {
+		t.Fatal(err)
+	}
+	cached := func() cachedModel {
+		var cache map[string]cachedModel
+		if _, err := statefile.Load(path, &cache); err != nil {
+			t.Fatal(err)
+		}
+		return cache["127.0.0.1:161"]
+	}
+	want := cachedModel{Model: "TS-MPPT-60", ModelGroup: "TS-MPPT", Serial: "MPPT1234", Detected: detected}
+	if got := cached(); got != want {
+		t.Errorf("cached %+v, want %+v", got, want)
+	}
+
+	// a restart checks the cached model against its group OID only
+	svc.Queries = nil
+	model, group, err := c.queryForModel()
+	if err != nil || model != "TS-MPPT-60" || group != "TS-MPPT" {
+		t.Errorf("queryForModel from cache = %s, %s, %v, want TS-MPPT-60, TS-MPPT", model, group, err)
+	}
+	if want := [][]string{{configtest.MPPTGroupOid}}; !reflect.DeepEqual(svc.Queries, want) {
+		t.Errorf("queries with the model cached = %v, want %v", svc.Queries, want)
+	}
+
+	// a controller replaced by another model is detected and cached again
+	svc.Values[configtest.MPPTGroupOid] = "TS-MPPT-45"
+	clk.Advance(time.Hour)
+	if model, _, err := c.queryForModel(); err != nil || model != "TS-MPPT-45" {
+		t.Errorf("queryForModel after replacement = %s, %v, want TS-MPPT-45", model, err)
+	}
+	if got := cached(); got.Model != "TS-MPPT-45" || !got.Detected.Equal(detected.Add(time.Hour)) {
+		t.Errorf("cached %+v after replacement, want TS-MPPT-45 detected an hour later", got)
+	}
+
+	// redetect ignores the cache
+	c.redetect = true
+	svc.Queries = nil
+	if _, _, err := c.queryForModel(); err != nil {
+		t.Fatal(err)
+	}
+	if len(svc.Queries) != 2 {
+		t.Errorf("queries with redetect = %v, want the model and serial OIDs", svc.Queries)
+	}
+}
@@ -0,0 +1,307 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"tsm/config"
+	rlog "tsm/log"
+)
+
+// Daemon polls the device like Poll, and also reloads the config and reopens
+// the outputs on SIGHUP and logs its status on SIGUSR1
+func (c *cmdService) Daemon() error {
+
+	st := &pollState{
+		hup:  make(chan os.Signal, 1),
+		usr1: make(chan os.Signal, 1),
+	}
+	signal.Notify(st.hup, syscall.SIGHUP)
+	signal.Notify(st.usr1, syscall.SIGUSR1)
+	defer signal.Stop(st.hup)
+	defer signal.Stop(st.usr1)
+	if c.watcher != nil {
+		st.changes = c.watcher.Changes()
+	}
+
+	return c.poll(st)
+}
+
+// reload rereads the config and recreates the optional features. The SNMP
+// session is kept unless the host, port, community or SNMP version changed,
+// and the internal polling loop is only restarted if the polled OIDs or the
+// session have changed. On a config file change an invalid config, or one
+// whose optional features or session cannot be created, is rejected leaving
+// everything as it was, on SIGHUP the outputs are reopened with the current
+// config.
+func (c *cmdService) reload(st *pollState, changed bool) {
+
+	// loading or building a config selects the model in it
+	defer func() { c.TSMCfg.SetModel(st.modelGroup) }()
+
+	cfg, rates := c.TSMCfg, st.rates
+	if c.loadConfig != nil {
+		newCfg, err := c.loadConfig()
+		switch {
+		case err != nil:
+			rlog.ErrMsg("reload: %s, keeping current config", err.Error())
+		case !hasModelGroup(newCfg, st.modelGroup):
+			rlog.ErrMsg("reload: model %s missing from new config, keeping current config", st.modelGroup)
+		default:
+			newCfg.SetModel(st.modelGroup)
+			if newRates, err := pollRates(newCfg, st.interval); err != nil {
+				rlog.ErrMsg("reload: %s, keeping current config", err.Error())
+				c.TSMCfg.SetModel(st.modelGroup)
+			} else {
+				cfg, rates = newCfg, newRates
+			}
+		}
+	}
+	if changed && cfg == c.TSMCfg {
+		return
+	}
+
+	// the new options are built while the current ones keep running, and
+	// only replace them once all were built
+	next := &cmdService{}
+	if c.buildOptions != nil {
+		opts, err := c.buildOptions(cfg)
+		if err != nil && cfg != c.TSMCfg && !changed {
+			rlog.ErrMsg("reload: %s, keeping current config", err.Error())
+			cfg, rates = c.TSMCfg, st.rates
+			cfg.SetModel(st.modelGroup)
+			opts, err = c.buildOptions(cfg)
+		}
+		if err != nil {
+			rlog.ErrMsg("reload: %s, keeping current config and options", err.Error())
+			return
+		}
+		for _, opt := range opts {
+			opt(next)
+		}
+	}
+
+	// the session is replaced before the options, so a config whose session
+	// cannot be opened is rejected like an invalid one
+	from, to := c.sessionFor(c.TSMCfg), c.sessionFor(cfg)
+	if from != to {
+		c.stopPolling(st)
+		if err := c.reconnect(cfg, from, to, st.modelGroup); err != nil {
+			rlog.ErrMsg("reload: %s, keeping current config", err.Error())
+			next.closeOptional()
+			if err := c.startPolling(st); err != nil {
+				rlog.CritMsg("reload: %s", err.Error())
+			}
+			return
+		}
+	}
+	c.replaceOptional(next)
+
+	c.TSMCfg = cfg
+	cfg.SetModel(st.modelGroup)
+	if from != to {
+		initOids(c)
+		st.rates = rates
+		if err := c.startPolling(st); err != nil {
+			rlog.CritMsg("reload: %s", err.Error())
+		}
+	} else if err := c.reloadOids(st); err != nil {
+		rlog.CritMsg("reload: %s", err.Error())
+	}
+
+	st.reloads++
+	rlog.NoticeMsg("reload complete")
+}
+
+// replaceOptional swaps in the optional features built in next. Each new
+// feature first takes over from the one it replaces, then the old ones are
+// closed.
+func (c *cmdService) replaceOptional(next *cmdService) {
+
+	if r, ok := next.output.(Resumer); ok {
+		r.Resume(c.output)
+	}
+	for _, proc := range next.processors {
+		if r, ok := proc.(Resumer); ok {
+			for _, prev := range c.processors {
+				r.Resume(prev)
+			}
+		}
+	}
+
+	c.closeOptional()
+	c.store, c.output, c.processors, c.bus = next.store, next.output, next.processors, next.bus
+}
+
+// session holds the settings of the SNMP session with the device
+type session struct {
+	host, port, community, version string
+}
+
+// sessionFor returns the session settings in cfg, with those of the running
+// session for the host and community if cfg does not set them
+func (c *cmdService) sessionFor(cfg *config.TSMConfig) session {
+
+	s := session{host: c.Host, port: c.Port, community: c.Community, version: cfg.Version}
+	if host, port, err := cfg.HostPort(); err == nil && host != "" {
+		s.host, s.port = host, port
+	}
+	if cfg.Community != "" {
+		s.community = cfg.Community
+	}
+	return s
+}
+
+// reconnect replaces the SNMP session with one with the settings in to,
+// first checking that a new host is still a modelGroup using the model
+// identification of cfg. If that fails the session is reopened with the
+// settings in from and the error returned.
+func (c *cmdService) reconnect(cfg *config.TSMConfig, from, to session, modelGroup string) error {
+
+	rlog.NoticeMsg("SNMP session settings changed, reconnecting to %s:%s", to.host, to.port)
+
+	c.snmpService.Close()
+	err := c.setSession(to)
+	if err == nil && (to.host != from.host || to.port != from.port) {
+		err = c.checkModel(cfg, modelGroup)
+	}
+	if err == nil {
+		err = c.snmpService.InitAndConnect(c.Host, c.Port, c.Community)
+	}
+	if err == nil {
+		rlog.SetFields("host", c.Host)
+		return nil
+	}
+
+	err = fmt.Errorf("%s:%s: %w", to.host, to.port, err)
+	c.snmpService.Close()
+	rerr := c.setSession(from)
+	if rerr == nil {
+		rerr = c.snmpService.InitAndConnect(c.Host, c.Port, c.Community)
+	}
+	if rerr != nil {
+		return fmt.Errorf("%s, and reopening the session to %s:%s failed: %s", err.Error(), from.host, from.port, rerr.Error())
+	}
+	return err
+}
+
+// setSession sets the settings in s for the next connection
+func (c *cmdService) setSession(s session) error {
+
+	c.Host, c.Port, c.Community = s.host, s.port, s.community
+	if vs, ok := c.snmpService.(VersionSetter); ok {
+		return vs.SetVersion(s.version)
+	}
+	return nil
+}
+
+// checkModel identifies the model of the device with the model groups of
+// cfg and checks it is in modelGroup, as the polled OIDs are those of
+// modelGroup
+func (c *cmdService) checkModel(cfg *config.TSMConfig, modelGroup string) error {
+
+	running := c.TSMCfg
+	c.TSMCfg = cfg
+	model, group, err := c.identifyModel()
+	c.TSMCfg = running
+	if err != nil {
+		return err
+	}
+	if group != modelGroup {
+		return fmt.Errorf("device is a %s (%s), not a %s; restart tsm to poll another model", model, group, modelGroup)
+	}
+	return nil
+}
+
+// reloadOids updates the OID lists from the current config, restarting the
+// internal polling loop only if the polled OIDs or their rates changed
+func (c *cmdService) reloadOids(st *pollState) error {
+
+	rates, err := pollRates(c.TSMCfg, st.interval)
+	if err != nil {
+		return err
+	}
+
+	newStatic, _, err := c.TSMCfg.StaticOidsInfo()
+	if err != nil {
+		return err
+	}
+	newData, _, err := c.TSMCfg.DataOidsInfo()
+	if err != nil {
+		return err
+	}
+
+	if equalOids(append(newStatic, newData...), allOids) && equalRates(rates, st.rates) {
+		// the polling loop reads allOids, so only the info is replaced
+		_, staticOidInfo, _ = c.TSMCfg.StaticOidsInfo()
+		_, dataOidInfo, _ = c.TSMCfg.DataOidsInfo()
+		staticOids, dataOids = newStatic, newData
+		return nil
+	}
+
+	rlog.NoticeMsg("polled OIDs changed, restarting internal polling loop")
+	c.stopPolling(st)
+	initOids(c)
+	st.rates = rates
+
+	return c.startPolling(st)
+}
+
+func equalOids(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for ndx := range a {
+		if a[ndx] != b[ndx] {
+			return false
+		}
+	}
+	return true
+}
+
+func equalRates(a, b map[string]time.Duration) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for oid, rate := range a {
+		if prev, ok := b[oid]; !ok || prev != rate {
+			return false
+		}
+	}
+	return true
+}
+
+// hasModelGroup reports whether cfg has OIDs for modelGroup
+func hasModelGroup(cfg *config.TSMConfig, modelGroup string) bool {
+	for _, devGroup := range cfg.Oids.DeviceGroups {
+		if devGroup.ModelGroup == modelGroup {
+			return true
+		}
+	}
+	return false
+}
+
+// logStatus writes a summary of the running poll loop to the log
+func (c *cmdService) logStatus(st *pollState) {
+
+	rlog.NoticeMsg("status: host %s:%s model %s interval %.0fs up %s",
+		c.Host, c.Port, st.modelGroup, st.interval.Seconds(),
+		c.clock.Now().Sub(st.started).Round(time.Second))
+
+	last := "none"
+	if !st.lastScan.IsZero() {
+		last = fmt.Sprintf("%s (%s ago)", st.lastScan.UTC().Format(time.RFC3339),
+			c.clock.Now().Sub(st.lastScan).Round(time.Second))
+	}
+	rlog.NoticeMsg("status: last scan %s, %d records, %d missing, %d repeated, %d reloads",
+		last, st.records, st.missing, st.repeated, st.reloads)
+
+	rlog.NoticeMsg("status: polling %d oids, store %t, outputs %t, events %t, %d processors",
+		len(allOids), c.store != nil, c.output != nil, c.bus != nil, len(c.processors))
+	for _, proc := range c.processors {
+		rlog.NoticeMsg("status: processor %T", proc)
+	}
+}
@@ -0,0 +1,256 @@
+package cmd
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"tsm/config"
+	"tsm/config/configtest"
+	"tsm/events"
+	"tsm/snmp/snmptest"
+)
+
+// reloadTest is a daemon set up to poll a TS-MPPT, reloading the config
+// returned by load
+type reloadTest struct {
+	c      *cmdService
+	svc    *snmptest.Service
+	st     *pollState
+	out    *recordWriter
+	builds int
+	procs  func(*config.TSMConfig) []ScanProcessor
+	fail   error
+}
+
+func newReloadTest(t *testing.T, load func() (*config.TSMConfig, error)) *reloadTest {
+
+	t.Helper()
+	rt := &reloadTest{svc: snmptest.NewService(nil), out: &recordWriter{}}
+	rt.c = newTestService([]string{"127.0.0.1", "daemon", "10"}, rt.svc, WithOutput(rt.out),
+		WithReload(load, func(cfg *config.TSMConfig) ([]Option, error) {
+			rt.builds++
+			if rt.fail != nil {
+				return nil, rt.fail
+			}
+			rt.out = &recordWriter{}
+			opts := []Option{WithOutput(rt.out)}
+			if rt.procs != nil {
+				opts = append(opts, WithProcessors(rt.procs(cfg)...))
+			}
+			return opts, nil
+		}))
+	rt.c.TSMCfg.SetModel("TS-MPPT")
+	initOids(rt.c)
+
+	rt.st = &pollState{interval: 10 * time.Second, modelGroup: "TS-MPPT"}
+	if err := rt.c.startPolling(rt.st); err != nil {
+		t.Fatal(err)
+	}
+	return rt
+}
+
+func TestReloadChanged(t *testing.T) {
+
+	newCfg := configtest.NewConfig()
+	newCfg.Oids.DeviceGroups[0].Measurements[0].Chancode = "XX"
+	rt := newReloadTest(t, func() (*config.TSMConfig, error) {
+		return newCfg, nil
+	})
+	first := rt.out
+
+	rt.c.reload(rt.st, true)
+	if rt.c.TSMCfg != newCfg || rt.builds != 1 || !first.closed || rt.c.output != rt.out || rt.st.reloads != 1 {
+		t.Errorf("config not reloaded: builds %d, outputs closed %v, reloads %d", rt.builds, first.closed, rt.st.reloads)
+	}
+	changed := newCfg.Oids.DeviceGroups[0].Measurements[0].Oid
+	if info, ok := rt.c.TSMCfg.OidInfoFor(changed); !ok || info.Chancode != "XX" {
+		t.Errorf("chancode after reload = %s, want XX", info.Chancode)
+	}
+	if rt.svc.Connects != 0 {
+		t.Errorf("reconnected %d times without a session change", rt.svc.Connects)
+	}
+}
+
+func TestReloadBuildFails(t *testing.T) {
+
+	newCfg := configtest.NewConfig()
+	rt := newReloadTest(t, func() (*config.TSMConfig, error) {
+		return newCfg, nil
+	})
+	cfg, first := rt.c.TSMCfg, rt.out
+	rt.fail = errors.New("output spool: permission denied")
+
+	// the running options are only replaced once the new ones are created
+	rt.c.reload(rt.st, true)
+	if rt.c.TSMCfg != cfg || rt.builds != 1 || first.closed || rt.c.output != first || rt.st.reloads != 0 {
+		t.Errorf("failed build applied: builds %d, outputs closed %v, reloads %d", rt.builds, first.closed, rt.st.reloads)
+	}
+
+	// SIGHUP falls back to the current config, and keeps the running
+	// options if they cannot be created either
+	rt.c.reload(rt.st, false)
+	if rt.c.TSMCfg != cfg || rt.builds != 3 || first.closed || rt.c.output != first || rt.st.reloads != 0 {
+		t.Errorf("failed SIGHUP build applied: builds %d, outputs closed %v, reloads %d", rt.builds, first.closed, rt.st.reloads)
+	}
+
+	rt.fail = nil
+	rt.c.reload(rt.st, true)
+	if rt.c.TSMCfg != newCfg || !first.closed || rt.c.output != rt.out || rt.st.reloads != 1 {
+		t.Errorf("config not reloaded once the build succeeds")
+	}
+}
+
+func TestReloadKeepsEvents(t *testing.T) {
+
+	newCfg := configtest.NewConfig()
+	rt := newReloadTest(t, func() (*config.TSMConfig, error) {
+		return newCfg, nil
+	})
+	bus := events.NewBus()
+	var sets []string
+	bus.Subscribe(func(ev events.Event) {
+		if ev.Kind == events.KindSet {
+			sets = append(sets, ev.Name)
+		}
+	})
+	rt.procs = func(cfg *config.TSMConfig) []ScanProcessor {
+		return []ScanProcessor{events.NewDetector(cfg, "127.0.0.1", bus)}
+	}
+	rt.c.processors = rt.procs(rt.c.TSMCfg)
+
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	scan := map[string]string{"1.3.6.1.4.1.33333.2.57.0": "1"}
+	rt.c.processors[0].Process(ts, &scan)
+	if len(sets) != 1 {
+		t.Fatalf("%d SET events for the first scan, want 1", len(sets))
+	}
+
+	// the alarm still set after a reload is not published again
+	rt.c.reload(rt.st, true)
+	if rt.c.TSMCfg != newCfg {
+		t.Fatalf("config not reloaded")
+	}
+	rt.c.processors[0].Process(ts.Add(10*time.Second), &scan)
+	if len(sets) != 1 {
+		t.Errorf("SET events %v after reload, want only the first", sets)
+	}
+}
+
+func TestReloadChangedInvalid(t *testing.T) {
+
+	rt := newReloadTest(t, func() (*config.TSMConfig, error) {
+		return nil, errors.New("tsm.toml: (3, 1): invalid")
+	})
+	cfg, first := rt.c.TSMCfg, rt.out
+
+	// a changed file with an invalid config leaves everything as it was
+	rt.c.reload(rt.st, true)
+	if rt.c.TSMCfg != cfg || rt.builds != 0 || first.closed || rt.st.reloads != 0 {
+		t.Errorf("invalid config applied: builds %d, outputs closed %v, reloads %d", rt.builds, first.closed, rt.st.reloads)
+	}
+
+	// SIGHUP still reopens the outputs
+	rt.c.reload(rt.st, false)
+	if rt.c.TSMCfg != cfg || rt.builds != 1 || !first.closed {
+		t.Errorf("SIGHUP did not reopen outputs: builds %d, outputs closed %v", rt.builds, first.closed)
+	}
+}
+
+func TestReloadCommunity(t *testing.T) {
+
+	newCfg := configtest.NewConfig()
+	newCfg.Community = "private"
+	rt := newReloadTest(t, func() (*config.TSMConfig, error) {
+		return newCfg, nil
+	})
+
+	rt.c.reload(rt.st, true)
+	if rt.svc.Closes != 1 || rt.svc.Connects != 1 || rt.svc.Community != "private" || rt.c.Community != "private" {
+		t.Errorf("closes %d, connects %d with %q, want a reconnect with private", rt.svc.Closes, rt.svc.Connects, rt.svc.Community)
+	}
+	if len(rt.svc.PollOids) != len(allOids) {
+		t.Errorf("polling loop not restarted after reconnecting")
+	}
+}
+
+func TestReloadHost(t *testing.T) {
+
+	newCfg := configtest.NewConfig()
+	newCfg.Host = "10.0.0.6:1161"
+	newCfg.Version = "1"
+	rt := newReloadTest(t, func() (*config.TSMConfig, error) {
+		return newCfg, nil
+	})
+	rt.svc.Values = map[string]string{configtest.MPPTGroupOid: "TS-MPPT-45"}
+
+	// the new host is checked to be the same model before polling it
+	rt.c.reload(rt.st, true)
+	if rt.c.TSMCfg != newCfg || rt.st.reloads != 1 {
+		t.Fatalf("config not reloaded")
+	}
+	if rt.svc.Host != "10.0.0.6" || rt.svc.Port != "1161" || rt.svc.Version != "1" || rt.c.Host != "10.0.0.6" {
+		t.Errorf("connected to %s:%s version %q, want 10.0.0.6:1161 version 1", rt.svc.Host, rt.svc.Port, rt.svc.Version)
+	}
+	if len(rt.svc.Queries) != 1 || len(rt.svc.PollOids) != len(allOids) {
+		t.Errorf("%d model queries, polling %d oids, want 1 and a restarted loop", len(rt.svc.Queries), len(rt.svc.PollOids))
+	}
+}
+
+func TestReloadHostFails(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		values map[string]string
+		err    error
+	}{
+		{"unreachable", map[string]string{configtest.MPPTGroupOid: "TS-MPPT-45"}, errors.New("no route to host")},
+		{"other model", map[string]string{configtest.PWMGroupOid: "TS-45"}, nil},
+	}
+	for _, tt := range tests {
+		newCfg := configtest.NewConfig()
+		newCfg.Host = "10.0.0.6"
+		newCfg.Version = "1"
+		rt := newReloadTest(t, func() (*config.TSMConfig, error) {
+			return newCfg, nil
+		})
+		cfg, first := rt.c.TSMCfg, rt.out
+		rt.svc.Values = tt.values
+		rt.svc.ConnectErrs = map[string]error{"10.0.0.6": tt.err}
+
+		// the config is rejected and polling resumes with the old session
+		rt.c.reload(rt.st, true)
+		if rt.c.TSMCfg != cfg || first.closed || rt.c.output != first || rt.st.reloads != 0 {
+			t.Errorf("%s: config applied: outputs closed %v, reloads %d", tt.name, first.closed, rt.st.reloads)
+		}
+		if rt.svc.Host != "127.0.0.1" || rt.svc.Port != "161" || rt.svc.Version != "" || rt.c.Host != "127.0.0.1" {
+			t.Errorf("%s: connected to %s:%s version %q, want the old session", tt.name, rt.svc.Host, rt.svc.Port, rt.svc.Version)
+		}
+		if len(rt.svc.PollOids) != len(allOids) {
+			t.Errorf("%s: polling loop not restarted", tt.name)
+		}
+	}
+}
+
+func TestReloadPollRates(t *testing.T) {
+
+	newCfg := configtest.NewConfig()
+	rt := newReloadTest(t, func() (*config.TSMConfig, error) {
+		return newCfg, nil
+	})
+	bv := "1.3.6.1.4.1.33333.2.38.0"
+
+	// an interval that is not a multiple of the sample interval is rejected
+	newCfg.Poll.Intervals = map[string]float64{"BV": 15}
+	rt.c.reload(rt.st, true)
+	if rt.c.TSMCfg == newCfg || rt.st.reloads != 0 {
+		t.Errorf("config with a 15s interval applied")
+	}
+
+	// a new interval restarts the polling loop with it
+	newCfg.Poll.Intervals = map[string]float64{"BV": 60}
+	rt.c.reload(rt.st, true)
+	if rt.c.TSMCfg != newCfg || rt.svc.PollRates[bv] != time.Minute || rt.st.rates[bv] != time.Minute {
+		t.Errorf("BV polled every %s after reload, want 1m", rt.svc.PollRates[bv])
+	}
+}
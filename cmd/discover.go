@@ -0,0 +1,264 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"tsm/config"
+	rlog "tsm/log"
+)
+
+const (
+	// DefaultDiscoverWorkers is the number of hosts probed at once
+	DefaultDiscoverWorkers = 32
+	// MaxDiscoverHosts limits the size of the network discover will scan
+	MaxDiscoverHosts = 65536
+)
+
+// discoverHosts returns the host addresses in cidr, a network such as
+// 10.0.0.0/24 or a single address. The network and broadcast addresses of
+// IPv4 networks larger than /31 are left out.
+func discoverHosts(cidr string) ([]string, error) {
+
+	if !strings.Contains(cidr, "/") {
+		ip := net.ParseIP(cidr)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid address %s", cidr)
+		}
+		return []string{ip.String()}, nil
+	}
+
+	ip, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	ones, bits := ipnet.Mask.Size()
+	if bits-ones > 16 {
+		return nil, fmt.Errorf("%s has more than %d addresses, use a smaller network", cidr, MaxDiscoverHosts)
+	}
+
+	var hosts []string
+	for addr := ip.Mask(ipnet.Mask); ipnet.Contains(addr); addr = nextIP(addr) {
+		hosts = append(hosts, addr.String())
+	}
+	if bits == 32 && len(hosts) > 2 {
+		hosts = hosts[1 : len(hosts)-1]
+	}
+	return hosts, nil
+}
+
+// nextIP returns the address after ip
+func nextIP(ip net.IP) net.IP {
+
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for ndx := len(next) - 1; ndx >= 0; ndx-- {
+		next[ndx]++
+		if next[ndx] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+// oidWithLabel returns the OID of the first of list whose label contains
+// any of words, ignoring case
+func oidWithLabel(list []config.OidInfo, words ...string) string {
+
+	for _, oidinfo := range list {
+		label := strings.ToLower(oidinfo.Label)
+		for _, word := range words {
+			if strings.Contains(label, word) {
+				return oidinfo.Oid
+			}
+		}
+	}
+	return ""
+}
+
+// probeOids returns the OIDs queried on each host: the EMC-1 serial number
+// and firmware version, and the model, probe and serial number OIDs of each
+// model group
+func (c *cmdService) probeOids() (oids []string, emcSerial, emcFirmware string, serials map[string]string) {
+
+	emcSerial = oidWithLabel(c.TSMCfg.Oids.EMCOids, "serial")
+	emcFirmware = oidWithLabel(c.TSMCfg.Oids.EMCOids, "fw", "firmware")
+	modelGroupOids, _ := c.TSMCfg.ModelInfo()
+
+	serials = make(map[string]string)
+	for _, devGroup := range c.TSMCfg.Oids.DeviceGroups {
+		if oid := oidWithLabel(devGroup.Static, "serial"); oid != "" {
+			serials[devGroup.ModelGroup] = oid
+		}
+	}
+
+	seen := make(map[string]bool)
+	add := func(oid string) {
+		if oid != "" && !seen[oid] {
+			seen[oid] = true
+			oids = append(oids, oid)
+		}
+	}
+	add(emcSerial)
+	add(emcFirmware)
+	for _, oid := range *modelGroupOids {
+		add(oid)
+	}
+	for _, oid := range c.TSMCfg.ProbeOids() {
+		add(oid)
+	}
+	for _, devGroup := range c.TSMCfg.Oids.DeviceGroups {
+		add(serials[devGroup.ModelGroup])
+	}
+	return oids, emcSerial, emcFirmware, serials
+}
+
+// answered returns a result, or "" for an OID the device does not have
+func answered(results map[string]string, oid string) string {
+	if val := results[oid]; val != "0" {
+		return val
+	}
+	return ""
+}
+
+// probe queries host for the EMC-1 and controller identity. It returns
+// false if the host does not answer or is not an EMC-1.
+func (c *cmdService) probe(svc SNMPService, host string) (config.InventoryDevice, bool) {
+
+	oids, emcSerial, emcFirmware, serials := c.probeOids()
+
+	if err := svc.InitAndConnect(host, c.Port, c.Community); err != nil {
+		rlog.DebugMsg("debug: %s:%s: %s", host, c.Port, err.Error())
+		return config.InventoryDevice{}, false
+	}
+	defer svc.Close()
+
+	_, results, err := svc.QueryOids(&oids)
+	if err != nil {
+		rlog.DebugMsg("debug: %s:%s: %s", host, c.Port, err.Error())
+		return config.InventoryDevice{}, false
+	}
+
+	dev := config.InventoryDevice{
+		Host:        net.JoinHostPort(host, c.Port),
+		EMCSerial:   answered(results, emcSerial),
+		EMCFirmware: answered(results, emcFirmware),
+		Discovered:  c.clock.Now().UTC(),
+	}
+	model, modelGroup, err := c.TSMCfg.IdentifyModel(results)
+	if err == nil {
+		dev.Model, dev.ModelGroup = model, modelGroup
+		dev.Serial = answered(results, serials[modelGroup])
+	} else if !errors.Is(err, config.ErrNoModel) {
+		rlog.WarningMsg("%s: %s", dev.Host, err.Error())
+	}
+
+	if dev.EMCSerial == "" && dev.Model == "" {
+		rlog.DebugMsg("debug: %s answered but is not an EMC-1", dev.Host)
+		return config.InventoryDevice{}, false
+	}
+	return dev, true
+}
+
+// discover probes hosts with a session per worker at a time, returning the
+// EMC-1s found in the order of hosts
+func (c *cmdService) discover(hosts []string) []config.InventoryDevice {
+
+	found := make([]*config.InventoryDevice, len(hosts))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+
+	workers := c.discoverWorkers
+	if workers < 1 {
+		workers = DefaultDiscoverWorkers
+	}
+	if workers > len(hosts) {
+		workers = len(hosts)
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ndx := range jobs {
+				if dev, ok := c.probe(c.newSession(), hosts[ndx]); ok {
+					found[ndx] = &dev
+				}
+			}
+		}()
+	}
+	for ndx := range hosts {
+		jobs <- ndx
+	}
+	close(jobs)
+	wg.Wait()
+
+	devices := make([]config.InventoryDevice, 0)
+	for _, dev := range found {
+		if dev != nil {
+			devices = append(devices, *dev)
+		}
+	}
+	return devices
+}
+
+// Discover scans the network given on the command line for EMC-1 bridges,
+// listing each with the controller behind it, and writes them to the
+// config inventory if enabled
+func (c *cmdService) Discover() error {
+	return c.discoverTo(os.Stdout)
+}
+
+func (c *cmdService) discoverTo(w io.Writer) error {
+
+	if c.newSession == nil {
+		return errors.New("discover needs live SNMP sessions")
+	}
+	if len(c.args) < 2 {
+		return errors.New("not enough parameters, a network such as 10.0.0.0/24 must be specified")
+	}
+
+	hosts, err := discoverHosts(c.args[1])
+	if err != nil {
+		return err
+	}
+	rlog.NoticeMsg("probing %d hosts in %s on port %s", len(hosts), c.args[1], c.Port)
+
+	devices := c.discover(hosts)
+	writeInventory(w, devices)
+	rlog.NoticeMsg("found %d EMC-1s in %s", len(devices), c.args[1])
+
+	if c.inventoryPath == "" || len(devices) == 0 {
+		return nil
+	}
+	if err := config.WriteInventory(c.inventoryPath, config.MergeInventory(c.TSMCfg.Inventory, devices)); err != nil {
+		return err
+	}
+	rlog.NoticeMsg("inventory written to %s", c.inventoryPath)
+	return nil
+}
+
+// writeInventory writes devices as a table
+func writeInventory(w io.Writer, devices []config.InventoryDevice) {
+
+	format := "%-22s %-12s %-12s %-24s %s\n"
+	fmt.Fprintf(w, format, "HOST", "EMC SERIAL", "EMC FW", "MODEL", "SERIAL")
+	for _, dev := range devices {
+		fmt.Fprintf(w, format, dev.Host, orDash(dev.EMCSerial), orDash(dev.EMCFirmware), orDash(dev.Model), orDash(dev.Serial))
+	}
+}
+
+// orDash returns str, or "-" if it is empty
+func orDash(str string) string {
+	if str == "" {
+		return "-"
+	}
+	return str
+}
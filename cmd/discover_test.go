@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"tsm/clock"
+	"tsm/config"
+	"tsm/config/configtest"
+	"tsm/snmp/snmptest"
+)
+
+func TestDiscoverHosts(t *testing.T) {
+
+	tests := []struct {
+		cidr string
+		want []string
+	}{
+		{"10.0.0.5", []string{"10.0.0.5"}},
+		{"10.0.0.5/32", []string{"10.0.0.5"}},
+		{"10.0.0.4/31", []string{"10.0.0.4", "10.0.0.5"}},
+		{"10.0.0.5/29", []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5", "10.0.0.6"}},
+		{"10.0.0.252/30", []string{"10.0.0.253", "10.0.0.254"}},
+		{"10.0.0.255/30", []string{"10.0.0.253", "10.0.0.254"}},
+	}
+	for _, tt := range tests {
+		got, err := discoverHosts(tt.cidr)
+		if err != nil {
+			t.Errorf("discoverHosts(%s): %s", tt.cidr, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("discoverHosts(%s) = %v, want %v", tt.cidr, got, tt.want)
+		}
+	}
+
+	if hosts, err := discoverHosts("10.0.0.0/16"); err != nil || len(hosts) != 65534 {
+		t.Errorf("discoverHosts(10.0.0.0/16) = %d hosts, %v, want 65534", len(hosts), err)
+	}
+	for _, cidr := range []string{"10.0.0.0/8", "10.0.0.300", "emc1/24"} {
+		if _, err := discoverHosts(cidr); err == nil {
+			t.Errorf("discoverHosts(%s) should fail", cidr)
+		}
+	}
+}
+
+// lanSession is an SNMP session to one of the devices on a fake network.
+// Hosts without a device time out.
+type lanSession struct {
+	*snmptest.Service
+	lan map[string]map[string]string
+}
+
+func (s *lanSession) InitAndConnect(host, port, community string) error {
+	if values, ok := s.lan[host]; ok {
+		s.Service = snmptest.NewService(values)
+	} else {
+		s.Service.QueryErr = errors.New("request timeout")
+	}
+	return s.Service.InitAndConnect(host, port, community)
+}
+
+func TestDiscover(t *testing.T) {
+
+	lan := map[string]map[string]string{
+		"10.0.0.2": {
+			"1.3.6.1.4.1.33333.1.1.0": "EMC0002",
+			"1.3.6.1.4.1.33333.1.2.0": "v1.2",
+			configtest.MPPTGroupOid:   "TS-MPPT-60",
+			"1.3.6.1.4.1.33333.2.2.0": "MPPT1234",
+		},
+		"10.0.0.5": {
+			"1.3.6.1.4.1.33333.1.1.0": "EMC0005",
+			"1.3.6.1.4.1.33333.1.2.0": "v1.3",
+			configtest.PWMGroupOid:    "TS-45",
+			"1.3.6.1.4.1.33333.8.2.0": "PWM5678",
+		},
+		// an EMC-1 without a controller
+		"10.0.0.6": {"1.3.6.1.4.1.33333.1.1.0": "EMC0006"},
+		// some other SNMP agent
+		"10.0.0.3": {"1.3.6.1.2.1.1.1.0": "printer"},
+	}
+	newSession := func() SNMPService {
+		return &lanSession{Service: snmptest.NewService(nil), lan: lan}
+	}
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	c := newTestService([]string{"discover", "10.0.0.0/29"}, nil,
+		WithSessions(newSession, 3), WithClock(clock.NewFake(start)))
+	c.Port = "1161"
+	c.TSMCfg.Oids.EMCOids = append(c.TSMCfg.Oids.EMCOids,
+		config.OidInfo{Oid: "1.3.6.1.4.1.33333.1.2.0", Label: "EMC-1 FW Version", Type: "string", Scaling: 1})
+	c.TSMCfg.Oids.DeviceGroups[1].Static = append(c.TSMCfg.Oids.DeviceGroups[1].Static,
+		config.OidInfo{Oid: "1.3.6.1.4.1.33333.8.2.0", Label: "Serial number", Type: "string", Scaling: 1})
+
+	var buf bytes.Buffer
+	if err := c.discoverTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []config.InventoryDevice{
+		{Host: "10.0.0.2:1161", EMCSerial: "EMC0002", EMCFirmware: "v1.2", Model: "TS-MPPT-60", ModelGroup: "TS-MPPT", Serial: "MPPT1234", Discovered: start},
+		{Host: "10.0.0.5:1161", EMCSerial: "EMC0005", EMCFirmware: "v1.3", Model: "TS-45", ModelGroup: "TS-PWM", Serial: "PWM5678", Discovered: start},
+		{Host: "10.0.0.6:1161", EMCSerial: "EMC0006", Discovered: start},
+	}
+	hosts := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5", "10.0.0.6"}
+	if got := c.discover(hosts); !reflect.DeepEqual(got, want) {
+		t.Errorf("discover = %+v, want %+v", got, want)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("discover output should have a header and 3 devices:\n%s", buf.String())
+	}
+	if fields := strings.Fields(lines[3]); !reflect.DeepEqual(fields, []string{"10.0.0.6:1161", "EMC0006", "-", "-", "-"}) {
+		t.Errorf("EMC-1 without a controller listed as %v", fields)
+	}
+}
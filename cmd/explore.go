@@ -0,0 +1,206 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"tsm/config"
+	rlog "tsm/log"
+)
+
+// DefaultWalkRoot is the Morningstar enterprise subtree walked when no OID
+// is given
+const DefaultWalkRoot = "1.3.6.1.4.1.33333"
+
+// explored is one OID returned by walk or get
+type explored struct {
+	oid   string
+	kind  string
+	value string
+	info  config.OidInfo
+	known bool
+}
+
+// explorer returns the SNMP service as an SNMPExplorer, connected to the
+// device, with the model group set if the device reports one
+func (c *cmdService) explorer() (SNMPExplorer, error) {
+
+	exp, ok := c.snmpService.(SNMPExplorer)
+	if !ok {
+		return nil, errors.New("walk and get need a live SNMP session")
+	}
+
+	rlog.NoticeMsg(fmt.Sprintf("running %s command on host: %s:%s\n", c.args[1], c.Host, c.Port))
+
+	if _, _, err := c.queryForModel(); err != nil {
+		rlog.WarningMsg("%s, only EMC-1 OIDs will be decoded", err.Error())
+	}
+	if err := c.snmpService.InitAndConnect(c.Host, c.Port, c.Community); err != nil {
+		return nil, err
+	}
+
+	return exp, nil
+}
+
+// describe looks up oid in the config, in the current model group first
+func (c *cmdService) describe(oid, kind, value string) explored {
+
+	ex := explored{oid: oid, kind: kind, value: value}
+	if info, ok := c.TSMCfg.OidInfoFor(oid); ok {
+		ex.info, ex.known = info, true
+	} else if info, ok := c.TSMCfg.OidInfoAnyGroup(oid); ok {
+		ex.info, ex.known = info, true
+	}
+	return ex
+}
+
+// Walk lists the OIDs under the root given on the command line, the
+// Morningstar subtree by default
+func (c *cmdService) Walk() error {
+	return c.walk(os.Stdout)
+}
+
+func (c *cmdService) walk(w io.Writer) error {
+
+	exp, err := c.explorer()
+	if err != nil {
+		return err
+	}
+	defer c.snmpService.Close()
+
+	// chancodes and labels are looked up in the model group explorer set
+	root := DefaultWalkRoot
+	if len(c.args) > 2 {
+		root = c.args[2]
+		if info, ok := c.TSMCfg.FindOid(root); ok {
+			root = info.Oid
+		}
+	}
+
+	var found []explored
+	err = exp.Walk(root, func(oid, kind, value string) error {
+		ex := c.describe(oid, kind, value)
+		writeExplored(w, ex)
+		found = append(found, ex)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(found) == 0 {
+		return fmt.Errorf("nothing found under %s", root)
+	}
+
+	if c.snippets {
+		writeSnippets(w, found)
+	}
+	return nil
+}
+
+// Get queries the OIDs, chancodes or labels given on the command line
+func (c *cmdService) Get() error {
+	return c.get(os.Stdout)
+}
+
+func (c *cmdService) get(w io.Writer) error {
+
+	if len(c.args) < 3 {
+		return errors.New("not enough parameters, at least one oid or label must be specified")
+	}
+
+	exp, err := c.explorer()
+	if err != nil {
+		return err
+	}
+	defer c.snmpService.Close()
+
+	oids := make([]string, 0, len(c.args)-2)
+	for _, name := range c.args[2:] {
+		if info, ok := c.TSMCfg.FindOid(name); ok {
+			oids = append(oids, info.Oid)
+		} else if isOid(name) {
+			oids = append(oids, strings.TrimPrefix(name, "."))
+		} else {
+			return fmt.Errorf("%s is not an OID, or a chancode or label in the config", name)
+		}
+	}
+
+	var found []explored
+	err = exp.Get(oids, func(oid, kind, value string) error {
+		ex := c.describe(oid, kind, value)
+		writeExplored(w, ex)
+		if !missingKind(kind) {
+			found = append(found, ex)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if c.snippets {
+		writeSnippets(w, found)
+	}
+	return nil
+}
+
+// isOid reports whether name is a dotted numeric OID
+func isOid(name string) bool {
+
+	name = strings.TrimPrefix(name, ".")
+	if name == "" {
+		return false
+	}
+	for _, part := range strings.Split(name, ".") {
+		if part == "" || strings.Trim(part, "0123456789") != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// missingKind reports whether an SNMP type is an exception for an OID the
+// device does not have
+func missingKind(kind string) bool {
+	return kind == "NoSuchObject" || kind == "NoSuchInstance" || kind == "EndOfMibView"
+}
+
+// writeExplored writes an OID with its raw value, and the label and decoded
+// value if it is in the config
+func writeExplored(w io.Writer, ex explored) {
+
+	if missingKind(ex.kind) {
+		fmt.Fprintf(w, "%-28s %s\n", ex.oid, ex.kind)
+		return
+	}
+	fmt.Fprintf(w, "%-28s %-16s %s", ex.oid, ex.kind, ex.value)
+	if ex.known {
+		decoded := strings.TrimSpace(ex.info.ValueString(ex.value))
+		if ex.info.Units != "" {
+			decoded += " " + ex.info.Units
+		}
+		fmt.Fprintf(w, "  (%s: %s)", ex.info.Label, decoded)
+	}
+	fmt.Fprintln(w)
+}
+
+// writeSnippets writes tsm.toml OidInfo entries for the OIDs found, as
+// configured for known OIDs and with a type guessed from the SNMP type for
+// the rest, ready to be pasted into a device group
+func writeSnippets(w io.Writer, found []explored) {
+
+	fmt.Fprintf(w, "\n# tsm.toml entries, set the labels, units, scaling and chancodes\n")
+	for _, ex := range found {
+		info := ex.info
+		if !ex.known {
+			info = config.OidInfo{Oid: ex.oid, Type: "number", Scaling: 1.0}
+			if ex.kind == "OctetString" {
+				info.Type = "string"
+			}
+		}
+		fmt.Fprintf(w, "%s,\n", info.TOML())
+	}
+}
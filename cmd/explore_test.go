@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"tsm/config/configtest"
+	"tsm/snmp/snmptest"
+)
+
+// mpptValues are the OIDs of a TS-MPPT with one OID not in the config
+var mpptValues = map[string]string{
+	configtest.MPPTGroupOid:    "TS-MPPT-60",
+	"1.3.6.1.4.1.33333.2.38.0": "2300",
+	"1.3.6.1.4.1.33333.2.46.0": "7",
+	"1.3.6.1.4.1.33333.2.99.0": "42",
+	"1.3.6.1.4.1.33333.1.1.0":  "EMC123",
+}
+
+func TestWalk(t *testing.T) {
+
+	svc := snmptest.NewService(mpptValues)
+	c := newTestService([]string{"127.0.0.1", "walk", "1.3.6.1.4.1.33333.2"}, svc, WithSnippets())
+
+	var buf bytes.Buffer
+	if err := c.walk(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+
+	wantPrefixes := []string{
+		"1.3.6.1.4.1.33333.2.1.0",
+		"1.3.6.1.4.1.33333.2.38.0",
+		"1.3.6.1.4.1.33333.2.46.0",
+		"1.3.6.1.4.1.33333.2.99.0",
+	}
+	for ndx, want := range wantPrefixes {
+		if ndx >= len(lines) || !strings.HasPrefix(lines[ndx], want+" ") {
+			t.Fatalf("walk output line %d should start with %s:\n%s", ndx, want, buf.String())
+		}
+	}
+	if !strings.Contains(lines[1], "(Battery voltage: 12.6 volts)") {
+		t.Errorf("battery voltage not decoded: %s", lines[1])
+	}
+	if !strings.Contains(lines[2], "(Charge State: float)") {
+		t.Errorf("charge state not decoded: %s", lines[2])
+	}
+	if strings.Contains(lines[3], "(") {
+		t.Errorf("unknown OID decoded: %s", lines[3])
+	}
+	if !strings.Contains(buf.String(), `{ oid = "1.3.6.1.4.1.33333.2.99.0", chancode = "", label = "", units = "", type = "number", scaling = 1.0 },`) {
+		t.Errorf("no snippet for the unknown OID:\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), `{ oid = "1.3.6.1.4.1.33333.2.38.0", chancode = "BV", label = "Battery voltage", units = "volts", type = "number", scaling = 0.005493164 },`) {
+		t.Errorf("no snippet for battery voltage:\n%s", buf.String())
+	}
+}
+
+func TestWalkChancode(t *testing.T) {
+
+	svc := snmptest.NewService(mpptValues)
+	c := newTestService([]string{"127.0.0.1", "walk", "BV"}, svc)
+	// BV is only in the TS-MPPT group, selected once the device is queried
+	c.TSMCfg.SetModel("TS-PWM")
+
+	var buf bytes.Buffer
+	if err := c.walk(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 || !strings.HasPrefix(lines[0], "1.3.6.1.4.1.33333.2.38.0 ") {
+		t.Errorf("walk of BV should list battery voltage only:\n%s", buf.String())
+	}
+}
+
+func TestWalkNothingFound(t *testing.T) {
+
+	svc := snmptest.NewService(mpptValues)
+	c := newTestService([]string{"127.0.0.1", "walk", "1.3.6.1.4.1.33333.9"}, svc)
+
+	var buf bytes.Buffer
+	if err := c.walk(&buf); err == nil {
+		t.Error("walk of an empty subtree should fail")
+	}
+}
+
+func TestGet(t *testing.T) {
+
+	svc := snmptest.NewService(mpptValues)
+	c := newTestService([]string{"127.0.0.1", "get", "bv", "Charge State", ".1.3.6.1.4.1.33333.2.99.0", "1.3.6.1.4.1.33333.2.98.0"}, svc)
+
+	var buf bytes.Buffer
+	if err := c.get(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("get output should have 4 lines:\n%s", buf.String())
+	}
+	if !strings.HasPrefix(lines[0], "1.3.6.1.4.1.33333.2.38.0 ") || !strings.Contains(lines[0], "12.6 volts") {
+		t.Errorf("chancode not resolved: %s", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], "1.3.6.1.4.1.33333.2.46.0 ") || !strings.Contains(lines[1], "(Charge State: float)") {
+		t.Errorf("label not resolved: %s", lines[1])
+	}
+	if !strings.HasPrefix(lines[2], "1.3.6.1.4.1.33333.2.99.0 ") {
+		t.Errorf("OID with a leading dot not queried: %s", lines[2])
+	}
+	if !strings.Contains(lines[3], "NoSuchObject") {
+		t.Errorf("missing OID not reported: %s", lines[3])
+	}
+}
+
+func TestGetUnknownName(t *testing.T) {
+
+	svc := snmptest.NewService(mpptValues)
+	c := newTestService([]string{"127.0.0.1", "get", "nosuchlabel"}, svc)
+
+	var buf bytes.Buffer
+	if err := c.get(&buf); err == nil {
+		t.Error("get of an unknown label should fail")
+	}
+}
+
+func TestIsOid(t *testing.T) {
+
+	for name, want := range map[string]bool{
+		"1.3.6.1": true, ".1.3.6.1": true, "BV": false, "1..3": false, "": false, "1.3.x": false,
+	} {
+		if got := isOid(name); got != want {
+			t.Errorf("isOid(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
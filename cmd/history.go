@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"tsm/config"
+	rlog "tsm/log"
+)
+
+// defaultHistorySpan is how far back history goes when no start time is given
+const defaultHistorySpan = 24 * time.Hour
+
+// historyPoint is one value of the requested channel
+type historyPoint struct {
+	Time    time.Time `json:"time"`
+	Channel string    `json:"channel"`
+	Value   string    `json:"value"`
+	Units   string    `json:"units"`
+	Quality string    `json:"quality"`
+}
+
+// parseHistoryTime accepts RFC3339, "2006-01-02T15:04:05", "2006-01-02" (all UTC
+// unless a zone is given) or a duration such as "36h" meaning that long before now
+func parseHistoryTime(tstr string, now time.Time) (time.Time, error) {
+
+	if dur, err := time.ParseDuration(tstr); err == nil {
+		return now.Add(-dur), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"} {
+		if ts, err := time.Parse(layout, tstr); err == nil {
+			return ts, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, use RFC3339, YYYY-MM-DD[THH:MM:SS] or a duration before now", tstr)
+}
+
+// historyArgsParse parses: history <channel> [from] [to] [text|csv|json]
+func historyArgsParse(args []string, now time.Time) (string, time.Time, time.Time, string, error) {
+
+	var err error
+
+	if len(args) < 2 {
+		return "", time.Time{}, time.Time{}, "", errors.New("not enough parameters, channel must be specified")
+	}
+	channel := args[1]
+	from := now.Add(-defaultHistorySpan)
+	to := now
+	format := "text"
+
+	if len(args) > 2 {
+		if from, err = parseHistoryTime(args[2], now); err != nil {
+			return "", from, to, "", err
+		}
+	}
+	if len(args) > 3 {
+		if to, err = parseHistoryTime(args[3], now); err != nil {
+			return "", from, to, "", err
+		}
+	}
+	if len(args) > 4 {
+		format = args[4]
+	}
+	switch format {
+	case "text", "csv", "json":
+	default:
+		return "", from, to, "", fmt.Errorf("invalid history format %q, must be text, csv or json", format)
+	}
+
+	return channel, from, to, format, nil
+}
+
+// History writes the stored values of one channel over a time range to stdout
+func (c *cmdService) History() error {
+
+	if c.store == nil {
+		return errors.New("history requires the [store] section to be enabled in the config")
+	}
+
+	channel, from, to, format, err := historyArgsParse(c.args, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	rlog.NoticeMsg("history of %s from %s to %s", channel, from.Format(time.RFC3339), to.Format(time.RFC3339))
+
+	points := make([]historyPoint, 0)
+	curModel := ""
+	scanned, known := false, false
+	err = c.store.Query(from, to, func(ts time.Time, model, quality string, scan *map[string]string, derived []config.DerivedChannel) error {
+
+		if model != "" && model != curModel {
+			c.TSMCfg.SetModel(model)
+			curModel = model
+		}
+
+		if quality != config.QualityMissing {
+			scanned = true
+		}
+		pt := historyPoint{Time: ts, Channel: channel, Quality: quality}
+		if oidinfo, ok := c.TSMCfg.FindOid(channel); ok {
+			known = true
+			pt.Units = oidinfo.Units
+			if raw, ok := (*scan)[oidinfo.Oid]; ok {
+				pt.Value = oidinfo.ValueString(raw)
+			}
+		} else {
+			found := false
+			for _, dc := range derived {
+				if strings.EqualFold(dc.Chancode, channel) || strings.EqualFold(dc.Label, channel) {
+					pt.Value, pt.Units = dc.ValueString(), dc.Units
+					found, known = true, true
+					break
+				}
+			}
+			if !found && quality != config.QualityMissing {
+				return nil
+			}
+		}
+		points = append(points, pt)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// a channel in no stored scan would only list the missing scans
+	if scanned && !known {
+		return fmt.Errorf("unknown channel %q, not an OID, chancode, label or derived channel of the stored scans", channel)
+	}
+
+	return writeHistory(os.Stdout, format, points)
+}
+
+func writeHistory(w io.Writer, format string, points []historyPoint) error {
+
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"time", "channel", "value", "units", "quality"})
+		for _, pt := range points {
+			cw.Write([]string{pt.Time.Format(time.RFC3339), pt.Channel, strings.TrimSpace(pt.Value), pt.Units, pt.Quality})
+		}
+		cw.Flush()
+		return cw.Error()
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(points)
+	default:
+		for _, pt := range points {
+			fmt.Fprintf(w, "%s  %10s %-6s %s\n",
+				pt.Time.Format("2006-01-02 15:04:05 MST"), strings.TrimSpace(pt.Value), pt.Units, pt.Quality)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"tsm/config"
+	"tsm/store"
+)
+
+func TestHistoryUnknownChannel(t *testing.T) {
+
+	cfg := config.NewConfig()
+	cfg.Oids.DeviceGroups = []config.DeviceInfo{{
+		ModelGroup: "TS-MPPT",
+		Measurements: []config.OidInfo{
+			{Oid: "1.3.6.1.4.1.33333.2.38.0", Chancode: "BV", Label: "Battery voltage", Units: "volts", Type: "number", Scaling: 0.005493164},
+		},
+	}}
+	st, err := store.NewStore(&config.StoreConfig{Enabled: true, Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ts := time.Now().UTC().Add(-time.Hour)
+	if err := st.Append(ts, "TS-MPPT", config.QualityOK, &map[string]string{"1.3.6.1.4.1.33333.2.38.0": "2300"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Append(ts.Add(time.Minute), "TS-MPPT", config.QualityMissing, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &cmdService{TSMCfg: cfg, store: st, args: []string{"history", "BV", "2h"}}
+	if err := c.History(); err != nil {
+		t.Errorf("history of BV: %s", err)
+	}
+	c.args = []string{"history", "XX", "2h"}
+	if err := c.History(); err == nil || !strings.Contains(err.Error(), "unknown channel") {
+		t.Errorf("history of an unknown channel: error %v, want unknown channel", err)
+	}
+}
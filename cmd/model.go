@@ -0,0 +1,186 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"tsm/config"
+	rlog "tsm/log"
+	"tsm/statefile"
+)
+
+// cachedModel is the model detected on a host, saved in the model cache so
+// a restart need not detect it again
+type cachedModel struct {
+	Model      string
+	ModelGroup string
+	Serial     string
+	Detected   time.Time
+}
+
+// queryForModel identifies the model of the device and sets the model group
+// in the config. The model is the one given with --model, else the one
+// cached for the host if the device still answers it, else the one the
+// device answers to the model and probe OIDs with.
+func (c *cmdService) queryForModel() (string, string, error) {
+
+	model, modelGroup, err := c.identifyModel()
+	if err != nil {
+		return "", "", err
+	}
+
+	c.TSMCfg.SetModel(modelGroup)
+	rlog.SetFields("model", modelGroup)
+	rlog.NoticeMsg(fmt.Sprintf("Controller identified as model: %s (%s)", model, modelGroup))
+
+	return model, modelGroup, nil
+}
+
+func (c *cmdService) identifyModel() (string, string, error) {
+
+	if c.model != "" {
+		model, modelGroup, ok := c.TSMCfg.ResolveModel(c.model)
+		if !ok {
+			return "", "", fmt.Errorf("model %s is not a model or model group in the config", c.model)
+		}
+		return model, modelGroup, nil
+	}
+
+	if err := c.snmpService.InitAndConnect(c.Host, c.Port, c.Community); err != nil {
+		return "", "", err
+	}
+	defer c.snmpService.Close()
+
+	if c.modelCachePath != "" && !c.redetect {
+		if cached, ok := c.cachedModel(); ok && c.confirmModel(cached) {
+			rlog.NoticeMsg("using model %s, serial %s, detected %s, from %s", cached.Model,
+				cached.Serial, cached.Detected.Format(time.RFC3339), c.modelCachePath)
+			return cached.Model, cached.ModelGroup, nil
+		}
+	}
+
+	model, modelGroup, serial, err := c.detectModel()
+	if err != nil {
+		return "", "", err
+	}
+	if c.modelCachePath != "" {
+		c.cacheModel(cachedModel{Model: model, ModelGroup: modelGroup, Serial: serial, Detected: c.clock.Now().UTC()})
+	}
+	return model, modelGroup, nil
+}
+
+// detectModel queries the model OIDs of the model groups and, if none
+// answered, the probe OIDs. With the model cache enabled it also reads the
+// serial number of the model found.
+func (c *cmdService) detectModel() (model, modelGroup, serial string, err error) {
+
+	modelGroupOids, _ := c.TSMCfg.ModelInfo()
+
+	_, results, err := c.snmpService.QueryOids(modelGroupOids)
+	if err != nil {
+		return "", "", "", err
+	}
+	model, modelGroup, err = c.TSMCfg.IdentifyModel(results)
+
+	// devices without a model OID answer a product ID or other probe OID
+	if probeOids := c.TSMCfg.ProbeOids(); errors.Is(err, config.ErrNoModel) && len(probeOids) > 0 {
+		_, probed, qerr := c.snmpService.QueryOids(&probeOids)
+		if qerr != nil {
+			return "", "", "", qerr
+		}
+		model, modelGroup, err = c.TSMCfg.IdentifyModel(probed)
+	}
+	if err != nil {
+		return "", "", "", fmt.Errorf("%s:%s: %s, give the model with --model", c.Host, c.Port, err.Error())
+	}
+
+	if c.modelCachePath == "" {
+		return model, modelGroup, "", nil
+	}
+	if devGroup, ok := c.modelGroup(modelGroup); ok {
+		if oid := oidWithLabel(devGroup.Static, "serial"); oid != "" {
+			if _, results, err := c.snmpService.QueryOids(&[]string{oid}); err == nil {
+				serial = answered(results, oid)
+			}
+		}
+	}
+	return model, modelGroup, serial, nil
+}
+
+// modelGroup returns the device group named name
+func (c *cmdService) modelGroup(name string) (config.DeviceInfo, bool) {
+
+	for _, devGroup := range c.TSMCfg.Oids.DeviceGroups {
+		if devGroup.ModelGroup == name {
+			return devGroup, true
+		}
+	}
+	return config.DeviceInfo{}, false
+}
+
+// confirmModel reports whether the device still answers the model OID, or
+// for groups without one the probe OID, of the cached model group with the
+// cached model, so a controller replaced by another model is detected again
+func (c *cmdService) confirmModel(cached cachedModel) bool {
+
+	devGroup, _ := c.modelGroup(cached.ModelGroup)
+	oid := devGroup.GroupOid
+	if oid == "" {
+		oid = devGroup.ProbeOid
+	}
+	if oid == "" {
+		return false
+	}
+
+	_, results, err := c.snmpService.QueryOids(&[]string{oid})
+	if err != nil {
+		return false
+	}
+	model, modelGroup, err := c.TSMCfg.IdentifyModel(results)
+	if err != nil || model != cached.Model || modelGroup != cached.ModelGroup {
+		answer := model
+		if err != nil {
+			answer = err.Error()
+		}
+		rlog.NoticeMsg("%s:%s answers %s, not the cached model %s (%s), detecting the model",
+			c.Host, c.Port, answer, cached.Model, cached.ModelGroup)
+		return false
+	}
+	return true
+}
+
+// cachedModel returns the model cached for the host, if its model group is
+// still in the config
+func (c *cmdService) cachedModel() (cachedModel, bool) {
+
+	cache := make(map[string]cachedModel)
+	if _, err := statefile.Load(c.modelCachePath, &cache); err != nil {
+		rlog.WarningMsg("model cache %s: %s", c.modelCachePath, err.Error())
+		return cachedModel{}, false
+	}
+	cached, ok := cache[c.Host+":"+c.Port]
+	if !ok {
+		return cachedModel{}, false
+	}
+	if _, modelGroup, ok := c.TSMCfg.ResolveModel(cached.Model); !ok || modelGroup != cached.ModelGroup {
+		rlog.NoticeMsg("cached model %s (%s) is not in the config, detecting the model", cached.Model, cached.ModelGroup)
+		return cachedModel{}, false
+	}
+	return cached, true
+}
+
+// cacheModel saves the model detected on the host in the model cache, which
+// may hold the models of other hosts polled by other instances
+func (c *cmdService) cacheModel(detected cachedModel) {
+
+	cache := make(map[string]cachedModel)
+	if _, err := statefile.Load(c.modelCachePath, &cache); err != nil {
+		rlog.WarningMsg("model cache %s: %s, replacing it", c.modelCachePath, err.Error())
+		cache = make(map[string]cachedModel)
+	}
+	cache[c.Host+":"+c.Port] = detected
+	if err := statefile.Save(c.modelCachePath, cache); err != nil {
+		rlog.WarningMsg("model cache %s: %s", c.modelCachePath, err.Error())
+	}
+}
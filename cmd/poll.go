@@ -26,6 +26,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"os"
 	"strconv"
 	"sync"
 	"time"
@@ -54,7 +56,39 @@ func getSampleInterval(intstr string) (float64, error) {
 	return val, nil
 }
 
-func formatScan(sampleInterval time.Duration, cfg *config.TSMConfig, ts time.Time, scan *map[string]string) string {
+// pollRates returns the OIDs of the current model group polled less often
+// than every sample interval, from [poll.intervals], with their intervals
+func pollRates(cfg *config.TSMConfig, interval time.Duration) (map[string]time.Duration, error) {
+
+	intervals, unknown := cfg.PollIntervals()
+	for _, name := range unknown {
+		rlog.WarningMsg("poll: %s is neither an OID category nor a channel of the current model", name)
+	}
+
+	rates := make(map[string]time.Duration)
+	for oid, secs := range intervals {
+		rate := time.Duration(secs * float64(time.Second))
+		if rate == interval {
+			continue
+		}
+		if rate != 0 && (rate < interval || rate%interval != 0) {
+			name := oid
+			if oidinfo, ok := cfg.OidInfoFor(oid); ok && oidinfo.Chancode != "" {
+				name = oidinfo.Chancode
+			} else if ok {
+				name = oidinfo.Label
+			}
+			return nil, fmt.Errorf("poll: interval %s for %s is not a multiple of the %s sample interval",
+				rate, name, interval)
+		}
+		rates[oid] = rate
+	}
+
+	return rates, nil
+}
+
+func formatScan(sampleInterval time.Duration, cfg *config.TSMConfig, ts time.Time, scan *map[string]string,
+	derived []config.DerivedChannel, rates map[string]time.Duration) string {
 
 	outstr := fmt.Sprintf(
 		"%04d %02d %02d %02d %02d %02d",
@@ -77,14 +111,74 @@ func formatScan(sampleInterval time.Duration, cfg *config.TSMConfig, ts time.Tim
 	for _, oidinfo := range oidInfos {
 		// fmt.Printf("oidinfo: %v\n", oidinfo)
 		oid := oidinfo.Oid
+		// a channel polled less often is only in the scans it was sampled for
+		if _, ok := (*scan)[oid]; !ok {
+			if _, slow := rates[oid]; slow {
+				continue
+			}
+		}
 		// outstr += fmt.Sprintf(" %s:%s", oidinfo.Chancode, (*scan)[oid])
 		outstr += fmt.Sprintf(" %s:%s", oidinfo.Chancode, oidinfo.ValueString((*scan)[oid]))
 	}
+	for _, dc := range derived {
+		outstr += fmt.Sprintf(" %s:%s", dc.Chancode, dc.ValueString())
+	}
 
 	return outstr
 
 }
 
+// processScan passes the scan to each ScanProcessor and collects their derived channels
+func (c *cmdService) processScan(ts time.Time, scan *map[string]string) []config.DerivedChannel {
+
+	var derived []config.DerivedChannel
+
+	for _, proc := range c.processors {
+		proc.Process(ts, scan)
+		derived = append(derived, proc.Derived()...)
+	}
+	for _, proc := range c.processors {
+		if dproc, ok := proc.(DerivedProcessor); ok {
+			dproc.ProcessDerived(ts, scan, derived)
+		}
+	}
+
+	return derived
+}
+
+// storeScan appends the scan to the local history, if enabled
+func (c *cmdService) storeScan(ts time.Time, model, quality string, scan *map[string]string, derived []config.DerivedChannel) {
+
+	if c.store == nil {
+		return
+	}
+	if err := c.store.Append(ts, model, quality, scan, derived); err != nil {
+		rlog.ErrMsg("could not store scan: %s", err.Error())
+	}
+}
+
+// writeRecord sends a record to the configured outputs, or stdout if there are none
+func (c *cmdService) writeRecord(rec string) {
+
+	if c.output == nil {
+		fmt.Printf("%s\n", rec)
+		return
+	}
+	if err := c.output.WriteRecord(rec); err != nil {
+		rlog.ErrMsg("could not write record: %s", err.Error())
+	}
+}
+
+// closeProcessors lets each ScanProcessor save its state before exiting
+func (c *cmdService) closeProcessors() {
+
+	for _, proc := range c.processors {
+		if err := proc.Close(); err != nil {
+			rlog.ErrMsg(err.Error())
+		}
+	}
+}
+
 func logDeviceInfo(ts time.Time, scan *map[string]string) {
 
 	for _, oidinfo := range staticOidInfo {
@@ -113,8 +207,89 @@ func pollArgsParse(args []string) (time.Duration, error) {
 
 }
 
+// pollState is the state of a running poll loop shared with the daemon
+// signal handlers
+type pollState struct {
+	interval   time.Duration
+	rates      map[string]time.Duration
+	modelGroup string
+	cancel     context.CancelFunc
+	wg         sync.WaitGroup
+
+	// daemon signals and config changes, nil when not running as a daemon
+	hup     chan os.Signal
+	usr1    chan os.Signal
+	changes <-chan string
+
+	started  time.Time
+	lastScan time.Time
+	records  int
+	missing  int
+	repeated int
+	reloads  int
+}
+
 // Poll the device
 func (c *cmdService) Poll() error {
+	return c.poll(&pollState{})
+}
+
+// startPolling starts the internal polling loop of the SNMP service
+func (c *cmdService) startPolling(st *pollState) error {
+
+	if rp, ok := c.snmpService.(RatePoller); ok {
+		rp.SetPollRates(st.rates)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	st.cancel = cancel
+
+	err := c.snmpService.PollStart(ctx, &st.wg, &allOids, st.interval)
+	if err != nil {
+		rlog.ErrMsg("could not start internal polling loop... quitting")
+		cancel()
+		st.wg.Wait()
+		return err
+	}
+	rlog.NoticeMsg("internal polling loop spawned")
+
+	return nil
+}
+
+// stopPolling stops the internal polling loop and waits for it to finish
+func (c *cmdService) stopPolling(st *pollState) {
+	st.cancel()
+	st.wg.Wait()
+}
+
+// waitUntil waits for the target time, handling daemon signals meanwhile.
+// It returns false if the command should exit.
+func (c *cmdService) waitUntil(targetTime time.Time, st *pollState) bool {
+
+	timer := c.clock.NewTimer(targetTime.Sub(c.clock.Now()))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C():
+			return true
+		case <-sigdone:
+			rlog.DebugMsg("got done signal")
+			return false
+		case <-st.hup:
+			rlog.NoticeMsg("SIGHUP received, reloading config and reopening outputs")
+			c.reload(st, false)
+		case path := <-st.changes:
+			rlog.NoticeMsg("%s changed, reloading config", path)
+			c.reload(st, true)
+		case <-st.usr1:
+			c.logStatus(st)
+		}
+	}
+}
+
+// poll runs the polling loop until signalled to stop
+func (c *cmdService) poll(st *pollState) error {
 
 	var (
 		modelGroup string
@@ -123,15 +298,14 @@ func (c *cmdService) Poll() error {
 
 	rlog.NoticeMsg(fmt.Sprintf("running %s command on host: %s:%s\n", c.args[0], c.Host, c.Port))
 
-	_, modelGroup, err = c.queryForModel()
-	c.TSMCfg.SetModel(modelGroup)
-	rlog.NoticeMsg(fmt.Sprintf("Controller identified as model: %s", modelGroup))
+	if _, modelGroup, err = c.queryForModel(); err != nil {
+		return err
+	}
 
 	dInterval, err := pollArgsParse(c.args)
 	if err != nil {
 		return err
 	}
-	hInterval := dInterval / 2
 
 	rlog.NoticeMsg(fmt.Sprintf("polling interval: %.0f sec(s)\n", dInterval.Seconds()))
 
@@ -142,26 +316,23 @@ func (c *cmdService) Poll() error {
 	defer c.snmpService.Close()
 
 	initOids(c)
+	if st.rates, err = pollRates(c.TSMCfg, dInterval); err != nil {
+		return err
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	var wg sync.WaitGroup
-
-	err = c.snmpService.PollStart(ctx, &wg, &allOids, dInterval)
-	if err != nil {
-		rlog.ErrMsg("could not start internal polling loop... quitting")
-		cancel()
-		wg.Wait()
+	st.interval = dInterval
+	st.modelGroup = modelGroup
+	st.started = c.clock.Now()
+	if err = c.startPolling(st); err != nil {
 		return err
 	}
-	rlog.NoticeMsg("internal polling loop spawned")
 
 	var (
 		scan, prevScan *map[string]string
 		ts             time.Time
-		offset         time.Duration
 	)
 
-	targetTime := time.Now().Round(dInterval).Add(dInterval)
+	sched := newScheduler(c.clock.Now(), dInterval)
 	first := true
 	scanMissed := false
 	scanRepeated := false
@@ -169,55 +340,54 @@ func (c *cmdService) Poll() error {
 
 	for !exiting {
 
-		targetTime = targetTime.Add(dInterval)
+		targetTime := sched.next()
 		rlog.DebugMsg("next target time: %v\n", targetTime.String())
 
-		select {
-
-		case <-time.After(time.Until(targetTime)):
-
-			prevScan = scan
-			ts, scan, err = c.snmpService.GetScan()
-			if scan == nil {
-				if !scanMissed {
-					rlog.ErrMsg("no rpm scan available\n")
-				}
-				scanMissed = true
-				first = true
-				continue
-			}
-
-			rlog.DebugMsg("Scan time:   %s", ts.String())
-			for _, oidinfo := range dataOidInfo {
-				rlog.DebugMsg("(%s) %s: %s", oidinfo.Chancode, oidinfo.Oid, (*scan)[oidinfo.Oid])
-			}
-
-			if first {
-				rlog.NoticeMsg("initial rpm scan received")
-				logDeviceInfo(ts, scan)
-				first = false
-			}
-			scanMissed = false
+		if !c.waitUntil(targetTime, st) {
+			exiting = true
+			continue
+		}
 
-		case <-sigdone:
-			rlog.DebugMsg("got done signal")
+		prevScan = scan
+		ts, scan, err = c.snmpService.GetScan()
+		if errors.Is(err, io.EOF) {
+			rlog.NoticeMsg("no more scans")
 			exiting = true
 			continue
 		}
+		if scan == nil {
+			if !scanMissed {
+				rlog.ErrMsg("no rpm scan available\n")
+			}
+			c.storeScan(targetTime, st.modelGroup, config.QualityMissing, nil, nil)
+			st.missing++
+			scanMissed = true
+			first = true
+			continue
+		}
+
+		rlog.DebugMsg("Scan time:   %s", ts.String())
+		for _, oidinfo := range dataOidInfo {
+			rlog.DebugMsg("(%s) %s: %s", oidinfo.Chancode, oidinfo.Oid, (*scan)[oidinfo.Oid])
+		}
 
-		// calcualte offset of scan time from target time.
-		// positive offset means scan time is after target time
-		offset = ts.Sub(targetTime)
+		if first {
+			rlog.NoticeMsg("initial rpm scan received")
+			logDeviceInfo(ts, scan)
+			first = false
+		}
+		scanMissed = false
 
 		// see if scan should go with next second, perhaps due to network lag
+		timing, offset := sched.check(ts)
+		rlog.DebugMsg("scan offset from target: %s", offset)
 
-		if offset > hInterval {
+		if timing == scanFuture {
 			// really should never get here unless this loop is taking more than an interval to complete
 			rlog.WarningMsg("well, this is awkward, a scan from more than 1/2 interval in the future")
 			rlog.WarningMsg("incrementing TargetTime by one interval (to catch up) creating a gap")
-			targetTime = ts.Round(dInterval) //targetTime.Add(dInterval)
 			first = true
-		} else if offset < -hInterval {
+		} else if timing == scanStale {
 			// current scan does not appear to be available.
 			rlog.ErrMsg("missing scan: current scan time (%v) not found within 1/2 interval of target (%v)", ts, targetTime)
 
@@ -227,22 +397,29 @@ func (c *cmdService) Poll() error {
 				rlog.WarningMsg("repeating previous scan value")
 				scanRepeated = true
 				scan = prevScan
+				st.repeated++
 			} else {
 				// missed scan but can't repeat previous, so there will be a gap
 				first = true
 			}
+			c.storeScan(targetTime, st.modelGroup, config.QualityMissing, nil, nil)
+			st.missing++
 			continue
 		}
 
 		scanRepeated = false
-		// send record to Stdout
-		fmt.Printf("%s\n", formatScan(dInterval, c.TSMCfg, ts, scan))
+		derived := c.processScan(ts, scan)
+		c.storeScan(ts, st.modelGroup, config.QualityOK, scan, derived)
+
+		c.writeRecord(formatScan(dInterval, c.TSMCfg, ts, scan, derived, st.rates))
+		st.lastScan = ts
+		st.records++
 
 	}
-	cancel()
-	wg.Wait()
+	c.stopPolling(st)
+	c.closeOptional()
 
-	rlog.NoticeMsg("poll exiting")
+	rlog.NoticeMsg("%s exiting", c.args[1])
 
 	return nil
 }
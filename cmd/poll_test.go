@@ -0,0 +1,196 @@
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"tsm/clock"
+	"tsm/config"
+	"tsm/config/configtest"
+	"tsm/snmp/snmptest"
+)
+
+// mpptScan returns a TS-MPPT scan of the test config
+func mpptScan(chargeState string) map[string]string {
+	return map[string]string{
+		"1.3.6.1.4.1.33333.2.60.0": "1",
+		"1.3.6.1.4.1.33333.2.46.0": chargeState,
+		"1.3.6.1.4.1.33333.2.38.0": "2276",
+		"1.3.6.1.4.1.33333.2.43.0": "4096",
+		"1.3.6.1.4.1.33333.2.49.0": "25",
+		"1.3.6.1.4.1.33333.2.57.0": "9",
+		"1.3.6.1.4.1.33333.2.55.0": "0",
+	}
+}
+
+func TestGetSampleInterval(t *testing.T) {
+
+	for _, str := range []string{"1", "10", "60", "2.5"} {
+		if _, err := getSampleInterval(str); err != nil {
+			t.Errorf("getSampleInterval(%s): %v", str, err)
+		}
+	}
+	for _, str := range []string{"0", "0.5", "61", "-1", "ten", ""} {
+		if val, err := getSampleInterval(str); err == nil {
+			t.Errorf("getSampleInterval(%s) = %f, want an error", str, val)
+		}
+	}
+}
+
+func TestFormatScan(t *testing.T) {
+
+	cfg := configtest.NewConfig()
+	cfg.SetModel("TS-MPPT")
+
+	ts := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	scan := mpptScan("5")
+	derived := []config.DerivedChannel{{Chancode: "EC", Value: 1.5}}
+
+	got := formatScan(10*time.Second, cfg, ts, &scan, derived, nil)
+	want := "2026 01 02 03 04 05 II TEST 00 10 :10000000 CS:mppt BV:12.5 CC:10.0 HT:25.0" +
+		" AL:rtsOpen, heatsinkTempSensorOpen FL:None EC:1.500"
+	if got != want {
+		t.Errorf("formatScan =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestFormatScanRates(t *testing.T) {
+
+	cfg := configtest.NewConfig()
+	cfg.SetModel("TS-MPPT")
+
+	ts := time.Date(2026, 1, 2, 3, 5, 0, 0, time.UTC)
+	rates := map[string]time.Duration{
+		"1.3.6.1.4.1.33333.2.38.0": time.Minute, // BV
+		"1.3.6.1.4.1.33333.2.57.0": 0,           // AL, sampled once
+	}
+
+	// BV is only listed in the scans it was sampled for
+	scan := mpptScan("5")
+	want := "2026 01 02 03 05 00 II TEST 00 10 :10000000 CS:mppt BV:12.5 CC:10.0 HT:25.0 AL:rtsOpen, heatsinkTempSensorOpen FL:None"
+	if got := formatScan(10*time.Second, cfg, ts, &scan, nil, rates); got != want {
+		t.Errorf("formatScan with BV =\n%q\nwant\n%q", got, want)
+	}
+	delete(scan, "1.3.6.1.4.1.33333.2.38.0")
+	want = "2026 01 02 03 05 00 II TEST 00 10 :10000000 CS:mppt CC:10.0 HT:25.0 AL:rtsOpen, heatsinkTempSensorOpen FL:None"
+	if got := formatScan(10*time.Second, cfg, ts, &scan, nil, rates); got != want {
+		t.Errorf("formatScan without BV =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestPollRates(t *testing.T) {
+
+	cfg := configtest.NewConfig()
+	cfg.SetModel("TS-MPPT")
+	cfg.Poll.Intervals = map[string]float64{"static": 0, "measurements": 60, "CC": 10}
+
+	rates, err := pollRates(cfg, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]time.Duration{
+		"1.3.6.1.4.1.33333.1.1.0":  0,
+		"1.3.6.1.4.1.33333.2.1.0":  0,
+		"1.3.6.1.4.1.33333.2.2.0":  0,
+		"1.3.6.1.4.1.33333.2.38.0": time.Minute,
+		"1.3.6.1.4.1.33333.2.49.0": time.Minute,
+	}
+	if !reflect.DeepEqual(rates, want) {
+		t.Errorf("pollRates = %v, want %v", rates, want)
+	}
+
+	for _, secs := range []float64{25, 5} {
+		cfg.Poll.Intervals = map[string]float64{"HT": secs}
+		if _, err := pollRates(cfg, 10*time.Second); err == nil || !strings.Contains(err.Error(), "HT") {
+			t.Errorf("pollRates with %gs for HT = %v, want an error", secs, err)
+		}
+	}
+}
+
+func TestPollTiming(t *testing.T) {
+
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(secs float64) time.Time {
+		return base.Add(time.Duration(secs * float64(time.Second)))
+	}
+
+	svc := snmptest.NewService(map[string]string{configtest.MPPTGroupOid: "TS-MPPT-60"})
+	svc.Scans = []snmptest.Scan{
+		{TS: at(20.5), Data: mpptScan("5")}, // target 20
+		{TS: at(30.2), Data: mpptScan("5")}, // target 30
+		{TS: at(31), Data: mpptScan("5")},   // target 40, stale so missing
+		{TS: at(50.1), Data: mpptScan("6")}, // target 50
+		{TS: at(66), Data: mpptScan("7")},   // target 60, from the future so targets move to 70
+		{TS: at(80)},                        // target 80, no scan
+	}
+
+	clk := clock.NewFake(at(3))
+	clk.AutoAdvance = true
+	out := &recordWriter{}
+	st := &scanStore{}
+	c := newTestService([]string{"127.0.0.1", "poll", "10"}, svc,
+		WithClock(clk), WithOutput(out), WithStore(st))
+
+	if err := c.Poll(); err != nil {
+		t.Fatal(err)
+	}
+
+	if svc.PollInterval != 10*time.Second {
+		t.Errorf("poll interval %s, want 10s", svc.PollInterval)
+	}
+	if len(svc.PollOids) != 10 {
+		t.Errorf("polled %d oids, want the 7 data and 3 static oids", len(svc.PollOids))
+	}
+
+	wantStored := []storedScan{
+		{at(20.5), config.QualityOK},
+		{at(30.2), config.QualityOK},
+		{at(40), config.QualityMissing},
+		{at(50.1), config.QualityOK},
+		{at(66), config.QualityOK},
+		{at(80), config.QualityMissing},
+	}
+	if !reflect.DeepEqual(st.scans, wantStored) {
+		t.Errorf("stored scans\n%v\nwant\n%v", st.scans, wantStored)
+	}
+
+	var stamps []string
+	for _, rec := range out.records {
+		stamps = append(stamps, rec[:19])
+	}
+	wantStamps := []string{
+		"2026 01 01 00 00 20",
+		"2026 01 01 00 00 30",
+		"2026 01 01 00 00 50",
+		"2026 01 01 00 01 06",
+	}
+	if !reflect.DeepEqual(stamps, wantStamps) {
+		t.Errorf("records at %v, want %v", stamps, wantStamps)
+	}
+	if !strings.Contains(out.records[3], "CS:float") {
+		t.Errorf("last record %q does not have the last scan", out.records[3])
+	}
+
+	// the final wait was for target 90 before the scans ran out
+	if got := clk.Now(); !got.Equal(at(90)) {
+		t.Errorf("clock at %s, want %s", got, at(90))
+	}
+	if !out.closed || !st.closed {
+		t.Error("outputs not closed on exit")
+	}
+}
+
+func TestPollModelNotFound(t *testing.T) {
+
+	svc := snmptest.NewService(nil)
+	c := newTestService([]string{"127.0.0.1", "poll", "10"}, svc, WithOutput(&recordWriter{}))
+
+	if err := c.Poll(); err == nil {
+		t.Fatal("Poll started on a device whose model was not found")
+	}
+	if svc.PollOids != nil {
+		t.Errorf("polled %v without a model", svc.PollOids)
+	}
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"time"
+)
+
+// scanTiming is how a scan's time compares with the target time it is
+// expected for
+type scanTiming int
+
+const (
+	// scanOnTime is within half an interval of the target
+	scanOnTime scanTiming = iota
+	// scanStale is more than half an interval before the target, the
+	// device has not answered since the previous target
+	scanStale
+	// scanFuture is more than half an interval after the target, the poll
+	// loop has fallen behind
+	scanFuture
+)
+
+// scheduler aligns the poll loop's target times to whole multiples of the
+// sample interval and checks each scan against them. It only does
+// arithmetic on the times passed to it, so it can be tested without a clock.
+type scheduler struct {
+	interval time.Duration
+	target   time.Time
+}
+
+// newScheduler returns a scheduler whose first target is the second whole
+// interval after now, leaving the internal polling loop an interval to
+// return its first scan
+func newScheduler(now time.Time, interval time.Duration) *scheduler {
+	return &scheduler{
+		interval: interval,
+		target:   now.Round(interval).Add(interval),
+	}
+}
+
+// next advances to and returns the next target time
+func (s *scheduler) next() time.Time {
+	s.target = s.target.Add(s.interval)
+	return s.target
+}
+
+// check classifies a scan taken at ts against the current target and its
+// offset from the target. A scan from the future moves the target to the
+// interval nearest ts, leaving a gap in the output.
+func (s *scheduler) check(ts time.Time) (scanTiming, time.Duration) {
+
+	offset := ts.Sub(s.target)
+	half := s.interval / 2
+
+	switch {
+	case offset > half:
+		s.target = ts.Round(s.interval)
+		return scanFuture, offset
+	case offset < -half:
+		return scanStale, offset
+	}
+	return scanOnTime, offset
+}
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedulerTargets(t *testing.T) {
+
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		start    time.Duration
+		interval time.Duration
+		want     []time.Duration
+	}{
+		{3 * time.Second, 10 * time.Second, []time.Duration{20 * time.Second, 30 * time.Second}},
+		{7 * time.Second, 10 * time.Second, []time.Duration{30 * time.Second, 40 * time.Second}},
+		{1500 * time.Millisecond, time.Second, []time.Duration{4 * time.Second, 5 * time.Second}},
+		{59 * time.Second, 60 * time.Second, []time.Duration{180 * time.Second, 240 * time.Second}},
+	}
+	for _, tt := range tests {
+		sched := newScheduler(base.Add(tt.start), tt.interval)
+		for _, want := range tt.want {
+			if got := sched.next(); !got.Equal(base.Add(want)) {
+				t.Errorf("start %s interval %s: target %s, want %s", tt.start, tt.interval, got.Sub(base), want)
+			}
+		}
+	}
+}
+
+func TestSchedulerCheck(t *testing.T) {
+
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		scan       time.Duration // scan time after the target
+		want       scanTiming
+		wantTarget time.Duration // target after the check, from base
+	}{
+		{"on time", 300 * time.Millisecond, scanOnTime, 20 * time.Second},
+		{"slightly early", -4 * time.Second, scanOnTime, 20 * time.Second},
+		{"half interval late", 5 * time.Second, scanOnTime, 20 * time.Second},
+		{"half interval early", -5 * time.Second, scanOnTime, 20 * time.Second},
+		{"stale", -5001 * time.Millisecond, scanStale, 20 * time.Second},
+		{"previous scan", -10 * time.Second, scanStale, 20 * time.Second},
+		{"future", 5001 * time.Millisecond, scanFuture, 30 * time.Second},
+		{"far future", 36 * time.Second, scanFuture, 60 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sched := newScheduler(base.Add(3*time.Second), 10*time.Second)
+			target := sched.next()
+
+			timing, offset := sched.check(target.Add(tt.scan))
+			if timing != tt.want {
+				t.Errorf("timing %d, want %d", timing, tt.want)
+			}
+			if offset != tt.scan {
+				t.Errorf("offset %s, want %s", offset, tt.scan)
+			}
+			if !sched.target.Equal(base.Add(tt.wantTarget)) {
+				t.Errorf("target %s, want %s", sched.target.Sub(base), tt.wantTarget)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"fmt"
+
+	rlog "tsm/log"
+)
+
+// Simulator serves a simulated device until closed
+type Simulator interface {
+	Start() error
+	Close() error
+}
+
+// Simulate runs the simulated device until signalled to stop
+func (c *cmdService) Simulate() error {
+
+	if c.simulator == nil {
+		return fmt.Errorf("no simulator configured")
+	}
+
+	rlog.NoticeMsg("running %s command", c.args[0])
+
+	if err := c.simulator.Start(); err != nil {
+		return err
+	}
+	<-sigdone
+	rlog.DebugMsg("got done signal")
+
+	err := c.simulator.Close()
+	rlog.NoticeMsg("simulate exiting")
+
+	return err
+}
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"tsm/config"
+	"tsm/events"
+	rlog "tsm/log"
+)
+
+// decodeVarbind renders a trap varbind using the OID's label and type from the config
+func decodeVarbind(cfg *config.TSMConfig, vb events.Varbind) string {
+
+	oidinfo, ok := cfg.OidInfoAnyGroup(vb.Oid)
+	if !ok {
+		return fmt.Sprintf("%s=%s", vb.Oid, vb.Value)
+	}
+	value := strings.TrimSpace(oidinfo.ValueString(vb.Value))
+	if oidinfo.Units != "" {
+		value += " " + oidinfo.Units
+	}
+	return fmt.Sprintf("%s=%s", oidinfo.Label, value)
+}
+
+// handleTrap logs a received trap and publishes it as an event
+func (c *cmdService) handleTrap(trap events.Trap) {
+
+	if trap.Source != c.Host {
+		rlog.With("source", trap.Source).Keyed("trap-source").WarningMsg("trap from %s ignored, only accepting traps from %s", trap.Source, c.Host)
+		return
+	}
+
+	tinfo, _ := c.TSMCfg.Traps.TrapInfoFor(trap.TrapOid)
+	sev, _ := config.ParseSeverity(tinfo.Severity)
+
+	details := make([]string, 0, len(trap.Varbinds))
+	for _, vb := range trap.Varbinds {
+		details = append(details, decodeVarbind(c.TSMCfg, vb))
+	}
+
+	kind := "trap"
+	if trap.Inform {
+		kind = "inform"
+	}
+	rlog.InfoMsg("%s %s from %s: %s [%s]", trap.Version, kind, trap.Source, tinfo.Label, strings.Join(details, ", "))
+
+	ev := events.Event{
+		Time:     trap.Time,
+		Source:   events.SourceTrap,
+		Host:     trap.Source,
+		Oid:      trap.TrapOid,
+		Label:    "Trap",
+		Name:     tinfo.Label,
+		Kind:     events.KindTrap,
+		Detail:   strings.Join(details, ", "),
+		Severity: sev,
+	}
+	if c.bus != nil {
+		c.bus.Publish(ev)
+	} else {
+		rlog.LogMsg(sev, "event: %s", ev.String())
+	}
+}
+
+// Traps receives traps and informs from the device until signalled to stop
+func (c *cmdService) Traps() error {
+
+	if c.trapService == nil {
+		return fmt.Errorf("no trap receiver configured")
+	}
+
+	rlog.NoticeMsg(fmt.Sprintf("running %s command on host: %s:%s\n", c.args[0], c.Host, c.Port))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errchan := make(chan error, 1)
+	go func() {
+		errchan <- c.trapService.ListenTraps(ctx, c.handleTrap)
+	}()
+
+	var err error
+	select {
+	case err = <-errchan:
+	case <-sigdone:
+		rlog.DebugMsg("got done signal")
+		cancel()
+		err = <-errchan
+	}
+	cancel()
+	c.closeProcessors()
+	if c.output != nil {
+		c.output.Close()
+	}
+
+	rlog.NoticeMsg("traps exiting")
+
+	return err
+}
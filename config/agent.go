@@ -0,0 +1,50 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// AgentConfig holds the settings for re-exporting the latest scan as an SNMP agent
+type AgentConfig struct {
+	Enabled    bool
+	Listen     string // address:port to listen on
+	Community  string // read community, any if empty
+	Enterprise string // private enterprise subtree for the scaled and derived channels
+	// MaxAge is the seconds without a new scan before device values are
+	// withdrawn, 0 for no limit. Unset uses DefaultAgentMaxAge.
+	MaxAge *float64
+}
+
+const (
+	// DefaultAgentListen is an unprivileged port on all interfaces
+	DefaultAgentListen = "0.0.0.0:1161"
+	// DefaultAgentMaxAge is how long a scan is served without a newer one
+	DefaultAgentMaxAge = 300
+)
+
+// Validate the agent section of the config
+func (acfg *AgentConfig) Validate() error {
+
+	if !acfg.Enabled {
+		return nil
+	}
+	if acfg.Listen == "" {
+		acfg.Listen = DefaultAgentListen
+	}
+	if acfg.MaxAge == nil {
+		maxAge := float64(DefaultAgentMaxAge)
+		acfg.MaxAge = &maxAge
+	}
+	if *acfg.MaxAge < 0 {
+		return errors.New("agent: maxage must not be negative")
+	}
+
+	acfg.Enterprise = strings.Trim(acfg.Enterprise, ".")
+	if !strings.HasPrefix(acfg.Enterprise, "1.3.6.1.4.1.") {
+		return fmt.Errorf("agent: enterprise %q must be under 1.3.6.1.4.1", acfg.Enterprise)
+	}
+
+	return nil
+}
@@ -0,0 +1,33 @@
+package config_test
+
+import (
+	"testing"
+
+	"tsm/config"
+)
+
+func TestAgentMaxAge(t *testing.T) {
+
+	zero, negative := 0.0, -1.0
+	tests := []struct {
+		maxAge *float64
+		want   float64
+	}{
+		{nil, config.DefaultAgentMaxAge},
+		{&zero, 0}, // no limit
+	}
+	for _, tt := range tests {
+		acfg := config.AgentConfig{Enabled: true, Enterprise: "1.3.6.1.4.1.99999.1", MaxAge: tt.maxAge}
+		if err := acfg.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		if *acfg.MaxAge != tt.want {
+			t.Errorf("maxage = %g, want %g", *acfg.MaxAge, tt.want)
+		}
+	}
+
+	acfg := config.AgentConfig{Enabled: true, Enterprise: "1.3.6.1.4.1.99999.1", MaxAge: &negative}
+	if err := acfg.Validate(); err == nil {
+		t.Error("negative maxage accepted")
+	}
+}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// BatteryConfig holds the settings for the battery state-of-charge estimator
+type BatteryConfig struct {
+	Enabled          bool
+	Chemistry        string  // flooded, agm, gel or lifepo4
+	Capacity         float64 // bank capacity in amp-hours
+	Cells            int     // cells in series
+	TempCoeff        float64 // per cell volts per deg C, 0 uses the chemistry default
+	ChargeEfficiency float64 // 0 uses the chemistry default
+	MinSOC           float64 // lowest usable state of charge in percent
+	RestCurrent      float64 // amps below which the battery is considered at rest
+	RestTime         float64 // seconds at rest before the voltage SOC is trusted
+	MaxGap           float64
+	StateFile        string
+
+	Voltage       string // battery voltage oid
+	Temperature   string // battery temperature oid
+	Current       string // net battery current oid, positive when charging
+	ChargeCurrent string // or charge and load current oids
+	LoadCurrent   string
+
+	SOCChancode      string
+	AutonomyChancode string
+	HealthChancode   string
+}
+
+const (
+	// DefaultBatteryRestCurrent is the default rest current threshold in amps
+	DefaultBatteryRestCurrent float64 = 0.5
+	// DefaultBatteryRestTime is the default rest time in seconds
+	DefaultBatteryRestTime float64 = 3600
+	// DefaultBatteryMaxGap is the longest gap in seconds coulomb counted across
+	DefaultBatteryMaxGap float64 = 180
+)
+
+// Validate the battery section of the config
+func (bcfg *BatteryConfig) Validate() error {
+
+	if !bcfg.Enabled {
+		return nil
+	}
+
+	switch bcfg.Chemistry {
+	case "flooded", "agm", "gel", "lifepo4":
+	default:
+		return fmt.Errorf("battery: invalid chemistry %q, must be one of flooded, agm, gel, lifepo4", bcfg.Chemistry)
+	}
+	if bcfg.Capacity <= 0 {
+		return errors.New("battery: capacity must be greater than 0 Ah")
+	}
+	if bcfg.Cells <= 0 {
+		return errors.New("battery: cells must be greater than 0")
+	}
+	if bcfg.Voltage == "" {
+		return errors.New("battery: voltage oid must be specified")
+	}
+	if bcfg.Current == "" && bcfg.ChargeCurrent == "" && bcfg.LoadCurrent == "" {
+		return errors.New("battery: current or chargecurrent/loadcurrent oids must be specified")
+	}
+	if bcfg.MinSOC < 0 || bcfg.MinSOC >= 100 {
+		return fmt.Errorf("battery: invalid minsoc %.0f, must be between 0 and 100", bcfg.MinSOC)
+	}
+	if bcfg.RestCurrent <= 0 {
+		bcfg.RestCurrent = DefaultBatteryRestCurrent
+	}
+	if bcfg.RestTime <= 0 {
+		bcfg.RestTime = DefaultBatteryRestTime
+	}
+	if bcfg.MaxGap <= 0 {
+		bcfg.MaxGap = DefaultBatteryMaxGap
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+// Package configtest provides a small TSMConfig for tests
+package configtest
+
+import "tsm/config"
+
+// Model group OIDs of the test config
+const (
+	MPPTGroupOid = "1.3.6.1.4.1.33333.2.1.0"
+	PWMGroupOid  = "1.3.6.1.4.1.33333.8.1.0"
+)
+
+// NewConfig returns a validated config with a cut down TS-MPPT and TS-PWM
+// model group, station II.TEST.00
+func NewConfig() *config.TSMConfig {
+
+	cfg := config.NewConfig()
+	cfg.General.Net = "II"
+	cfg.General.Sta = "TEST"
+	cfg.General.Loc = "00"
+
+	cfg.Oids.EMCOids = []config.OidInfo{
+		{Oid: "1.3.6.1.4.1.33333.1.1.0", Label: "EMC-1 Serial Number", Type: "string", Scaling: 1},
+	}
+	cfg.Oids.DeviceGroups = []config.DeviceInfo{
+		{
+			GroupOid:   MPPTGroupOid,
+			ModelGroup: "TS-MPPT",
+			Modellist:  []string{"TS-MPPT-45", "TS-MPPT-60"},
+			Static: []config.OidInfo{
+				{Oid: "1.3.6.1.4.1.33333.2.1.0", Label: "Controller", Type: "string", Scaling: 1},
+				{Oid: "1.3.6.1.4.1.33333.2.2.0", Label: "Serial number", Type: "string", Scaling: 1},
+			},
+			Status: []config.OidInfo{
+				{Oid: "1.3.6.1.4.1.33333.2.60.0", Label: "DIP Switches", Type: "bitreverse", Scaling: 8},
+				{Oid: "1.3.6.1.4.1.33333.2.46.0", Chancode: "CS", Label: "Charge State", Type: "map",
+					Values: []string{"start", "nightCheck", "disconnect", "night", "fault", "mppt", "absorption", "float", "equalize", "slave"}},
+			},
+			Measurements: []config.OidInfo{
+				{Oid: "1.3.6.1.4.1.33333.2.38.0", Chancode: "BV", Label: "Battery voltage", Units: "volts", Type: "number", Scaling: 0.005493164},
+				{Oid: "1.3.6.1.4.1.33333.2.43.0", Chancode: "CC", Label: "Charge current", Units: "amps", Type: "number", Scaling: 0.002441406},
+				{Oid: "1.3.6.1.4.1.33333.2.49.0", Chancode: "HT", Label: "Heatsink temperature", Units: "deg C", Type: "number", Scaling: 1},
+			},
+			Alarms: []config.OidInfo{
+				{Oid: "1.3.6.1.4.1.33333.2.57.0", Chancode: "AL", Label: "Alarms (now)", Type: "bitmap",
+					Values: []string{"rtsOpen", "rtsShorted", "rtsDisconnected", "heatsinkTempSensorOpen"}},
+			},
+			Faults: []config.OidInfo{
+				{Oid: "1.3.6.1.4.1.33333.2.55.0", Chancode: "FL", Label: "Faults (now)", Type: "bitmap",
+					Values: []string{"overcurrent", "fetShort", "softwareFault"}},
+			},
+		},
+		{
+			GroupOid:   PWMGroupOid,
+			ModelGroup: "TS-PWM",
+			Modellist:  []string{"TS-45", "TS-60"},
+			Static: []config.OidInfo{
+				{Oid: "1.3.6.1.4.1.33333.8.1.0", Label: "Controller", Type: "string", Scaling: 1},
+			},
+			Status: []config.OidInfo{
+				{Oid: "1.3.6.1.4.1.33333.8.47.0", Chancode: "LS", Label: "Load State", Type: "map",
+					Values: []string{"START", "NORMAL", "LVDWarning", "LVD"}},
+			},
+			Measurements: []config.OidInfo{
+				{Oid: "1.3.6.1.4.1.33333.8.30.0", Chancode: "SP1", Label: "Battery voltage", Units: "volts", Type: "number", Scaling: 0.1},
+			},
+			Alarms: []config.OidInfo{
+				{Oid: "1.3.6.1.4.1.33333.8.42.0", Chancode: "SPA", Label: "Alarms (now)", Type: "bitmap",
+					Values: []string{"rtsOpen", "rtsShorted"}},
+			},
+		},
+	}
+
+	if err := cfg.Validate(); err != nil {
+		panic(err)
+	}
+	return cfg
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"net"
 	"strconv"
 	"strings"
 )
@@ -25,8 +26,30 @@ func NewConfig() *TSMConfig {
 
 // TSMConfig hold the RPM configuration structure
 type TSMConfig struct {
-	General generalConfig
-	Oids    oids
+	// Community is the SNMP read community, from --community, TSM_COMMUNITY
+	// or community at the top of the config file
+	Community string
+
+	// Host is the device to poll, as host or host:port, when the command
+	// line does not give one. Version is the SNMP version, 1 or 2c, the
+	// default. Changing either makes the daemon reconnect on reload.
+	Host    string
+	Version string
+
+	General   generalConfig
+	Log       LogConfig
+	Poll      PollConfig
+	Energy    EnergyConfig
+	Battery   BatteryConfig
+	Store     StoreConfig
+	Output    OutputConfig
+	Events    EventsConfig
+	Notify    NotifyConfig
+	Traps     TrapsConfig
+	Agent     AgentConfig
+	Simulate  SimulateConfig
+	Inventory []InventoryDevice
+	Oids      oids
 }
 
 // GeneralConfig top lebel config settings
@@ -34,6 +57,10 @@ type generalConfig struct {
 	Sta string
 	Net string
 	Loc string
+
+	// ModelCache is the state file the model detected on each host is
+	// saved in, so restarts skip detecting it. Empty to always detect it.
+	ModelCache string
 }
 
 // Oids wraps the info for different categrories of
@@ -48,6 +75,13 @@ type DeviceInfo struct {
 	ModelGroup string
 	Modellist  []string
 
+	// ProbeOid identifies the group when GroupOid does not answer, as
+	// with some MeterBus devices behind an EMC-1. It is an OID only this
+	// product family answers, such as a product ID register. ProductIds
+	// maps its values to model names.
+	ProbeOid   string
+	ProductIds map[string]string
+
 	Static       []OidInfo
 	Status       []OidInfo
 	Measurements []OidInfo
@@ -64,6 +98,7 @@ type OidInfo struct {
 	Type         string
 	Scaling      float64
 	Values       []string
+	Latched      bool // bitmap holding bits set since the daily reset, not the live state
 	Result       string
 	Register     uint16
 	RegisterType string
@@ -84,6 +119,9 @@ func (oidInfo *OidInfo) ValueString(resstr string) string {
 		return fmt.Sprintf(fmtstr, reverseBits(val, uint(oidInfo.Scaling)))
 	case "map":
 		val, _ := strconv.ParseUint(resstr, 10, 64)
+		if val >= uint64(len(oidInfo.Values)) {
+			return resstr
+		}
 		return fmt.Sprintf("%s", oidInfo.Values[val])
 	case "bitmap":
 		val, _ := strconv.ParseUint(resstr, 10, 64)
@@ -98,6 +136,38 @@ func (oidInfo *OidInfo) ValueString(resstr string) string {
 	return resstr
 }
 
+// TOML formats the OID as a tsm.toml inline table
+func (oidInfo *OidInfo) TOML() string {
+
+	str := fmt.Sprintf("{ oid = %q, chancode = %q, label = %q, units = %q, type = %q",
+		oidInfo.Oid, oidInfo.Chancode, oidInfo.Label, oidInfo.Units, oidInfo.Type)
+	if oidInfo.Type == "map" || oidInfo.Type == "bitmap" {
+		quoted := make([]string, len(oidInfo.Values))
+		for ndx, val := range oidInfo.Values {
+			quoted[ndx] = fmt.Sprintf("%q", val)
+		}
+		str += fmt.Sprintf(", values = [%s]", strings.Join(quoted, ", "))
+		if oidInfo.Latched {
+			str += ", latched = true"
+		}
+		return str + " }"
+	}
+	scaling := oidInfo.Scaling
+	if scaling == 0 {
+		scaling = 1
+	}
+	return str + fmt.Sprintf(", scaling = %s }", formatFloat(scaling))
+}
+
+// formatFloat writes f as a TOML float
+func formatFloat(f float64) string {
+	str := fmt.Sprintf("%.10g", f)
+	if !strings.ContainsAny(str, ".e") {
+		str += ".0"
+	}
+	return str
+}
+
 // return an array of Status OidInfo for curModel
 func (cfg TSMConfig) StaticOids() *[]OidInfo {
 	statics := append(cfg.Oids.EMCOids, cfg.Oids.DeviceGroups[curModelNdx].Static...)
@@ -120,8 +190,79 @@ func (cfg TSMConfig) FaultOids() *[]OidInfo {
 	return &cfg.Oids.DeviceGroups[curModelNdx].Faults
 }
 
+// DefaultPort is the SNMP port of a Host without one
+const DefaultPort = "161"
+
+// HostPort splits Host into host and port, the port defaulting to
+// DefaultPort. Both are empty if Host is.
+func (cfg *TSMConfig) HostPort() (string, string, error) {
+
+	if cfg.Host == "" {
+		return "", "", nil
+	}
+	// a bare IPv6 address has colons but no port
+	if bare := strings.Trim(cfg.Host, "[]"); !strings.Contains(cfg.Host, ":") || net.ParseIP(bare) != nil {
+		return bare, DefaultPort, nil
+	}
+	host, port, err := net.SplitHostPort(cfg.Host)
+	if err != nil {
+		return "", "", fmt.Errorf("host %q: %w", cfg.Host, err)
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil || host == "" {
+		return "", "", fmt.Errorf("host %q: must be host or host:port", cfg.Host)
+	}
+	return host, port, nil
+}
+
 // Validate the rpm TOML config file
-func (cfg TSMConfig) Validate() (e error) {
+func (cfg *TSMConfig) Validate() (e error) {
+
+	if _, _, err := cfg.HostPort(); err != nil {
+		return err
+	}
+	switch cfg.Version {
+	case "", "1", "2c":
+	default:
+		return fmt.Errorf("version %q: SNMP version must be 1 or 2c", cfg.Version)
+	}
+	if err := cfg.Log.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.Poll.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.Energy.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.checkEnergyOids(); err != nil {
+		return err
+	}
+
+	if err := cfg.Battery.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.Store.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.Output.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.Events.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.Notify.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.Traps.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.Agent.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.Simulate.Validate(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -203,9 +344,13 @@ func (cfg *TSMConfig) ModelInfo() (*[]string, *map[string]string) {
 	modelMap := make(map[string]string, cnt)
 	modelOids := make([]string, 0, cnt)
 
-	// oids := make([]string, 0, cnt)
+	// groups may share a model OID, such as the TS-MPPT and TS-MPPT-600V
+	seen := make(map[string]bool, cnt)
 	for _, devGroup := range cfg.Oids.DeviceGroups {
-		modelOids = append(modelOids, devGroup.GroupOid)
+		if devGroup.GroupOid != "" && !seen[devGroup.GroupOid] {
+			seen[devGroup.GroupOid] = true
+			modelOids = append(modelOids, devGroup.GroupOid)
+		}
 		for _, model := range devGroup.Modellist {
 			modelMap[model] = devGroup.ModelGroup
 		}
@@ -215,6 +360,213 @@ func (cfg *TSMConfig) ModelInfo() (*[]string, *map[string]string) {
 
 }
 
+// OidInfoFor returns the OidInfo for oid from the EMC OIDs or the current model group
+func (cfg *TSMConfig) OidInfoFor(oid string) (OidInfo, bool) {
+
+	for _, oidinfo := range cfg.Oids.EMCOids {
+		if oidinfo.Oid == oid {
+			return oidinfo, true
+		}
+	}
+	if curModelGroup == "" {
+		return OidInfo{}, false
+	}
+	devGroup := cfg.Oids.DeviceGroups[curModelNdx]
+	listlist := [][]OidInfo{devGroup.Static, devGroup.Status, devGroup.Measurements, devGroup.Alarms, devGroup.Faults}
+	for _, list := range listlist {
+		for _, oidinfo := range list {
+			if oidinfo.Oid == oid {
+				return oidinfo, true
+			}
+		}
+	}
+	return OidInfo{}, false
+}
+
+// OidInfoAnyGroup returns the OidInfo for oid from the EMC OIDs or any model group
+func (cfg *TSMConfig) OidInfoAnyGroup(oid string) (OidInfo, bool) {
+
+	for _, oidinfo := range cfg.Oids.EMCOids {
+		if oidinfo.Oid == oid {
+			return oidinfo, true
+		}
+	}
+	for _, devGroup := range cfg.Oids.DeviceGroups {
+		listlist := [][]OidInfo{devGroup.Static, devGroup.Status, devGroup.Measurements, devGroup.Alarms, devGroup.Faults}
+		for _, list := range listlist {
+			for _, oidinfo := range list {
+				if oidinfo.Oid == oid {
+					return oidinfo, true
+				}
+			}
+		}
+	}
+	return OidInfo{}, false
+}
+
+// FindOid returns the OidInfo in the current model group whose OID, chancode or
+// label (case insensitive) matches name
+func (cfg *TSMConfig) FindOid(name string) (OidInfo, bool) {
+
+	if oidinfo, ok := cfg.OidInfoFor(name); ok {
+		return oidinfo, true
+	}
+	if curModelGroup == "" {
+		return OidInfo{}, false
+	}
+	devGroup := cfg.Oids.DeviceGroups[curModelNdx]
+	listlist := [][]OidInfo{cfg.Oids.EMCOids, devGroup.Static, devGroup.Status, devGroup.Measurements, devGroup.Alarms, devGroup.Faults}
+	for _, list := range listlist {
+		for _, oidinfo := range list {
+			if (oidinfo.Chancode != "" && strings.EqualFold(oidinfo.Chancode, name)) ||
+				strings.EqualFold(oidinfo.Label, name) {
+				return oidinfo, true
+			}
+		}
+	}
+	return OidInfo{}, false
+}
+
+// ScaledValue returns the raw result for a "number" oid multiplied by its scaling factor
+func (cfg *TSMConfig) ScaledValue(oid string, scan *map[string]string) (float64, error) {
+
+	oidinfo, ok := cfg.OidInfoFor(oid)
+	if !ok {
+		return 0, fmt.Errorf("oid %s not found in current model group", oid)
+	}
+	if oidinfo.Type != "number" {
+		return 0, fmt.Errorf("oid %s (%s) is not a number", oid, oidinfo.Label)
+	}
+	resstr, ok := (*scan)[oid]
+	if !ok {
+		return 0, fmt.Errorf("oid %s (%s) missing from scan", oid, oidinfo.Label)
+	}
+	val, err := strconv.ParseFloat(resstr, 64)
+	if err != nil {
+		return 0, err
+	}
+	return val * oidinfo.Scaling, nil
+}
+
+// ProbeOids returns the fallback OIDs that identify the groups whose model
+// OID does not answer
+func (cfg *TSMConfig) ProbeOids() []string {
+
+	var oids []string
+	for _, devGroup := range cfg.Oids.DeviceGroups {
+		if devGroup.ProbeOid != "" {
+			oids = append(oids, devGroup.ProbeOid)
+		}
+	}
+	return oids
+}
+
+// ErrNoModel is returned by IdentifyModel for a device that answered none
+// of the model or probe OIDs, such as an EMC-1 without a controller
+var ErrNoModel = errors.New("device did not answer any model group or probe OID")
+
+// answer returns the result for oid, or "" if the device does not have it
+func answer(results map[string]string, oid string) string {
+	if oid == "" {
+		return ""
+	}
+	if val := strings.TrimSpace(results[oid]); val != "0" {
+		return val
+	}
+	return ""
+}
+
+// IdentifyModel returns the model and model group of the device from the
+// answers to the model and probe OIDs in results. A group matches when its
+// GroupOid answers a model in its Modellist; of groups sharing a GroupOid
+// the first in config order listing the model wins. Only if no model OID
+// answered are the probe OIDs used: a group matches when its ProbeOid
+// answers, with the model ProductIds maps the answer to, else the only
+// model in its list, else the group name. It is an error for no group or
+// several to match.
+func (cfg *TSMConfig) IdentifyModel(results map[string]string) (string, string, error) {
+
+	type match struct{ model, group string }
+	var (
+		matches  []match
+		unlisted []string
+	)
+
+	matched := make(map[string]bool)
+	for _, devGroup := range cfg.Oids.DeviceGroups {
+		model := answer(results, devGroup.GroupOid)
+		if model == "" || matched[devGroup.GroupOid] {
+			continue
+		}
+		for _, listed := range devGroup.Modellist {
+			if listed == model {
+				matches = append(matches, match{model, devGroup.ModelGroup})
+				matched[devGroup.GroupOid] = true
+				break
+			}
+		}
+	}
+	for _, devGroup := range cfg.Oids.DeviceGroups {
+		model := answer(results, devGroup.GroupOid)
+		if model == "" || matched[devGroup.GroupOid] {
+			continue
+		}
+		// report each unlisted answer once, whichever groups share its OID
+		matched[devGroup.GroupOid] = true
+		unlisted = append(unlisted, fmt.Sprintf("%s from %s", model, devGroup.GroupOid))
+	}
+
+	if len(matches) == 0 && len(unlisted) == 0 {
+		for _, devGroup := range cfg.Oids.DeviceGroups {
+			val := answer(results, devGroup.ProbeOid)
+			if val == "" || matched[devGroup.ProbeOid] {
+				continue
+			}
+			matched[devGroup.ProbeOid] = true
+			model, ok := devGroup.ProductIds[val]
+			if !ok && len(devGroup.Modellist) == 1 {
+				model = devGroup.Modellist[0]
+			} else if !ok {
+				model = devGroup.ModelGroup
+			}
+			matches = append(matches, match{model, devGroup.ModelGroup})
+		}
+	}
+
+	switch {
+	case len(matches) == 1:
+		return matches[0].model, matches[0].group, nil
+	case len(matches) > 1:
+		names := make([]string, len(matches))
+		for ndx, m := range matches {
+			names[ndx] = fmt.Sprintf("%s (%s)", m.model, m.group)
+		}
+		return "", "", fmt.Errorf("device matches several model groups: %s", strings.Join(names, ", "))
+	case len(unlisted) > 0:
+		return "", "", fmt.Errorf("device model not in any modellist: %s", strings.Join(unlisted, ", "))
+	}
+	return "", "", ErrNoModel
+}
+
+// ResolveModel returns the model and model group for name, a model in one
+// of the model lists or a model group name
+func (cfg *TSMConfig) ResolveModel(name string) (string, string, bool) {
+
+	for _, devGroup := range cfg.Oids.DeviceGroups {
+		for _, model := range devGroup.Modellist {
+			if model == name {
+				return model, devGroup.ModelGroup, true
+			}
+		}
+	}
+	for _, devGroup := range cfg.Oids.DeviceGroups {
+		if devGroup.ModelGroup == name {
+			return name, name, true
+		}
+	}
+	return "", "", false
+}
+
 func (cfg *TSMConfig) SetModel(model string) {
 	for ndx, devGroup := range cfg.Oids.DeviceGroups {
 		if model == devGroup.ModelGroup {
@@ -0,0 +1,56 @@
+package config
+
+import "testing"
+
+func TestReverseBits(t *testing.T) {
+
+	tests := []struct {
+		num  uint64
+		len  uint
+		want uint64
+	}{
+		{0, 8, 0},
+		{1, 8, 0x80},
+		{0x80, 8, 1},
+		{0b1011, 4, 0b1101},
+		{0b0110, 8, 0b01100000},
+		{0xff, 8, 0xff},
+	}
+	for _, tt := range tests {
+		if got := reverseBits(tt.num, tt.len); got != tt.want {
+			t.Errorf("reverseBits(%b, %d) = %b, want %b", tt.num, tt.len, got, tt.want)
+		}
+	}
+}
+
+func TestBitmapString(t *testing.T) {
+
+	names := []string{"rtsOpen", "rtsShorted", "rtsDisconnected"}
+	tests := []struct {
+		bits uint64
+		want string
+	}{
+		{0, ""},
+		{1, "rtsOpen"},
+		{0b110, "rtsShorted, rtsDisconnected"},
+		{0b111, "rtsOpen, rtsShorted, rtsDisconnected"},
+		{0b1000, ""},
+	}
+	for _, tt := range tests {
+		if got := bitmapString(tt.bits, names); got != tt.want {
+			t.Errorf("bitmapString(%b) = %q, want %q", tt.bits, got, tt.want)
+		}
+	}
+}
+
+func TestDataOidsInfoNeedsModel(t *testing.T) {
+
+	saved := curModelGroup
+	defer func() { curModelGroup = saved }()
+
+	curModelGroup = ""
+	cfg := NewConfig()
+	if _, _, err := cfg.DataOidsInfo(); err == nil {
+		t.Error("DataOidsInfo without a model did not fail")
+	}
+}
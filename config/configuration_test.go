@@ -0,0 +1,300 @@
+package config_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"tsm/config"
+	"tsm/config/configtest"
+)
+
+func TestValueString(t *testing.T) {
+
+	tests := []struct {
+		name string
+		info config.OidInfo
+		raw  string
+		want string
+	}{
+		{"string", config.OidInfo{Type: "string"}, "TS-MPPT-60", "TS-MPPT-60"},
+		{"number", config.OidInfo{Type: "number", Scaling: 0.1}, "125", "12.5"},
+		{"number unscaled", config.OidInfo{Type: "number", Scaling: 1}, "7", " 7.0"},
+		{"number not numeric", config.OidInfo{Type: "number", Scaling: 1}, "", " 0.0"},
+		{"bitreverse", config.OidInfo{Type: "bitreverse", Scaling: 8}, "1", "10000000"},
+		{"bitreverse padded", config.OidInfo{Type: "bitreverse", Scaling: 8}, "6", "01100000"},
+		{"map", config.OidInfo{Type: "map", Values: []string{"start", "night", "mppt"}}, "2", "mppt"},
+		{"map out of range", config.OidInfo{Type: "map", Values: []string{"start", "night"}}, "5", "5"},
+		{"bitmap", config.OidInfo{Type: "bitmap", Values: []string{"a", "b", "c"}}, "5", "a, c"},
+		{"bitmap none", config.OidInfo{Type: "bitmap", Values: []string{"a", "b"}}, "0", "None"},
+		{"bitmap unnamed bits", config.OidInfo{Type: "bitmap", Values: []string{"a"}}, "6", "None"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.info.ValueString(tt.raw); got != tt.want {
+				t.Errorf("ValueString(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetModelAndModelInfo(t *testing.T) {
+
+	cfg := configtest.NewConfig()
+
+	oids, models := cfg.ModelInfo()
+	if want := []string{configtest.MPPTGroupOid, configtest.PWMGroupOid}; !reflect.DeepEqual(*oids, want) {
+		t.Errorf("model oids = %v, want %v", *oids, want)
+	}
+	wantModels := map[string]string{
+		"TS-MPPT-45": "TS-MPPT",
+		"TS-MPPT-60": "TS-MPPT",
+		"TS-45":      "TS-PWM",
+		"TS-60":      "TS-PWM",
+	}
+	if !reflect.DeepEqual(*models, wantModels) {
+		t.Errorf("models = %v, want %v", *models, wantModels)
+	}
+
+	cfg.SetModel("TS-PWM")
+	if got := (*cfg.StatusOids())[0].Label; got != "Load State" {
+		t.Errorf("TS-PWM status = %s, want Load State", got)
+	}
+	cfg.SetModel("TS-MPPT")
+	if got := (*cfg.StatusOids())[1].Label; got != "Charge State" {
+		t.Errorf("TS-MPPT status = %s, want Charge State", got)
+	}
+
+	// an unknown model leaves the current one
+	cfg.SetModel("TS-UNKNOWN")
+	if got := (*cfg.StatusOids())[1].Label; got != "Charge State" {
+		t.Errorf("status after unknown model = %s, want Charge State", got)
+	}
+}
+
+func TestDataOidsInfo(t *testing.T) {
+
+	cfg := configtest.NewConfig()
+	cfg.SetModel("TS-MPPT")
+
+	oids, infos, err := cfg.DataOidsInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// status, then measurements, alarms and faults in config order
+	want := []string{
+		"1.3.6.1.4.1.33333.2.60.0",
+		"1.3.6.1.4.1.33333.2.46.0",
+		"1.3.6.1.4.1.33333.2.38.0",
+		"1.3.6.1.4.1.33333.2.43.0",
+		"1.3.6.1.4.1.33333.2.49.0",
+		"1.3.6.1.4.1.33333.2.57.0",
+		"1.3.6.1.4.1.33333.2.55.0",
+	}
+	if !reflect.DeepEqual(oids, want) {
+		t.Errorf("oids = %v, want %v", oids, want)
+	}
+	for ndx, info := range infos {
+		if info.Oid != oids[ndx] {
+			t.Errorf("info %d is %s, want %s", ndx, info.Oid, oids[ndx])
+		}
+	}
+
+	static, _, err := cfg.StaticOidsInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatic := []string{"1.3.6.1.4.1.33333.1.1.0", "1.3.6.1.4.1.33333.2.1.0", "1.3.6.1.4.1.33333.2.2.0"}
+	if !reflect.DeepEqual(static, wantStatic) {
+		t.Errorf("static oids = %v, want %v", static, wantStatic)
+	}
+}
+
+func TestFindOid(t *testing.T) {
+
+	cfg := configtest.NewConfig()
+	cfg.SetModel("TS-MPPT")
+
+	for _, name := range []string{"1.3.6.1.4.1.33333.2.38.0", "BV", "bv", "Battery voltage", "battery VOLTAGE"} {
+		info, ok := cfg.FindOid(name)
+		if !ok || info.Oid != "1.3.6.1.4.1.33333.2.38.0" {
+			t.Errorf("FindOid(%q) = %s, %v", name, info.Oid, ok)
+		}
+	}
+	if _, ok := cfg.FindOid("SP1"); ok {
+		t.Error("FindOid found a channel of another model group")
+	}
+}
+
+func TestOidInfoTOML(t *testing.T) {
+
+	tests := []struct {
+		info config.OidInfo
+		want string
+	}{
+		{config.OidInfo{Oid: "1.2.3", Type: "number", Scaling: 2},
+			`{ oid = "1.2.3", chancode = "", label = "", units = "", type = "number", scaling = 2.0 }`},
+		{config.OidInfo{Oid: "1.2.3", Type: "string"},
+			`{ oid = "1.2.3", chancode = "", label = "", units = "", type = "string", scaling = 1.0 }`},
+		{config.OidInfo{Oid: "1.2.3", Chancode: "LS", Label: "Load State", Type: "map", Values: []string{"Start", "Normal"}},
+			`{ oid = "1.2.3", chancode = "LS", label = "Load State", units = "", type = "map", values = ["Start", "Normal"] }`},
+		{config.OidInfo{Oid: "1.2.4", Chancode: "ALT", Label: "Alarms (today)", Type: "bitmap", Values: []string{"RTS open"}, Latched: true},
+			`{ oid = "1.2.4", chancode = "ALT", label = "Alarms (today)", units = "", type = "bitmap", values = ["RTS open"], latched = true }`},
+	}
+	for _, tt := range tests {
+		if got := tt.info.TOML(); got != tt.want {
+			t.Errorf("TOML(%+v) = %s, want %s", tt.info, got, tt.want)
+		}
+	}
+}
+
+func TestModelInfoSharedGroupOid(t *testing.T) {
+
+	cfg := configtest.NewConfig()
+	cfg.Oids.DeviceGroups = append(cfg.Oids.DeviceGroups,
+		config.DeviceInfo{GroupOid: configtest.MPPTGroupOid, ModelGroup: "TS-MPPT-600V", Modellist: []string{"TS-MPPT-60-600V-48"}},
+		config.DeviceInfo{ModelGroup: "SS-MPPT", Modellist: []string{"SS-MPPT-15L"}, ProbeOid: "1.3.6.1.4.1.33333.5.3.0"})
+
+	oids, models := cfg.ModelInfo()
+	if want := []string{configtest.MPPTGroupOid, configtest.PWMGroupOid}; !reflect.DeepEqual(*oids, want) {
+		t.Errorf("model oids = %v, want %v", *oids, want)
+	}
+	if group := (*models)["TS-MPPT-60-600V-48"]; group != "TS-MPPT-600V" {
+		t.Errorf("TS-MPPT-60-600V-48 group = %q, want TS-MPPT-600V", group)
+	}
+}
+
+func TestIdentifyModel(t *testing.T) {
+
+	const (
+		psProbe = "1.3.6.1.4.1.33333.4.3.0"
+		ssProbe = "1.3.6.1.4.1.33333.5.3.0"
+	)
+	cfg := configtest.NewConfig()
+	cfg.Oids.DeviceGroups = append(cfg.Oids.DeviceGroups,
+		// TS-MPPT-60 is also listed by the TS-MPPT group earlier in the config
+		config.DeviceInfo{GroupOid: configtest.MPPTGroupOid, ModelGroup: "TS-MPPT-600V", Modellist: []string{"TS-MPPT-60-600V-48", "TS-MPPT-60"}},
+		config.DeviceInfo{ModelGroup: "PS-MPPT", Modellist: []string{"PS-MPPT-25", "PS-MPPT-40"},
+			ProbeOid: psProbe, ProductIds: map[string]string{"2": "PS-MPPT-40"}},
+		config.DeviceInfo{ModelGroup: "SS-MPPT", Modellist: []string{"SS-MPPT-15L"}, ProbeOid: ssProbe})
+
+	if want := []string{psProbe, ssProbe}; !reflect.DeepEqual(cfg.ProbeOids(), want) {
+		t.Errorf("ProbeOids = %v, want %v", cfg.ProbeOids(), want)
+	}
+
+	tests := []struct {
+		name    string
+		results map[string]string
+		model   string
+		group   string
+		err     string
+	}{
+		{"model oid", map[string]string{configtest.MPPTGroupOid: "TS-MPPT-60", configtest.PWMGroupOid: "0"}, "TS-MPPT-60", "TS-MPPT", ""},
+		{"shared model oid", map[string]string{configtest.MPPTGroupOid: "TS-MPPT-60-600V-48"}, "TS-MPPT-60-600V-48", "TS-MPPT-600V", ""},
+		{"model oid before probe", map[string]string{configtest.PWMGroupOid: "TS-45", ssProbe: "v1.1"}, "TS-45", "TS-PWM", ""},
+		{"product id", map[string]string{psProbe: " 2 "}, "PS-MPPT-40", "PS-MPPT", ""},
+		{"unknown product id", map[string]string{psProbe: "7"}, "PS-MPPT", "PS-MPPT", ""},
+		{"single model", map[string]string{ssProbe: "v1.1"}, "SS-MPPT-15L", "SS-MPPT", ""},
+		{"several models", map[string]string{configtest.MPPTGroupOid: "TS-MPPT-60", configtest.PWMGroupOid: "TS-45"}, "", "", "several model groups: TS-MPPT-60 (TS-MPPT), TS-45 (TS-PWM)"},
+		{"several probes", map[string]string{psProbe: "2", ssProbe: "v1.1"}, "", "", "several model groups"},
+		{"unlisted model", map[string]string{configtest.MPPTGroupOid: "TS-MPPT-80", ssProbe: "v1.1"}, "", "", "not in any modellist: TS-MPPT-80 from " + configtest.MPPTGroupOid},
+		{"no answer", map[string]string{psProbe: "0", ssProbe: ""}, "", "", config.ErrNoModel.Error()},
+	}
+	for _, tt := range tests {
+		model, group, err := cfg.IdentifyModel(tt.results)
+		if model != tt.model || group != tt.group {
+			t.Errorf("%s: IdentifyModel = %q, %q, want %q, %q", tt.name, model, group, tt.model, tt.group)
+		}
+		if (err == nil) != (tt.err == "") || (err != nil && !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s: IdentifyModel error = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestResolveModel(t *testing.T) {
+
+	cfg := configtest.NewConfig()
+
+	tests := []struct {
+		name  string
+		model string
+		group string
+	}{
+		{"TS-45", "TS-45", "TS-PWM"},
+		{"TS-MPPT", "TS-MPPT", "TS-MPPT"},
+		{"TS-80", "", ""},
+	}
+	for _, tt := range tests {
+		model, group, ok := cfg.ResolveModel(tt.name)
+		if model != tt.model || group != tt.group || ok != (tt.model != "") {
+			t.Errorf("ResolveModel(%s) = %q, %q, %v, want %q, %q", tt.name, model, group, ok, tt.model, tt.group)
+		}
+	}
+}
+
+func TestHostPort(t *testing.T) {
+
+	tests := []struct {
+		host string
+		want string
+		err  bool
+	}{
+		{"", ":", false},
+		{"10.0.0.5", "10.0.0.5:161", false},
+		{"emc1.local:1161", "emc1.local:1161", false},
+		{"fe80::1", "fe80::1:161", false},
+		{"[fe80::1]:1161", "fe80::1:1161", false},
+		{"10.0.0.5:snmp", "", true},
+		{":161", "", true},
+	}
+	for _, tt := range tests {
+		cfg := configtest.NewConfig()
+		cfg.Host = tt.host
+		host, port, err := cfg.HostPort()
+		if (err != nil) != tt.err || (err == nil && host+":"+port != tt.want) {
+			t.Errorf("HostPort(%q) = %q, %q, %v, want %q", tt.host, host, port, err, tt.want)
+		}
+		if verr := cfg.Validate(); (verr != nil) != tt.err {
+			t.Errorf("Validate with host %q = %v", tt.host, verr)
+		}
+	}
+
+	cfg := configtest.NewConfig()
+	cfg.Version = "3"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "1 or 2c") {
+		t.Errorf("Validate with version 3 = %v", err)
+	}
+}
+
+func TestPollIntervals(t *testing.T) {
+
+	cfg := configtest.NewConfig()
+	cfg.SetModel("TS-MPPT")
+	cfg.Poll.Intervals = map[string]float64{"measurements": 600, "bv": 10, "serial number": 3600, "XX": 60}
+	if err := cfg.Poll.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	intervals, unknown := cfg.PollIntervals()
+	want := map[string]float64{
+		"1.3.6.1.4.1.33333.1.1.0":  0, // static by default
+		"1.3.6.1.4.1.33333.2.1.0":  0,
+		"1.3.6.1.4.1.33333.2.2.0":  3600, // by label
+		"1.3.6.1.4.1.33333.2.38.0": 10,   // by chancode
+		"1.3.6.1.4.1.33333.2.43.0": 600,
+		"1.3.6.1.4.1.33333.2.49.0": 600,
+	}
+	if !reflect.DeepEqual(intervals, want) {
+		t.Errorf("PollIntervals = %v, want %v", intervals, want)
+	}
+	if !reflect.DeepEqual(unknown, []string{"XX"}) {
+		t.Errorf("unknown names = %v, want [XX]", unknown)
+	}
+
+	cfg.Poll.Intervals = map[string]float64{"faults": -1}
+	if err := cfg.Poll.Validate(); err == nil {
+		t.Error("negative interval accepted")
+	}
+}
@@ -0,0 +1,17 @@
+package config
+
+import "fmt"
+
+// DerivedChannel is a value computed by tsm from the polled scans rather
+// than read directly from the device
+type DerivedChannel struct {
+	Chancode string
+	Label    string
+	Units    string
+	Value    float64
+}
+
+// ValueString generates a text string for output of the derived value
+func (dc *DerivedChannel) ValueString() string {
+	return fmt.Sprintf("%.3f", dc.Value)
+}
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WriteDeviceGroups writes groups as the devicegroups array of the [oids]
+// section, laid out as in tsm.toml
+func WriteDeviceGroups(w io.Writer, groups []DeviceInfo) error {
+
+	var b strings.Builder
+	b.WriteString("devicegroups = [\n")
+	for _, group := range groups {
+		quoted := make([]string, len(group.Modellist))
+		for ndx, model := range group.Modellist {
+			quoted[ndx] = fmt.Sprintf("%q", model)
+		}
+		fmt.Fprintf(&b, "    {\n")
+		fmt.Fprintf(&b, "        groupoid = %q,\n", group.GroupOid)
+		fmt.Fprintf(&b, "        modelgroup = %q,\n", group.ModelGroup)
+		fmt.Fprintf(&b, "        modellist = [%s],\n", strings.Join(quoted, ", "))
+		lists := []struct {
+			name string
+			oids []OidInfo
+		}{
+			{"static", group.Static},
+			{"status", group.Status},
+			{"measurements", group.Measurements},
+			{"alarms", group.Alarms},
+			{"faults", group.Faults},
+		}
+		for ndx, list := range lists {
+			fmt.Fprintf(&b, "        %s = [\n", list.name)
+			for _, oidInfo := range list.oids {
+				fmt.Fprintf(&b, "            %s,\n", oidInfo.TOML())
+			}
+			// no comma after the last key of the inline table
+			if ndx < len(lists)-1 {
+				fmt.Fprintf(&b, "        ],\n")
+			} else {
+				fmt.Fprintf(&b, "        ]\n")
+			}
+		}
+		fmt.Fprintf(&b, "    },\n")
+	}
+	b.WriteString("]\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
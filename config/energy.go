@@ -0,0 +1,107 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// EnergyConfig holds the settings for integrating current and power
+// channels into daily amp-hour and kWh totals
+type EnergyConfig struct {
+	Enabled      bool
+	StateFile    string
+	Reset        string // "utc" or "local" midnight
+	MaxGap       float64
+	SaveInterval float64
+	Accumulators []AccumulatorConfig
+}
+
+// AccumulatorConfig describes one daily energy total. Current is the OID of
+// a current channel in amps, Power the OID of a power channel in watts. If
+// Power is not given but Voltage is, power is calculated as current * voltage.
+type AccumulatorConfig struct {
+	Name        string
+	Current     string
+	Voltage     string
+	Power       string
+	AhChancode  string
+	KWhChancode string
+}
+
+const (
+	// DefaultEnergyMaxGap is the longest gap in seconds that will be integrated across
+	DefaultEnergyMaxGap float64 = 180
+	// DefaultEnergySaveInterval is how often in seconds the running totals are saved
+	DefaultEnergySaveInterval float64 = 60
+)
+
+// Validate the energy section of the config
+func (ecfg *EnergyConfig) Validate() error {
+
+	if !ecfg.Enabled {
+		return nil
+	}
+
+	switch ecfg.Reset {
+	case "":
+		ecfg.Reset = "utc"
+	case "utc", "local":
+	default:
+		return fmt.Errorf("energy: invalid reset %q, must be \"utc\" or \"local\"", ecfg.Reset)
+	}
+	if ecfg.MaxGap <= 0 {
+		ecfg.MaxGap = DefaultEnergyMaxGap
+	}
+	if ecfg.SaveInterval <= 0 {
+		ecfg.SaveInterval = DefaultEnergySaveInterval
+	}
+	if ecfg.StateFile == "" {
+		return errors.New("energy: statefile must be specified")
+	}
+
+	names := make(map[string]bool)
+	for _, acc := range ecfg.Accumulators {
+		if acc.Name == "" {
+			return errors.New("energy: accumulator name must be specified")
+		}
+		if names[acc.Name] {
+			return fmt.Errorf("energy: duplicate accumulator name %q", acc.Name)
+		}
+		names[acc.Name] = true
+		if acc.Current == "" && acc.Power == "" {
+			return fmt.Errorf("energy: accumulator %q needs a current or power oid", acc.Name)
+		}
+		if acc.KWhChancode != "" && acc.Power == "" && (acc.Current == "" || acc.Voltage == "") {
+			return fmt.Errorf("energy: accumulator %q needs a power oid or current and voltage oids for kWh", acc.Name)
+		}
+	}
+
+	return nil
+}
+
+// checkEnergyOids rejects accumulator OIDs that are not "number" channels of
+// a device group, which could never be integrated
+func (cfg *TSMConfig) checkEnergyOids() error {
+
+	if !cfg.Energy.Enabled {
+		return nil
+	}
+	for _, acc := range cfg.Energy.Accumulators {
+		channels := []struct{ name, oid string }{
+			{"current", acc.Current}, {"voltage", acc.Voltage}, {"power", acc.Power},
+		}
+		for _, ch := range channels {
+			if ch.oid == "" {
+				continue
+			}
+			oidinfo, ok := cfg.OidInfoAnyGroup(ch.oid)
+			if !ok {
+				return fmt.Errorf("energy: accumulator %q %s oid %s is not in any device group", acc.Name, ch.name, ch.oid)
+			}
+			if oidinfo.Type != "number" {
+				return fmt.Errorf("energy: accumulator %q %s oid %s (%s) is not a number", acc.Name, ch.name, ch.oid, oidinfo.Label)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package config_test
+
+import (
+	"strings"
+	"testing"
+
+	"tsm/config"
+)
+
+func TestEnergyOids(t *testing.T) {
+
+	tests := []struct {
+		current string
+		err     string
+	}{
+		{"1.3.6.1.4.1.33333.8.34.0", ""},
+		{"1.3.6.1.4.1.33333.8.99.0", "not in any device group"},
+		{"1.3.6.1.4.1.33333.8.47.0", "is not a number"},
+	}
+
+	for _, tt := range tests {
+		cfg := config.NewConfig()
+		cfg.Oids.DeviceGroups = []config.DeviceInfo{{
+			ModelGroup: "TS-PWM",
+			Status: []config.OidInfo{
+				{Oid: "1.3.6.1.4.1.33333.8.47.0", Label: "Load State", Type: "map"},
+			},
+			Measurements: []config.OidInfo{
+				{Oid: "1.3.6.1.4.1.33333.8.34.0", Label: "Load current", Type: "number", Scaling: 1},
+			},
+		}}
+		cfg.Energy = config.EnergyConfig{
+			Enabled:      true,
+			StateFile:    "energy.json",
+			Accumulators: []config.AccumulatorConfig{{Name: "load", Current: tt.current}},
+		}
+		err := cfg.Validate()
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("current %s: error %v, want %q", tt.current, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package config
+
+import (
+	"fmt"
+	"log/syslog"
+	"strings"
+)
+
+// EventsConfig holds the settings for detecting alarm, fault and state
+// transitions between successive scans
+type EventsConfig struct {
+	Enabled       bool
+	AlarmSeverity string   // severity of an alarm bit setting
+	FaultSeverity string   // severity of a fault bit setting or a fault state
+	ClearSeverity string   // severity of a bit clearing
+	StateSeverity string   // severity of any other state change
+	FaultStates   []string // map values treated as faults, case insensitive
+}
+
+var severities = map[string]syslog.Priority{
+	"emerg":   syslog.LOG_EMERG,
+	"alert":   syslog.LOG_ALERT,
+	"crit":    syslog.LOG_CRIT,
+	"err":     syslog.LOG_ERR,
+	"warning": syslog.LOG_WARNING,
+	"notice":  syslog.LOG_NOTICE,
+	"info":    syslog.LOG_INFO,
+	"debug":   syslog.LOG_DEBUG,
+}
+
+// ParseSeverity converts a syslog severity name such as "warning" to its priority
+func ParseSeverity(name string) (syslog.Priority, error) {
+	if sev, ok := severities[strings.ToLower(name)]; ok {
+		return sev, nil
+	}
+	return 0, fmt.Errorf("invalid severity %q", name)
+}
+
+// SeverityName converts a syslog priority to its severity name
+func SeverityName(sev syslog.Priority) string {
+	for name, pri := range severities {
+		if pri == sev&0b0111 {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+// Validate the events section of the config
+func (evcfg *EventsConfig) Validate() error {
+
+	defaults := []struct {
+		sev *string
+		def string
+	}{
+		{&evcfg.AlarmSeverity, "warning"},
+		{&evcfg.FaultSeverity, "err"},
+		{&evcfg.ClearSeverity, "notice"},
+		{&evcfg.StateSeverity, "notice"},
+	}
+	for _, d := range defaults {
+		if *d.sev == "" {
+			*d.sev = d.def
+		}
+		if _, err := ParseSeverity(*d.sev); err != nil {
+			return fmt.Errorf("events: %s", err.Error())
+		}
+	}
+	if len(evcfg.FaultStates) == 0 {
+		evcfg.FaultStates = []string{"fault"}
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// InventoryDevice is a controller found on the network by the discover
+// command, written to the config as an [[inventory]] table
+type InventoryDevice struct {
+	Host        string // address:port of the EMC-1
+	EMCSerial   string
+	EMCFirmware string
+	Model       string // controller model, empty if no controller answered
+	ModelGroup  string
+	Serial      string // controller serial number
+	Discovered  time.Time
+}
+
+const (
+	// inventoryBegin and inventoryEnd mark the inventory written by
+	// WriteInventory, which replaces everything between them
+	inventoryBegin = "# BEGIN inventory, written by tsm discover"
+	inventoryEnd   = "# END inventory"
+)
+
+// MergeInventory returns the devices in inventory updated with those in
+// found, sorted by host
+func MergeInventory(inventory, found []InventoryDevice) []InventoryDevice {
+
+	byHost := make(map[string]InventoryDevice, len(inventory)+len(found))
+	for _, dev := range inventory {
+		byHost[dev.Host] = dev
+	}
+	for _, dev := range found {
+		byHost[dev.Host] = dev
+	}
+
+	merged := make([]InventoryDevice, 0, len(byHost))
+	for _, dev := range byHost {
+		merged = append(merged, dev)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return hostLess(merged[i].Host, merged[j].Host)
+	})
+	return merged
+}
+
+// hostLess orders address:port hosts by IP address, then port
+func hostLess(a, b string) bool {
+
+	ahost, aport, _ := net.SplitHostPort(a)
+	bhost, bport, _ := net.SplitHostPort(b)
+	aip, bip := net.ParseIP(ahost), net.ParseIP(bhost)
+	if aip == nil || bip == nil {
+		return a < b
+	}
+	if cmp := bytes.Compare(aip.To16(), bip.To16()); cmp != 0 {
+		return cmp < 0
+	}
+	return aport < bport
+}
+
+// WriteInventory replaces the inventory in the config file at path with
+// devices, or appends it if the file has none. The rest of the file is left
+// as it is.
+func WriteInventory(path string, devices []InventoryDevice) error {
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	text := string(content)
+
+	var block strings.Builder
+	block.WriteString(inventoryBegin + "\n")
+	for _, dev := range devices {
+		fmt.Fprintf(&block, "[[inventory]]\n")
+		fmt.Fprintf(&block, "host = %q\n", dev.Host)
+		fmt.Fprintf(&block, "emcserial = %q\n", dev.EMCSerial)
+		fmt.Fprintf(&block, "emcfirmware = %q\n", dev.EMCFirmware)
+		fmt.Fprintf(&block, "model = %q\n", dev.Model)
+		fmt.Fprintf(&block, "modelgroup = %q\n", dev.ModelGroup)
+		fmt.Fprintf(&block, "serial = %q\n", dev.Serial)
+		fmt.Fprintf(&block, "discovered = %s\n", dev.Discovered.UTC().Format(time.RFC3339))
+	}
+	block.WriteString(inventoryEnd + "\n")
+
+	begin := strings.Index(text, inventoryBegin)
+	end := strings.Index(text, inventoryEnd)
+	switch {
+	case begin >= 0 && end > begin:
+		end += len(inventoryEnd)
+		if end < len(text) && text[end] == '\n' {
+			end++
+		}
+		text = text[:begin] + block.String() + text[end:]
+	case begin >= 0 || end >= 0:
+		return fmt.Errorf("%s: unmatched inventory markers", path)
+	default:
+		if text != "" && !strings.HasSuffix(text, "\n") {
+			text += "\n"
+		}
+		text += "\n" + block.String()
+	}
+
+	// write a temporary file and rename it so a failed write leaves the config intact
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(text), info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,103 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"tsm/config"
+
+	"github.com/pelletier/go-toml"
+)
+
+func TestMergeInventory(t *testing.T) {
+
+	old := []config.InventoryDevice{
+		{Host: "10.0.0.10:161", Model: "TS-45"},
+		{Host: "10.0.0.2:161", Model: "TS-MPPT-45"},
+	}
+	found := []config.InventoryDevice{
+		{Host: "10.0.0.2:161", Model: "TS-MPPT-60"},
+		{Host: "10.0.0.9:161", Model: "TS-60"},
+	}
+
+	got := config.MergeInventory(old, found)
+	want := []config.InventoryDevice{
+		{Host: "10.0.0.2:161", Model: "TS-MPPT-60"},
+		{Host: "10.0.0.9:161", Model: "TS-60"},
+		{Host: "10.0.0.10:161", Model: "TS-45"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MergeInventory = %+v, want %+v", got, want)
+	}
+}
+
+// readInventory parses the inventory tables in the config file at path
+func readInventory(t *testing.T, path string) []config.InventoryDevice {
+
+	t.Helper()
+	tree, err := toml.LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg struct {
+		Inventory []struct {
+			Host, EMCSerial, Model string
+			Discovered             time.Time
+		} `toml:"inventory"`
+	}
+	if err := tree.Unmarshal(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	var devices []config.InventoryDevice
+	for _, dev := range cfg.Inventory {
+		devices = append(devices, config.InventoryDevice{Host: dev.Host, EMCSerial: dev.EMCSerial, Model: dev.Model, Discovered: dev.Discovered})
+	}
+	return devices
+}
+
+func TestWriteInventory(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "tsm.toml")
+	original := "# station config\n[general]\nsta = \"TEST\"\n"
+	if err := os.WriteFile(path, []byte(original), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	discovered := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	first := []config.InventoryDevice{
+		{Host: "10.0.0.2:161", EMCSerial: "EMC0002", Model: "TS-MPPT-60", Discovered: discovered},
+		{Host: "10.0.0.5:161", EMCSerial: "EMC0005", Model: "TS-45", Discovered: discovered},
+	}
+	if err := config.WriteInventory(path, first); err != nil {
+		t.Fatal(err)
+	}
+	if got := readInventory(t, path); !reflect.DeepEqual(got, first) {
+		t.Errorf("inventory = %+v, want %+v", got, first)
+	}
+
+	second := first[1:]
+	if err := config.WriteInventory(path, second); err != nil {
+		t.Fatal(err)
+	}
+	if got := readInventory(t, path); !reflect.DeepEqual(got, second) {
+		t.Errorf("rewritten inventory = %+v, want %+v", got, second)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(content), original) {
+		t.Errorf("config before the inventory changed:\n%s", content)
+	}
+	if n := strings.Count(string(content), "[[inventory]]"); n != 1 {
+		t.Errorf("config has %d inventory tables, want 1:\n%s", n, content)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("config mode changed: %v %v", info.Mode(), err)
+	}
+}
@@ -0,0 +1,192 @@
+package config
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pelletier/go-toml"
+)
+
+// MaxIncludeDepth limits how deeply config files may include others
+const MaxIncludeDepth = 8
+
+//go:embed defaults.toml
+var defaults []byte
+
+// Defaults returns the built in config, the OIDs of the EMC-1 and the
+// Morningstar controllers, that config files are merged over
+func Defaults() io.Reader {
+	return bytes.NewReader(defaults)
+}
+
+// Layer is the settings of one config file, with its include list removed
+type Layer struct {
+	Path     string
+	Settings map[string]interface{}
+}
+
+// ReadLayers reads the config file at path and the files it includes,
+// returning them in the order they are merged: the files each file
+// includes, in order, before the file itself
+func ReadLayers(path string) ([]Layer, error) {
+	return readLayers(path, nil)
+}
+
+func readLayers(path string, including []string) ([]Layer, error) {
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, inc := range including {
+		if inc == abs {
+			return nil, fmt.Errorf("%s: includes itself", path)
+		}
+	}
+	if len(including) > MaxIncludeDepth {
+		return nil, fmt.Errorf("%s: includes nested more than %d deep", path, MaxIncludeDepth)
+	}
+
+	tree, err := toml.LoadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	settings := tree.ToMap()
+
+	key, val := lookup(settings, "include")
+	delete(settings, key)
+	includes, err := includePaths(path, val)
+	if err != nil {
+		return nil, err
+	}
+
+	var layers []Layer
+	nested := append(append([]string{}, including...), abs)
+	for _, inc := range includes {
+		incLayers, err := readLayers(inc, nested)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, incLayers...)
+	}
+	return append(layers, Layer{Path: path, Settings: settings}), nil
+}
+
+// includePaths returns the files include, a path or list of paths or
+// patterns, names, relative to the directory of the file at path
+func includePaths(path string, include interface{}) ([]string, error) {
+
+	var patterns []string
+	switch inc := include.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		patterns = []string{inc}
+	case []interface{}:
+		for _, item := range inc {
+			pattern, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s: include must be a list of file names", path)
+			}
+			patterns = append(patterns, pattern)
+		}
+	default:
+		return nil, fmt.Errorf("%s: include must be a list of file names", path)
+	}
+
+	var paths []string
+	for _, pattern := range patterns {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s: include %s: %w", path, pattern, err)
+		}
+		// a pattern may match nothing, a file name must exist
+		if len(matches) == 0 && !strings.ContainsAny(pattern, "*?[") {
+			return nil, fmt.Errorf("%s: include %s: %w", path, pattern, os.ErrNotExist)
+		}
+		paths = append(paths, matches...)
+	}
+	return paths, nil
+}
+
+// lookup returns the key in settings matching name case insensitively, as
+// viper does, and its value
+func lookup(settings map[string]interface{}, name string) (string, interface{}) {
+	for key, val := range settings {
+		if strings.EqualFold(key, name) {
+			return key, val
+		}
+	}
+	return name, nil
+}
+
+// MergeDeviceGroups replaces the device groups in the settings of a layer
+// with base, the groups merged so far, updated with the layer's groups: a
+// group replaces the one in base of the same modelgroup, compared case
+// insensitively, or is added after them. Settings without device groups are left as they are.
+func MergeDeviceGroups(base interface{}, settings map[string]interface{}) {
+
+	_, oidsVal := lookup(settings, "oids")
+	oids, ok := oidsVal.(map[string]interface{})
+	if !ok {
+		return
+	}
+	groupsKey, groupsVal := lookup(oids, "devicegroups")
+	groups, ok := groupsVal.([]interface{})
+	if !ok {
+		return
+	}
+
+	merged := append([]interface{}{}, tableList(base)...)
+	for _, group := range groups {
+		name := modelGroupName(group)
+		replaced := false
+		for ndx, prev := range merged {
+			if name != "" && strings.EqualFold(modelGroupName(prev), name) {
+				merged[ndx] = group
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			merged = append(merged, group)
+		}
+	}
+	oids[groupsKey] = merged
+}
+
+// tableList returns a decoded array of tables as a list
+func tableList(val interface{}) []interface{} {
+
+	switch list := val.(type) {
+	case []interface{}:
+		return list
+	case []map[string]interface{}:
+		tables := make([]interface{}, len(list))
+		for ndx, table := range list {
+			tables[ndx] = table
+		}
+		return tables
+	}
+	return nil
+}
+
+// modelGroupName returns the modelgroup of a decoded device group
+func modelGroupName(group interface{}) string {
+
+	table, ok := group.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	_, name := lookup(table, "modelgroup")
+	str, _ := name.(string)
+	return str
+}
@@ -0,0 +1,172 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"tsm/config"
+
+	"github.com/pelletier/go-toml"
+	"github.com/spf13/viper"
+)
+
+// writeFiles writes files, by path relative to dir, returning dir
+func writeFiles(t *testing.T, files map[string]string) string {
+
+	t.Helper()
+	dir := t.TempDir()
+	for name, text := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// modelGroups returns the modelgroup of each device group in settings
+func modelGroups(settings map[string]interface{}) []string {
+
+	var names []string
+	oids, _ := settings["oids"].(map[string]interface{})
+	groups, _ := oids["devicegroups"].([]interface{})
+	for _, group := range groups {
+		names = append(names, group.(map[string]interface{})["modelgroup"].(string))
+	}
+	return names
+}
+
+func TestDefaults(t *testing.T) {
+
+	tree, err := toml.LoadReader(config.Defaults())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"TS-MPPT", "TS-PWM", "TS-MPPT-600V"}
+	if got := modelGroups(tree.ToMap()); !reflect.DeepEqual(got, want) {
+		t.Errorf("default model groups = %v, want %v", got, want)
+	}
+
+	// decoded as loadConfig does
+	v := viper.New()
+	v.SetConfigType("toml")
+	if err := v.ReadConfig(config.Defaults()); err != nil {
+		t.Fatal(err)
+	}
+	var cfg config.TSMConfig
+	if err := v.Unmarshal(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	today := 0
+	for _, devGroup := range cfg.Oids.DeviceGroups {
+		for _, oidInfo := range append(devGroup.Alarms, devGroup.Faults...) {
+			if !strings.Contains(oidInfo.Label, "(today)") {
+				continue
+			}
+			today++
+			if !oidInfo.Latched {
+				t.Errorf("%s %s is not latched", devGroup.ModelGroup, oidInfo.Label)
+			}
+		}
+	}
+	if today == 0 {
+		t.Error("no (today) bitmaps in the defaults")
+	}
+	if tree.Has("general") {
+		t.Error("defaults have station settings")
+	}
+}
+
+func TestReadLayers(t *testing.T) {
+
+	dir := writeFiles(t, map[string]string{
+		"tsm.toml":             "include = [\"groups.toml\", \"tsm.d/*.toml\"]\n[general]\nsta = \"SITE\"\n",
+		"groups.toml":          "include = \"/dev/null\"\n",
+		"tsm.d/20-notify.toml": "[notify]\nenabled = false\n",
+		"tsm.d/10-store.toml":  "include = \"../common.toml\"\n[store]\nenabled = false\n",
+		"common.toml":          "[general]\nnet = \"II\"\n",
+	})
+
+	layers, err := config.ReadLayers(filepath.Join(dir, "tsm.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, layer := range layers {
+		paths = append(paths, strings.TrimPrefix(layer.Path, dir+string(filepath.Separator)))
+		if _, ok := layer.Settings["include"]; ok {
+			t.Errorf("%s: include not removed", layer.Path)
+		}
+	}
+	want := []string{"/dev/null", "groups.toml", "common.toml", "tsm.d/10-store.toml", "tsm.d/20-notify.toml", "tsm.toml"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("layers = %v, want %v", paths, want)
+	}
+}
+
+func TestReadLayersErrors(t *testing.T) {
+
+	tests := map[string]map[string]string{
+		"includes itself": {
+			"tsm.toml":   "include = [\"other.toml\"]\n",
+			"other.toml": "include = [\"tsm.toml\"]\n",
+		},
+		"does not exist": {
+			"tsm.toml": "include = [\"missing.toml\"]\n",
+		},
+		"list of file names": {
+			"tsm.toml": "include = 3\n",
+		},
+		"tsm.toml: (1, 2)": {
+			"tsm.toml": "[general\n",
+		},
+	}
+	for want, files := range tests {
+		dir := writeFiles(t, files)
+		if _, err := config.ReadLayers(filepath.Join(dir, "tsm.toml")); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("ReadLayers error = %v, want %q", err, want)
+		}
+	}
+
+	// a pattern matching nothing is not an error
+	dir := writeFiles(t, map[string]string{"tsm.toml": "include = [\"tsm.d/*.toml\"]\n"})
+	if layers, err := config.ReadLayers(filepath.Join(dir, "tsm.toml")); err != nil || len(layers) != 1 {
+		t.Errorf("ReadLayers = %d layers, %v, want 1", len(layers), err)
+	}
+}
+
+func TestMergeDeviceGroups(t *testing.T) {
+
+	group := func(name, oid string) map[string]interface{} {
+		return map[string]interface{}{"modelgroup": name, "groupoid": oid}
+	}
+	base := []interface{}{group("TS-MPPT", "1"), group("TS-PWM", "2")}
+	settings := map[string]interface{}{
+		"oids": map[string]interface{}{
+			"devicegroups": []interface{}{group("ts-pwm", "3"), map[string]interface{}{"ModelGroup": "NEW", "groupoid": "4"}},
+		},
+	}
+
+	config.MergeDeviceGroups(base, settings)
+	got := settings["oids"].(map[string]interface{})["devicegroups"]
+	want := []interface{}{group("TS-MPPT", "1"), group("ts-pwm", "3"), map[string]interface{}{"ModelGroup": "NEW", "groupoid": "4"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("merged groups = %v, want %v", got, want)
+	}
+	if base[1].(map[string]interface{})["groupoid"] != "2" {
+		t.Error("base groups changed")
+	}
+
+	// settings without device groups are left as they are
+	settings = map[string]interface{}{"oids": map[string]interface{}{"emcoids": []interface{}{}}}
+	config.MergeDeviceGroups(base, settings)
+	if _, ok := settings["oids"].(map[string]interface{})["devicegroups"]; ok {
+		t.Error("device groups added to settings without them")
+	}
+}
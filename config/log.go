@@ -0,0 +1,121 @@
+package config
+
+import (
+	"fmt"
+	"log/syslog"
+)
+
+// LogConfig holds the log destinations, used unless -log is given, and the
+// suppression of repeated messages
+type LogConfig struct {
+	Level      string // lowest level logged, notice by default; --debug logs everything
+	Sinks      []LogSinkConfig
+	Suppress   float64 // seconds identical messages are collapsed for, negative to disable
+	RateLimits []LogRateLimit
+}
+
+// LogRateLimit allows Count messages with Key per Period seconds
+type LogRateLimit struct {
+	Key    string
+	Count  int
+	Period float64
+}
+
+// LogSinkConfig is one log destination
+type LogSinkConfig struct {
+	Type   string // syslog, stderr or file
+	Path   string // file
+	Format string // text or json, for stderr and file
+	// MaxSize is the megabytes a file is rotated at, 0 for no rotation.
+	// Unset uses DefaultLogMaxSize.
+	MaxSize *float64
+	// MaxFiles is the number of rotated files kept, 0 to keep none. Unset
+	// uses DefaultLogMaxFiles.
+	MaxFiles *int
+}
+
+const (
+	// DefaultLogMaxSize is the size in megabytes a log file is rotated at
+	DefaultLogMaxSize = 10
+	// DefaultLogMaxFiles is the number of rotated log files kept
+	DefaultLogMaxFiles = 5
+	// DefaultLogSuppress is the window in seconds identical messages are collapsed for
+	DefaultLogSuppress = 60
+)
+
+// logLevels are the names of the syslog levels, by priority
+var logLevels = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// Priority returns the syslog level named by Level
+func (lcfg *LogConfig) Priority() syslog.Priority {
+	for ndx, name := range logLevels {
+		if name == lcfg.Level {
+			return syslog.Priority(ndx)
+		}
+	}
+	return syslog.LOG_NOTICE
+}
+
+// Validate the log section of the config
+func (lcfg *LogConfig) Validate() error {
+
+	switch lcfg.Level {
+	case "":
+		lcfg.Level = "notice"
+	case "emerg", "alert", "crit", "err", "warning", "notice", "info", "debug":
+	default:
+		return fmt.Errorf("log: invalid level %q", lcfg.Level)
+	}
+
+	for ndx := range lcfg.Sinks {
+		if err := lcfg.Sinks[ndx].Validate(); err != nil {
+			return err
+		}
+	}
+
+	if lcfg.Suppress == 0 {
+		lcfg.Suppress = DefaultLogSuppress
+	}
+	for _, rl := range lcfg.RateLimits {
+		if rl.Key == "" || rl.Count < 1 || rl.Period <= 0 {
+			return fmt.Errorf("log: ratelimit %q needs a count and period greater than 0", rl.Key)
+		}
+	}
+
+	return nil
+}
+
+// Validate a log sink and fill in its defaults
+func (scfg *LogSinkConfig) Validate() error {
+
+	switch scfg.Format {
+	case "":
+		scfg.Format = "text"
+	case "text", "json":
+	default:
+		return fmt.Errorf("log: invalid format %q", scfg.Format)
+	}
+
+	switch scfg.Type {
+	case "syslog", "stderr":
+	case "file":
+		if scfg.Path == "" {
+			return fmt.Errorf("log: file sink requires a path")
+		}
+		if scfg.MaxSize == nil {
+			maxSize := float64(DefaultLogMaxSize)
+			scfg.MaxSize = &maxSize
+		}
+		if scfg.MaxFiles == nil {
+			maxFiles := DefaultLogMaxFiles
+			scfg.MaxFiles = &maxFiles
+		}
+		if *scfg.MaxSize < 0 || *scfg.MaxFiles < 0 {
+			return fmt.Errorf("log: maxsize and maxfiles must not be negative")
+		}
+	default:
+		return fmt.Errorf("log: invalid sink type %q", scfg.Type)
+	}
+
+	return nil
+}
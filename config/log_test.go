@@ -0,0 +1,28 @@
+package config_test
+
+import (
+	"testing"
+
+	"tsm/config"
+)
+
+func TestLogSinkDefaults(t *testing.T) {
+
+	zero, zeroFiles := 0.0, 0
+	sink := config.LogSinkConfig{Type: "file", Path: "tsm.log"}
+	if err := sink.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if *sink.MaxSize != config.DefaultLogMaxSize || *sink.MaxFiles != config.DefaultLogMaxFiles || sink.Format != "text" {
+		t.Errorf("defaults maxsize %g maxfiles %d format %s", *sink.MaxSize, *sink.MaxFiles, sink.Format)
+	}
+
+	// 0 disables rotation and keeps no old files
+	sink = config.LogSinkConfig{Type: "file", Path: "tsm.log", MaxSize: &zero, MaxFiles: &zeroFiles}
+	if err := sink.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if *sink.MaxSize != 0 || *sink.MaxFiles != 0 {
+		t.Errorf("maxsize %g maxfiles %d, want 0 kept", *sink.MaxSize, *sink.MaxFiles)
+	}
+}
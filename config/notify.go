@@ -0,0 +1,157 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// NotifyConfig holds the alert notification rules and the sinks they are sent to
+type NotifyConfig struct {
+	Enabled bool
+	HoldOff float64 // default seconds between notifications for the same alert
+	Repeat  float64 // default seconds between repeats while active, 0 for no repeats
+	Sinks   []NotifySinkConfig
+	Rules   []NotifyRuleConfig
+}
+
+// NotifySinkConfig describes where notifications are sent. Type is one of
+// smtp, webhook or exec.
+type NotifySinkConfig struct {
+	Name    string
+	Type    string
+	Server  string   // smtp: host:port of the relay
+	From    string   // smtp: sender address
+	To      []string // smtp: recipient addresses
+	URL     string   // webhook: URL the JSON notification is POSTed to
+	Command []string // exec: command run with the JSON notification on stdin
+	Timeout float64  // seconds
+}
+
+// NotifyRuleConfig is either a threshold rule on a channel (Above and/or
+// Below) or an event rule matching alarm/fault/state events and traps.
+type NotifyRuleConfig struct {
+	Name       string
+	Severity   string
+	Sinks      []string // sink names, all sinks if empty
+	HoldOff    float64
+	Repeat     float64
+	NoRecovery bool // do not notify when the alert clears
+
+	// threshold rules
+	Channel    string // chancode, label or oid of a polled or derived channel
+	Above      *float64
+	Below      *float64
+	Hysteresis float64
+
+	// event rules, empty fields match anything
+	Event     bool
+	Kind      string // SET, CHANGE or TRAP
+	Label     string
+	EventName string
+}
+
+const (
+	// DefaultNotifyHoldOff is the default hold-off in seconds
+	DefaultNotifyHoldOff float64 = 300
+	// DefaultNotifyTimeout is the default sink timeout in seconds
+	DefaultNotifyTimeout float64 = 30
+)
+
+// HasEventRules reports whether any rule notifies of events rather than a
+// channel threshold
+func (ncfg *NotifyConfig) HasEventRules() bool {
+	for _, rule := range ncfg.Rules {
+		if rule.Event {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate the notify section of the config
+func (ncfg *NotifyConfig) Validate() error {
+
+	if !ncfg.Enabled {
+		return nil
+	}
+	if ncfg.HoldOff <= 0 {
+		ncfg.HoldOff = DefaultNotifyHoldOff
+	}
+
+	sinks := make(map[string]bool)
+	for ndx := range ncfg.Sinks {
+		sink := &ncfg.Sinks[ndx]
+		if sink.Name == "" || sinks[sink.Name] {
+			return fmt.Errorf("notify: sink %d needs a unique name", ndx+1)
+		}
+		sinks[sink.Name] = true
+		if sink.Timeout <= 0 {
+			sink.Timeout = DefaultNotifyTimeout
+		}
+		switch sink.Type {
+		case "smtp":
+			if sink.Server == "" || sink.From == "" || len(sink.To) == 0 {
+				return fmt.Errorf("notify: smtp sink %q needs server, from and to", sink.Name)
+			}
+		case "webhook":
+			if sink.URL == "" {
+				return fmt.Errorf("notify: webhook sink %q needs a url", sink.Name)
+			}
+		case "exec":
+			if len(sink.Command) == 0 {
+				return fmt.Errorf("notify: exec sink %q needs a command", sink.Name)
+			}
+		default:
+			return fmt.Errorf("notify: sink %q has invalid type %q", sink.Name, sink.Type)
+		}
+	}
+	if len(ncfg.Sinks) == 0 {
+		return errors.New("notify: at least one sink must be configured")
+	}
+
+	rules := make(map[string]bool)
+	for ndx := range ncfg.Rules {
+		rule := &ncfg.Rules[ndx]
+		if rule.Name == "" || rules[rule.Name] {
+			return fmt.Errorf("notify: rule %d needs a unique name", ndx+1)
+		}
+		rules[rule.Name] = true
+		if rule.Severity == "" {
+			rule.Severity = "warning"
+		}
+		if _, err := ParseSeverity(rule.Severity); err != nil {
+			return fmt.Errorf("notify: rule %q: %s", rule.Name, err.Error())
+		}
+		for _, name := range rule.Sinks {
+			if !sinks[name] {
+				return fmt.Errorf("notify: rule %q uses unknown sink %q", rule.Name, name)
+			}
+		}
+		if rule.HoldOff <= 0 {
+			rule.HoldOff = ncfg.HoldOff
+		}
+		if rule.Repeat <= 0 {
+			rule.Repeat = ncfg.Repeat
+		}
+
+		if rule.Event {
+			switch rule.Kind {
+			case "", "SET", "CHANGE", "TRAP":
+			default:
+				return fmt.Errorf("notify: rule %q has invalid kind %q", rule.Name, rule.Kind)
+			}
+			continue
+		}
+		if rule.Channel == "" {
+			return fmt.Errorf("notify: rule %q needs a channel or event = true", rule.Name)
+		}
+		if rule.Above == nil && rule.Below == nil {
+			return fmt.Errorf("notify: rule %q needs an above or below threshold", rule.Name)
+		}
+		if rule.Hysteresis < 0 {
+			return fmt.Errorf("notify: rule %q hysteresis must not be negative", rule.Name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"fmt"
+)
+
+// OutputConfig holds the downstream sinks that poll records are written to
+type OutputConfig struct {
+	SpoolDir     string
+	SpoolMaxSize float64 // megabytes per buffered sink
+	Sinks        []SinkConfig
+}
+
+// SinkConfig describes one output sink. Type is one of stdout, file, tcp, http
+// or exec. Buffered sinks spool records to disk while the consumer is
+// unavailable and replay them in order once it returns.
+type SinkConfig struct {
+	Name     string
+	Type     string
+	Path     string   // file: file or named pipe to append to
+	Address  string   // tcp: host:port to connect to
+	URL      string   // http: URL to POST each record to
+	Command  []string // exec: command and args that read records on stdin
+	Timeout  float64  // seconds, for connecting and writing
+	Buffered bool
+}
+
+const (
+	// DefaultSpoolMaxSize is the default spool size cap in megabytes
+	DefaultSpoolMaxSize float64 = 100
+	// DefaultSinkTimeout is the default connect and write timeout in seconds
+	DefaultSinkTimeout float64 = 10
+)
+
+// Validate the output section of the config
+func (ocfg *OutputConfig) Validate() error {
+
+	if len(ocfg.Sinks) == 0 {
+		ocfg.Sinks = []SinkConfig{{Name: "stdout", Type: "stdout"}}
+	}
+	if ocfg.SpoolMaxSize <= 0 {
+		ocfg.SpoolMaxSize = DefaultSpoolMaxSize
+	}
+
+	names := make(map[string]bool)
+	for ndx := range ocfg.Sinks {
+		sink := &ocfg.Sinks[ndx]
+		if sink.Name == "" {
+			sink.Name = fmt.Sprintf("%s%d", sink.Type, ndx)
+		}
+		if names[sink.Name] {
+			return fmt.Errorf("output: duplicate sink name %q", sink.Name)
+		}
+		names[sink.Name] = true
+		if sink.Timeout <= 0 {
+			sink.Timeout = DefaultSinkTimeout
+		}
+
+		switch sink.Type {
+		case "stdout":
+		case "file":
+			if sink.Path == "" {
+				return fmt.Errorf("output: file sink %q needs a path", sink.Name)
+			}
+		case "tcp":
+			if sink.Address == "" {
+				return fmt.Errorf("output: tcp sink %q needs an address", sink.Name)
+			}
+		case "http":
+			if sink.URL == "" {
+				return fmt.Errorf("output: http sink %q needs a url", sink.Name)
+			}
+		case "exec":
+			if len(sink.Command) == 0 {
+				return fmt.Errorf("output: exec sink %q needs a command", sink.Name)
+			}
+		default:
+			return fmt.Errorf("output: sink %q has invalid type %q", sink.Name, sink.Type)
+		}
+
+		if sink.Buffered && ocfg.SpoolDir == "" {
+			return fmt.Errorf("output: buffered sink %q needs spooldir to be set", sink.Name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// PollConfig sets how often poll and daemon sample each OID
+type PollConfig struct {
+	// Intervals maps an OID category (static, status, measurements,
+	// alarms or faults), or a chancode or label, to the seconds between
+	// samples; 0 samples it once after connecting. A chancode or label
+	// overrides its category. Others are sampled every poll interval.
+	Intervals map[string]float64
+}
+
+// PollCategories are the OID categories intervals may be given for
+var PollCategories = []string{"static", "status", "measurements", "alarms", "faults"}
+
+// Validate the poll section of the config
+func (pcfg *PollConfig) Validate() error {
+
+	if pcfg.Intervals == nil {
+		pcfg.Intervals = make(map[string]float64)
+	}
+	if _, ok := lookupInterval(pcfg.Intervals, "static"); !ok {
+		// static OIDs do not change for a given device and firmware
+		pcfg.Intervals["static"] = 0
+	}
+	for name, secs := range pcfg.Intervals {
+		if secs < 0 {
+			return fmt.Errorf("poll: interval for %s must not be negative", name)
+		}
+	}
+
+	return nil
+}
+
+func isPollCategory(name string) bool {
+	for _, category := range PollCategories {
+		if strings.EqualFold(category, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupInterval returns the interval for name, matched case insensitively
+// as viper lower cases keys
+func lookupInterval(intervals map[string]float64, name string) (float64, bool) {
+	for key, secs := range intervals {
+		if strings.EqualFold(key, name) {
+			return secs, true
+		}
+	}
+	return 0, false
+}
+
+// PollIntervals returns the seconds between samples of each OID of the
+// current model group that has an interval set, and the names in
+// [poll.intervals] that are neither a category nor a channel of the group
+func (cfg *TSMConfig) PollIntervals() (map[string]float64, []string) {
+
+	if curModelGroup == "" {
+		return nil, nil
+	}
+	devGroup := cfg.Oids.DeviceGroups[curModelNdx]
+	categories := [][]OidInfo{
+		append(append([]OidInfo{}, cfg.Oids.EMCOids...), devGroup.Static...),
+		devGroup.Status,
+		devGroup.Measurements,
+		devGroup.Alarms,
+		devGroup.Faults,
+	}
+
+	intervals := make(map[string]float64)
+	for ndx, list := range categories {
+		catSecs, catOk := lookupInterval(cfg.Poll.Intervals, PollCategories[ndx])
+		for _, oidinfo := range list {
+			if secs, ok := lookupInterval(cfg.Poll.Intervals, oidinfo.Chancode); ok && oidinfo.Chancode != "" {
+				intervals[oidinfo.Oid] = secs
+			} else if secs, ok := lookupInterval(cfg.Poll.Intervals, oidinfo.Label); ok && oidinfo.Label != "" {
+				intervals[oidinfo.Oid] = secs
+			} else if catOk {
+				intervals[oidinfo.Oid] = catSecs
+			}
+		}
+	}
+
+	var unknown []string
+	for name := range cfg.Poll.Intervals {
+		if !isPollCategory(name) {
+			if _, ok := cfg.FindOid(name); !ok {
+				unknown = append(unknown, name)
+			}
+		}
+	}
+	sort.Strings(unknown)
+
+	return intervals, unknown
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// SimulateConfig holds the behaviour of the simulated device
+type SimulateConfig struct {
+	DayLength     float64  // real seconds per simulated day, shorter to speed up the charge cycle
+	StartHour     *float64 // simulated hour of day at start, the current time if not set
+	Seed          int64    // random seed, 0 for a different run each time
+	SystemVoltage float64  // nominal battery voltage, 12, 24 or 48
+	Capacity      float64  // battery capacity in Ah
+	InitialSoc    float64  // state of charge at start, 0 to 1
+	ArrayCurrent  float64  // peak charge current in amps at noon
+	LoadCurrent   float64  // average load current in amps
+	Noise         float64  // relative random noise on measurements
+	DropRate      float64  // fraction of requests not answered
+	Delay         float64  // seconds before answering
+	AlarmRate     float64  // random alarm bits set per simulated day
+	AlarmDuration float64  // seconds a random alarm bit stays set
+	Events        []SimEvent
+}
+
+// SimEvent is a scripted change in the simulated device, starting At seconds
+// after the simulator starts and lasting Duration seconds
+type SimEvent struct {
+	At       float64
+	Duration float64
+	Channel  string   // oid, chancode or label of a bitmap, map or number to override
+	Bit      string   // bitmap bit name to set
+	Value    *float64 // scaled number or map index to hold the channel at
+	DropRate *float64 // fraction of requests not answered meanwhile
+	Delay    *float64 // seconds before answering meanwhile
+}
+
+// Validate the simulate section of the config
+func (scfg *SimulateConfig) Validate() error {
+
+	if scfg.DayLength == 0 {
+		scfg.DayLength = 86400
+	}
+	if scfg.SystemVoltage == 0 {
+		scfg.SystemVoltage = 12
+	}
+	if scfg.Capacity == 0 {
+		scfg.Capacity = 200
+	}
+	if scfg.InitialSoc == 0 {
+		scfg.InitialSoc = 0.8
+	}
+	if scfg.ArrayCurrent == 0 {
+		scfg.ArrayCurrent = 20
+	}
+	if scfg.LoadCurrent == 0 {
+		scfg.LoadCurrent = 2
+	}
+	if scfg.AlarmDuration == 0 {
+		scfg.AlarmDuration = 60
+	}
+
+	if scfg.DayLength < 0 || scfg.Capacity < 0 || scfg.ArrayCurrent < 0 || scfg.LoadCurrent < 0 {
+		return errors.New("simulate: daylength, capacity and currents must be positive")
+	}
+	if scfg.InitialSoc < 0 || scfg.InitialSoc > 1 || scfg.DropRate < 0 || scfg.DropRate > 1 {
+		return errors.New("simulate: initialsoc and droprate must be between 0 and 1")
+	}
+	for ndx, ev := range scfg.Events {
+		if ev.At < 0 || ev.Duration < 0 {
+			return fmt.Errorf("simulate: event %d: at and duration must not be negative", ndx+1)
+		}
+		if ev.Channel == "" && ev.DropRate == nil && ev.Delay == nil {
+			return fmt.Errorf("simulate: event %d changes nothing", ndx+1)
+		}
+		if ev.Channel != "" && ev.Bit == "" && ev.Value == nil {
+			return fmt.Errorf("simulate: event %d: %s needs a bit or value", ndx+1, ev.Channel)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,32 @@
+package config
+
+import "errors"
+
+// StoreConfig holds the settings for the local on disk scan history
+type StoreConfig struct {
+	Enabled bool
+	Dir     string
+	MaxAge  float64 // days, 0 for no age limit
+	MaxSize float64 // megabytes, 0 for no size limit
+}
+
+// Scan quality flags recorded with each stored scan
+const (
+	// QualityOK is a scan received within 1/2 interval of its target time
+	QualityOK = "ok"
+	// QualityMissing marks a target time for which no scan was available
+	QualityMissing = "missing"
+)
+
+// Validate the store section of the config
+func (scfg *StoreConfig) Validate() error {
+
+	if scfg.Dir == "" && scfg.Enabled {
+		return errors.New("store: dir must be specified")
+	}
+	if scfg.MaxAge < 0 || scfg.MaxSize < 0 {
+		return errors.New("store: maxage and maxsize must not be negative")
+	}
+
+	return nil
+}
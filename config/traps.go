@@ -0,0 +1,80 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TrapsConfig holds the settings for receiving SNMP traps and informs
+type TrapsConfig struct {
+	Listen    string // address:port to listen on
+	Community string // required v1/v2c community, any if empty
+
+	// SNMPv3 user, v3 traps are only accepted if V3User is set
+	V3User         string
+	AuthProtocol   string // MD5, SHA, SHA224, SHA256, SHA384, SHA512 or empty for none
+	AuthPassphrase string
+	PrivProtocol   string // DES, AES, AES192, AES256 or empty for none
+	PrivPassphrase string
+
+	Notifications []TrapInfo
+}
+
+// TrapInfo names a notification OID and sets the severity it is logged at
+type TrapInfo struct {
+	Oid      string
+	Label    string
+	Severity string
+}
+
+const (
+	// DefaultTrapsListen is the standard snmptrap port on all interfaces
+	DefaultTrapsListen = "0.0.0.0:162"
+	// DefaultTrapSeverity is the severity of notifications not listed in the config
+	DefaultTrapSeverity = "warning"
+)
+
+// Validate the traps section of the config
+func (tcfg *TrapsConfig) Validate() error {
+
+	if tcfg.Listen == "" {
+		tcfg.Listen = DefaultTrapsListen
+	}
+
+	switch strings.ToUpper(tcfg.AuthProtocol) {
+	case "", "MD5", "SHA", "SHA224", "SHA256", "SHA384", "SHA512":
+	default:
+		return fmt.Errorf("traps: invalid authprotocol %q", tcfg.AuthProtocol)
+	}
+	switch strings.ToUpper(tcfg.PrivProtocol) {
+	case "", "DES", "AES", "AES192", "AES256":
+	default:
+		return fmt.Errorf("traps: invalid privprotocol %q", tcfg.PrivProtocol)
+	}
+	if tcfg.PrivProtocol != "" && tcfg.AuthProtocol == "" {
+		return fmt.Errorf("traps: privprotocol requires an authprotocol")
+	}
+
+	for ndx := range tcfg.Notifications {
+		ti := &tcfg.Notifications[ndx]
+		ti.Oid = strings.TrimPrefix(ti.Oid, ".")
+		if ti.Severity == "" {
+			ti.Severity = DefaultTrapSeverity
+		}
+		if _, err := ParseSeverity(ti.Severity); err != nil {
+			return fmt.Errorf("traps: notification %s: %s", ti.Oid, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// TrapInfoFor returns the configured info for a notification OID
+func (tcfg *TrapsConfig) TrapInfoFor(oid string) (TrapInfo, bool) {
+	for _, ti := range tcfg.Notifications {
+		if ti.Oid == oid {
+			return ti, true
+		}
+	}
+	return TrapInfo{Oid: oid, Label: oid, Severity: DefaultTrapSeverity}, false
+}
@@ -0,0 +1,145 @@
+package config
+
+import (
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// DefaultWatchDelay is how long a Watcher waits after a config file changes
+// for more changes, as editors often write a file in several steps
+const DefaultWatchDelay = time.Second
+
+// Watcher reports changes to config files. It watches their directories,
+// so files replaced by renaming a new one over them are seen too.
+type Watcher struct {
+	fsw     *fsnotify.Watcher
+	delay   time.Duration
+	changes chan string
+
+	mutex sync.Mutex
+	files map[string]bool
+	dirs  map[string]bool
+}
+
+// NewWatcher watches the config files at paths, reporting a change delay
+// after the last write to one of them
+func NewWatcher(paths []string, delay time.Duration) (*Watcher, error) {
+
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &Watcher{
+		fsw:     fsw,
+		delay:   delay,
+		changes: make(chan string, 1),
+		dirs:    make(map[string]bool),
+	}
+	if err := w.Watch(paths); err != nil {
+		fsw.Close()
+		return nil, err
+	}
+	go w.run()
+
+	return w, nil
+}
+
+// Watch replaces the files watched with paths, as after a reload changes
+// the files included
+func (w *Watcher) Watch(paths []string) error {
+
+	files := make(map[string]bool, len(paths))
+	dirs := make(map[string]bool)
+	for _, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		files[abs] = true
+		dirs[filepath.Dir(abs)] = true
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for dir := range dirs {
+		if !w.dirs[dir] {
+			if err := w.fsw.Add(dir); err != nil {
+				return err
+			}
+		}
+	}
+	for dir := range w.dirs {
+		if !dirs[dir] {
+			w.fsw.Remove(dir)
+		}
+	}
+	w.files, w.dirs = files, dirs
+
+	return nil
+}
+
+// Changes receives the path of a config file that changed. Changes made
+// while the previous one is not received yet are reported once.
+func (w *Watcher) Changes() <-chan string {
+	return w.changes
+}
+
+// Close stops watching
+func (w *Watcher) Close() error {
+	return w.fsw.Close()
+}
+
+// watched reports whether path is one of the config files
+func (w *Watcher) watched(path string) bool {
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.files[filepath.Clean(path)]
+}
+
+func (w *Watcher) run() {
+
+	var (
+		pending string
+		timer   *time.Timer
+		fire    <-chan time.Time
+	)
+	errs := w.fsw.Errors
+
+	for {
+		select {
+		case event, ok := <-w.fsw.Events:
+			if !ok {
+				if timer != nil {
+					timer.Stop()
+				}
+				return
+			}
+			if event.Op == fsnotify.Chmod || !w.watched(event.Name) {
+				continue
+			}
+			pending = filepath.Clean(event.Name)
+			if timer != nil {
+				timer.Stop()
+			}
+			timer = time.NewTimer(w.delay)
+			fire = timer.C
+		case _, ok := <-errs:
+			// errors such as a full event queue only lose events
+			if !ok {
+				errs = nil
+			}
+		case <-fire:
+			fire = nil
+			select {
+			case w.changes <- pending:
+			default:
+			}
+		}
+	}
+}
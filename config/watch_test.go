@@ -0,0 +1,76 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tsm/config"
+)
+
+// waitChange returns the next change reported by w, or "" after a while
+func waitChange(w *config.Watcher) string {
+	select {
+	case path := <-w.Changes():
+		return path
+	case <-time.After(2 * time.Second):
+		return ""
+	}
+}
+
+func TestWatcher(t *testing.T) {
+
+	dir := t.TempDir()
+	site := filepath.Join(dir, "tsm.toml")
+	groups := filepath.Join(dir, "groups.toml")
+	for _, path := range []string{site, groups} {
+		if err := os.WriteFile(path, []byte("# empty\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w, err := config.NewWatcher([]string{site}, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// several writes are reported once
+	for ndx := 0; ndx < 3; ndx++ {
+		if err := os.WriteFile(site, []byte("[general]\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := waitChange(w); got != site {
+		t.Errorf("change = %q, want %s", got, site)
+	}
+	select {
+	case path := <-w.Changes():
+		t.Errorf("second change %s reported for one edit", path)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// other files in the directory are not watched until added
+	if err := os.WriteFile(groups, []byte("[oids]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := waitChange(w); got != "" {
+		t.Errorf("change to an unwatched file reported: %s", got)
+	}
+	if err := w.Watch([]string{site, groups}); err != nil {
+		t.Fatal(err)
+	}
+
+	// editors often save by renaming a new file over the old one
+	tmp := filepath.Join(dir, ".groups.toml.swp")
+	if err := os.WriteFile(tmp, []byte("[oids]\nemcoids = []\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, groups); err != nil {
+		t.Fatal(err)
+	}
+	if got := waitChange(w); got != groups {
+		t.Errorf("change = %q, want %s", got, groups)
+	}
+}
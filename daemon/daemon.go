@@ -0,0 +1,116 @@
+// Package daemon has the process housekeeping for running tsm unattended:
+// detaching from the terminal and guarding against a second instance with a
+// pidfile
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// childEnv marks the re-executed, detached copy of the process
+const childEnv = "TSM_DAEMON_CHILD"
+
+// IsChild reports whether this process is the detached copy started by Detach
+func IsChild() bool {
+	return os.Getenv(childEnv) != ""
+}
+
+// Detach re-executes the program with the same arguments in a new session,
+// with stdin, stdout and stderr on /dev/null, and returns its pid. The caller
+// should exit once Detach returns successfully.
+func Detach() (int, error) {
+
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	devnull, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer devnull.Close()
+
+	child := exec.Command(exe, os.Args[1:]...)
+	child.Env = append(os.Environ(), childEnv+"=1")
+	child.Stdin = devnull
+	child.Stdout = devnull
+	child.Stderr = devnull
+	child.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	if err := child.Start(); err != nil {
+		return 0, fmt.Errorf("could not start daemon: %w", err)
+	}
+	pid := child.Process.Pid
+	child.Process.Release()
+
+	return pid, nil
+}
+
+// WritePidfile records the pid of this process in path. It fails if path
+// names a process that is still running and replaces it if the process is gone.
+func WritePidfile(path string) error {
+
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = fmt.Fprintf(f, "%d\n", os.Getpid())
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			return err
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return err
+		}
+
+		pid, rerr := ReadPidfile(path)
+		if rerr == nil && running(pid) {
+			return fmt.Errorf("already running with pid %d (%s)", pid, path)
+		}
+		// stale pidfile from a process that did not clean up
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+
+	return fmt.Errorf("could not create pidfile %s", path)
+}
+
+// RemovePidfile removes path if it still holds the pid of this process
+func RemovePidfile(path string) error {
+
+	pid, err := ReadPidfile(path)
+	if err != nil {
+		return err
+	}
+	if pid != os.Getpid() {
+		return fmt.Errorf("pidfile %s belongs to pid %d", path, pid)
+	}
+	return os.Remove(path)
+}
+
+// ReadPidfile returns the pid recorded in path
+func ReadPidfile(path string) (int, error) {
+
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(buf)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("invalid pidfile %s", path)
+	}
+	return pid, nil
+}
+
+// running reports whether a process with pid exists
+func running(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
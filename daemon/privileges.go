@@ -0,0 +1,87 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// ErrNotPrivileged is returned by DropPrivileges when the process is neither
+// root nor already the requested user
+var ErrNotPrivileged = errors.New("not running as root")
+
+// DropPrivileges switches the process to username, with its primary and
+// supplementary groups, and verifies that root cannot be regained. It does
+// nothing if the process is already running as username.
+func DropPrivileges(username string) (*user.User, error) {
+
+	usr, err := user.Lookup(username)
+	if err != nil {
+		return nil, err
+	}
+	uid, err := strconv.Atoi(usr.Uid)
+	if err != nil {
+		return nil, fmt.Errorf("invalid uid %q for %s", usr.Uid, username)
+	}
+	gid, err := strconv.Atoi(usr.Gid)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gid %q for %s", usr.Gid, username)
+	}
+
+	if os.Getuid() == uid && os.Geteuid() == uid && os.Getgid() == gid && os.Getegid() == gid {
+		return usr, nil
+	}
+	if os.Geteuid() != 0 {
+		return nil, fmt.Errorf("cannot switch to user %s: %w", username, ErrNotPrivileged)
+	}
+
+	groups, err := supplementaryGroups(usr, gid)
+	if err != nil {
+		return nil, err
+	}
+
+	// groups must be changed while still root
+	if err := syscall.Setgroups(groups); err != nil {
+		return nil, fmt.Errorf("setgroups for %s: %w", username, err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return nil, fmt.Errorf("setgid %d: %w", gid, err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return nil, fmt.Errorf("setuid %d: %w", uid, err)
+	}
+
+	if os.Getuid() != uid || os.Geteuid() != uid || os.Getgid() != gid || os.Getegid() != gid {
+		return nil, fmt.Errorf("switching to user %s left uid %d/%d gid %d/%d",
+			username, os.Getuid(), os.Geteuid(), os.Getgid(), os.Getegid())
+	}
+	if uid != 0 && syscall.Setuid(0) == nil {
+		return nil, fmt.Errorf("root privileges could be regained after switching to user %s", username)
+	}
+
+	return usr, nil
+}
+
+// supplementaryGroups returns the group ids of the groups usr is a member of
+func supplementaryGroups(usr *user.User, gid int) ([]int, error) {
+
+	gids, err := usr.GroupIds()
+	if err != nil {
+		return nil, fmt.Errorf("groups for %s: %w", usr.Username, err)
+	}
+
+	groups := []int{gid}
+	for _, g := range gids {
+		id, err := strconv.Atoi(g)
+		if err != nil {
+			return nil, fmt.Errorf("invalid group id %q for %s", g, usr.Username)
+		}
+		if id != gid {
+			groups = append(groups, id)
+		}
+	}
+	return groups, nil
+}
@@ -0,0 +1,275 @@
+// Package energy integrates current and power channels from the poll scan
+// stream into daily amp-hour and kWh totals
+package energy
+
+import (
+	"fmt"
+	"time"
+
+	"tsm/config"
+	rlog "tsm/log"
+)
+
+// totals holds the running values for one accumulator
+type totals struct {
+	Ah      float64
+	Wh      float64
+	Gaps    int
+	Seconds float64
+
+	// last sample integrated, used as the left side of the next trapezoid
+	LastValid bool
+	LastTS    time.Time
+	LastAmps  float64
+	LastWatts float64
+}
+
+// sample is one reading of an accumulator's channels
+type sample struct {
+	ts    time.Time
+	amps  float64
+	watts float64
+}
+
+// Integrator trapezoidally integrates the configured channels across polls
+type Integrator struct {
+	cfg      *config.TSMConfig
+	ecfg     *config.EnergyConfig
+	loc      *time.Location
+	maxGap   time.Duration
+	saveIntv time.Duration
+
+	day      string
+	totals   map[string]*totals
+	lastSave time.Time
+}
+
+// NewIntegrator constructor. Running totals are restored from the state file if present.
+func NewIntegrator(cfg *config.TSMConfig) (*Integrator, error) {
+
+	integ := &Integrator{
+		cfg:      cfg,
+		ecfg:     &cfg.Energy,
+		loc:      time.UTC,
+		maxGap:   time.Duration(cfg.Energy.MaxGap * float64(time.Second)),
+		saveIntv: time.Duration(cfg.Energy.SaveInterval * float64(time.Second)),
+		totals:   make(map[string]*totals),
+	}
+	if cfg.Energy.Reset == "local" {
+		integ.loc = time.Local
+	}
+	for _, acc := range cfg.Energy.Accumulators {
+		integ.totals[acc.Name] = &totals{}
+	}
+
+	if err := integ.load(); err != nil {
+		return nil, err
+	}
+
+	return integ, nil
+}
+
+// Resume takes over the running totals of the integrator this one replaces,
+// which are newer than those in the state file
+func (integ *Integrator) Resume(prev interface{}) {
+
+	old, ok := prev.(*Integrator)
+	if !ok {
+		return
+	}
+	integ.day = old.day
+	integ.lastSave = old.lastSave
+	for name := range integ.totals {
+		if tot, ok := old.totals[name]; ok {
+			resumed := *tot
+			integ.totals[name] = &resumed
+		}
+	}
+}
+
+// dayKey returns the accounting day that ts falls in
+func (integ *Integrator) dayKey(ts time.Time) string {
+	return ts.In(integ.loc).Format("2006-01-02")
+}
+
+// startOfDay returns the reset time that began the accounting day ts falls in
+func (integ *Integrator) startOfDay(ts time.Time) time.Time {
+	lt := ts.In(integ.loc)
+	return time.Date(lt.Year(), lt.Month(), lt.Day(), 0, 0, 0, 0, integ.loc)
+}
+
+// readSample gets the current and power values for acc from scan
+func (integ *Integrator) readSample(acc *config.AccumulatorConfig, ts time.Time, scan *map[string]string) (sample, error) {
+
+	var (
+		smp sample
+		err error
+	)
+
+	smp.ts = ts
+	if acc.Current != "" {
+		if smp.amps, err = integ.cfg.ScaledValue(acc.Current, scan); err != nil {
+			return smp, err
+		}
+	}
+	if acc.Power != "" {
+		if smp.watts, err = integ.cfg.ScaledValue(acc.Power, scan); err != nil {
+			return smp, err
+		}
+	} else if acc.Voltage != "" {
+		var volts float64
+		if volts, err = integ.cfg.ScaledValue(acc.Voltage, scan); err != nil {
+			return smp, err
+		}
+		smp.watts = smp.amps * volts
+	}
+
+	return smp, nil
+}
+
+// Process integrates a new scan into the running totals
+func (integ *Integrator) Process(ts time.Time, scan *map[string]string) {
+
+	day := integ.dayKey(ts)
+	if integ.day == "" {
+		integ.day = day
+	}
+
+	samples := make([]*sample, len(integ.ecfg.Accumulators))
+	for ndx := range integ.ecfg.Accumulators {
+		acc := &integ.ecfg.Accumulators[ndx]
+		smp, err := integ.readSample(acc, ts, scan)
+		if err != nil {
+			rlog.WarningMsg("energy %s: %s", acc.Name, err.Error())
+			tot := integ.totals[acc.Name]
+			if tot.LastValid {
+				tot.Gaps++
+			}
+			tot.LastValid = false
+			continue
+		}
+		samples[ndx] = &smp
+	}
+
+	if day != integ.day {
+		// close out the day with each channel's value interpolated at midnight
+		midnight := integ.startOfDay(ts)
+		for ndx, smp := range samples {
+			tot := integ.totals[integ.ecfg.Accumulators[ndx].Name]
+			if smp == nil || !integ.canIntegrate(tot, *smp) {
+				continue
+			}
+			prev := tot.last()
+			frac := midnight.Sub(prev.ts).Seconds() / smp.ts.Sub(prev.ts).Seconds()
+			mid := sample{
+				ts:    midnight,
+				amps:  prev.amps + (smp.amps-prev.amps)*frac,
+				watts: prev.watts + (smp.watts-prev.watts)*frac,
+			}
+			tot.add(prev, mid)
+			tot.setLast(mid)
+		}
+		integ.rollover(day)
+	}
+
+	for ndx, smp := range samples {
+		if smp == nil {
+			continue
+		}
+		acc := &integ.ecfg.Accumulators[ndx]
+		tot := integ.totals[acc.Name]
+		if tot.LastValid && smp.ts.Sub(tot.LastTS) > integ.maxGap {
+			tot.Gaps++
+			rlog.WarningMsg("energy %s: %.0f sec gap since %s not integrated",
+				acc.Name, smp.ts.Sub(tot.LastTS).Seconds(), tot.LastTS.Format(time.RFC3339))
+		} else if integ.canIntegrate(tot, *smp) {
+			tot.add(tot.last(), *smp)
+		}
+		tot.setLast(*smp)
+	}
+
+	if ts.Sub(integ.lastSave) >= integ.saveIntv {
+		if err := integ.save(); err != nil {
+			rlog.ErrMsg("energy: could not save state to %s: %s", integ.ecfg.StateFile, err.Error())
+		}
+		integ.lastSave = ts
+	}
+}
+
+// canIntegrate reports whether the interval from the last sample to smp can be integrated
+func (integ *Integrator) canIntegrate(tot *totals, smp sample) bool {
+	return tot.LastValid && smp.ts.After(tot.LastTS) && smp.ts.Sub(tot.LastTS) <= integ.maxGap
+}
+
+func (tot *totals) last() sample {
+	return sample{tot.LastTS, tot.LastAmps, tot.LastWatts}
+}
+
+func (tot *totals) setLast(smp sample) {
+	tot.LastValid = true
+	tot.LastTS = smp.ts
+	tot.LastAmps = smp.amps
+	tot.LastWatts = smp.watts
+}
+
+// add integrates the trapezoid between s0 and s1
+func (tot *totals) add(s0, s1 sample) {
+	hours := s1.ts.Sub(s0.ts).Hours()
+	tot.Ah += (s0.amps + s1.amps) / 2 * hours
+	tot.Wh += (s0.watts + s1.watts) / 2 * hours
+	tot.Seconds += s1.ts.Sub(s0.ts).Seconds()
+}
+
+// rollover logs the totals for the day that just ended and resets them
+func (integ *Integrator) rollover(newDay string) {
+
+	if newDay == integ.day {
+		return
+	}
+
+	integ.logSummary()
+	for _, tot := range integ.totals {
+		tot.Ah, tot.Wh, tot.Gaps, tot.Seconds = 0, 0, 0, 0
+	}
+	integ.day = newDay
+}
+
+// logSummary writes the current day's totals to the log
+func (integ *Integrator) logSummary() {
+	for _, acc := range integ.ecfg.Accumulators {
+		tot := integ.totals[acc.Name]
+		rlog.NoticeMsg("energy %s %s: %.3f Ah, %.4f kWh, %.0f sec integrated, %d gap(s)",
+			integ.day, acc.Name, tot.Ah, tot.Wh/1000, tot.Seconds, tot.Gaps)
+	}
+}
+
+// Derived returns the running daily totals as output channels
+func (integ *Integrator) Derived() []config.DerivedChannel {
+
+	derived := make([]config.DerivedChannel, 0, 2*len(integ.ecfg.Accumulators))
+	for _, acc := range integ.ecfg.Accumulators {
+		tot := integ.totals[acc.Name]
+		if acc.AhChancode != "" {
+			derived = append(derived, config.DerivedChannel{
+				Chancode: acc.AhChancode,
+				Label:    fmt.Sprintf("%s amp-hours today", acc.Name),
+				Units:    "Ah",
+				Value:    tot.Ah,
+			})
+		}
+		if acc.KWhChancode != "" {
+			derived = append(derived, config.DerivedChannel{
+				Chancode: acc.KWhChancode,
+				Label:    fmt.Sprintf("%s energy today", acc.Name),
+				Units:    "kWh",
+				Value:    tot.Wh / 1000,
+			})
+		}
+	}
+	return derived
+}
+
+// Close saves the running totals
+func (integ *Integrator) Close() error {
+	return integ.save()
+}
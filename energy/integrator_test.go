@@ -0,0 +1,145 @@
+package energy_test
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tsm/config"
+	"tsm/energy"
+)
+
+const (
+	loadCurrent = "1.3.6.1.4.1.33333.8.34.0"
+	battVoltage = "1.3.6.1.4.1.33333.8.35.0"
+)
+
+// newConfig returns a TS-PWM config integrating the load current and power
+// into the state file in dir
+func newConfig(dir string) *config.TSMConfig {
+
+	cfg := config.NewConfig()
+	cfg.Oids.DeviceGroups = []config.DeviceInfo{{
+		ModelGroup: "TS-PWM",
+		Measurements: []config.OidInfo{
+			{Oid: loadCurrent, Label: "Load current", Units: "amps", Type: "number", Scaling: 0.1},
+			{Oid: battVoltage, Label: "Battery voltage", Units: "volts", Type: "number", Scaling: 0.1},
+		},
+	}}
+	cfg.Energy = config.EnergyConfig{
+		Enabled:   true,
+		StateFile: filepath.Join(dir, "energy.json"),
+		Accumulators: []config.AccumulatorConfig{
+			{Name: "load", Current: loadCurrent, Voltage: battVoltage, AhChancode: "LAH", KWhChancode: "LKWH"},
+		},
+	}
+	if err := cfg.Validate(); err != nil {
+		panic(err)
+	}
+	cfg.SetModel("TS-PWM")
+	return cfg
+}
+
+// scan returns a scan with the load current and battery voltage
+func scan(amps, volts float64) *map[string]string {
+	return &map[string]string{
+		loadCurrent: fmt.Sprintf("%.0f", amps*10),
+		battVoltage: fmt.Sprintf("%.0f", volts*10),
+	}
+}
+
+// totals returns the amp-hours and kWh derived by integ
+func totals(integ *energy.Integrator) (ah, kwh float64) {
+	for _, dc := range integ.Derived() {
+		switch dc.Chancode {
+		case "LAH":
+			ah = dc.Value
+		case "LKWH":
+			kwh = dc.Value
+		}
+	}
+	return ah, kwh
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestIntegrate(t *testing.T) {
+
+	integ, err := energy.NewIntegrator(newConfig(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	// 2 A rising to 4 A over an hour at 12 V is 3 Ah, 36 Wh
+	for min := 0; min <= 60; min++ {
+		integ.Process(start.Add(time.Duration(min)*time.Minute), scan(2+2*float64(min)/60, 12))
+	}
+	if ah, kwh := totals(integ); !near(ah, 3) || !near(kwh, 0.036) {
+		t.Errorf("totals %.6f Ah, %.6f kWh, want 3 Ah, 0.036 kWh", ah, kwh)
+	}
+}
+
+func TestIntegrateGap(t *testing.T) {
+
+	integ, err := energy.NewIntegrator(newConfig(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	// the 10 minutes between scans is longer than maxgap and not integrated
+	integ.Process(start, scan(6, 12))
+	integ.Process(start.Add(time.Minute), scan(6, 12))
+	integ.Process(start.Add(11*time.Minute), scan(6, 12))
+	integ.Process(start.Add(12*time.Minute), scan(6, 12))
+	if ah, _ := totals(integ); !near(ah, 0.2) {
+		t.Errorf("%.6f Ah integrated across a gap, want 0.2", ah)
+	}
+}
+
+func TestIntegrateMidnight(t *testing.T) {
+
+	integ, err := energy.NewIntegrator(newConfig(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	midnight := time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)
+
+	// the minute across midnight is split between the days
+	integ.Process(midnight.Add(-90*time.Second), scan(6, 12))
+	integ.Process(midnight.Add(-30*time.Second), scan(6, 12))
+	integ.Process(midnight.Add(30*time.Second), scan(6, 12))
+	if ah, _ := totals(integ); !near(ah, 0.05) {
+		t.Errorf("%.6f Ah after the reset, want 0.05", ah)
+	}
+}
+
+func TestIntegrateRestore(t *testing.T) {
+
+	cfg := newConfig(t.TempDir())
+	integ, err := energy.NewIntegrator(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	integ.Process(start, scan(6, 12))
+	integ.Process(start.Add(time.Minute), scan(6, 12))
+	if err := integ.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a restart continues the totals and integrates from the last sample
+	integ, err = energy.NewIntegrator(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	integ.Process(start.Add(2*time.Minute), scan(6, 12))
+	if ah, _ := totals(integ); !near(ah, 0.2) {
+		t.Errorf("%.6f Ah after restart, want 0.2", ah)
+	}
+}
@@ -0,0 +1,48 @@
+package energy
+
+import (
+	rlog "tsm/log"
+	"tsm/statefile"
+)
+
+// persistedState is the on disk form of the running totals
+type persistedState struct {
+	Day    string
+	Totals map[string]*totals
+}
+
+// load restores running totals from the state file. A missing file is not an error.
+func (integ *Integrator) load() error {
+
+	var state persistedState
+
+	found, err := statefile.Load(integ.ecfg.StateFile, &state)
+	if err != nil {
+		return err
+	}
+	if !found {
+		rlog.NoticeMsg("energy: no saved state in %s, starting new totals", integ.ecfg.StateFile)
+		return nil
+	}
+
+	integ.day = state.Day
+	for name, tot := range state.Totals {
+		// accumulators removed from the config are dropped
+		if _, ok := integ.totals[name]; ok {
+			integ.totals[name] = tot
+		}
+	}
+	rlog.NoticeMsg("energy: restored totals for %s from %s", state.Day, integ.ecfg.StateFile)
+
+	return nil
+}
+
+// save writes running totals to the state file
+func (integ *Integrator) save() error {
+
+	if integ.day == "" {
+		return nil
+	}
+
+	return statefile.Save(integ.ecfg.StateFile, persistedState{integ.day, integ.totals})
+}
@@ -0,0 +1,191 @@
+package events
+
+import (
+	"fmt"
+	"log/syslog"
+	"strconv"
+	"time"
+
+	"tsm/config"
+	rlog "tsm/log"
+)
+
+// watched is an OID whose transitions are detected
+type watched struct {
+	info  config.OidInfo
+	fault bool // bits in a fault bitmap
+	prev  uint64
+	seen  bool
+}
+
+// Detector diffs successive scans and publishes an event for every alarm or
+// fault bit that sets or clears and every map state that changes. It is a
+// cmd.ScanProcessor that contributes no derived channels.
+type Detector struct {
+	cfg      *config.TSMConfig
+	bus      *Bus
+	host     string
+	watching []*watched
+	resumed  map[string]watched // state taken over from a replaced detector
+
+	alarmSev, faultSev, clearSev, stateSev syslog.Priority
+}
+
+// NewDetector constructor
+func NewDetector(cfg *config.TSMConfig, host string, bus *Bus) *Detector {
+
+	det := &Detector{cfg: cfg, host: host, bus: bus}
+	det.alarmSev, _ = config.ParseSeverity(cfg.Events.AlarmSeverity)
+	det.faultSev, _ = config.ParseSeverity(cfg.Events.FaultSeverity)
+	det.clearSev, _ = config.ParseSeverity(cfg.Events.ClearSeverity)
+	det.stateSev, _ = config.ParseSeverity(cfg.Events.StateSeverity)
+
+	return det
+}
+
+// watchList collects the bitmap and map OIDs of the current model. Latched
+// bitmaps are skipped, they repeat the live bits and only clear at the
+// controller's daily reset.
+func (det *Detector) watchList() {
+
+	lists := []struct {
+		oids  *[]config.OidInfo
+		fault bool
+	}{
+		{det.cfg.StatusOids(), false},
+		{det.cfg.AlarmOids(), false},
+		{det.cfg.FaultOids(), true},
+	}
+	for _, list := range lists {
+		for _, oidinfo := range *list.oids {
+			if oidinfo.Latched || (oidinfo.Type != "bitmap" && oidinfo.Type != "map") {
+				continue
+			}
+			w := &watched{info: oidinfo, fault: list.fault}
+			if prev, ok := det.resumed[oidinfo.Oid]; ok && prev.info.Type == oidinfo.Type {
+				w.prev, w.seen = prev.prev, prev.seen
+			}
+			det.watching = append(det.watching, w)
+		}
+	}
+}
+
+// Resume takes over the last values seen by the detector this one replaces,
+// so that a reload does not publish again the bits already set
+func (det *Detector) Resume(prev interface{}) {
+
+	old, ok := prev.(*Detector)
+	if !ok {
+		return
+	}
+	det.resumed = make(map[string]watched)
+	for oid, w := range old.resumed {
+		det.resumed[oid] = w
+	}
+	for _, w := range old.watching {
+		det.resumed[w.info.Oid] = *w
+	}
+}
+
+// Process compares scan to the previous one and publishes the transitions
+func (det *Detector) Process(ts time.Time, scan *map[string]string) {
+
+	if det.watching == nil {
+		det.watchList()
+	}
+
+	for _, w := range det.watching {
+		resstr, ok := (*scan)[w.info.Oid]
+		if !ok {
+			continue
+		}
+		val, err := strconv.ParseUint(resstr, 10, 64)
+		if err != nil {
+			rlog.WarningMsg("events: %s value %q: %s", w.info.Label, resstr, err.Error())
+			continue
+		}
+
+		switch w.info.Type {
+		case "bitmap":
+			// bits already set on the first scan are reported as setting
+			det.bitEvents(ts, w, val)
+		case "map":
+			if w.seen && val != w.prev {
+				det.stateEvent(ts, w, val)
+			} else if !w.seen {
+				rlog.NoticeMsg("events: %s initial state %s", w.info.Label, stateName(&w.info, val))
+			}
+		}
+		w.prev = val
+		w.seen = true
+	}
+}
+
+func (det *Detector) bitEvents(ts time.Time, w *watched, val uint64) {
+
+	changed := val ^ w.prev
+	for bit := 0; bit < len(w.info.Values) && bit < 64; bit++ {
+		mask := uint64(1) << uint(bit)
+		if changed&mask == 0 {
+			continue
+		}
+		ev := det.newEvent(ts, w)
+		ev.Name = w.info.Values[bit]
+		if val&mask != 0 {
+			ev.Kind = KindSet
+			ev.Severity = det.alarmSev
+			if w.fault {
+				ev.Severity = det.faultSev
+			}
+		} else {
+			ev.Kind = KindClear
+			ev.Severity = det.clearSev
+		}
+		det.bus.Publish(ev)
+	}
+}
+
+func (det *Detector) stateEvent(ts time.Time, w *watched, val uint64) {
+
+	ev := det.newEvent(ts, w)
+	ev.Kind = KindChange
+	ev.Old = stateName(&w.info, w.prev)
+	ev.New = stateName(&w.info, val)
+	ev.Name = ev.New
+	ev.Severity = det.stateSev
+	if isFaultState(&det.cfg.Events, ev.New) {
+		ev.Severity = det.faultSev
+	} else if isFaultState(&det.cfg.Events, ev.Old) {
+		ev.Severity = det.clearSev
+	}
+	det.bus.Publish(ev)
+}
+
+func (det *Detector) newEvent(ts time.Time, w *watched) Event {
+	return Event{
+		Time:     ts,
+		Source:   SourcePoll,
+		Host:     det.host,
+		Oid:      w.info.Oid,
+		Label:    w.info.Label,
+		Chancode: w.info.Chancode,
+	}
+}
+
+// stateName returns the map value name, or the number if it is out of range
+func stateName(oidinfo *config.OidInfo, val uint64) string {
+	if val < uint64(len(oidinfo.Values)) {
+		return oidinfo.Values[val]
+	}
+	return fmt.Sprintf("%d", val)
+}
+
+// Derived returns nothing, events are published on the bus
+func (det *Detector) Derived() []config.DerivedChannel {
+	return nil
+}
+
+// Close has nothing to release
+func (det *Detector) Close() error {
+	return nil
+}
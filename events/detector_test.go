@@ -0,0 +1,138 @@
+package events
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"tsm/config"
+)
+
+const (
+	alarmsNow   = "1.3.6.1.4.1.33333.2.57.0"
+	alarmsToday = "1.3.6.1.4.1.33333.2.58.0"
+	faultsNow   = "1.3.6.1.4.1.33333.2.55.0"
+	chargeState = "1.3.6.1.4.1.33333.2.46.0"
+)
+
+// newDetector returns a detector on a cut down TS-MPPT config and the
+// events it publishes
+func newDetector(t *testing.T) (*Detector, *[]Event) {
+
+	cfg := config.NewConfig()
+	cfg.Oids.DeviceGroups = []config.DeviceInfo{{
+		ModelGroup: "TS-MPPT",
+		Status: []config.OidInfo{
+			{Oid: chargeState, Label: "Charge State", Type: "map",
+				Values: []string{"start", "nightCheck", "disconnect", "night", "fault", "mppt"}},
+		},
+		Alarms: []config.OidInfo{
+			{Oid: alarmsNow, Label: "Alarms (now)", Type: "bitmap", Values: []string{"rtsOpen", "rtsShorted"}},
+			{Oid: alarmsToday, Label: "Alarms (today)", Type: "bitmap", Latched: true, Values: []string{"rtsOpen", "rtsShorted"}},
+		},
+		Faults: []config.OidInfo{
+			{Oid: faultsNow, Label: "Faults (now)", Type: "bitmap", Values: []string{"overcurrent", "fetShort"}},
+		},
+	}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	cfg.SetModel("TS-MPPT")
+
+	bus := NewBus()
+	events := &[]Event{}
+	bus.Subscribe(func(ev Event) { *events = append(*events, ev) })
+
+	return NewDetector(cfg, "127.0.0.1", bus), events
+}
+
+// scan of the watched OIDs, latched holding every alarm bit seen today
+func scan(alarms, latched, faults, state int) *map[string]string {
+	return &map[string]string{
+		alarmsNow:   fmt.Sprint(alarms),
+		alarmsToday: fmt.Sprint(latched),
+		faultsNow:   fmt.Sprint(faults),
+		chargeState: fmt.Sprint(state),
+	}
+}
+
+// summary lists the events as strings
+func summary(events []Event) []string {
+	list := make([]string, 0, len(events))
+	for _, ev := range events {
+		list = append(list, ev.String())
+	}
+	return list
+}
+
+func TestDetectorBits(t *testing.T) {
+
+	det, events := newDetector(t)
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	det.Process(ts, scan(0, 0, 0, 5))
+	det.Process(ts.Add(time.Minute), scan(2, 2, 1, 5))
+	det.Process(ts.Add(2*time.Minute), scan(0, 2, 1, 5))
+
+	// the latched copy neither repeats the set nor clears at the daily reset
+	det.Process(ts.Add(3*time.Minute), scan(0, 0, 0, 5))
+
+	want := []string{
+		"Alarms (now) rtsShorted SET",
+		"Faults (now) overcurrent SET",
+		"Alarms (now) rtsShorted CLEAR",
+		"Faults (now) overcurrent CLEAR",
+	}
+	if got := summary(*events); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("events %q, want %q", got, want)
+	}
+	if sev := (*events)[1].Severity; sev != det.faultSev {
+		t.Errorf("fault severity %d, want %d", sev, det.faultSev)
+	}
+}
+
+func TestDetectorFirstScan(t *testing.T) {
+
+	det, events := newDetector(t)
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	// bits set on the first scan are reported, the initial state is not
+	det.Process(ts, scan(1, 1, 0, 3))
+	want := []string{"Alarms (now) rtsOpen SET"}
+	if got := summary(*events); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("events %q, want %q", got, want)
+	}
+}
+
+func TestDetectorState(t *testing.T) {
+
+	det, events := newDetector(t)
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	det.Process(ts, scan(0, 0, 0, 3))
+	det.Process(ts.Add(time.Minute), scan(0, 0, 0, 5))
+	det.Process(ts.Add(2*time.Minute), scan(0, 0, 0, 5))
+	want := []string{"Charge State CHANGE: night -> mppt"}
+	if got := summary(*events); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("events %q, want %q", got, want)
+	}
+}
+
+func TestDetectorResume(t *testing.T) {
+
+	old, events := newDetector(t)
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	old.Process(ts, scan(2, 2, 0, 5))
+
+	// the replacement does not publish again the alarm still set
+	det, _ := newDetector(t)
+	det.bus = old.bus
+	det.Resume(old)
+	det.Process(ts.Add(time.Minute), scan(2, 2, 0, 5))
+	det.Process(ts.Add(2*time.Minute), scan(0, 2, 0, 5))
+
+	want := []string{"Alarms (now) rtsShorted SET", "Alarms (now) rtsShorted CLEAR"}
+	if got := summary(*events); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("events %q, want %q", got, want)
+	}
+}
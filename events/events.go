@@ -0,0 +1,138 @@
+// Package events detects discrete alarm, fault and state transitions and
+// distributes them to subscribers such as the outputs and notifications
+package events
+
+import (
+	"fmt"
+	"log/syslog"
+	"strings"
+	"sync"
+	"time"
+
+	"tsm/config"
+	rlog "tsm/log"
+)
+
+// Event kinds
+const (
+	// KindSet is a bitmap bit asserting
+	KindSet = "SET"
+	// KindClear is a bitmap bit clearing
+	KindClear = "CLEAR"
+	// KindChange is a map state changing value
+	KindChange = "CHANGE"
+	// KindTrap is a notification received from the device
+	KindTrap = "TRAP"
+)
+
+// Event sources
+const (
+	SourcePoll = "poll"
+	SourceTrap = "trap"
+)
+
+// Event is one discrete transition
+type Event struct {
+	Time     time.Time
+	Source   string
+	Host     string
+	Oid      string
+	Label    string
+	Chancode string
+	Name     string // bit name, new state or trap name
+	Kind     string
+	Old      string
+	New      string
+	Detail   string
+	Severity syslog.Priority
+}
+
+// Varbind is a trap variable binding
+type Varbind struct {
+	Oid   string
+	Value string
+}
+
+// Trap is a trap or inform received from the device
+type Trap struct {
+	Time      time.Time
+	Source    string
+	Version   string
+	Community string
+	Inform    bool
+	TrapOid   string
+	Varbinds  []Varbind
+}
+
+// String generates a human readable description of the event
+func (ev *Event) String() string {
+
+	switch ev.Kind {
+	case KindChange:
+		return fmt.Sprintf("%s %s: %s -> %s", ev.Label, ev.Kind, ev.Old, ev.New)
+	case KindTrap:
+		return fmt.Sprintf("%s from %s: %s %s", ev.Kind, ev.Host, ev.Name, ev.Detail)
+	}
+	return fmt.Sprintf("%s %s %s", ev.Label, ev.Name, ev.Kind)
+}
+
+// Record formats the event as an output record, starting with the same time
+// and station fields as a poll record
+func (ev *Event) Record(cfg *config.TSMConfig) string {
+
+	ts := ev.Time.UTC()
+	name := ev.Name
+	if ev.Kind == KindChange {
+		name = ev.Old + "->" + ev.New
+	}
+
+	return fmt.Sprintf("%04d %02d %02d %02d %02d %02d %s %s %s EVENT %s %s %s %s",
+		ts.Year(), ts.Month(), ts.Day(), ts.Hour(), ts.Minute(), ts.Second(),
+		cfg.General.Net, cfg.General.Sta, cfg.General.Loc,
+		ev.Kind, config.SeverityName(ev.Severity), name, ev.Label)
+}
+
+// Bus distributes published events to every subscriber
+type Bus struct {
+	mutex       sync.Mutex
+	subscribers []func(Event)
+}
+
+// NewBus constructor
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// Subscribe registers fn to be called for every event published
+func (bus *Bus) Subscribe(fn func(Event)) {
+
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	bus.subscribers = append(bus.subscribers, fn)
+}
+
+// Publish logs the event at its severity and passes it to all subscribers
+func (bus *Bus) Publish(ev Event) {
+
+	rlog.With("oid", ev.Oid, "source", ev.Source).LogMsg(ev.Severity, "event: %s", ev.String())
+
+	bus.mutex.Lock()
+	subs := make([]func(Event), len(bus.subscribers))
+	copy(subs, bus.subscribers)
+	bus.mutex.Unlock()
+
+	for _, fn := range subs {
+		fn(ev)
+	}
+}
+
+// isFaultState reports whether a map value is one of the configured fault states
+func isFaultState(evcfg *config.EventsConfig, state string) bool {
+	for _, fs := range evcfg.FaultStates {
+		if strings.EqualFold(fs, state) {
+			return true
+		}
+	}
+	return false
+}
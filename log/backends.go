@@ -0,0 +1,239 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/syslog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Record formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+var levelNames = []string{"EMERG", "ALERT", "CRIT", "ERR", "WARNING", "NOTICE", "INFO", "DEBUG"}
+
+func levelName(lvl syslog.Priority) string {
+	return levelNames[lvl&0b0111]
+}
+
+// fieldString formats fields as space separated key=value pairs, quoting
+// values that contain spaces or quotes
+func fieldString(fields []Field) string {
+
+	var buf strings.Builder
+	for ndx, field := range fields {
+		if ndx > 0 {
+			buf.WriteByte(' ')
+		}
+		val := fmt.Sprint(field.Value)
+		if val == "" || strings.ContainsAny(val, " \t\n\"=") {
+			val = strconv.Quote(val)
+		}
+		fmt.Fprintf(&buf, "%s=%s", field.Key, val)
+	}
+	return buf.String()
+}
+
+// formatRecord renders rec as a single line of text or JSON, ending in a newline
+func formatRecord(rec *Record, format string) []byte {
+
+	if format == FormatJSON {
+		obj := make(map[string]interface{}, len(rec.Fields)+5)
+		for _, field := range rec.Fields {
+			obj[field.Key] = field.Value
+		}
+		obj["time"] = rec.Time.UTC().Format(time.RFC3339Nano)
+		obj["level"] = strings.ToLower(levelName(rec.Level))
+		obj["tag"] = rec.Tag
+		obj["pid"] = os.Getpid()
+		obj["msg"] = strings.TrimRight(rec.Msg, "\n")
+		line, err := json.Marshal(obj)
+		if err != nil {
+			line, _ = json.Marshal(map[string]string{"msg": rec.Msg, "error": err.Error()})
+		}
+		return append(line, '\n')
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %s[%d] %s: %s",
+		rec.Time.UTC().Format("2006-01-02T15:04:05.000Z"), rec.Tag, os.Getpid(),
+		levelName(rec.Level), strings.TrimRight(rec.Msg, "\n"))
+	if len(rec.Fields) > 0 {
+		buf.WriteByte(' ')
+		buf.WriteString(fieldString(rec.Fields))
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+// syslogBackend sends records to the local syslog daemon
+type syslogBackend struct {
+	writer *syslog.Writer
+}
+
+// NewSyslogBackend connects to the syslog daemon, logging to the facility in priority
+func NewSyslogBackend(tag string, priority syslog.Priority) (Backend, error) {
+
+	writer, err := syslog.New(priority, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogBackend{writer: writer}, nil
+}
+
+func (b *syslogBackend) Write(rec *Record) error {
+
+	msg := rec.Msg
+	if len(rec.Fields) > 0 {
+		msg = strings.TrimRight(msg, "\n") + " " + fieldString(rec.Fields)
+	}
+
+	switch rec.Level {
+	case syslog.LOG_EMERG:
+		return b.writer.Emerg(msg)
+	case syslog.LOG_ALERT:
+		return b.writer.Alert(msg)
+	case syslog.LOG_CRIT:
+		return b.writer.Crit(msg)
+	case syslog.LOG_ERR:
+		return b.writer.Err(msg)
+	case syslog.LOG_WARNING:
+		return b.writer.Warning(msg)
+	case syslog.LOG_NOTICE:
+		return b.writer.Notice(msg)
+	case syslog.LOG_INFO:
+		return b.writer.Info(msg)
+	case syslog.LOG_DEBUG:
+		// syslog does not show the level, so mark debug messages
+		return b.writer.Debug("DEBUG: " + msg)
+	}
+	return b.writer.Notice(msg)
+}
+
+func (b *syslogBackend) Close() error {
+	return b.writer.Close()
+}
+
+// streamBackend writes formatted records to a stream such as stderr
+type streamBackend struct {
+	writer io.Writer
+	format string
+}
+
+// NewStreamBackend writes records to w in format, which is not closed
+func NewStreamBackend(w io.Writer, format string) Backend {
+	return &streamBackend{writer: w, format: format}
+}
+
+func (b *streamBackend) Write(rec *Record) error {
+	_, err := b.writer.Write(formatRecord(rec, b.format))
+	return err
+}
+
+func (b *streamBackend) Close() error {
+	return nil
+}
+
+// fileBackend appends formatted records to a file, rotating it by size
+type fileBackend struct {
+	mutex    sync.Mutex
+	path     string
+	format   string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+// NewFileBackend appends records to path in format. When the file would grow
+// beyond maxSize bytes it is renamed to path.1, shifting older files up to
+// path.<maxFiles>. A maxSize of 0 disables rotation.
+func NewFileBackend(path, format string, maxSize int64, maxFiles int) (Backend, error) {
+
+	b := &fileBackend{path: path, format: format, maxSize: maxSize, maxFiles: maxFiles}
+	if err := b.open(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *fileBackend) open() error {
+
+	file, err := os.OpenFile(b.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	b.file = file
+	b.size = info.Size()
+	return nil
+}
+
+// rotate shifts the old files up one and starts a new file
+func (b *fileBackend) rotate() error {
+
+	b.file.Close()
+	b.file = nil
+
+	if b.maxFiles > 0 {
+		os.Remove(fmt.Sprintf("%s.%d", b.path, b.maxFiles))
+		for ndx := b.maxFiles - 1; ndx > 0; ndx-- {
+			os.Rename(fmt.Sprintf("%s.%d", b.path, ndx), fmt.Sprintf("%s.%d", b.path, ndx+1))
+		}
+		if err := os.Rename(b.path, b.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(b.path); err != nil {
+		return err
+	}
+
+	return b.open()
+}
+
+func (b *fileBackend) Write(rec *Record) error {
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	line := formatRecord(rec, b.format)
+	if b.file == nil {
+		if err := b.open(); err != nil {
+			return err
+		}
+	}
+	if b.maxSize > 0 && b.size > 0 && b.size+int64(len(line)) > b.maxSize {
+		if err := b.rotate(); err != nil {
+			return fmt.Errorf("rotating %s: %w", b.path, err)
+		}
+	}
+
+	n, err := b.file.Write(line)
+	b.size += int64(n)
+	return err
+}
+
+func (b *fileBackend) Close() error {
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.file == nil {
+		return nil
+	}
+	err := b.file.Close()
+	b.file = nil
+	return err
+}
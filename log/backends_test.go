@@ -0,0 +1,137 @@
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memBackend keeps the records written to it
+type memBackend struct {
+	recs []Record
+}
+
+func (b *memBackend) Write(rec *Record) error {
+	b.recs = append(b.recs, *rec)
+	return nil
+}
+
+func (b *memBackend) Close() error {
+	return nil
+}
+
+func TestFileBackendRotate(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "tsm.log")
+	rec := &Record{Time: time.Now(), Level: syslog.LOG_NOTICE, Tag: "tsm", Msg: strings.Repeat("x", 100)}
+	size := int64(len(formatRecord(rec, FormatText)))
+
+	// three records a file, two old files kept
+	b, err := NewFileBackend(path, FormatText, 3*size, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ndx := 0; ndx < 10; ndx++ {
+		if err := b.Write(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b.Close()
+
+	for name, want := range map[string]int64{"tsm.log": size, "tsm.log.1": 3 * size, "tsm.log.2": 3 * size} {
+		info, err := os.Stat(filepath.Join(filepath.Dir(path), name))
+		if err != nil || info.Size() != want {
+			t.Errorf("%s: %v, want %d bytes", name, err, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); err == nil {
+		t.Error("more than maxfiles old files kept")
+	}
+}
+
+func TestFileBackendNoRotate(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "tsm.log")
+	rec := &Record{Time: time.Now(), Level: syslog.LOG_NOTICE, Tag: "tsm", Msg: "message"}
+
+	b, err := NewFileBackend(path, FormatText, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ndx := 0; ndx < 10; ndx++ {
+		b.Write(rec)
+	}
+	b.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil || strings.Count(string(data), "\n") != 10 {
+		t.Errorf("%d records in an unrotated file, %v, want 10", strings.Count(string(data), "\n"), err)
+	}
+	if _, err := os.Stat(path + ".1"); err == nil {
+		t.Error("file rotated with maxsize 0")
+	}
+}
+
+func TestFormatJSON(t *testing.T) {
+
+	rec := &Record{
+		Time:   time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		Level:  syslog.LOG_WARNING,
+		Tag:    "tsm",
+		Msg:    "battery low\n",
+		Fields: []Field{{"host", "10.0.0.1"}, {"volts", 11.5}},
+	}
+	line := formatRecord(rec, FormatJSON)
+	if !strings.HasSuffix(string(line), "}\n") || strings.Count(string(line), "\n") != 1 {
+		t.Fatalf("not one line of JSON: %q", line)
+	}
+	var obj map[string]interface{}
+	if err := json.Unmarshal(line, &obj); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"time": "2024-05-01T12:00:00Z", "level": "warning", "tag": "tsm",
+		"msg": "battery low", "host": "10.0.0.1", "volts": 11.5,
+	}
+	for key, val := range want {
+		if obj[key] != val {
+			t.Errorf("%s = %v, want %v", key, obj[key], val)
+		}
+	}
+}
+
+func TestFields(t *testing.T) {
+
+	mem := &memBackend{}
+	SetBackends("tsm", mem)
+	SetLogLevel(syslog.LOG_INFO)
+	SetFields("host", "10.0.0.1", "model", "TS-MPPT")
+	defer func() {
+		SetFields("host", nil, "model", nil)
+		SetBackends("tsm")
+	}()
+
+	// message fields override the default ones of the same key, a nil
+	// default removes it
+	With("model", "TS-45", "oid", "1.3.6.1.4.1.33333.2.38.0").InfoMsg("one")
+	SetFields("model", nil)
+	InfoMsg("two")
+	DebugMsg("below the level")
+
+	got := make([]string, 0, len(mem.recs))
+	for _, rec := range mem.recs {
+		got = append(got, rec.Msg+" "+fieldString(rec.Fields))
+	}
+	want := []string{
+		"one host=10.0.0.1 model=TS-45 oid=1.3.6.1.4.1.33333.2.38.0",
+		"two host=10.0.0.1",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("records %q, want %q", got, want)
+	}
+}
@@ -4,129 +4,294 @@ import (
 	"fmt"
 	"log/syslog"
 	"os"
+	"sync"
+	"time"
+
+	"tsm/clock"
 )
 
-var logger *syslog.Writer
-var logLevel syslog.Priority
+// Field is a key/value pair attached to a log message
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Record is one log message as passed to the backends
+type Record struct {
+	Time   time.Time
+	Level  syslog.Priority
+	Tag    string
+	Msg    string
+	Fields []Field
+}
 
-// InitLogging subsystem. Caller must call Logger.Close() to close connection to syslog daemon
+// Backend writes log records to a destination
+type Backend interface {
+	Write(*Record) error
+	Close() error
+}
+
+var (
+	mutex         sync.Mutex
+	backends      []Backend
+	logTag        = "tsm"
+	logLevel      syslog.Priority
+	defaultFields []Field
+	logClock      = clock.New()
+)
+
+// InitLogging subsystem with the syslog backend. Caller must call Close() to close connection to syslog daemon
 func InitLogging(tag string, defaultLogLevel syslog.Priority) error {
 
-	if logger != nil {
-		logger.Close()
-	}
+	logLevel = defaultLogLevel & 0b0111 // log level is first 3 bits of syslog flag set
 
-	log, err := syslog.New(defaultLogLevel, tag)
+	backend, err := NewSyslogBackend(tag, defaultLogLevel)
 	if err != nil {
-		logger = nil
+		SetBackends(tag)
 		return err
 	}
-	logLevel = defaultLogLevel & 0b0111 // log level is first 3 bits of syslog flag set
-	logger = log
+	SetBackends(tag, backend)
 	DebugMsg(fmt.Sprintf("priority: %bg; log level: %bg", defaultLogLevel, logLevel))
 	return nil
 }
 
+// SetBackends replaces the current backends, closing them. With no backends
+// messages are written to stderr.
+func SetBackends(tag string, newBackends ...Backend) {
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for _, backend := range backends {
+		backend.Close()
+	}
+	logTag = tag
+	backends = newBackends
+}
+
+// Close writes any pending repeat summaries and closes all backends
+func Close() {
+	stopFlusher()
+	flushSuppressed(true)
+	SetBackends(logTag)
+}
+
 // SetLogLevel sets a new default logging level
 func SetLogLevel(newlvl syslog.Priority) {
 	logLevel = newlvl & 0b0111
 }
 
-// Msg for loggin msg if requested level is <= current logLevel
-func logMsg(lvl syslog.Priority, msg string) {
-	if lvl <= logLevel {
-		switch lvl {
-		case syslog.LOG_EMERG:
-			logger.Emerg(msg)
-		case syslog.LOG_ALERT:
-			logger.Alert(msg)
-		case syslog.LOG_CRIT:
-			logger.Crit(msg)
-		case syslog.LOG_ERR:
-			logger.Err(msg)
-		case syslog.LOG_WARNING:
-			logger.Warning(msg)
-		case syslog.LOG_NOTICE:
-			logger.Notice(msg)
-		case syslog.LOG_INFO:
-			logger.Info(msg)
-		case syslog.LOG_DEBUG:
-			logger.Debug(msg)
-		default:
-			logger.Notice(msg)
+// SetClock times messages and the suppression windows and rate limit
+// periods with clk
+func SetClock(clk clock.Clock) {
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	logClock = clk
+}
+
+// SetFields sets fields added to every message, such as the host being monitored.
+// A field with a nil value is removed.
+func SetFields(kv ...interface{}) {
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for _, field := range makeFields(kv) {
+		defaultFields = setField(defaultFields, field)
+	}
+}
+
+func setField(fields []Field, field Field) []Field {
+
+	for ndx := range fields {
+		if fields[ndx].Key == field.Key {
+			if field.Value == nil {
+				return append(fields[:ndx:ndx], fields[ndx+1:]...)
+			}
+			fields[ndx].Value = field.Value
+			return fields
 		}
 	}
+	if field.Value == nil {
+		return fields
+	}
+	return append(fields, field)
 }
 
-// EmergMsg log Emerg msessages
-func EmergMsg(msgfmt string, a ...interface{}) {
-	if logger == nil {
-		fmt.Fprintln(os.Stderr, "logger is nil. You must call InitLogging()")
+// makeFields pairs up alternating keys and values
+func makeFields(kv []interface{}) []Field {
+
+	fields := make([]Field, 0, len(kv)/2)
+	for ndx := 0; ndx+1 < len(kv); ndx += 2 {
+		fields = append(fields, Field{Key: fmt.Sprint(kv[ndx]), Value: kv[ndx+1]})
+	}
+	if len(kv)%2 == 1 {
+		fields = append(fields, Field{Key: "field", Value: kv[len(kv)-1]})
+	}
+	return fields
+}
+
+// logMsg writes msg to every backend if lvl is <= current logLevel and it
+// is not suppressed as a repeat or by the rate limit for key
+func logMsg(lvl syslog.Priority, key string, fields []Field, msg string) {
+
+	if lvl > logLevel {
 		return
 	}
-	logMsg(syslog.LOG_EMERG, fmt.Sprintf(msgfmt, a...))
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	rec := &Record{
+		Time:   logClock.Now(),
+		Level:  lvl,
+		Tag:    logTag,
+		Msg:    msg,
+		Fields: fields,
+	}
+	if len(defaultFields) > 0 {
+		rec.Fields = make([]Field, 0, len(defaultFields)+len(fields))
+		rec.Fields = append(rec.Fields, defaultFields...)
+		for _, field := range fields {
+			rec.Fields = setField(rec.Fields, field)
+		}
+	}
+
+	if suppress(rec, key) {
+		return
+	}
+	writeRecord(rec)
 }
 
-// AlertMsg log Emerg msessages
-func AlertMsg(msgfmt string, a ...interface{}) {
-	if logger == nil {
-		fmt.Fprintln(os.Stderr, "logger is nil. You must call InitLogging()")
+// writeRecord passes rec to the backends, the mutex must be held
+func writeRecord(rec *Record) {
+
+	if len(backends) == 0 {
+		stderrBackend.Write(rec)
 		return
 	}
-	logMsg(syslog.LOG_ALERT, fmt.Sprintf(msgfmt, a...))
+	for _, backend := range backends {
+		if err := backend.Write(rec); err != nil {
+			fmt.Fprintf(os.Stderr, "log: %s\n", err.Error())
+		}
+	}
+}
+
+// stderrBackend is used until a backend is set
+var stderrBackend = NewStreamBackend(os.Stderr, FormatText)
+
+// Entry holds fields added to the messages logged through it, and the key
+// they are rate limited by
+type Entry struct {
+	key    string
+	fields []Field
+}
+
+// With returns an Entry that adds the alternating keys and values to its messages
+func With(kv ...interface{}) *Entry {
+	return &Entry{fields: makeFields(kv)}
+}
+
+// With returns a copy of the Entry with more fields
+func (e *Entry) With(kv ...interface{}) *Entry {
+	fields := make([]Field, len(e.fields))
+	copy(fields, e.fields)
+	for _, field := range makeFields(kv) {
+		fields = setField(fields, field)
+	}
+	return &Entry{key: e.key, fields: fields}
+}
+
+// Keyed returns an Entry whose messages are rate limited and collapsed as
+// repeats by key, rather than by their text
+func Keyed(key string) *Entry {
+	return &Entry{key: key}
+}
+
+// Keyed returns a copy of the Entry with its messages rate limited by key
+func (e *Entry) Keyed(key string) *Entry {
+	return &Entry{key: key, fields: e.fields}
+}
+
+// LogMsg log msessages at the given severity
+func (e *Entry) LogMsg(lvl syslog.Priority, msgfmt string, a ...interface{}) {
+	logMsg(lvl&0b0111, e.key, e.fields, fmt.Sprintf(msgfmt, a...))
+}
+
+// CritMsg log Crit msessages
+func (e *Entry) CritMsg(msgfmt string, a ...interface{}) {
+	logMsg(syslog.LOG_CRIT, e.key, e.fields, fmt.Sprintf(msgfmt, a...))
+}
+
+// ErrMsg log Err msessages
+func (e *Entry) ErrMsg(msgfmt string, a ...interface{}) {
+	logMsg(syslog.LOG_ERR, e.key, e.fields, fmt.Sprintf(msgfmt, a...))
+}
+
+// WarningMsg log Warning msessages
+func (e *Entry) WarningMsg(msgfmt string, a ...interface{}) {
+	logMsg(syslog.LOG_WARNING, e.key, e.fields, fmt.Sprintf(msgfmt, a...))
+}
+
+// NoticeMsg log Notice msessages
+func (e *Entry) NoticeMsg(msgfmt string, a ...interface{}) {
+	logMsg(syslog.LOG_NOTICE, e.key, e.fields, fmt.Sprintf(msgfmt, a...))
+}
+
+// InfoMsg log Info msessages
+func (e *Entry) InfoMsg(msgfmt string, a ...interface{}) {
+	logMsg(syslog.LOG_INFO, e.key, e.fields, fmt.Sprintf(msgfmt, a...))
+}
+
+// DebugMsg log Debug msessages
+func (e *Entry) DebugMsg(msgfmt string, a ...interface{}) {
+	logMsg(syslog.LOG_DEBUG, e.key, e.fields, fmt.Sprintf(msgfmt, a...))
+}
+
+// LogMsg log msessages at the given severity
+func LogMsg(lvl syslog.Priority, msgfmt string, a ...interface{}) {
+	logMsg(lvl&0b0111, "", nil, fmt.Sprintf(msgfmt, a...))
+}
+
+// EmergMsg log Emerg msessages
+func EmergMsg(msgfmt string, a ...interface{}) {
+	logMsg(syslog.LOG_EMERG, "", nil, fmt.Sprintf(msgfmt, a...))
+}
+
+// AlertMsg log Emerg msessages
+func AlertMsg(msgfmt string, a ...interface{}) {
+	logMsg(syslog.LOG_ALERT, "", nil, fmt.Sprintf(msgfmt, a...))
 }
 
 // CritMsg log Emerg msessages
 func CritMsg(msgfmt string, a ...interface{}) {
-	if logger == nil {
-		fmt.Fprintln(os.Stderr, "logger is nil. You must call InitLogging()")
-		return
-	}
-	logMsg(syslog.LOG_CRIT, fmt.Sprintf(msgfmt, a...))
+	logMsg(syslog.LOG_CRIT, "", nil, fmt.Sprintf(msgfmt, a...))
 }
 
 // ErrMsg log Emerg msessages
 func ErrMsg(msgfmt string, a ...interface{}) {
-	if logger == nil {
-		fmt.Fprintln(os.Stderr, "logger is nil. You must call InitLogging()")
-		return
-	}
-	logMsg(syslog.LOG_ERR, fmt.Sprintf(msgfmt, a...))
+	logMsg(syslog.LOG_ERR, "", nil, fmt.Sprintf(msgfmt, a...))
 }
 
 // WarningMsg log Emerg msessages
 func WarningMsg(msgfmt string, a ...interface{}) {
-	if logger == nil {
-		fmt.Fprintln(os.Stderr, "logger is nil. You must call InitLogging()")
-		return
-	}
-	logMsg(syslog.LOG_WARNING, fmt.Sprintf(msgfmt, a...))
+	logMsg(syslog.LOG_WARNING, "", nil, fmt.Sprintf(msgfmt, a...))
 }
 
 // NoticeMsg log Emerg msessages
 func NoticeMsg(msgfmt string, a ...interface{}) {
-	if logger == nil {
-		fmt.Fprintln(os.Stderr, "logger is nil. You must call InitLogging()")
-		return
-	}
-	logMsg(syslog.LOG_NOTICE, fmt.Sprintf(msgfmt, a...))
+	logMsg(syslog.LOG_NOTICE, "", nil, fmt.Sprintf(msgfmt, a...))
 }
 
 // InfoMsg log Emerg msessages
 func InfoMsg(msgfmt string, a ...interface{}) {
-	if logger == nil {
-		fmt.Fprintln(os.Stderr, "logger is nil. You must call InitLogging()")
-		return
-	}
-	logMsg(syslog.LOG_INFO, fmt.Sprintf(msgfmt, a...))
+	logMsg(syslog.LOG_INFO, "", nil, fmt.Sprintf(msgfmt, a...))
 }
 
 // DebugMsg log Emerg msessages
 func DebugMsg(msgfmt string, a ...interface{}) {
 	// func DebugMsg(msg string) {
-	if logger == nil {
-		fmt.Fprintln(os.Stderr, "logger is nil. You must call InitLogging()")
-		return
-	}
-	logMsg(syslog.LOG_DEBUG, "DEBUG: "+fmt.Sprintf(msgfmt, a...))
+	logMsg(syslog.LOG_DEBUG, "", nil, fmt.Sprintf(msgfmt, a...))
 }
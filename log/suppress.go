@@ -0,0 +1,202 @@
+package log
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// repeat tracks an identical message seen within the suppression window
+type repeat struct {
+	first time.Time
+	count int
+	last  *Record
+}
+
+// rateLimit allows count messages with a key per period
+type rateLimit struct {
+	count   int
+	period  time.Duration
+	start   time.Time
+	sent    int
+	dropped int
+	last    *Record
+}
+
+var (
+	suppressWindow time.Duration
+	repeats        = make(map[string]*repeat)
+	rateLimits     = make(map[string]*rateLimit)
+
+	flusherMutex sync.Mutex
+	flusherStop  chan struct{}
+)
+
+// SetSuppression collapses identical messages logged within window of the
+// first into one "repeated N times" summary at the end of the window.
+// Messages logged through a Keyed Entry are identical if their keys match.
+// A window of 0 disables suppression.
+func SetSuppression(window time.Duration) {
+
+	mutex.Lock()
+	suppressWindow = window
+	mutex.Unlock()
+
+	if window > 0 {
+		startFlusher()
+	}
+}
+
+// ClearRateLimits removes every rate limit, as before applying new ones
+func ClearRateLimits() {
+
+	mutex.Lock()
+	rateLimits = make(map[string]*rateLimit)
+	mutex.Unlock()
+}
+
+// SetRateLimit allows at most count messages logged with key per period,
+// the rest are summarised at the end of the period. A count of 0 removes
+// the limit.
+func SetRateLimit(key string, count int, period time.Duration) {
+
+	mutex.Lock()
+	if count <= 0 || period <= 0 {
+		delete(rateLimits, key)
+	} else {
+		rateLimits[key] = &rateLimit{count: count, period: period}
+	}
+	mutex.Unlock()
+
+	startFlusher()
+}
+
+// startFlusher writes the summaries of windows that end without another
+// message arriving, until stopFlusher
+func startFlusher() {
+
+	flusherMutex.Lock()
+	defer flusherMutex.Unlock()
+
+	if flusherStop != nil {
+		return
+	}
+	stop := make(chan struct{})
+	flusherStop = stop
+	go func() {
+		for {
+			mutex.Lock()
+			timer := logClock.NewTimer(time.Second)
+			mutex.Unlock()
+			select {
+			case <-timer.C():
+				flushSuppressed(false)
+			case <-stop:
+				timer.Stop()
+				return
+			}
+		}
+	}()
+}
+
+// stopFlusher stops the goroutine started by startFlusher
+func stopFlusher() {
+
+	flusherMutex.Lock()
+	defer flusherMutex.Unlock()
+
+	if flusherStop != nil {
+		close(flusherStop)
+		flusherStop = nil
+	}
+}
+
+// suppress reports whether rec should not be written because of a rate
+// limit or as a repeat. The mutex must be held.
+func suppress(rec *Record, key string) bool {
+
+	if rl, ok := rateLimits[key]; key != "" && ok {
+		if rec.Time.Sub(rl.start) >= rl.period {
+			rl.summarise()
+			rl.start = rec.Time
+		}
+		if rl.sent < rl.count {
+			rl.sent++
+			return false
+		}
+		rl.dropped++
+		rl.last = rec
+		return true
+	}
+
+	if suppressWindow <= 0 {
+		return false
+	}
+
+	rkey := key
+	if rkey == "" {
+		rkey = fmt.Sprintf("%d %s %s", rec.Level, rec.Msg, fieldString(rec.Fields))
+	}
+	if rp, ok := repeats[rkey]; ok {
+		if rec.Time.Sub(rp.first) < suppressWindow {
+			rp.count++
+			rp.last = rec
+			return true
+		}
+		rp.summarise()
+	}
+	repeats[rkey] = &repeat{first: rec.Time}
+
+	return false
+}
+
+// flushSuppressed writes the summaries of ended windows, or of all windows
+func flushSuppressed(all bool) {
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	now := logClock.Now()
+	for rkey, rp := range repeats {
+		if all || now.Sub(rp.first) >= suppressWindow {
+			rp.summarise()
+			delete(repeats, rkey)
+		}
+	}
+	for _, rl := range rateLimits {
+		if all || now.Sub(rl.start) >= rl.period {
+			rl.summarise()
+			rl.start = time.Time{}
+		}
+	}
+}
+
+// summarise writes a summary of the suppressed repeats. The mutex must be held.
+func (rp *repeat) summarise() {
+
+	if rp.count == 0 {
+		return
+	}
+	summary := *rp.last
+	summary.Time = logClock.Now()
+	summary.Msg = fmt.Sprintf("message repeated %d times in %s: %s",
+		rp.count, rp.last.Time.Sub(rp.first).Round(time.Second), rp.last.Msg)
+	writeRecord(&summary)
+	rp.count = 0
+}
+
+// summarise writes a summary of the rate limited messages and starts a new
+// period. The mutex must be held.
+func (rl *rateLimit) summarise() {
+
+	if rl.dropped > 0 {
+		summary := *rl.last
+		summary.Time = logClock.Now()
+		summary.Msg = fmt.Sprintf("%d similar messages suppressed in %s, last: %s",
+			rl.dropped, rl.period, rl.last.Msg)
+		writeRecord(&summary)
+	}
+	rl.sent = 0
+	rl.dropped = 0
+	rl.last = nil
+}
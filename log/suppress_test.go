@@ -0,0 +1,126 @@
+package log
+
+import (
+	"log/syslog"
+	"strings"
+	"testing"
+	"time"
+
+	"tsm/clock"
+)
+
+// fakeLog logs to a memBackend on a fake clock until the test ends
+func fakeLog(t *testing.T) (*memBackend, *clock.Fake) {
+
+	mem := &memBackend{}
+	clk := clock.NewFake(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	SetClock(clk)
+	SetBackends("tsm", mem)
+	level := logLevel
+	SetLogLevel(syslog.LOG_DEBUG)
+
+	t.Cleanup(func() {
+		SetSuppression(0)
+		mutex.Lock()
+		rateLimits = make(map[string]*rateLimit)
+		mutex.Unlock()
+		Close()
+		SetLogLevel(level)
+		SetClock(clock.New())
+	})
+	return mem, clk
+}
+
+// messages returns the messages written so far
+func messages(mem *memBackend) []string {
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	msgs := make([]string, 0, len(mem.recs))
+	for _, rec := range mem.recs {
+		msgs = append(msgs, rec.Msg)
+	}
+	return msgs
+}
+
+func checkMessages(t *testing.T, mem *memBackend, want ...string) {
+
+	t.Helper()
+	if got := messages(mem); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("messages\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestSuppressRepeats(t *testing.T) {
+
+	mem, clk := fakeLog(t)
+	SetSuppression(10 * time.Second)
+
+	WarningMsg("battery low")
+	for ndx := 0; ndx < 3; ndx++ {
+		ErrMsg("no scan available")
+		clk.Advance(time.Second)
+	}
+	checkMessages(t, mem, "battery low", "no scan available")
+
+	// the window ends without another repeat
+	clk.Advance(7 * time.Second)
+	flushSuppressed(false)
+	checkMessages(t, mem, "battery low", "no scan available",
+		"message repeated 2 times in 2s: no scan available")
+
+	// a repeat after the window starts a new one
+	ErrMsg("no scan available")
+	ErrMsg("no scan available")
+	checkMessages(t, mem, "battery low", "no scan available",
+		"message repeated 2 times in 2s: no scan available",
+		"no scan available")
+}
+
+func TestSuppressKeyed(t *testing.T) {
+
+	mem, clk := fakeLog(t)
+	SetSuppression(10 * time.Second)
+
+	// keyed messages are repeats whatever their text
+	Keyed("snmp-query").ErrMsg("request timeout after 1s")
+	clk.Advance(time.Second)
+	Keyed("snmp-query").ErrMsg("request timeout after 2s")
+	clk.Advance(10 * time.Second)
+	Keyed("snmp-query").ErrMsg("request timeout after 3s")
+
+	checkMessages(t, mem, "request timeout after 1s",
+		"message repeated 1 times in 1s: request timeout after 2s",
+		"request timeout after 3s")
+}
+
+func TestRateLimit(t *testing.T) {
+
+	mem, clk := fakeLog(t)
+	SetRateLimit("snmp-query", 2, time.Minute)
+
+	for ndx := 1; ndx <= 5; ndx++ {
+		Keyed("snmp-query").ErrMsg("timeout %d", ndx)
+		clk.Advance(time.Second)
+	}
+	ErrMsg("not rate limited")
+	checkMessages(t, mem, "timeout 1", "timeout 2", "not rate limited")
+
+	clk.Advance(time.Minute)
+	flushSuppressed(false)
+	Keyed("snmp-query").ErrMsg("timeout 6")
+	checkMessages(t, mem, "timeout 1", "timeout 2", "not rate limited",
+		"3 similar messages suppressed in 1m0s, last: timeout 5",
+		"timeout 6")
+
+	// the summary of the period in progress is written on Close
+	Keyed("snmp-query").ErrMsg("timeout 7")
+	Keyed("snmp-query").ErrMsg("timeout 8")
+	stopFlusher()
+	flushSuppressed(true)
+	checkMessages(t, mem, "timeout 1", "timeout 2", "not rate limited",
+		"3 similar messages suppressed in 1m0s, last: timeout 5",
+		"timeout 6", "timeout 7",
+		"1 similar messages suppressed in 1m0s, last: timeout 8")
+}
@@ -17,25 +17,30 @@ limitations under the License.
 */
 
 import (
-	"flag"
+	"errors"
 	"fmt"
-	"log"
 	"log/syslog"
 	"net"
 	"os"
-	"os/user"
 	"path/filepath"
-	"strconv"
 	"strings"
-	"syscall"
+	"time"
 
+	"tsm/agent"
+	"tsm/battery"
 	"tsm/cmd"
 	"tsm/config"
+	"tsm/daemon"
+	"tsm/energy"
+	"tsm/events"
 	l "tsm/log"
+	"tsm/notify"
+	"tsm/output"
 	"tsm/serializers/tui"
+	"tsm/simulator"
 	"tsm/snmp"
+	"tsm/store"
 
-	"github.com/pkg/errors"
 	"github.com/spf13/viper"
 )
 
@@ -47,141 +52,274 @@ type appConfig struct {
 	port      string
 	runAsUser string
 	community string
+	pidfile   string
+	detach    bool
+	logSinks  string
+	logFormat string
+	record    string
+	replay    string
+	speed     float64
+	simModel  string
+	simListen string
+	snippets  bool
+	workers   int
+	timeout   time.Duration
+	inventory bool
+	model     string
+	redetect  bool
+	watch     bool
+	args      []string
 	tsmCfg    *config.TSMConfig
 }
 
-func initLogging(debug bool) error {
+// initLogging starts logging to the sinks given with --log, or else to
+// syslog. If syslog is unavailable messages go to stderr.
+func initLogging(appCfg *appConfig) error {
 
 	severityLevel := syslog.LOG_NOTICE
-	if debug {
+	if appCfg.debug {
 		severityLevel = syslog.LOG_DEBUG
 	}
+	l.SetLogLevel(severityLevel)
+	l.SetSuppression(config.DefaultLogSuppress * time.Second)
+
+	if appCfg.logSinks != "" {
+		sinks, err := parseLogSinks(appCfg.logSinks, appCfg.logFormat)
+		if err != nil {
+			return err
+		}
+		return setLogSinks(sinks)
+	}
+
 	err := l.InitLogging("tsm", syslog.LOG_LOCAL0|severityLevel)
 	if err != nil {
-		return err
+		l.WarningMsg("syslog unavailable, logging to stderr: %s", err.Error())
 	}
-	// if appCfg.debug {
-	l.SetLogLevel(severityLevel)
-	// }
 	return nil
 }
 
-func logStartup() error {
+// parseLogSinks converts the --log flag, a comma separated list of syslog,
+// stderr and file=<path>, to sinks
+func parseLogSinks(spec, format string) ([]config.LogSinkConfig, error) {
 
-	abspath, err := filepath.Abs(os.Args[0])
+	var sinks []config.LogSinkConfig
+	for _, item := range strings.Split(spec, ",") {
+		sink := config.LogSinkConfig{Format: format}
+		if strings.HasPrefix(item, "file=") {
+			sink.Type = "file"
+			sink.Path = strings.TrimPrefix(item, "file=")
+		} else {
+			sink.Type = strings.TrimSpace(item)
+		}
+		if err := sink.Validate(); err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	return sinks, nil
+}
+
+// openLogConfig opens the sinks in the config for applyLogConfig, unless
+// they are given on the command line
+func openLogConfig(appCfg *appConfig, lcfg *config.LogConfig) ([]l.Backend, error) {
+
+	if appCfg.logSinks != "" || len(lcfg.Sinks) == 0 {
+		return nil, nil
+	}
+	return openLogSinks(lcfg.Sinks)
+}
+
+// applyLogConfig applies the level and repeated message settings in the
+// config, except the level given on the command line, and replaces the log
+// backends with those opened by openLogConfig, if any
+func applyLogConfig(appCfg *appConfig, lcfg *config.LogConfig, backends []l.Backend) {
+
+	if !appCfg.debug {
+		l.SetLogLevel(lcfg.Priority())
+	}
+	setLogSuppression(lcfg)
+	if backends != nil {
+		l.SetBackends("tsm", backends...)
+	}
+}
+
+// closeBackends closes log backends that were opened but not used
+func closeBackends(backends []l.Backend) {
+	for _, b := range backends {
+		b.Close()
+	}
+}
+
+// setLogSuppression applies the repeated message settings from the config
+func setLogSuppression(lcfg *config.LogConfig) {
+
+	l.SetSuppression(0)
+	if lcfg.Suppress > 0 {
+		l.SetSuppression(time.Duration(lcfg.Suppress * float64(time.Second)))
+	}
+	l.ClearRateLimits()
+	for _, rl := range lcfg.RateLimits {
+		l.SetRateLimit(rl.Key, rl.Count, time.Duration(rl.Period*float64(time.Second)))
+	}
+}
+
+// setLogSinks replaces the log backends with sinks
+func setLogSinks(sinks []config.LogSinkConfig) error {
+
+	backends, err := openLogSinks(sinks)
 	if err != nil {
 		return err
 	}
-	l.NoticeMsg(fmt.Sprintf("%s starting up...", abspath))
+	l.SetBackends("tsm", backends...)
+
 	return nil
 }
 
-func setUser(username string) error {
-
-	var uid, gid int
-	nrtsuser, err := user.Lookup(username)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err.Error())
-		l.WarningMsg(err.Error())
-	} else {
-		if uid, err = strconv.Atoi(nrtsuser.Uid); err != nil {
-			fmt.Fprintln(os.Stderr, err.Error())
-			l.ErrMsg("could not convert uid %v to int", uid)
-			l.ErrMsg(err.Error())
+// openLogSinks creates a log backend for each of sinks
+func openLogSinks(sinks []config.LogSinkConfig) ([]l.Backend, error) {
+
+	backends := make([]l.Backend, 0, len(sinks))
+	for _, sink := range sinks {
+		var (
+			backend l.Backend
+			err     error
+		)
+		switch sink.Type {
+		case "syslog":
+			backend, err = l.NewSyslogBackend("tsm", syslog.LOG_LOCAL0)
+		case "stderr":
+			backend = l.NewStreamBackend(os.Stderr, sink.Format)
+		case "file":
+			backend, err = l.NewFileBackend(sink.Path, sink.Format, int64(*sink.MaxSize*1024*1024), *sink.MaxFiles)
 		}
-		if gid, err = strconv.Atoi(nrtsuser.Gid); err != nil {
-			fmt.Fprintln(os.Stderr, err.Error())
-			l.ErrMsg("could not convert gid %v to int", gid)
-			l.ErrMsg(err.Error())
-		}
-		syscall.Setuid(uid)
-		syscall.Setgid(gid)
-		os.Chdir(nrtsuser.HomeDir)
-		l.NoticeMsg(fmt.Sprintf("nrtsuser.HomeDir: %s", nrtsuser.HomeDir))
-
-		var wd string
-		wd, err = os.Getwd()
 		if err != nil {
-			fmt.Fprintln(os.Stderr, err.Error())
-			l.ErrMsg("could not determine working dir", gid)
-			l.ErrMsg(err.Error())
-		} else {
-			l.NoticeMsg(fmt.Sprintf("working dir: %s", wd))
+			closeBackends(backends)
+			return nil, fmt.Errorf("log %s sink: %w", sink.Type, err)
 		}
+		backends = append(backends, backend)
 	}
-	return err
+
+	return backends, nil
 }
 
-// read CLI flags adjust app config appropriately
-func (c *appConfig) readCLI(params []string) error {
+func logStartup() error {
+
+	abspath, err := filepath.Abs(os.Args[0])
+	if err != nil {
+		return err
+	}
+	l.NoticeMsg(fmt.Sprintf("%s starting up...", abspath))
+	return nil
+}
 
-	var err error
+// setUser switches to the run as user and its home directory. If the process
+// cannot switch users it continues as the current user, unless required.
+func setUser(username string, required bool) error {
 
-	// sanity check on params; needs at least host[:port] and cmd
-	if len(params) < 2 {
-		err := errors.New("command line error, not enough parameters")
+	nrtsuser, err := daemon.DropPrivileges(username)
+	if errors.Is(err, daemon.ErrNotPrivileged) && !required {
+		l.WarningMsg("%s, continuing as uid %d", err.Error(), os.Getuid())
+		return nil
+	}
+	if err != nil {
 		return err
 	}
+	l.NoticeMsg("running as user %s (uid %s, gid %s)", nrtsuser.Username, nrtsuser.Uid, nrtsuser.Gid)
+
+	if err = os.Chdir(nrtsuser.HomeDir); err != nil {
+		l.WarningMsg(err.Error())
+	}
+	l.NoticeMsg(fmt.Sprintf("nrtsuser.HomeDir: %s", nrtsuser.HomeDir))
 
-	// parse host[]:port]
-	hostport := flag.Args()[0]
-	c.host, c.port, err = formatHostPort(hostport)
+	wd, err := os.Getwd()
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
+		l.ErrMsg("could not determine working dir")
 		l.ErrMsg(err.Error())
-		os.Exit(1)
+	} else {
+		l.NoticeMsg(fmt.Sprintf("working dir: %s", wd))
 	}
+	return nil
+}
 
-	// get command
-	cmd := params[1]
-	if !validCmd(cmd) {
-		err = fmt.Errorf("\ninvalid command: %s", cmd)
-		return err
+// configSearchPath are the directories searched for tsm.toml without --config
+var configSearchPath = []string{".", "$HOME/dev/tsm", "$HOME/etc"}
+
+// configFiles are the config files read by loadConfig, in the order they
+// were merged over the built in defaults
+var configFiles []string
+
+// findConfig returns the config file given with --config, else the first
+// tsm.toml in the search path, or "" if there is none
+func findConfig(tsmCfgFile string) (string, error) {
+
+	if tsmCfgFile != "" {
+		if _, err := os.Stat(tsmCfgFile); err != nil {
+			return "", err
+		}
+		return tsmCfgFile, nil
+	}
+	for _, dir := range configSearchPath {
+		path := filepath.Join(os.ExpandEnv(dir), "tsm.toml")
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
 	}
-	c.cmd = cmd
+	return "", nil
+}
 
-	return err
+// configSources describes where the config was read from
+func configSources() string {
+	return strings.Join(append([]string{"built in defaults"}, configFiles...), ", ")
 }
 
-// initConfig reads in config file and ENV variables if set.
+// loadConfig reads the built in defaults, then merges the config file and
+// the files it includes over them
 func loadConfig(tsmCfgFile string) (*config.TSMConfig, error) {
 
-	var err error
-
-	if tsmCfgFile != "" {
-		// Use config file from the flag.
-		viper.SetConfigFile(tsmCfgFile)
-	} else {
-
-		// Search for config cwd first, then in home directory.
-		viper.AddConfigPath(".")
-		viper.AddConfigPath("$HOME/dev/tsm")
-		viper.AddConfigPath("$HOME/etc")
-		viper.SetConfigName("tsm")
-		viper.SetConfigType("toml")
+	path, err := findConfig(tsmCfgFile)
+	if err != nil {
+		return nil, err
 	}
 
-	viper.AutomaticEnv() // read in environment variables that match
-
-	// If a config file is found, read it in.
-	if err := viper.ReadInConfig(); err != nil {
-		fmt.Println(err)
+	viper.SetConfigType("toml")
+	if err := viper.ReadConfig(config.Defaults()); err != nil {
 		return nil, err
 	}
-	l.NoticeMsg(fmt.Sprintf("Using config file: %s", viper.ConfigFileUsed()))
+	viper.AutomaticEnv() // read in environment variables that match, such as TSM_GENERAL_STA
+
+	var files []string
+	if path == "" {
+		l.WarningMsg("no tsm.toml in %s, using the built in defaults", strings.Join(configSearchPath, ", "))
+	} else {
+		viper.SetConfigFile(path)
+		layers, err := config.ReadLayers(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, layer := range layers {
+			config.MergeDeviceGroups(viper.Get("oids.devicegroups"), layer.Settings)
+			if err := viper.MergeConfigMap(layer.Settings); err != nil {
+				return nil, fmt.Errorf("%s: %w", layer.Path, err)
+			}
+			files = append(files, layer.Path)
+		}
+	}
 
 	tsmCfg := config.NewConfig()
 	if err := viper.Unmarshal(&tsmCfg); err != nil {
 		return nil, err
 	}
-	// tsmCfg.CfgFile = viper.ConfigFileUsed()
 
 	if err := tsmCfg.Validate(); err != nil {
-		fmt.Printf(err.Error())
 		return nil, err
 	}
 
-	return tsmCfg, err
+	// a config that fails to load leaves the files of the last one in place
+	configFiles = files
+	l.NoticeMsg(fmt.Sprintf("Using config: %s", configSources()))
+
+	return tsmCfg, nil
 }
 
 func formatHostPort(rawHost string) (string, string, error) {
@@ -200,111 +338,326 @@ func formatHostPort(rawHost string) (string, string, error) {
 
 }
 
-func executeCmd(cmd string, cmdSvc cmd.TSMCmdService) {
+// running are the optional features a daemon reload shares with the ones
+// it creates, as the old and new ones cannot both open them
+type running struct {
+	outs *output.Outputs
+	exp  *agent.Exporter
+}
 
-	var err error
+// cmdOptions creates the optional features enabled in the config for the
+// command, sharing those in cur and replacing them in it. If one cannot be
+// created those already created are closed and cur is left as it was.
+func cmdOptions(appCfg *appConfig, tsmCfg *config.TSMConfig, cur *running) (opts []cmd.Option, err error) {
 
-	switch cmd {
-	// case "poll":
-	// 	err = cmdSvc.Poll()
-	case "status":
-		err = cmdSvc.Status()
+	var created []interface{ Close() error }
+	defer func() {
+		if err != nil {
+			for ndx := len(created) - 1; ndx >= 0; ndx-- {
+				created[ndx].Close()
+			}
+		}
+	}()
+
+	if appCfg.cmd == "simulate" {
+		sim, err := simulator.NewSimulator(tsmCfg, appCfg.simModel, appCfg.simListen, appCfg.community)
+		if err != nil {
+			return nil, err
+		}
+		return append(opts, cmd.WithSimulator(sim)), nil
+	}
+
+	if appCfg.snippets {
+		opts = append(opts, cmd.WithSnippets())
+	}
+
+	if appCfg.model != "" {
+		opts = append(opts, cmd.WithModel(appCfg.model))
+	}
+	if tsmCfg.General.ModelCache != "" && appCfg.replay == "" {
+		opts = append(opts, cmd.WithModelCache(tsmCfg.General.ModelCache, appCfg.redetect))
 	}
 
+	if appCfg.cmd == "discover" {
+		opts = append(opts, cmd.WithSessions(func() cmd.SNMPService {
+			svc := snmp.NewSnmpService()
+			svc.SetTimeout(appCfg.timeout)
+			svc.SetVersion(tsmCfg.Version)
+			return svc
+		}, appCfg.workers))
+		if appCfg.inventory {
+			if len(configFiles) == 0 {
+				return nil, errors.New("--write needs a config file to add the inventory to")
+			}
+			opts = append(opts, cmd.WithInventory(configFiles[len(configFiles)-1]))
+		}
+		return opts, nil
+	}
+
+	polling := appCfg.cmd == "poll" || appCfg.cmd == "daemon"
+
+	if tsmCfg.Store.Enabled && (polling || appCfg.cmd == "history") {
+		st, err := store.NewStore(&tsmCfg.Store)
+		if err != nil {
+			return nil, err
+		}
+		created = append(created, st)
+		opts = append(opts, cmd.WithStore(st))
+	}
+
+	if !polling && appCfg.cmd != "traps" {
+		return opts, nil
+	}
+
+	outs, err := output.NewOutputs(&tsmCfg.Output, cur.outs)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err.Error())
-		l.ErrMsg(err.Error())
+		return nil, err
+	}
+	created = append(created, outs)
+	opts = append(opts, cmd.WithOutput(outs))
+
+	var bus *events.Bus
+	if tsmCfg.Events.Enabled || appCfg.cmd == "traps" {
+		bus = events.NewBus()
+		bus.Subscribe(func(ev events.Event) {
+			outs.WriteRecord(ev.Record(tsmCfg))
+		})
+	}
+
+	if appCfg.cmd == "traps" {
+		opts = append(opts, cmd.WithEvents(bus), cmd.WithTraps(snmp.NewTrapReceiver(&tsmCfg.Traps)))
+		if tsmCfg.Notify.Enabled {
+			ntf := notify.NewNotifier(tsmCfg, appCfg.host, bus)
+			created = append(created, ntf)
+			opts = append(opts, cmd.WithProcessors(ntf))
+		}
+		cur.outs = outs
+		return opts, nil
 	}
-}
 
-func validCmd(cmd string) bool {
-	validCommands := []string{
-		// "poll",
-		"status",
-		// "mb",
+	if tsmCfg.Events.Enabled {
+		opts = append(opts, cmd.WithEvents(bus), cmd.WithProcessors(events.NewDetector(tsmCfg, appCfg.host, bus)))
+	} else if tsmCfg.Notify.Enabled && tsmCfg.Notify.HasEventRules() {
+		l.WarningMsg("notify: rules with event = true never fire unless [events] enabled = true")
 	}
-	for _, n := range validCommands {
-		if cmd == n {
-			return true
+	if tsmCfg.Energy.Enabled {
+		integ, err := energy.NewIntegrator(tsmCfg)
+		if err != nil {
+			return nil, err
 		}
+		created = append(created, integ)
+		opts = append(opts, cmd.WithProcessors(integ))
+	}
+	if tsmCfg.Battery.Enabled {
+		est, err := battery.NewEstimator(tsmCfg)
+		if err != nil {
+			return nil, err
+		}
+		created = append(created, est)
+		opts = append(opts, cmd.WithProcessors(est))
+	}
+	if tsmCfg.Notify.Enabled {
+		ntf := notify.NewNotifier(tsmCfg, appCfg.host, bus)
+		created = append(created, ntf)
+		opts = append(opts, cmd.WithProcessors(ntf))
+	}
+	var exp *agent.Exporter
+	if tsmCfg.Agent.Enabled {
+		if exp, err = agent.NewExporter(tsmCfg, appCfg.host, cur.exp); err != nil {
+			return nil, err
+		}
+		opts = append(opts, cmd.WithProcessors(exp))
 	}
-	return false
-}
 
-func defineGlobalFlags(appCfg *appConfig) {
+	cur.outs, cur.exp = outs, exp
+	return opts, nil
+}
 
-	flag.BoolVar(&appCfg.debug, "d", false, "enable debug logging")
-	flag.BoolVar(&appCfg.debug, "debug", false, "enable debug logging")
-	flag.StringVar(&appCfg.cfgFile, "c", "", "specify TSM config file")
-	flag.StringVar(&appCfg.cfgFile, "config", "", "specify TSM config file")
-	flag.StringVar(&appCfg.runAsUser, "u", appCfg.runAsUser, "specify username instead of booger")
-	flag.StringVar(&appCfg.runAsUser, "user", appCfg.runAsUser, "specify user to run as")
-	flag.StringVar(&appCfg.community, "community", appCfg.community, "specify snmp read community")
-	flag.Parse()
+// executeCmd runs the command with the command service
+func executeCmd(cmd string, cmdSvc cmd.TSMCmdService) error {
 
+	switch cmd {
+	case "poll":
+		return cmdSvc.Poll()
+	case "daemon":
+		return cmdSvc.Daemon()
+	case "status":
+		return cmdSvc.Status()
+	case "history":
+		return cmdSvc.History()
+	case "traps":
+		return cmdSvc.Traps()
+	case "simulate":
+		return cmdSvc.Simulate()
+	case "walk":
+		return cmdSvc.Walk()
+	case "get":
+		return cmdSvc.Get()
+	case "discover":
+		return cmdSvc.Discover()
+	}
+	return fmt.Errorf("invalid command: %s", cmd)
 }
 
-func main() {
+// run starts logging, switches user, loads the config and runs the command
+// set up by the command line
+func run(appCfg *appConfig) (err error) {
 
-	var err error
-	var tsmCfg *config.TSMConfig
-	var appCfg = &appConfig{
-		debug:     false,
-		cfgFile:   "",
-		cmd:       "",
-		host:      "",
-		port:      "",
-		runAsUser: "nrts",
-		community: "public",
-		tsmCfg:    nil,
+	if err = initLogging(appCfg); err != nil {
+		return fmt.Errorf("error creating logger: %w", err)
 	}
+	defer l.Close()
+	defer func() {
+		if err != nil {
+			l.ErrMsg(err.Error())
+		}
+	}()
 
-	defineGlobalFlags(appCfg)
+	if appCfg.host != "" {
+		l.SetFields("host", appCfg.host)
+	}
 
-	err = initLogging(appCfg.debug)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "error creating logger (fprintf)")
-		log.Fatal("error creating logger (log.fatal)")
+	if appCfg.cmd == "daemon" && appCfg.detach && !daemon.IsChild() {
+		pid, err := daemon.Detach()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s daemon started with pid %d\n", os.Args[0], pid)
+		return nil
 	}
 
-	err = appCfg.readCLI(flag.Args())
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err.Error())
-		l.ErrMsg(err.Error())
-		flag.Usage()
-		os.Exit(1)
+	if err = logStartup(); err != nil {
+		return err
 	}
 
-	err = logStartup()
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err.Error())
-		l.ErrMsg(err.Error())
-		os.Exit(1)
+	if err = setUser(appCfg.runAsUser, appCfg.cmd == "daemon"); err != nil {
+		return err
 	}
 
-	err = setUser(appCfg.runAsUser)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err.Error())
-		l.ErrMsg(err.Error())
-		os.Exit(1)
+	if appCfg.cmd == "daemon" && appCfg.pidfile != "" {
+		if err = daemon.WritePidfile(appCfg.pidfile); err != nil {
+			return err
+		}
+		defer daemon.RemovePidfile(appCfg.pidfile)
 	}
 
 	// read tsm config file
-	tsmCfg, err = loadConfig(appCfg.cfgFile)
+	tsmCfg, err := loadConfig(appCfg.cfgFile)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err.Error())
-		l.ErrMsg(err.Error())
-		os.Exit(1)
+		return err
 	}
+	appCfg.community = tsmCfg.Community
+	if appCfg.host == "" && (appCfg.cmd == "poll" || appCfg.cmd == "daemon") {
+		if appCfg.host, appCfg.port, err = tsmCfg.HostPort(); err != nil {
+			return err
+		}
+		if appCfg.host == "" {
+			return fmt.Errorf("%s needs a host, on the command line or host in the config", appCfg.cmd)
+		}
+		appCfg.args[0] = net.JoinHostPort(appCfg.host, appCfg.port)
+		l.SetFields("host", appCfg.host)
+	}
+
+	backends, err := openLogConfig(appCfg, &tsmCfg.Log)
+	if err != nil {
+		return err
+	}
+	applyLogConfig(appCfg, &tsmCfg.Log, backends)
 
 	tuiLizer := tui.NewTui(appCfg.host, appCfg.port)
 
-	snmpSvc := snmp.NewSnmpService()
+	cur := &running{}
+	opts, err := cmdOptions(appCfg, tsmCfg, cur)
+	if err != nil {
+		return err
+	}
+
+	if appCfg.cmd == "daemon" {
+		var watcher *config.Watcher
+		if appCfg.watch && len(configFiles) > 0 {
+			if watcher, err = config.NewWatcher(configFiles, config.DefaultWatchDelay); err != nil {
+				return err
+			}
+			defer watcher.Close()
+			opts = append(opts, cmd.WithConfigWatcher(watcher))
+			l.NoticeMsg("reloading the config when %s changes", strings.Join(configFiles, ", "))
+		}
+		opts = append(opts, cmd.WithReload(
+			func() (*config.TSMConfig, error) {
+				cfg, err := loadConfig(appCfg.cfgFile)
+				if err == nil && watcher != nil {
+					// the new config may include other files
+					if err := watcher.Watch(configFiles); err != nil {
+						l.ErrMsg("watching the config files: %s", err.Error())
+					}
+				}
+				return cfg, err
+			},
+			func(cfg *config.TSMConfig) ([]cmd.Option, error) {
+				// the log config is only applied once the options are created
+				backends, err := openLogConfig(appCfg, &cfg.Log)
+				if err != nil {
+					return nil, err
+				}
+				opts, err := cmdOptions(appCfg, cfg, cur)
+				if err != nil {
+					closeBackends(backends)
+					return nil, err
+				}
+				applyLogConfig(appCfg, &cfg.Log, backends)
+				return opts, nil
+			}))
+	}
+
+	var snmpSvc cmd.SNMPService
+	if appCfg.replay != "" {
+		snmpSvc, err = snmp.NewReplayService(appCfg.replay, appCfg.speed)
+		if err != nil {
+			return err
+		}
+	} else {
+		liveSvc := snmp.NewSnmpService()
+		if err = liveSvc.SetVersion(tsmCfg.Version); err != nil {
+			return err
+		}
+		if appCfg.record != "" {
+			rec, err := snmp.NewRecorder(appCfg.record)
+			if err != nil {
+				return err
+			}
+			defer rec.Close()
+			liveSvc.SetRecorder(rec)
+			l.NoticeMsg("recording queries to %s", appCfg.record)
+		}
+		snmpSvc = liveSvc
+	}
 	cmdSvc := cmd.NewTSMCmdService(
-		appCfg.host, appCfg.port, appCfg.community, flag.Args(),
-		snmpSvc, tsmCfg, tuiLizer)
+		appCfg.host, appCfg.port, appCfg.community, appCfg.args,
+		snmpSvc, tsmCfg, tuiLizer, opts...)
 
-	executeCmd(appCfg.cmd, cmdSvc)
+	err = executeCmd(appCfg.cmd, cmdSvc)
 
 	l.NoticeMsg("%s shutting down", os.Args[0])
+	return err
+}
+
+func main() {
+
+	var appCfg = &appConfig{
+		debug:     false,
+		cfgFile:   "",
+		cmd:       "",
+		host:      "",
+		port:      "",
+		runAsUser: "nrts",
+		community: "public",
+		tsmCfg:    nil,
+	}
+
+	root := newRootCmd(appCfg)
+	root.SetArgs(legacyArgs(root, os.Args[1:]))
+	if err := root.Execute(); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,293 @@
+package mib
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"tsm/config"
+)
+
+// units maps the UNITS of MIB objects to the units used in tsm.toml
+var units = map[string]string{
+	"v":       "volts",
+	"volt":    "volts",
+	"volts":   "volts",
+	"a":       "amps",
+	"amp":     "amps",
+	"amps":    "amps",
+	"w":       "watts",
+	"watt":    "watts",
+	"watts":   "watts",
+	"ah":      "amp hours",
+	"kwh":     "kWh",
+	"wh":      "Wh",
+	"c":       "deg C",
+	"degc":    "deg C",
+	"deg c":   "deg C",
+	"celsius": "deg C",
+	"h":       "hours",
+	"hours":   "hours",
+	"s":       "seconds",
+	"seconds": "seconds",
+	"%":       "percent",
+	"percent": "percent",
+}
+
+var (
+	// scalingFactor matches "Scaling: 0.1", "scaling factor 0.1" and "scale 0.1"
+	scalingFactor = regexp.MustCompile(`(?i)scal(?:e|ing)(?:\s+factor)?\s*[:=]?\s*([0-9]*\.?[0-9]+(?:e-?[0-9]+)?)\b`)
+	// scalingPower matches "n * 180 * 2^-15" and "96.667*2^-15"
+	scalingPower = regexp.MustCompile(`(?:n\s*\*\s*)?([0-9]*\.?[0-9]+)\s*\*\s*2\s*\^\s*-\s*([0-9]+)`)
+	// scalingDivide matches "n/10"
+	scalingDivide = regexp.MustCompile(`\bn\s*/\s*([0-9]+)\b`)
+	// displayHint matches the DISPLAY-HINT of a fixed point integer, "d-2"
+	displayHint = regexp.MustCompile(`^d-([0-9]+)$`)
+	// bitStart splits a description before each "bit 3: RTS open"
+	bitStart = regexp.MustCompile(`(?i)\bbit\s+`)
+	// bitName matches the number and name of a bit after bitStart
+	bitName = regexp.MustCompile(`^([0-9]+)\s*[:=-]\s*(.+)$`)
+)
+
+// integerBases are the syntaxes of integers that may hold a bitfield
+var integerBases = map[string]bool{
+	"INTEGER":    true,
+	"Integer32":  true,
+	"Unsigned32": true,
+	"Gauge32":    true,
+}
+
+// scaling returns the factor a raw value of obj is multiplied by, from its
+// DISPLAY-HINT or description, or 1
+func scaling(obj *Object) float64 {
+
+	if m := displayHint.FindStringSubmatch(obj.Syntax.DisplayHint); m != nil {
+		places, _ := strconv.Atoi(m[1])
+		return math.Pow(10, -float64(places))
+	}
+	if m := scalingPower.FindStringSubmatch(obj.Description); m != nil {
+		base, _ := strconv.ParseFloat(m[1], 64)
+		power, _ := strconv.Atoi(m[2])
+		return base * math.Pow(2, -float64(power))
+	}
+	if m := scalingDivide.FindStringSubmatch(obj.Description); m != nil {
+		div, _ := strconv.ParseFloat(m[1], 64)
+		if div != 0 {
+			return 1 / div
+		}
+	}
+	if m := scalingFactor.FindStringSubmatch(obj.Description); m != nil {
+		if factor, err := strconv.ParseFloat(m[1], 64); err == nil && factor != 0 {
+			return factor
+		}
+	}
+	return 1
+}
+
+// label returns the first sentence of the description if it is short,
+// otherwise the object name split into words
+func label(obj *Object) string {
+
+	sentence := obj.Description
+	if end := strings.IndexAny(sentence, ".;:"); end >= 0 {
+		sentence = sentence[:end]
+	}
+	sentence = strings.TrimSpace(sentence)
+	if sentence != "" && len(sentence) <= 40 {
+		return sentence
+	}
+
+	var words []string
+	var word []rune
+	runes := []rune(obj.Name)
+	for ndx, ch := range runes {
+		upper := unicode.IsUpper(ch)
+		// a capital starts a word, unless it continues an acronym
+		if ndx > 0 && upper && (!unicode.IsUpper(runes[ndx-1]) ||
+			(ndx+1 < len(runes) && unicode.IsLower(runes[ndx+1]))) {
+			words = append(words, string(word))
+			word = nil
+		}
+		word = append(word, ch)
+	}
+	words = append(words, string(word))
+	text := strings.Join(words, " ")
+	return strings.ToUpper(text[:1]) + text[1:]
+}
+
+// namedValues returns the names indexed by value, with gaps filled with
+// prefix and the value
+func namedValues(named []NamedNumber, prefix string) ([]string, error) {
+
+	var max int64 = -1
+	for _, nn := range named {
+		if nn.Value < 0 || nn.Value > 255 {
+			return nil, fmt.Errorf("%s(%d) is outside 0 to 255", nn.Name, nn.Value)
+		}
+		if nn.Value > max {
+			max = nn.Value
+		}
+	}
+	values := make([]string, max+1)
+	for ndx := range values {
+		values[ndx] = fmt.Sprintf("%s%d", prefix, ndx)
+	}
+	for _, nn := range named {
+		values[nn.Value] = nn.Name
+	}
+	return values, nil
+}
+
+// describedBits returns the bits named in the description of an integer
+// object, each as "bit 3: RTS open" up to the next bit
+func describedBits(obj *Object) []NamedNumber {
+
+	if !integerBases[obj.Syntax.Base] || len(obj.Syntax.Enums) > 0 {
+		return nil
+	}
+	var bits []NamedNumber
+	for _, part := range bitStart.Split(obj.Description, -1)[1:] {
+		m := bitName.FindStringSubmatch(strings.TrimSpace(part))
+		if m == nil {
+			continue
+		}
+		bit, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		name := strings.TrimSpace(strings.TrimRight(m[2], ",;. "))
+		bits = append(bits, NamedNumber{Name: name, Value: bit})
+	}
+	return bits
+}
+
+// OidInfo converts a scalar object to a tsm.toml OID entry. The chancode
+// is left for the user to assign. An integer with bits named in its
+// description is a bitmap. BITS objects are not supported, as they are sent
+// as an OCTET STRING rather than the integer tsm decodes bitmaps from.
+func OidInfo(obj *Object) (config.OidInfo, error) {
+
+	info := config.OidInfo{
+		Oid:     obj.Oid + ".0",
+		Label:   label(obj),
+		Units:   units[strings.ToLower(strings.TrimSpace(obj.Units))],
+		Type:    "number",
+		Scaling: 1,
+	}
+	if info.Units == "" {
+		info.Units = obj.Units
+	}
+
+	var err error
+	bits := describedBits(obj)
+	switch {
+	case obj.Syntax.Base == "BITS":
+		return info, fmt.Errorf("%s: BITS are not supported", obj.Name)
+	case len(bits) > 0:
+		info.Type, info.Scaling = "bitmap", 0
+		info.Values, err = namedValues(bits, "bit")
+	case len(obj.Syntax.Enums) > 0:
+		info.Type, info.Scaling = "map", 0
+		info.Values, err = namedValues(obj.Syntax.Enums, "value")
+	case obj.Syntax.Base == "OCTET STRING" || obj.Syntax.Base == "OBJECT IDENTIFIER" || obj.Syntax.Base == "IpAddress":
+		info.Type = "string"
+	default:
+		info.Scaling = scaling(obj)
+	}
+	if err != nil {
+		return info, fmt.Errorf("%s: %w", obj.Name, err)
+	}
+	return info, nil
+}
+
+// section returns the device group list a scalar object belongs in:
+// strings and settings are static, enumerations are status, bitmaps are
+// alarms or faults and other numbers are measurements
+func section(obj *Object, info config.OidInfo) string {
+
+	switch {
+	case info.Type == "bitmap":
+		if strings.Contains(strings.ToLower(obj.Name+" "+info.Label), "fault") {
+			return "faults"
+		}
+		return "alarms"
+	case info.Type == "string" || obj.Access == "read-write" || obj.Access == "read-create":
+		return "static"
+	case info.Type == "map":
+		return "status"
+	}
+	return "measurements"
+}
+
+// modelOid returns the OID of the object most likely to hold the model
+// name: a string whose name or label mentions the model or controller,
+// otherwise the first string
+func modelOid(group *config.DeviceInfo) string {
+
+	first := ""
+	for _, info := range group.Static {
+		if info.Type != "string" {
+			continue
+		}
+		if first == "" {
+			first = info.Oid
+		}
+		text := strings.ToLower(info.Label)
+		if strings.Contains(text, "model") || strings.Contains(text, "controller") {
+			return info.Oid
+		}
+	}
+	return first
+}
+
+// DeviceGroups converts the scalar objects of modules to device groups, one
+// for each node the objects are defined under. Tables and BITS objects are
+// left out. The group OID is a guess and the model list is empty, to be
+// filled in.
+func DeviceGroups(modules []*Module) ([]config.DeviceInfo, error) {
+
+	var groups []config.DeviceInfo
+	byParent := make(map[string]int)
+
+	for _, mod := range modules {
+		for _, obj := range mod.Objects {
+			if obj.Columnar || obj.Syntax.Sequence || obj.Syntax.Base == "BITS" ||
+				obj.Access == "not-accessible" || obj.Access == "accessible-for-notify" {
+				continue
+			}
+			info, err := OidInfo(obj)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", mod.Name, err)
+			}
+
+			ndx, ok := byParent[obj.Parent]
+			if !ok {
+				ndx = len(groups)
+				byParent[obj.Parent] = ndx
+				groups = append(groups, config.DeviceInfo{ModelGroup: obj.Parent, Modellist: []string{}})
+			}
+			group := &groups[ndx]
+
+			switch section(obj, info) {
+			case "static":
+				group.Static = append(group.Static, info)
+			case "status":
+				group.Status = append(group.Status, info)
+			case "alarms":
+				group.Alarms = append(group.Alarms, info)
+			case "faults":
+				group.Faults = append(group.Faults, info)
+			default:
+				group.Measurements = append(group.Measurements, info)
+			}
+		}
+	}
+
+	for ndx := range groups {
+		groups[ndx].GroupOid = modelOid(&groups[ndx])
+	}
+	return groups, nil
+}
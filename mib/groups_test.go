@@ -0,0 +1,97 @@
+package mib
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"tsm/config"
+
+	"github.com/pelletier/go-toml"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func TestDeviceGroups(t *testing.T) {
+
+	modules, err := ParseFiles(testFiles...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups, err := DeviceGroups(modules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := config.WriteDeviceGroups(&buf, groups); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.String()
+
+	golden := filepath.Join("testdata", "devicegroups.golden")
+	if *update {
+		if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("device groups differ from %s\ngot:\n%s\nwant:\n%s", golden, got, want)
+	}
+
+	// the output reads back as the same device groups
+	tree, err := toml.Load(got)
+	if err != nil {
+		t.Fatalf("device groups are not valid TOML: %s", err)
+	}
+	var oids struct {
+		DeviceGroups []config.DeviceInfo `toml:"devicegroups"`
+	}
+	if err := tree.Unmarshal(&oids); err != nil {
+		t.Fatal(err)
+	}
+	if len(oids.DeviceGroups) != 1 || len(oids.DeviceGroups[0].Measurements) != len(groups[0].Measurements) {
+		t.Errorf("read back %+v", oids.DeviceGroups)
+	}
+}
+
+func TestNamedValues(t *testing.T) {
+
+	values, err := namedValues([]NamedNumber{{"start", 0}, {"night", 3}}, "value")
+	if err != nil || !reflect.DeepEqual(values, []string{"start", "value1", "value2", "night"}) {
+		t.Errorf("namedValues = %v, %v", values, err)
+	}
+	for _, named := range [][]NamedNumber{{{"big", 256}}, {{"negative", -1}}} {
+		if values, err := namedValues(named, "value"); err == nil {
+			t.Errorf("namedValues(%v) = %v, want an error", named, values)
+		}
+	}
+}
+
+func TestOidInfoBitmap(t *testing.T) {
+
+	obj := &Object{Name: "alarms", Oid: "1.3.6.1.4.1.33333.2.57", Syntax: Syntax{Base: "Unsigned32"},
+		Description: "Alarms. bit 0: RTS open, bit 2: RTS disconnected"}
+	info, err := OidInfo(obj)
+	if err != nil || info.Type != "bitmap" || !reflect.DeepEqual(info.Values, []string{"RTS open", "bit1", "RTS disconnected"}) {
+		t.Errorf("OidInfo = %+v, %v", info, err)
+	}
+
+	obj.Description = "Alarms. bit 300: out of range"
+	if _, err := OidInfo(obj); err == nil {
+		t.Error("bit 300 accepted")
+	}
+
+	// BITS arrive as an OCTET STRING, not an integer bitmap
+	obj = &Object{Name: "dipSwitches", Oid: "1.3.6.1.4.1.33333.2.48", Syntax: Syntax{Base: "BITS", Bits: []NamedNumber{{"switch1", 0}}}}
+	if info, err := OidInfo(obj); err == nil {
+		t.Errorf("BITS converted to %+v", info)
+	}
+}
@@ -0,0 +1,91 @@
+package mib
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// token is a word, number, quoted string or symbol of a MIB module
+type token struct {
+	text   string
+	quoted bool
+	line   int
+}
+
+// lex splits the text of a MIB into tokens, dropping comments. Comments
+// run from -- to the end of the line or the next --.
+func lex(text string) ([]token, error) {
+
+	var tokens []token
+	line := 1
+	for pos := 0; pos < len(text); {
+		ch := rune(text[pos])
+		switch {
+		case ch == '\n':
+			line++
+			pos++
+		case unicode.IsSpace(ch):
+			pos++
+		case strings.HasPrefix(text[pos:], "--"):
+			pos += 2
+			for pos < len(text) && text[pos] != '\n' {
+				if strings.HasPrefix(text[pos:], "--") {
+					pos += 2
+					break
+				}
+				pos++
+			}
+		case ch == '"':
+			end := strings.IndexByte(text[pos+1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated string", line)
+			}
+			str := text[pos+1 : pos+1+end]
+			tokens = append(tokens, token{text: str, quoted: true, line: line})
+			line += strings.Count(str, "\n")
+			pos += end + 2
+		case strings.HasPrefix(text[pos:], "::="):
+			tokens = append(tokens, token{text: "::=", line: line})
+			pos += 3
+		case strings.HasPrefix(text[pos:], ".."):
+			tokens = append(tokens, token{text: "..", line: line})
+			pos += 2
+		case strings.ContainsRune("{}(),;|[]", ch):
+			tokens = append(tokens, token{text: string(ch), line: line})
+			pos++
+		case isWordChar(ch):
+			end := pos
+			for end < len(text) && isWordChar(rune(text[end])) {
+				// a word ends before a comment
+				if strings.HasPrefix(text[end:], "--") {
+					break
+				}
+				end++
+			}
+			tokens = append(tokens, token{text: text[pos:end], line: line})
+			pos = end
+		default:
+			// hex and binary strings such as 'ff'H are not used by the
+			// objects imported, skip them
+			if ch == '\'' {
+				end := strings.IndexByte(text[pos+1:], '\'')
+				if end < 0 {
+					return nil, fmt.Errorf("line %d: unterminated quoted value", line)
+				}
+				pos += end + 2
+				for pos < len(text) && isWordChar(rune(text[pos])) {
+					pos++
+				}
+				tokens = append(tokens, token{text: "0", line: line})
+				continue
+			}
+			return nil, fmt.Errorf("line %d: unexpected character %q", line, ch)
+		}
+	}
+	return tokens, nil
+}
+
+func isWordChar(ch rune) bool {
+	return ch < unicode.MaxASCII && (unicode.IsLetter(ch) || unicode.IsDigit(ch) || ch == '-' || ch == '_')
+}
@@ -0,0 +1,546 @@
+// Package mib reads the objects defined in SMIv2 MIB modules, such as the
+// Morningstar MIBs, to generate tsm.toml device groups
+package mib
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// NamedNumber is an enumerated value of an INTEGER or a bit of a BITS type
+type NamedNumber struct {
+	Name  string
+	Value int64
+}
+
+// Syntax is the type of an object, after resolving textual conventions
+type Syntax struct {
+	Base        string // INTEGER, OCTET STRING, BITS, Integer32, Gauge32, DisplayString, etc.
+	Type        string // the type named in the SYNTAX clause
+	Enums       []NamedNumber
+	Bits        []NamedNumber
+	DisplayHint string
+	Sequence    bool // SEQUENCE or SEQUENCE OF, a table or table row
+}
+
+// Object is an OBJECT-TYPE
+type Object struct {
+	Name        string
+	Module      string
+	Parent      string // name of the node the object is defined under
+	Oid         string
+	Syntax      Syntax
+	Units       string
+	Access      string
+	Description string
+	Columnar    bool // a column of a table rather than a scalar
+}
+
+// Module is a MIB module with its objects in the order defined
+type Module struct {
+	Name    string
+	Objects []*Object
+}
+
+// wellKnown are the OIDs of the names MIBs import from SNMPv2-SMI
+var wellKnown = map[string]string{
+	"iso":          "1",
+	"org":          "1.3",
+	"dod":          "1.3.6",
+	"internet":     "1.3.6.1",
+	"directory":    "1.3.6.1.1",
+	"mgmt":         "1.3.6.1.2",
+	"mib-2":        "1.3.6.1.2.1",
+	"transmission": "1.3.6.1.2.1.10",
+	"experimental": "1.3.6.1.3",
+	"private":      "1.3.6.1.4",
+	"enterprises":  "1.3.6.1.4.1",
+	"security":     "1.3.6.1.5",
+	"snmpV2":       "1.3.6.1.6",
+	"snmpDomains":  "1.3.6.1.6.1",
+	"snmpProxys":   "1.3.6.1.6.2",
+	"snmpModules":  "1.3.6.1.6.3",
+}
+
+// wellKnownTypes are the textual conventions MIBs import from SNMPv2-TC
+var wellKnownTypes = map[string]Syntax{
+	"DisplayString":  {Base: "OCTET STRING", DisplayHint: "255a"},
+	"TruthValue":     {Base: "INTEGER", Enums: []NamedNumber{{"true", 1}, {"false", 2}}},
+	"PhysAddress":    {Base: "OCTET STRING", DisplayHint: "1x:"},
+	"MacAddress":     {Base: "OCTET STRING", DisplayHint: "1x:"},
+	"TimeStamp":      {Base: "TimeTicks"},
+	"TimeInterval":   {Base: "INTEGER"},
+	"DateAndTime":    {Base: "OCTET STRING"},
+	"TestAndIncr":    {Base: "INTEGER"},
+	"AutonomousType": {Base: "OBJECT IDENTIFIER"},
+	"RowStatus": {Base: "INTEGER", Enums: []NamedNumber{
+		{"active", 1}, {"notInService", 2}, {"notReady", 3}, {"createAndGo", 4}, {"createAndWait", 5}, {"destroy", 6}}},
+	"StorageType": {Base: "INTEGER", Enums: []NamedNumber{
+		{"other", 1}, {"volatile", 2}, {"nonVolatile", 3}, {"permanent", 4}, {"readOnly", 5}}},
+}
+
+// macros are the definitions whose value is an OID
+var macros = map[string]bool{
+	"OBJECT-TYPE":        true,
+	"MODULE-IDENTITY":    true,
+	"OBJECT-IDENTITY":    true,
+	"NOTIFICATION-TYPE":  true,
+	"OBJECT-GROUP":       true,
+	"NOTIFICATION-GROUP": true,
+	"MODULE-COMPLIANCE":  true,
+	"AGENT-CAPABILITIES": true,
+}
+
+// oidRef is an OID value relative to a named parent, until resolved
+type oidRef struct {
+	parent string
+	subids []string
+}
+
+// parser holds the definitions of the modules read so far. Names are
+// resolved once all the modules have been read, so modules may be given in
+// any order.
+type parser struct {
+	tokens []token
+	pos    int
+
+	module  *Module
+	modules []*Module
+	refs    map[string]oidRef
+	types   map[string]Syntax
+	objects map[string]*Object
+}
+
+// ParseFiles reads the MIB modules in paths and returns them with the OIDs
+// of their objects resolved
+func ParseFiles(paths ...string) ([]*Module, error) {
+
+	p := newParser()
+	for _, path := range paths {
+		text, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.parse(string(text)); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if err := p.resolve(); err != nil {
+		return nil, err
+	}
+	return p.modules, nil
+}
+
+// Parse reads MIB modules from text
+func Parse(text string) ([]*Module, error) {
+
+	p := newParser()
+	if err := p.parse(text); err != nil {
+		return nil, err
+	}
+	if err := p.resolve(); err != nil {
+		return nil, err
+	}
+	return p.modules, nil
+}
+
+func newParser() *parser {
+	return &parser{
+		refs:    make(map[string]oidRef),
+		types:   make(map[string]Syntax),
+		objects: make(map[string]*Object),
+	}
+}
+
+func (p *parser) peek(ahead int) string {
+	if p.pos+ahead < len(p.tokens) {
+		return p.tokens[p.pos+ahead].text
+	}
+	return ""
+}
+
+// at reports whether the current token is the symbol or keyword text
+func (p *parser) at(text string) bool {
+	return p.pos < len(p.tokens) && !p.tokens[p.pos].quoted && p.tokens[p.pos].text == text
+}
+
+func (p *parser) next() token {
+	if p.pos < len(p.tokens) {
+		tok := p.tokens[p.pos]
+		p.pos++
+		return tok
+	}
+	return token{}
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	line := 0
+	if p.pos < len(p.tokens) {
+		line = p.tokens[p.pos].line
+	} else if len(p.tokens) > 0 {
+		line = p.tokens[len(p.tokens)-1].line
+	}
+	return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) expect(text string) error {
+	if tok := p.next(); tok.text != text || tok.quoted {
+		p.pos--
+		return p.errorf("expected %s, found %q", text, tok.text)
+	}
+	return nil
+}
+
+// skipBraces skips a balanced { } or ( ) group starting at the current token
+func (p *parser) skipBraces() {
+
+	open := p.peek(0)
+	close := map[string]string{"{": "}", "(": ")"}[open]
+	if close == "" {
+		return
+	}
+	depth := 0
+	for p.pos < len(p.tokens) {
+		tok := p.next()
+		switch tok.text {
+		case open:
+			depth++
+		case close:
+			depth--
+			if depth == 0 {
+				return
+			}
+		}
+	}
+}
+
+// parse reads the modules in text
+func (p *parser) parse(text string) error {
+
+	tokens, err := lex(text)
+	if err != nil {
+		return err
+	}
+	p.tokens, p.pos = tokens, 0
+
+	for p.pos < len(p.tokens) {
+		name := p.next().text
+		switch {
+		case p.peek(0) == "DEFINITIONS":
+			p.module = &Module{Name: name}
+			p.modules = append(p.modules, p.module)
+			for p.pos < len(p.tokens) && p.next().text != "BEGIN" {
+			}
+		case p.module == nil:
+			return p.errorf("definition %s outside a module", name)
+		case name == "END":
+			p.module = nil
+		case name == "IMPORTS" || name == "EXPORTS":
+			for p.pos < len(p.tokens) && p.next().text != ";" {
+			}
+		case p.peek(0) == "OBJECT" && p.peek(1) == "IDENTIFIER" && p.peek(2) == "::=":
+			p.pos += 3
+			if err := p.oidValue(name); err != nil {
+				return err
+			}
+		case macros[p.peek(0)]:
+			if err := p.macro(name); err != nil {
+				return err
+			}
+		case p.peek(0) == "MACRO":
+			// macro definitions are only found in the base MIBs
+			for p.pos < len(p.tokens) && p.next().text != "END" {
+			}
+		case p.peek(0) == "::=":
+			p.pos++
+			if err := p.typeAssignment(name); err != nil {
+				return err
+			}
+		default:
+			return p.errorf("unexpected %q", name)
+		}
+	}
+	if p.module != nil {
+		return fmt.Errorf("module %s has no END", p.module.Name)
+	}
+	return nil
+}
+
+// oidValue reads { parent subid ... } as the OID of name
+func (p *parser) oidValue(name string) error {
+
+	if err := p.expect("{"); err != nil {
+		return err
+	}
+	var ref oidRef
+	for p.pos < len(p.tokens) && p.peek(0) != "}" {
+		tok := p.next().text
+		if p.peek(0) == "(" {
+			// name(number) form, the number is what counts
+			p.next()
+			tok = p.next().text
+			if err := p.expect(")"); err != nil {
+				return err
+			}
+		}
+		if ref.parent == "" && len(ref.subids) == 0 {
+			if _, err := strconv.ParseUint(tok, 10, 32); err != nil {
+				ref.parent = tok
+				continue
+			}
+		}
+		ref.subids = append(ref.subids, tok)
+	}
+	if err := p.expect("}"); err != nil {
+		return err
+	}
+	p.refs[name] = ref
+	return nil
+}
+
+// macro reads an OBJECT-TYPE or another macro with an OID value
+func (p *parser) macro(name string) error {
+
+	kind := p.next().text
+	obj := &Object{Name: name, Module: p.module.Name}
+
+	for p.pos < len(p.tokens) && !p.at("::=") {
+		clause := p.next()
+		if clause.quoted {
+			continue
+		}
+		switch clause.text {
+		case "SYNTAX":
+			syntax, err := p.syntax()
+			if err != nil {
+				return err
+			}
+			obj.Syntax = syntax
+		case "UNITS":
+			obj.Units = p.next().text
+		case "MAX-ACCESS", "ACCESS":
+			obj.Access = p.next().text
+		case "DESCRIPTION":
+			obj.Description = normalize(p.next().text)
+		case "{", "(":
+			p.pos--
+			p.skipBraces()
+		}
+	}
+	if err := p.expect("::="); err != nil {
+		return err
+	}
+	if err := p.oidValue(name); err != nil {
+		return err
+	}
+
+	if kind == "OBJECT-TYPE" {
+		p.module.Objects = append(p.module.Objects, obj)
+		p.objects[name] = obj
+	}
+	return nil
+}
+
+// typeAssignment reads a textual convention, or a type such as a table row
+// SEQUENCE
+func (p *parser) typeAssignment(name string) error {
+
+	if p.peek(0) != "TEXTUAL-CONVENTION" {
+		syntax, err := p.syntax()
+		if err != nil {
+			return err
+		}
+		p.types[name] = syntax
+		return nil
+	}
+
+	p.next()
+	var hint string
+	for p.pos < len(p.tokens) {
+		clause := p.next()
+		switch clause.text {
+		case "DISPLAY-HINT":
+			hint = p.next().text
+		case "SYNTAX":
+			syntax, err := p.syntax()
+			if err != nil {
+				return err
+			}
+			if hint != "" {
+				syntax.DisplayHint = hint
+			}
+			p.types[name] = syntax
+			return nil
+		case "STATUS":
+			p.next()
+		case "DESCRIPTION", "REFERENCE":
+			p.next()
+		default:
+			return p.errorf("unexpected %q in textual convention %s", clause.text, name)
+		}
+	}
+	return p.errorf("textual convention %s has no SYNTAX", name)
+}
+
+// syntax reads a type with its enumerations or constraints
+func (p *parser) syntax() (Syntax, error) {
+
+	var syntax Syntax
+	switch tok := p.next().text; tok {
+	case "OCTET", "OBJECT":
+		second := p.next().text
+		syntax.Base = tok + " " + second
+	case "SEQUENCE":
+		syntax.Base, syntax.Sequence = "SEQUENCE", true
+		if p.peek(0) == "OF" {
+			p.next()
+			syntax.Type = p.next().text
+		} else {
+			p.skipBraces()
+		}
+		return syntax, nil
+	default:
+		syntax.Base = tok
+	}
+	syntax.Type = syntax.Base
+
+	if p.peek(0) == "{" {
+		named, err := p.namedNumbers()
+		if err != nil {
+			return syntax, err
+		}
+		if syntax.Base == "BITS" {
+			syntax.Bits = named
+		} else {
+			syntax.Enums = named
+		}
+	}
+	if p.peek(0) == "(" {
+		p.skipBraces()
+	}
+	return syntax, nil
+}
+
+// namedNumbers reads { name(number), ... }
+func (p *parser) namedNumbers() ([]NamedNumber, error) {
+
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var named []NamedNumber
+	for p.pos < len(p.tokens) && p.peek(0) != "}" {
+		name := p.next().text
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		num := p.next().text
+		value, err := strconv.ParseInt(num, 10, 64)
+		if err != nil {
+			return nil, p.errorf("invalid value %q for %s", num, name)
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		named = append(named, NamedNumber{name, value})
+		if p.peek(0) == "," {
+			p.next()
+		}
+	}
+	return named, p.expect("}")
+}
+
+// resolve sets the OIDs of the objects, resolves the textual conventions
+// they use and marks the columns of tables
+func (p *parser) resolve() error {
+
+	oids := make(map[string]string)
+	var resolveOid func(name string, depth int) (string, error)
+	resolveOid = func(name string, depth int) (string, error) {
+		if oid, ok := oids[name]; ok {
+			return oid, nil
+		}
+		if oid, ok := wellKnown[name]; ok {
+			return oid, nil
+		}
+		ref, ok := p.refs[name]
+		if !ok {
+			return "", fmt.Errorf("unknown OID name %s, add the MIB that defines it", name)
+		}
+		if depth > 64 {
+			return "", fmt.Errorf("OID of %s loops", name)
+		}
+		oid := ""
+		if ref.parent != "" {
+			parent, err := resolveOid(ref.parent, depth+1)
+			if err != nil {
+				return "", err
+			}
+			oid = parent
+		}
+		for _, subid := range ref.subids {
+			if oid != "" {
+				oid += "."
+			}
+			oid += subid
+		}
+		oids[name] = oid
+		return oid, nil
+	}
+
+	for _, mod := range p.modules {
+		for _, obj := range mod.Objects {
+			oid, err := resolveOid(obj.Name, 0)
+			if err != nil {
+				return fmt.Errorf("%s %s: %w", mod.Name, obj.Name, err)
+			}
+			obj.Oid = oid
+			obj.Parent = p.refs[obj.Name].parent
+			obj.Syntax = p.resolveType(obj.Syntax)
+		}
+	}
+
+	// the objects under a table row are columns
+	for _, mod := range p.modules {
+		for _, obj := range mod.Objects {
+			parent := p.refs[obj.Name].parent
+			if row, ok := p.objects[parent]; ok && row.Syntax.Sequence {
+				obj.Columnar = true
+			}
+		}
+	}
+	return nil
+}
+
+// resolveType replaces a textual convention with its underlying type,
+// keeping the convention's name in Type
+func (p *parser) resolveType(syntax Syntax) Syntax {
+
+	for depth := 0; depth < 16; depth++ {
+		tc, ok := p.types[syntax.Base]
+		if !ok {
+			tc, ok = wellKnownTypes[syntax.Base]
+		}
+		if !ok {
+			break
+		}
+		if syntax.DisplayHint == "" {
+			syntax.DisplayHint = tc.DisplayHint
+		}
+		if syntax.Enums == nil {
+			syntax.Enums = tc.Enums
+		}
+		if syntax.Bits == nil {
+			syntax.Bits = tc.Bits
+		}
+		syntax.Sequence = syntax.Sequence || tc.Sequence
+		syntax.Base = tc.Base
+	}
+	return syntax
+}
+
+// normalize collapses the white space of a description
+func normalize(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
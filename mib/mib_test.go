@@ -0,0 +1,141 @@
+package mib
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testFiles = []string{"testdata/TRISTAR-MPPT-TEST-MIB.mib", "testdata/MORNINGSTAR-TEST-SMI.mib"}
+
+// objects returns the objects of modules by name
+func objects(modules []*Module) map[string]*Object {
+	objs := make(map[string]*Object)
+	for _, mod := range modules {
+		for _, obj := range mod.Objects {
+			objs[obj.Name] = obj
+		}
+	}
+	return objs
+}
+
+func TestParseFiles(t *testing.T) {
+
+	modules, err := ParseFiles(testFiles...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(modules) != 2 || modules[0].Name != "TRISTAR-MPPT-TEST-MIB" || modules[1].Name != "MORNINGSTAR-TEST-SMI" {
+		t.Fatalf("modules = %v", modules)
+	}
+	objs := objects(modules)
+
+	tests := []struct {
+		name     string
+		oid      string
+		base     string
+		columnar bool
+	}{
+		{"model", "1.3.6.1.4.1.33333.2.1", "OCTET STRING", false},
+		{"adcVbFMed", "1.3.6.1.4.1.33333.2.38", "Integer32", false},
+		{"chargeState", "1.3.6.1.4.1.33333.2.46", "INTEGER", false},
+		{"heatsinkTemp", "1.3.6.1.4.1.33333.2.49", "Integer32", false},
+		{"dipSwitches", "1.3.6.1.4.1.33333.2.48", "BITS", false},
+		{"faults", "1.3.6.1.4.1.33333.2.55", "Unsigned32", false},
+		{"eqAuto", "1.3.6.1.4.1.33333.2.70", "INTEGER", false},
+		{"logTable", "1.3.6.1.4.1.33333.2.80", "SEQUENCE", false},
+		{"logVbMin", "1.3.6.1.4.1.33333.2.80.1.2", "Integer32", true},
+	}
+	for _, tt := range tests {
+		obj, ok := objs[tt.name]
+		if !ok {
+			t.Errorf("%s not parsed", tt.name)
+			continue
+		}
+		if obj.Oid != tt.oid || obj.Syntax.Base != tt.base || obj.Columnar != tt.columnar {
+			t.Errorf("%s = %s %s columnar %v, want %s %s %v", tt.name,
+				obj.Oid, obj.Syntax.Base, obj.Columnar, tt.oid, tt.base, tt.columnar)
+		}
+	}
+
+	if hint := objs["heatsinkTemp"].Syntax.DisplayHint; hint != "d-1" {
+		t.Errorf("heatsinkTemp display hint = %q, want d-1", hint)
+	}
+	if enums := objs["chargeState"].Syntax.Enums; len(enums) != 10 || enums[7] != (NamedNumber{"float", 7}) {
+		t.Errorf("chargeState enums = %v", enums)
+	}
+	if enums := objs["eqAuto"].Syntax.Enums; !reflect.DeepEqual(enums, []NamedNumber{{"true", 1}, {"false", 2}}) {
+		t.Errorf("TruthValue enums = %v", enums)
+	}
+	if bits := objs["dipSwitches"].Syntax.Bits; len(bits) != 4 || bits[3] != (NamedNumber{"switch4", 3}) {
+		t.Errorf("dipSwitches bits = %v", bits)
+	}
+	if desc := objs["adcIbFShadow"].Description; strings.Contains(desc, "\n") || !strings.HasPrefix(desc, "Battery charge current as reported by the controller after filtering") {
+		t.Errorf("description not normalized: %q", desc)
+	}
+	if _, ok := objs["faultTrap"]; ok {
+		t.Error("notification parsed as an object")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+
+	tests := map[string]string{
+		"missing module": "TRISTAR-MPPT-TEST-MIB",
+		"unknown parent": `A-MIB DEFINITIONS ::= BEGIN
+x OBJECT-TYPE SYNTAX Integer32 MAX-ACCESS read-only STATUS current DESCRIPTION "x" ::= { nowhere 1 }
+END`,
+		"no end": `A-MIB DEFINITIONS ::= BEGIN
+x OBJECT IDENTIFIER ::= { enterprises 1 }`,
+		"unterminated string": `A-MIB DEFINITIONS ::= BEGIN
+x OBJECT-TYPE DESCRIPTION "x`,
+	}
+	for name, text := range tests {
+		if name == "missing module" {
+			if _, err := ParseFiles(testFiles[0]); err == nil || !strings.Contains(err.Error(), "morningstar") {
+				t.Errorf("%s: err = %v, want unknown morningstar", name, err)
+			}
+			continue
+		}
+		if _, err := Parse(text); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestLabel(t *testing.T) {
+
+	tests := []struct {
+		obj  Object
+		want string
+	}{
+		{Object{Name: "adcVbFMed", Description: "Battery voltage, filtered. Scaling: n * 180 * 2^-15"}, "Battery voltage, filtered"},
+		{Object{Name: "adcIbFShadow", Description: "Battery charge current as reported by the controller after filtering"}, "Adc Ib F Shadow"},
+		{Object{Name: "vbRefSlaveDIPSwitch"}, "Vb Ref Slave DIP Switch"},
+	}
+	for _, tt := range tests {
+		if got := label(&tt.obj); got != tt.want {
+			t.Errorf("label(%s) = %q, want %q", tt.obj.Name, got, tt.want)
+		}
+	}
+}
+
+func TestScaling(t *testing.T) {
+
+	tests := []struct {
+		obj  Object
+		want float64
+	}{
+		{Object{Description: "Battery voltage. Scaling: n * 180 * 2^-15"}, 180.0 / 32768},
+		{Object{Description: "96.667*2^-15"}, 96.667 / 32768},
+		{Object{Description: "Current, n/10 amps"}, 0.1},
+		{Object{Description: "Power, scaling factor 0.5"}, 0.5},
+		{Object{Syntax: Syntax{DisplayHint: "d-2"}}, 0.01},
+		{Object{Description: "Hourmeter"}, 1},
+	}
+	for _, tt := range tests {
+		if got := scaling(&tt.obj); got != tt.want {
+			t.Errorf("scaling(%q, %q) = %g, want %g", tt.obj.Description, tt.obj.Syntax.DisplayHint, got, tt.want)
+		}
+	}
+}
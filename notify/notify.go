@@ -0,0 +1,443 @@
+// Package notify sends alert notifications when channel thresholds are
+// crossed or alarm/fault events occur, and again when they recover
+package notify
+
+import (
+	"fmt"
+	"log/syslog"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"tsm/config"
+	"tsm/events"
+	rlog "tsm/log"
+)
+
+// Notification states
+const (
+	StateAlert     = "ALERT"
+	StateRepeat    = "REPEAT"
+	StateRecovered = "RECOVERED"
+)
+
+// queueSize is the number of notifications that can wait for delivery
+const queueSize = 100
+
+// Notification is what is sent to the sinks. It is the JSON payload of
+// webhook and exec sinks.
+type Notification struct {
+	Time      time.Time `json:"time"`
+	Host      string    `json:"host"`
+	Net       string    `json:"net"`
+	Sta       string    `json:"sta"`
+	Loc       string    `json:"loc"`
+	Rule      string    `json:"rule"`
+	Severity  string    `json:"severity"`
+	State     string    `json:"state"`
+	Channel   string    `json:"channel"`
+	Value     string    `json:"value"`
+	Threshold string    `json:"threshold,omitempty"`
+	Message   string    `json:"message"`
+}
+
+// Subject is a one line summary of the notification. Line breaks and other
+// control characters from the rule or message are replaced by spaces.
+func (n *Notification) Subject() string {
+	subject := fmt.Sprintf("[tsm %s %s] %s %s: %s", n.Sta, strings.ToUpper(n.Severity), n.State, n.Rule, n.Message)
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, subject)
+}
+
+// Body is the full text of the notification
+func (n *Notification) Body() string {
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Station:   %s %s %s\n", n.Net, n.Sta, n.Loc)
+	fmt.Fprintf(&b, "Host:      %s\n", n.Host)
+	fmt.Fprintf(&b, "Time:      %s\n", n.Time.UTC().Format("2006-01-02 15:04:05 MST"))
+	fmt.Fprintf(&b, "Rule:      %s\n", n.Rule)
+	fmt.Fprintf(&b, "Severity:  %s\n", n.Severity)
+	fmt.Fprintf(&b, "State:     %s\n", n.State)
+	fmt.Fprintf(&b, "Channel:   %s\n", n.Channel)
+	fmt.Fprintf(&b, "Value:     %s\n", n.Value)
+	if n.Threshold != "" {
+		fmt.Fprintf(&b, "Threshold: %s\n", n.Threshold)
+	}
+	fmt.Fprintf(&b, "\n%s\n", n.Message)
+	return b.String()
+}
+
+// rule is a configured rule with its parsed settings
+type rule struct {
+	cfg      *config.NotifyRuleConfig
+	severity syslog.Priority
+	holdOff  time.Duration
+	repeat   time.Duration
+}
+
+// alert tracks one active or recently active condition of a rule
+type alert struct {
+	rule     *rule
+	active   bool
+	notified bool // an ALERT was sent for the current activation
+	lastSent time.Time
+	last     Notification
+}
+
+// delivery is a notification queued for a set of sinks
+type delivery struct {
+	n     Notification
+	sinks []string
+}
+
+// Notifier evaluates the rules against each scan and every published event.
+// It is a cmd.ScanProcessor that contributes no derived channels.
+type Notifier struct {
+	cfg   *config.TSMConfig
+	host  string
+	rules []*rule
+	sinks map[string]sink
+	names []string
+
+	mutex  sync.Mutex
+	alerts map[string]*alert
+	closed bool
+	queue  chan delivery
+	wg     sync.WaitGroup
+}
+
+// NewNotifier constructor. If bus is not nil the notifier subscribes to its events.
+func NewNotifier(cfg *config.TSMConfig, host string, bus *events.Bus) *Notifier {
+
+	ntf := &Notifier{
+		cfg:    cfg,
+		host:   host,
+		sinks:  make(map[string]sink),
+		alerts: make(map[string]*alert),
+		queue:  make(chan delivery, queueSize),
+	}
+	for ndx := range cfg.Notify.Sinks {
+		scfg := &cfg.Notify.Sinks[ndx]
+		ntf.sinks[scfg.Name] = newSink(scfg)
+		ntf.names = append(ntf.names, scfg.Name)
+	}
+	for ndx := range cfg.Notify.Rules {
+		rcfg := &cfg.Notify.Rules[ndx]
+		sev, _ := config.ParseSeverity(rcfg.Severity)
+		ntf.rules = append(ntf.rules, &rule{
+			cfg:      rcfg,
+			severity: sev,
+			holdOff:  time.Duration(rcfg.HoldOff * float64(time.Second)),
+			repeat:   time.Duration(rcfg.Repeat * float64(time.Second)),
+		})
+	}
+
+	ntf.wg.Add(1)
+	go ntf.deliver()
+
+	if bus != nil {
+		bus.Subscribe(ntf.HandleEvent)
+	}
+
+	return ntf
+}
+
+// Resume takes over the alerts of the notifier this one replaces, so that a
+// reload neither sends them again nor restarts their hold-off and repeat
+// timers. Alerts for rules no longer configured are dropped.
+func (ntf *Notifier) Resume(prev interface{}) {
+
+	old, ok := prev.(*Notifier)
+	if !ok {
+		return
+	}
+	old.mutex.Lock()
+	defer old.mutex.Unlock()
+	ntf.mutex.Lock()
+	defer ntf.mutex.Unlock()
+
+	for key, a := range old.alerts {
+		for _, r := range ntf.rules {
+			if r.cfg.Name == a.rule.cfg.Name {
+				resumed := *a
+				resumed.rule = r
+				ntf.alerts[key] = &resumed
+				break
+			}
+		}
+	}
+}
+
+// Process does nothing, rules are evaluated in ProcessDerived once the
+// derived channels for the scan are available
+func (ntf *Notifier) Process(ts time.Time, scan *map[string]string) {
+}
+
+// ProcessDerived evaluates the threshold rules and sends any repeats due
+func (ntf *Notifier) ProcessDerived(ts time.Time, scan *map[string]string, derived []config.DerivedChannel) {
+
+	ntf.mutex.Lock()
+	defer ntf.mutex.Unlock()
+
+	for _, r := range ntf.rules {
+		if r.cfg.Event {
+			continue
+		}
+		val, units, ok := ntf.channelValue(r.cfg.Channel, scan, derived)
+		if !ok {
+			continue
+		}
+		ntf.evalThreshold(ts, r, val, units)
+	}
+
+	for _, a := range ntf.alerts {
+		ntf.checkRepeat(ts, a)
+	}
+}
+
+// channelValue finds the value of a polled "number" channel or a derived channel
+func (ntf *Notifier) channelValue(channel string, scan *map[string]string, derived []config.DerivedChannel) (float64, string, bool) {
+
+	if oidinfo, ok := ntf.cfg.FindOid(channel); ok {
+		val, err := ntf.cfg.ScaledValue(oidinfo.Oid, scan)
+		if err != nil {
+			rlog.DebugMsg("notify: %s", err.Error())
+			return 0, "", false
+		}
+		return val, oidinfo.Units, true
+	}
+	for _, dc := range derived {
+		if strings.EqualFold(dc.Chancode, channel) || strings.EqualFold(dc.Label, channel) {
+			return dc.Value, dc.Units, true
+		}
+	}
+	return 0, "", false
+}
+
+func (ntf *Notifier) evalThreshold(ts time.Time, r *rule, val float64, units string) {
+
+	rc := r.cfg
+	breach := (rc.Above != nil && val > *rc.Above) || (rc.Below != nil && val < *rc.Below)
+	clear := (rc.Above == nil || val < *rc.Above-rc.Hysteresis) &&
+		(rc.Below == nil || val > *rc.Below+rc.Hysteresis)
+
+	var limits []string
+	if rc.Above != nil {
+		limits = append(limits, fmt.Sprintf("above %g", *rc.Above))
+	}
+	if rc.Below != nil {
+		limits = append(limits, fmt.Sprintf("below %g", *rc.Below))
+	}
+
+	n := ntf.newNotification(ts, r)
+	n.Channel = rc.Channel
+	n.Value = strings.TrimSpace(fmt.Sprintf("%.3f %s", val, units))
+	n.Threshold = strings.Join(limits, ", ")
+
+	a := ntf.alert(rc.Name, r)
+	switch {
+	case breach && !a.active:
+		n.Message = fmt.Sprintf("%s is %s, %s", rc.Channel, n.Value, n.Threshold)
+		ntf.activate(ts, a, n)
+	case clear && a.active:
+		n.Message = fmt.Sprintf("%s is %s, back within limits (%s, hysteresis %g)", rc.Channel, n.Value, n.Threshold, rc.Hysteresis)
+		ntf.recover(ts, a, n)
+	case a.active:
+		a.last.Value = n.Value
+	}
+}
+
+// HandleEvent evaluates the event rules against an alarm, fault, state or trap event
+func (ntf *Notifier) HandleEvent(ev events.Event) {
+
+	ntf.mutex.Lock()
+	defer ntf.mutex.Unlock()
+
+	for _, r := range ntf.rules {
+		rc := r.cfg
+		if !rc.Event {
+			continue
+		}
+		if rc.Label != "" && !strings.EqualFold(rc.Label, ev.Label) {
+			continue
+		}
+
+		n := ntf.newNotification(ev.Time, r)
+		n.Channel = ev.Label
+		n.Value = ev.Name
+		n.Message = ev.String()
+
+		switch ev.Kind {
+		case events.KindSet, events.KindTrap:
+			if (rc.Kind == "" || rc.Kind == ev.Kind) && nameMatches(rc.EventName, ev.Name) {
+				a := ntf.alert(rc.Name+"/"+ev.Label+"/"+ev.Name, r)
+				ntf.activate(ev.Time, a, n)
+				if ev.Kind == events.KindTrap {
+					// traps have no clearing event
+					a.active = false
+				}
+			}
+		case events.KindClear:
+			if rc.Kind == "" || rc.Kind == events.KindSet {
+				if a, ok := ntf.alerts[rc.Name+"/"+ev.Label+"/"+ev.Name]; ok && a.active {
+					ntf.recover(ev.Time, a, n)
+				}
+			}
+		case events.KindChange:
+			if rc.Kind != "" && rc.Kind != events.KindChange {
+				continue
+			}
+			if rc.EventName == "" {
+				// every change is a one off notification
+				a := ntf.alert(rc.Name+"/"+ev.Label, r)
+				ntf.activate(ev.Time, a, n)
+				a.active = false
+				continue
+			}
+			if a, ok := ntf.alerts[rc.Name+"/"+ev.Label+"/"+ev.Old]; ok && a.active && nameMatches(rc.EventName, ev.Old) {
+				ntf.recover(ev.Time, a, n)
+			}
+			if nameMatches(rc.EventName, ev.New) {
+				ntf.activate(ev.Time, ntf.alert(rc.Name+"/"+ev.Label+"/"+ev.New, r), n)
+			}
+		}
+	}
+}
+
+func nameMatches(want, name string) bool {
+	return want == "" || strings.EqualFold(want, name)
+}
+
+// alert returns the alert for key, creating it if needed
+func (ntf *Notifier) alert(key string, r *rule) *alert {
+	a, ok := ntf.alerts[key]
+	if !ok {
+		a = &alert{rule: r}
+		ntf.alerts[key] = a
+	}
+	return a
+}
+
+// activate marks an alert active and notifies unless it is within its hold-off
+func (ntf *Notifier) activate(ts time.Time, a *alert, n Notification) {
+
+	a.active = true
+	a.last = n
+	if !a.lastSent.IsZero() && ts.Sub(a.lastSent) < a.rule.holdOff {
+		rlog.InfoMsg("notify: %s held off, last notification %s", n.Rule, a.lastSent.Format(time.RFC3339))
+		a.notified = false
+		return
+	}
+	n.State = StateAlert
+	ntf.send(a, n)
+	a.notified = true
+	a.lastSent = ts
+}
+
+// recover marks an alert inactive and sends a recovery if an alert was sent
+func (ntf *Notifier) recover(ts time.Time, a *alert, n Notification) {
+
+	a.active = false
+	if !a.notified || a.rule.cfg.NoRecovery {
+		a.notified = false
+		return
+	}
+	n.State = StateRecovered
+	ntf.send(a, n)
+	a.notified = false
+	a.lastSent = ts
+}
+
+// checkRepeat re-sends an active alert whose repeat interval has passed, or
+// the original alert if it was held off
+func (ntf *Notifier) checkRepeat(ts time.Time, a *alert) {
+
+	if !a.active {
+		return
+	}
+	n := a.last
+	n.Time = ts
+	switch {
+	case !a.notified && ts.Sub(a.lastSent) >= a.rule.holdOff:
+		n.State = StateAlert
+		a.notified = true
+	case a.notified && a.rule.repeat > 0 && ts.Sub(a.lastSent) >= a.rule.repeat:
+		n.State = StateRepeat
+	default:
+		return
+	}
+	ntf.send(a, n)
+	a.lastSent = ts
+}
+
+func (ntf *Notifier) newNotification(ts time.Time, r *rule) Notification {
+	return Notification{
+		Time:     ts,
+		Host:     ntf.host,
+		Net:      ntf.cfg.General.Net,
+		Sta:      ntf.cfg.General.Sta,
+		Loc:      ntf.cfg.General.Loc,
+		Rule:     r.cfg.Name,
+		Severity: config.SeverityName(r.severity),
+	}
+}
+
+// send logs the notification and queues it for delivery without blocking
+func (ntf *Notifier) send(a *alert, n Notification) {
+
+	rlog.LogMsg(a.rule.severity, "notify: %s %s: %s", n.State, n.Rule, n.Message)
+	if ntf.closed {
+		return
+	}
+
+	sinks := a.rule.cfg.Sinks
+	if len(sinks) == 0 {
+		sinks = ntf.names
+	}
+	select {
+	case ntf.queue <- delivery{n, sinks}:
+	default:
+		rlog.ErrMsg("notify: delivery queue full, %s %s dropped", n.State, n.Rule)
+	}
+}
+
+// deliver sends queued notifications to their sinks
+func (ntf *Notifier) deliver() {
+
+	defer ntf.wg.Done()
+
+	for dlv := range ntf.queue {
+		for _, name := range dlv.sinks {
+			if err := ntf.sinks[name].send(&dlv.n); err != nil {
+				rlog.ErrMsg("notify: sending %s %s to %s: %s", dlv.n.State, dlv.n.Rule, name, err.Error())
+			}
+		}
+	}
+}
+
+// Derived returns nothing, notifications are sent to the sinks
+func (ntf *Notifier) Derived() []config.DerivedChannel {
+	return nil
+}
+
+// Close waits for queued notifications to be delivered
+func (ntf *Notifier) Close() error {
+
+	ntf.mutex.Lock()
+	if ntf.closed {
+		ntf.mutex.Unlock()
+		return nil
+	}
+	ntf.closed = true
+	close(ntf.queue)
+	ntf.mutex.Unlock()
+
+	ntf.wg.Wait()
+	return nil
+}
@@ -0,0 +1,35 @@
+package notify
+
+import (
+	"testing"
+	"time"
+
+	"tsm/config"
+	"tsm/events"
+)
+
+func TestResume(t *testing.T) {
+
+	cfg := config.NewConfig()
+	cfg.Notify.Rules = []config.NotifyRuleConfig{
+		{Name: "alarms", Severity: "warning", Event: true, Kind: events.KindSet, HoldOff: 300},
+		{Name: "removed", Severity: "warning", Event: true, Kind: events.KindSet, HoldOff: 300},
+	}
+	old := NewNotifier(cfg, "127.0.0.1", nil)
+	defer old.Close()
+	old.HandleEvent(events.Event{Time: time.Now(), Kind: events.KindSet, Label: "Alarms (now)", Name: "RTS open"})
+
+	newCfg := config.NewConfig()
+	newCfg.Notify.Rules = cfg.Notify.Rules[:1]
+	ntf := NewNotifier(newCfg, "127.0.0.1", nil)
+	defer ntf.Close()
+	ntf.Resume(old)
+
+	if len(ntf.alerts) != 1 {
+		t.Fatalf("%d alerts resumed, want 1", len(ntf.alerts))
+	}
+	a, ok := ntf.alerts["alarms/Alarms (now)/RTS open"]
+	if !ok || !a.active || !a.notified || a.rule != ntf.rules[0] {
+		t.Errorf("alert not resumed for the new rule: %+v", a)
+	}
+}
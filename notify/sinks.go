@@ -0,0 +1,170 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"tsm/config"
+)
+
+// sink delivers a notification
+type sink interface {
+	send(*Notification) error
+}
+
+func newSink(scfg *config.NotifySinkConfig) sink {
+
+	timeout := time.Duration(scfg.Timeout * float64(time.Second))
+
+	switch scfg.Type {
+	case "smtp":
+		return &smtpSink{server: scfg.Server, from: scfg.From, to: scfg.To, timeout: timeout}
+	case "webhook":
+		return &webhookSink{url: scfg.URL, client: &http.Client{Timeout: timeout}}
+	case "exec":
+		return &execSink{command: scfg.Command, timeout: timeout}
+	}
+	return nil
+}
+
+// smtpSink mails notifications through a local relay without authentication
+type smtpSink struct {
+	server  string
+	from    string
+	to      []string
+	timeout time.Duration
+}
+
+func (s *smtpSink) send(n *Notification) error {
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", n.Subject()))
+	fmt.Fprintf(&msg, "Date: %s\r\n", n.Time.Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&msg, "%s\r\n", n.Body())
+
+	return s.sendMail(msg.Bytes())
+}
+
+// sendMail is smtp.SendMail with the whole exchange limited to the timeout,
+// so a relay that stops answering cannot hold up delivery or Close
+func (s *smtpSink) sendMail(msg []byte) error {
+
+	conn, err := net.DialTimeout("tcp", s.server, s.timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(s.timeout)); err != nil {
+		return err
+	}
+
+	host, _, err := net.SplitHostPort(s.server)
+	if err != nil {
+		return err
+	}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(s.from); err != nil {
+		return err
+	}
+	for _, addr := range s.to {
+		if err := client.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	wc, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := wc.Write(msg); err != nil {
+		return err
+	}
+	if err := wc.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// webhookSink POSTs the notification as JSON
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *webhookSink) send(n *Notification) error {
+
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", s.url, resp.Status)
+	}
+	return nil
+}
+
+// execSink runs a local command with the JSON notification on stdin and the
+// main fields in TSM_* environment variables
+type execSink struct {
+	command []string
+	timeout time.Duration
+}
+
+func (s *execSink) send(n *Notification) error {
+
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, s.command[0], s.command[1:]...)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Env = append(os.Environ(),
+		"TSM_RULE="+n.Rule,
+		"TSM_STATE="+n.State,
+		"TSM_SEVERITY="+n.Severity,
+		"TSM_HOST="+n.Host,
+		"TSM_STATION="+n.Sta,
+		"TSM_CHANNEL="+n.Channel,
+		"TSM_VALUE="+n.Value,
+		"TSM_MESSAGE="+n.Message,
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %s: %s", s.command[0], err.Error(), strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package notify
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveSMTP answers one SMTP session on ln, returning the message data
+func serveSMTP(ln net.Listener, data chan<- string) {
+
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	rdr := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	reply("220 test ESMTP")
+	var msg strings.Builder
+	for {
+		line, err := rdr.ReadString('\n')
+		if err != nil {
+			return
+		}
+		switch cmd := strings.ToUpper(strings.Fields(line + " x")[0]); cmd {
+		case "EHLO", "HELO", "MAIL", "RCPT", "RSET", "NOOP":
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			for {
+				line, err := rdr.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				msg.WriteString(line)
+			}
+			data <- msg.String()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 unknown")
+		}
+	}
+}
+
+func TestSMTPSink(t *testing.T) {
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	data := make(chan string, 1)
+	go serveSMTP(ln, data)
+
+	s := &smtpSink{server: ln.Addr().String(), from: "tsm@example.com", to: []string{"ops@example.com"}, timeout: time.Second}
+	n := &Notification{Rule: "low battery", State: "firing", Time: time.Now()}
+	if err := s.send(n); err != nil {
+		t.Fatal(err)
+	}
+	if msg := <-data; !strings.Contains(msg, "To: ops@example.com") {
+		t.Errorf("message sent =\n%s", msg)
+	}
+}
+
+func TestSMTPSinkSubject(t *testing.T) {
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	data := make(chan string, 1)
+	go serveSMTP(ln, data)
+
+	// a message from the device must not be able to add headers
+	s := &smtpSink{server: ln.Addr().String(), from: "tsm@example.com", to: []string{"ops@example.com"}, timeout: time.Second}
+	n := &Notification{Rule: "trap\r\nBcc: evil@example.com", State: "firing", Message: "hot\nX-Evil: 1 °C", Time: time.Now()}
+	if err := s.send(n); err != nil {
+		t.Fatal(err)
+	}
+	msg := <-data
+	headers := msg[:strings.Index(msg, "\r\n\r\n")]
+	if strings.Contains(headers, "\nBcc:") || strings.Contains(headers, "\nX-Evil:") {
+		t.Errorf("headers injected by the subject:\n%s", headers)
+	}
+	if !strings.Contains(headers, "Subject: =?utf-8?q?") {
+		t.Errorf("non ASCII subject not encoded:\n%s", headers)
+	}
+}
+
+func TestSMTPSinkTimeout(t *testing.T) {
+
+	// a relay that accepts the connection and never answers
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(5 * time.Second)
+		}
+	}()
+
+	s := &smtpSink{server: ln.Addr().String(), from: "tsm@example.com", to: []string{"ops@example.com"}, timeout: 100 * time.Millisecond}
+	start := time.Now()
+	if err := s.send(&Notification{Time: start}); err == nil {
+		t.Error("send to a silent relay succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("send took %s with a 100ms timeout", elapsed)
+	}
+}
@@ -0,0 +1,271 @@
+// Package output delivers poll records to the configured downstream sinks,
+// optionally spooling them to disk while a sink is unavailable
+package output
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"tsm/config"
+	rlog "tsm/log"
+	"tsm/spool"
+)
+
+const (
+	minRetryDelay = 1 * time.Second
+	maxRetryDelay = 60 * time.Second
+)
+
+// Outputs fans each record out to every configured sink
+type Outputs struct {
+	sinks []namedWriter
+}
+
+// namedWriter is a sink, buffered or not, with its config name
+type namedWriter interface {
+	write([]byte)
+	close()
+}
+
+// NewOutputs constructor. Buffered sinks reopen their spool and resume
+// delivering any records left from a previous run. A spool already open in
+// prev, the outputs being replaced, is shared instead, and prev keeps
+// delivering from it until Resume hands it over.
+func NewOutputs(ocfg *config.OutputConfig, prev *Outputs) (*Outputs, error) {
+
+	outs := &Outputs{}
+	for ndx := range ocfg.Sinks {
+		scfg := &ocfg.Sinks[ndx]
+		sink, err := newSink(scfg)
+		if err != nil {
+			outs.Close()
+			return nil, err
+		}
+
+		if !scfg.Buffered {
+			outs.sinks = append(outs.sinks, &directSink{name: scfg.Name, sink: sink})
+			continue
+		}
+
+		dir := filepath.Join(ocfg.SpoolDir, scfg.Name)
+		if shared := prev.spooling(dir); shared != nil {
+			outs.sinks = append(outs.sinks, &bufferedSink{name: scfg.Name, dir: dir, sink: sink, queue: shared.queue})
+			continue
+		}
+		queue, err := spool.Open(dir, int64(ocfg.SpoolMaxSize*1024*1024))
+		if err != nil {
+			outs.Close()
+			return nil, err
+		}
+		bs := &bufferedSink{name: scfg.Name, dir: dir, sink: sink, queue: queue, owner: true}
+		bs.start()
+		outs.sinks = append(outs.sinks, bs)
+	}
+
+	return outs, nil
+}
+
+// spooling returns the buffered sink spooling to dir, if any
+func (outs *Outputs) spooling(dir string) *bufferedSink {
+	if outs == nil {
+		return nil
+	}
+	for _, sink := range outs.sinks {
+		if bs, ok := sink.(*bufferedSink); ok && bs.dir == dir {
+			return bs
+		}
+	}
+	return nil
+}
+
+// Resume takes over the spools shared with prev, the outputs this replaces.
+// Each shared spool is delivered by prev until then, and by outs after.
+func (outs *Outputs) Resume(prev interface{}) {
+
+	old, ok := prev.(*Outputs)
+	if !ok {
+		return
+	}
+	for _, sink := range outs.sinks {
+		bs, ok := sink.(*bufferedSink)
+		if !ok || bs.owner {
+			continue
+		}
+		if shared := old.spooling(bs.dir); shared != nil && shared.queue == bs.queue {
+			shared.stop()
+			shared.owner, bs.owner = false, true
+			bs.start()
+		}
+	}
+}
+
+// WriteRecord sends a record to all sinks. Buffered sinks never block on an
+// unavailable sink; direct sinks write in the caller, so one that is
+// unavailable may block for up to its timeout on every record.
+func (outs *Outputs) WriteRecord(rec string) error {
+	for _, sink := range outs.sinks {
+		sink.write([]byte(rec))
+	}
+	return nil
+}
+
+// Close all sinks. Buffered records not yet delivered stay in their spool.
+func (outs *Outputs) Close() error {
+	for _, sink := range outs.sinks {
+		sink.close()
+	}
+	outs.sinks = nil
+	return nil
+}
+
+// directSink writes records straight to the sink, dropping them on error
+type directSink struct {
+	name   string
+	sink   Sink
+	failed bool
+}
+
+func (ds *directSink) write(rec []byte) {
+	if err := ds.sink.Write(rec); err != nil {
+		if !ds.failed {
+			rlog.ErrMsg("output %s: %s, records dropped until it recovers", ds.name, err.Error())
+		}
+		ds.failed = true
+		return
+	}
+	if ds.failed {
+		rlog.NoticeMsg("output %s: recovered", ds.name)
+	}
+	ds.failed = false
+}
+
+func (ds *directSink) close() {
+	if err := ds.sink.Close(); err != nil {
+		rlog.ErrMsg("output %s: %s", ds.name, err.Error())
+	}
+}
+
+// bufferedSink queues records on disk and delivers them in order from a
+// separate goroutine, retrying with backoff while the sink is unavailable
+type bufferedSink struct {
+	name     string
+	dir      string
+	sink     Sink
+	queue    *spool.Queue
+	owner    bool // delivers from and closes the queue
+	notify   chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// start delivering from the queue
+func (bs *bufferedSink) start() {
+
+	bs.notify = make(chan struct{}, 1)
+	bs.done = make(chan struct{})
+	bs.wg.Add(1)
+	go bs.deliver()
+}
+
+// stop delivering from the queue
+func (bs *bufferedSink) stop() {
+
+	if bs.done == nil {
+		return
+	}
+	bs.stopOnce.Do(func() { close(bs.done) })
+	bs.wg.Wait()
+}
+
+func (bs *bufferedSink) write(rec []byte) {
+	if err := bs.queue.Push(rec); err != nil {
+		rlog.ErrMsg("output %s: could not spool record: %s", bs.name, err.Error())
+		return
+	}
+	if bs.notify == nil {
+		return
+	}
+	select {
+	case bs.notify <- struct{}{}:
+	default:
+	}
+}
+
+// deliver sends spooled records to the sink until closed
+func (bs *bufferedSink) deliver() {
+
+	defer bs.wg.Done()
+
+	delay := minRetryDelay
+	failed := false
+	replaying := false
+	sent := 0
+
+	for {
+		rec, err := bs.queue.Peek()
+		if errors.Is(err, spool.ErrEmpty) {
+			if replaying {
+				rlog.NoticeMsg("output %s: spool drained, %d record(s) delivered", bs.name, sent)
+			}
+			replaying, sent = false, 0
+			select {
+			case <-bs.notify:
+				continue
+			case <-bs.done:
+				return
+			}
+		} else if err != nil {
+			// unreadable records are skipped, wait before trying the next one
+			select {
+			case <-time.After(minRetryDelay):
+				continue
+			case <-bs.done:
+				return
+			}
+		}
+
+		if err := bs.sink.Write(rec); err != nil {
+			if !failed {
+				rlog.ErrMsg("output %s: %s, spooling records until it recovers", bs.name, err.Error())
+			}
+			// the sink closes itself if it must reconnect
+			failed, replaying = true, true
+			select {
+			case <-time.After(delay):
+			case <-bs.done:
+				return
+			}
+			if delay *= 2; delay > maxRetryDelay {
+				delay = maxRetryDelay
+			}
+			continue
+		}
+
+		if failed {
+			rlog.NoticeMsg("output %s: recovered, replaying spooled records", bs.name)
+			failed = false
+		}
+		delay = minRetryDelay
+		sent++
+		if err := bs.queue.Ack(); err != nil {
+			rlog.ErrMsg("output %s: %s", bs.name, err.Error())
+		}
+	}
+}
+
+func (bs *bufferedSink) close() {
+
+	bs.stop()
+	if err := bs.sink.Close(); err != nil {
+		rlog.ErrMsg("output %s: %s", bs.name, err.Error())
+	}
+	if !bs.owner {
+		return
+	}
+	if err := bs.queue.Close(); err != nil {
+		rlog.ErrMsg("output %s: %s", bs.name, err.Error())
+	}
+}
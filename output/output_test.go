@@ -0,0 +1,63 @@
+package output
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tsm/config"
+)
+
+// waitFile waits for the records delivered to the file at path to be want
+func waitFile(t *testing.T, path, want string) {
+
+	t.Helper()
+	var data []byte
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if data, _ = os.ReadFile(path); string(data) == want {
+			return
+		}
+	}
+	t.Errorf("%s = %q, want %q", filepath.Base(path), data, want)
+}
+
+func TestOutputsResume(t *testing.T) {
+
+	dir := t.TempDir()
+	ocfg := func(path string) *config.OutputConfig {
+		return &config.OutputConfig{
+			SpoolDir:     filepath.Join(dir, "spool"),
+			SpoolMaxSize: 1,
+			Sinks:        []config.SinkConfig{{Name: "archive", Type: "file", Path: path, Timeout: 1, Buffered: true}},
+		}
+	}
+	first, second := filepath.Join(dir, "first"), filepath.Join(dir, "second")
+
+	prev, err := NewOutputs(ocfg(first), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev.WriteRecord("one")
+	waitFile(t, first, "one\n")
+
+	// outputs closed without taking over leave the spool to prev
+	discarded, err := NewOutputs(ocfg(second), prev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	discarded.Close()
+	prev.WriteRecord("two")
+	waitFile(t, first, "one\ntwo\n")
+
+	outs, err := NewOutputs(ocfg(second), prev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outs.Resume(prev)
+	prev.Close()
+	outs.WriteRecord("three")
+	waitFile(t, second, "three\n")
+	outs.Close()
+	waitFile(t, first, "one\ntwo\n")
+}
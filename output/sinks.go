@@ -0,0 +1,236 @@
+package output
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"tsm/config"
+)
+
+// Sink is a downstream consumer of poll records. Write either delivers the
+// whole record or returns an error, after which the sink reconnects on the
+// next Write.
+type Sink interface {
+	Write([]byte) error
+	Close() error
+}
+
+// newSink creates the Sink described by scfg
+func newSink(scfg *config.SinkConfig) (Sink, error) {
+
+	timeout := time.Duration(scfg.Timeout * float64(time.Second))
+
+	switch scfg.Type {
+	case "stdout":
+		return &writerSink{w: os.Stdout}, nil
+	case "file":
+		return &fileSink{path: scfg.Path, timeout: timeout}, nil
+	case "tcp":
+		return &tcpSink{address: scfg.Address, timeout: timeout}, nil
+	case "http":
+		return &httpSink{url: scfg.URL, client: &http.Client{Timeout: timeout}}, nil
+	case "exec":
+		return &execSink{command: scfg.Command}, nil
+	}
+
+	return nil, fmt.Errorf("unknown sink type %q", scfg.Type)
+}
+
+// writerSink writes records to an io.Writer that is never reopened
+type writerSink struct {
+	w io.Writer
+}
+
+func (s *writerSink) Write(rec []byte) error {
+	_, err := s.w.Write(append(rec, '\n'))
+	return err
+}
+
+func (s *writerSink) Close() error {
+	return nil
+}
+
+// fileSink appends records to a file or named pipe. A named pipe is opened
+// without blocking, so one with no reader is unavailable rather than
+// stalling the caller, and writes to it time out. A record torn by a timeout
+// is finished before anything else is written, so the reader never sees a
+// partial line, and retrying that record does not write it twice.
+type fileSink struct {
+	path    string
+	timeout time.Duration
+	file    *os.File
+	last    []byte // the last record written
+	rest    []byte // unwritten tail of the last record after a timeout
+}
+
+func (s *fileSink) Write(rec []byte) error {
+
+	if s.file == nil {
+		file, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY|syscall.O_NONBLOCK, 0644)
+		if errors.Is(err, syscall.ENXIO) {
+			return fmt.Errorf("%s: sink unavailable, named pipe has no reader", s.path)
+		}
+		if err != nil {
+			return err
+		}
+		s.file = file
+	}
+
+	if s.rest != nil {
+		if err := s.write(s.rest); err != nil {
+			return err
+		}
+		if bytes.Equal(rec, s.last) {
+			return nil
+		}
+	}
+
+	line := make([]byte, len(rec)+1)
+	copy(line, rec)
+	line[len(rec)] = '\n'
+	s.last = line[:len(rec)]
+	return s.write(line)
+}
+
+// write buf to the file. After a timeout that wrote part of buf the file is
+// kept open with the remainder in rest; any other error closes it.
+func (s *fileSink) write(buf []byte) error {
+
+	s.rest = nil
+	// only pipes support deadlines, regular file writes do not block
+	if err := s.file.SetWriteDeadline(time.Now().Add(s.timeout)); err != nil && !errors.Is(err, os.ErrNoDeadline) {
+		s.Close()
+		return err
+	}
+	n, err := s.file.Write(buf)
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		if n > 0 {
+			s.rest = buf[n:]
+		}
+		return err
+	}
+	s.Close()
+	return err
+}
+
+func (s *fileSink) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file, s.last, s.rest = nil, nil, nil
+	return err
+}
+
+// tcpSink writes records to a TCP connection
+type tcpSink struct {
+	address string
+	timeout time.Duration
+	conn    net.Conn
+}
+
+func (s *tcpSink) Write(rec []byte) error {
+
+	if s.conn == nil {
+		conn, err := net.DialTimeout("tcp", s.address, s.timeout)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(s.timeout))
+	if _, err := s.conn.Write(append(rec, '\n')); err != nil {
+		s.Close()
+		return err
+	}
+	return nil
+}
+
+func (s *tcpSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// httpSink POSTs each record as a text/plain request body
+type httpSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *httpSink) Write(rec []byte) error {
+
+	resp, err := s.client.Post(s.url, "text/plain", bytes.NewReader(rec))
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", s.url, resp.Status)
+	}
+	return nil
+}
+
+func (s *httpSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+// execSink writes records to the stdin of a command, restarting it if it exits
+type execSink struct {
+	command []string
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+}
+
+func (s *execSink) Write(rec []byte) error {
+
+	if s.cmd == nil {
+		cmd := exec.Command(s.command[0], s.command[1:]...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return err
+		}
+		if err := cmd.Start(); err != nil {
+			return err
+		}
+		s.cmd, s.stdin = cmd, stdin
+	}
+	if _, err := s.stdin.Write(append(rec, '\n')); err != nil {
+		s.Close()
+		return err
+	}
+	return nil
+}
+
+func (s *execSink) Close() error {
+	if s.cmd == nil {
+		return nil
+	}
+	s.stdin.Close()
+	err := s.cmd.Wait()
+	s.cmd, s.stdin = nil, nil
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		// the command exiting is how a broken pipe shows up
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,125 @@
+package output
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestFileSinkPipe(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "records")
+	if err := syscall.Mkfifo(path, 0644); err != nil {
+		t.Skip(err)
+	}
+	sink := &fileSink{path: path, timeout: 100 * time.Millisecond}
+	defer sink.Close()
+
+	// no reader is an error, not a hang
+	done := make(chan error, 1)
+	go func() { done <- sink.Write([]byte("first")) }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("write to a pipe with no reader succeeded")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("write to a pipe with no reader blocked")
+	}
+
+	reader, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if err := sink.Write([]byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(reader).ReadString('\n')
+	if err != nil || line != "second\n" {
+		t.Errorf("read %q, %v, want second", line, err)
+	}
+
+	// a reader that stops reading times the write out
+	big := make([]byte, 1024*1024)
+	go func() { done <- sink.Write(big) }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("write to a full pipe succeeded")
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("write to a full pipe blocked")
+	}
+}
+
+func TestFileSinkFile(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "records")
+	sink := &fileSink{path: path, timeout: time.Second}
+	for _, rec := range []string{"one", "two"} {
+		if err := sink.Write([]byte(rec)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sink.Close()
+	if data, err := os.ReadFile(path); err != nil || string(data) != "one\ntwo\n" {
+		t.Errorf("file = %q, %v", data, err)
+	}
+}
+
+func TestFileSinkPipeRetry(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "records")
+	if err := syscall.Mkfifo(path, 0644); err != nil {
+		t.Skip(err)
+	}
+	reader, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	sink := &fileSink{path: path, timeout: 100 * time.Millisecond}
+	defer sink.Close()
+
+	// a record bigger than the pipe buffer times out part written
+	big := bytes.Repeat([]byte("x"), 1024*1024)
+	if err := sink.Write(big); err == nil {
+		t.Fatal("write to a full pipe succeeded")
+	}
+
+	// the retry finishes the record instead of writing it again
+	lines := make(chan string, 3)
+	go func() {
+		rdr := bufio.NewReader(reader)
+		for {
+			line, err := rdr.ReadString('\n')
+			if err != nil {
+				close(lines)
+				return
+			}
+			lines <- line
+		}
+	}()
+	sink.timeout = 5 * time.Second
+	if err := sink.Write(big); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Write([]byte("next")); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{string(big) + "\n", "next\n"} {
+		select {
+		case line := <-lines:
+			if line != want {
+				t.Fatalf("read %d bytes %.10q, want %d bytes %.10q", len(line), line, len(want), want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("record not read")
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package tui
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tsm/config/configtest"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func TestFormat(t *testing.T) {
+
+	ts := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		model   string
+		results map[string]string
+	}{
+		{
+			name:  "mppt",
+			model: "TS-MPPT",
+			results: map[string]string{
+				"1.3.6.1.4.1.33333.1.1.0":  "EMC0001",
+				"1.3.6.1.4.1.33333.2.1.0":  "TS-MPPT-60",
+				"1.3.6.1.4.1.33333.2.2.0":  "16220001",
+				"1.3.6.1.4.1.33333.2.60.0": "6",
+				"1.3.6.1.4.1.33333.2.46.0": "5",
+				"1.3.6.1.4.1.33333.2.38.0": "2276",
+				"1.3.6.1.4.1.33333.2.43.0": "4096",
+				"1.3.6.1.4.1.33333.2.49.0": "25",
+				"1.3.6.1.4.1.33333.2.57.0": "9",
+				"1.3.6.1.4.1.33333.2.55.0": "0",
+			},
+		},
+		{
+			name:  "pwm",
+			model: "TS-PWM",
+			results: map[string]string{
+				"1.3.6.1.4.1.33333.1.1.0":  "EMC0002",
+				"1.3.6.1.4.1.33333.8.1.0":  "TS-45",
+				"1.3.6.1.4.1.33333.8.47.0": "9",
+				"1.3.6.1.4.1.33333.8.30.0": "131",
+				"1.3.6.1.4.1.33333.8.42.0": "0",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			cfg := configtest.NewConfig()
+			cfg.SetModel(tt.model)
+
+			got := NewTui("192.168.1.10", "161").Format(ts, "192.168.1.10", "161", &tt.results, cfg)
+
+			golden := filepath.Join("testdata", tt.name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("Format output differs from %s\ngot:\n%s\nwant:\n%s", golden, got, want)
+			}
+		})
+	}
+}
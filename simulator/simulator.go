@@ -0,0 +1,467 @@
+// Package simulator runs a simulated charge controller behind an SNMP agent,
+// serving the OIDs in the config for one model group with values from a
+// simple solar charging model
+package simulator
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"tsm/agent"
+	"tsm/config"
+	rlog "tsm/log"
+
+	g "github.com/gosnmp/gosnmp"
+)
+
+// updateInterval is how often the simulated values change
+const updateInterval = time.Second
+
+// Simulator is a simulated device
+type Simulator struct {
+	cfg    *config.TSMConfig
+	scfg   *config.SimulateConfig
+	model  string
+	group  config.DeviceInfo
+	agent  *agent.Agent
+	rng    *rand.Rand
+	speed  float64 // simulated seconds per real second
+	events []*event
+
+	started  time.Time
+	simStart time.Time
+
+	// battery model state
+	soc        float64
+	minBattery float64
+	maxBattery float64
+	alarms     map[string]time.Time // "oid bit" of random alarms and when they clear
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// event is a scripted SimEvent resolved against the model group
+type event struct {
+	cfg    config.SimEvent
+	oid    string
+	info   config.OidInfo
+	bit    int
+	active bool
+}
+
+// NewSimulator constructor. model may be a model name, such as TS-MPPT-60, or
+// a model group, in which case the first model in the group is simulated.
+func NewSimulator(cfg *config.TSMConfig, model, listen, community string) (*Simulator, error) {
+
+	sim := &Simulator{
+		cfg:    cfg,
+		scfg:   &cfg.Simulate,
+		alarms: make(map[string]time.Time),
+		done:   make(chan struct{}),
+	}
+
+	var groups []string
+	for _, devGroup := range cfg.Oids.DeviceGroups {
+		if strings.EqualFold(devGroup.ModelGroup, model) && len(devGroup.Modellist) > 0 {
+			sim.group, sim.model = devGroup, devGroup.Modellist[0]
+			groups = append(groups, devGroup.ModelGroup)
+			continue
+		}
+		for _, name := range devGroup.Modellist {
+			if strings.EqualFold(name, model) {
+				sim.group, sim.model = devGroup, name
+				groups = append(groups, devGroup.ModelGroup)
+				break
+			}
+		}
+	}
+	switch len(groups) {
+	case 0:
+		return nil, fmt.Errorf("model %s not found in config", model)
+	case 1:
+	default:
+		return nil, fmt.Errorf("model %s is in more than one model group: %s", model, strings.Join(groups, ", "))
+	}
+	cfg.SetModel(sim.group.ModelGroup)
+
+	seed := sim.scfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	sim.rng = rand.New(rand.NewSource(seed))
+	sim.speed = 86400 / sim.scfg.DayLength
+	sim.soc = sim.scfg.InitialSoc
+
+	sim.started = time.Now()
+	sim.simStart = sim.started
+	if sim.scfg.StartHour != nil {
+		y, m, d := sim.started.Date()
+		sim.simStart = time.Date(y, m, d, 0, 0, 0, 0, time.Local).
+			Add(time.Duration(*sim.scfg.StartHour * float64(time.Hour)))
+	}
+
+	for ndx, evcfg := range sim.scfg.Events {
+		ev := &event{cfg: evcfg, bit: -1}
+		if evcfg.Channel != "" {
+			info, ok := cfg.FindOid(evcfg.Channel)
+			if !ok {
+				return nil, fmt.Errorf("simulate event %d: channel %s not found for %s", ndx+1, evcfg.Channel, sim.model)
+			}
+			ev.oid, ev.info = info.Oid, info
+			if evcfg.Bit != "" {
+				ev.bit = valueIndex(info.Values, evcfg.Bit)
+				if ev.bit < 0 {
+					return nil, fmt.Errorf("simulate event %d: %s has no bit %s", ndx+1, info.Label, evcfg.Bit)
+				}
+			}
+		}
+		sim.events = append(sim.events, ev)
+	}
+
+	sim.agent = agent.NewAgent(listen, community)
+	sim.agent.SetSystem(fmt.Sprintf("simulated %s", sim.model), "1.3.6.1.4.1.33333", sim.model)
+	sim.agent.SetImpairment(sim.scfg.DropRate, seconds(sim.scfg.Delay))
+
+	return sim, nil
+}
+
+// Model returns the name of the simulated model
+func (sim *Simulator) Model() string {
+	return sim.model
+}
+
+// Addr returns the address the simulator is listening on, once started
+func (sim *Simulator) Addr() string {
+	if addr := sim.agent.Addr(); addr != nil {
+		return addr.String()
+	}
+	return ""
+}
+
+// Start serves the simulated device until Close
+func (sim *Simulator) Start() error {
+
+	sim.update(time.Now())
+	if err := sim.agent.Start(); err != nil {
+		return err
+	}
+	rlog.NoticeMsg("simulating %s (%s), one day every %s",
+		sim.model, sim.group.ModelGroup, seconds(sim.scfg.DayLength))
+
+	sim.wg.Add(1)
+	go func() {
+		defer sim.wg.Done()
+		ticker := time.NewTicker(updateInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				sim.update(now)
+			case <-sim.done:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Close stops the simulator
+func (sim *Simulator) Close() error {
+	close(sim.done)
+	sim.wg.Wait()
+	return sim.agent.Close()
+}
+
+// simTime returns the simulated time at now
+func (sim *Simulator) simTime(now time.Time) time.Time {
+	return sim.simStart.Add(time.Duration(float64(now.Sub(sim.started)) * sim.speed))
+}
+
+// state is the simulated device at one instant
+type state struct {
+	sun           float64 // 0 at night to 1 at noon
+	battery       float64 // volts
+	charge        float64 // amps into the battery from the array
+	load          float64 // amps to the load
+	arrayVoltage  float64
+	targetVoltage float64
+	ambient       float64 // deg C
+	heatsink      float64 // deg C
+	runtime       float64 // hours
+}
+
+// update advances the model to now and replaces the agent's variables
+func (sim *Simulator) update(now time.Time) {
+
+	st := sim.step(now)
+
+	// scripted impairments override the configured ones while active
+	dropRate, delay := sim.scfg.DropRate, sim.scfg.Delay
+	elapsed := now.Sub(sim.started).Seconds()
+	for _, ev := range sim.events {
+		active := elapsed >= ev.cfg.At && elapsed < ev.cfg.At+ev.cfg.Duration
+		if active != ev.active {
+			ev.active = active
+			rlog.NoticeMsg("simulate: event at %.0fs %s", ev.cfg.At, map[bool]string{true: "started", false: "ended"}[active])
+		}
+		if active && ev.cfg.DropRate != nil {
+			dropRate = *ev.cfg.DropRate
+		}
+		if active && ev.cfg.Delay != nil {
+			delay = *ev.cfg.Delay
+		}
+	}
+	sim.agent.SetImpairment(dropRate, seconds(delay))
+
+	vars := []agent.Variable{
+		{Oid: sim.group.GroupOid, Type: g.OctetString, Value: sim.model},
+	}
+	lists := [][]config.OidInfo{sim.cfg.Oids.EMCOids, sim.group.Static, sim.group.Status,
+		sim.group.Measurements, sim.group.Alarms, sim.group.Faults}
+	for _, list := range lists {
+		for _, info := range list {
+			vars = append(vars, sim.variable(now, &info, st))
+		}
+	}
+
+	sim.agent.Update(vars)
+}
+
+// step integrates the battery state of charge up to now
+func (sim *Simulator) step(now time.Time) *state {
+
+	scfg := sim.scfg
+	simNow := sim.simTime(now)
+	hour := float64(simNow.Hour()) + float64(simNow.Minute())/60 + float64(simNow.Second())/3600
+	nominal := scfg.SystemVoltage / 12
+
+	st := &state{}
+	st.sun = math.Max(0, math.Sin(math.Pi*(hour-6)/12))
+	st.load = scfg.LoadCurrent * (1 + 0.2*math.Sin(2*math.Pi*hour/24)) * sim.noise()
+
+	// charging tapers off as the battery fills
+	st.charge = scfg.ArrayCurrent * st.sun * sim.noise()
+	if sim.soc > 0.9 {
+		st.charge *= math.Max(0.05, (1-sim.soc)*10)
+	}
+
+	dt := updateInterval.Hours() * sim.speed
+	sim.soc += (st.charge - st.load) * dt / scfg.Capacity
+	sim.soc = math.Min(1, math.Max(0, sim.soc))
+
+	// resting voltage from state of charge plus the charge/discharge drop
+	st.battery = nominal * (11.8 + 1.0*sim.soc + 0.02*(st.charge-st.load))
+	st.targetVoltage = nominal * 14.4
+	if sim.soc > 0.98 {
+		st.targetVoltage = nominal * 13.7
+	}
+	if st.sun > 0 {
+		st.arrayVoltage = nominal * (17 + 3*st.sun) * sim.noise()
+	}
+	if sim.minBattery == 0 || st.battery < sim.minBattery {
+		sim.minBattery = st.battery
+	}
+	if st.battery > sim.maxBattery {
+		sim.maxBattery = st.battery
+	}
+	st.ambient = 15 + 10*st.sun
+	st.heatsink = st.ambient + 0.8*st.charge
+	st.runtime = simNow.Sub(sim.simStart).Hours()
+
+	sim.randomAlarms(now)
+
+	return st
+}
+
+// randomAlarms sets and clears the randomly injected alarm bits
+func (sim *Simulator) randomAlarms(now time.Time) {
+
+	for key, clears := range sim.alarms {
+		if now.After(clears) {
+			delete(sim.alarms, key)
+		}
+	}
+
+	// alarms per simulated day converted to a chance per update
+	chance := sim.scfg.AlarmRate * updateInterval.Seconds() * sim.speed / 86400
+	if chance <= 0 || sim.rng.Float64() >= chance || len(sim.group.Alarms) == 0 {
+		return
+	}
+	info := sim.group.Alarms[sim.rng.Intn(len(sim.group.Alarms))]
+	if info.Type != "bitmap" || len(info.Values) == 0 {
+		return
+	}
+	bit := sim.rng.Intn(len(info.Values))
+	sim.alarms[fmt.Sprintf("%s %d", info.Oid, bit)] = now.Add(seconds(sim.scfg.AlarmDuration))
+	rlog.NoticeMsg("simulate: random alarm %s %s", info.Label, info.Values[bit])
+}
+
+// variable returns the served value of one OID
+func (sim *Simulator) variable(now time.Time, info *config.OidInfo, st *state) agent.Variable {
+
+	v := agent.Variable{Oid: info.Oid, Type: g.Integer, Value: 0}
+
+	switch info.Type {
+	case "string":
+		v.Type = g.OctetString
+		v.Value = sim.stringValue(info)
+		return v
+	case "number":
+		value, ok := sim.number(info, st)
+		if !ok {
+			value = 0
+		}
+		if ev := sim.override(info.Oid); ev != nil && ev.cfg.Value != nil {
+			value = *ev.cfg.Value
+		}
+		scaling := info.Scaling
+		if scaling == 0 {
+			scaling = 1
+		}
+		v.Value = int(math.Round(value / scaling))
+		return v
+	case "map":
+		v.Value = sim.mapValue(info, st)
+	case "bitmap":
+		v.Value = sim.bits(now, info)
+	}
+
+	if ev := sim.override(info.Oid); ev != nil && ev.cfg.Value != nil {
+		v.Value = int(*ev.cfg.Value)
+	}
+	return v
+}
+
+// override returns the active scripted value event for oid
+func (sim *Simulator) override(oid string) *event {
+	for _, ev := range sim.events {
+		if ev.active && ev.oid == oid && ev.bit < 0 {
+			return ev
+		}
+	}
+	return nil
+}
+
+func (sim *Simulator) stringValue(info *config.OidInfo) string {
+
+	label := strings.ToLower(info.Label)
+	switch {
+	case strings.Contains(label, "serial"):
+		return "SIM00001"
+	case strings.Contains(label, "controller") || strings.Contains(label, "model"):
+		return sim.model
+	case strings.Contains(label, "version"), strings.Contains(label, "build"):
+		return "sim-1.0"
+	}
+	return "simulated"
+}
+
+// number picks the modelled quantity for a channel from its label and units
+func (sim *Simulator) number(info *config.OidInfo, st *state) (float64, bool) {
+
+	label := strings.ToLower(info.Label)
+	units := strings.ToLower(info.Units)
+
+	switch {
+	case strings.Contains(units, "volt"):
+		switch {
+		case strings.Contains(label, "max"):
+			return sim.maxBattery, true
+		case strings.Contains(label, "min"):
+			return sim.minBattery, true
+		case strings.Contains(label, "target"):
+			return st.targetVoltage, true
+		case strings.Contains(label, "array"):
+			return st.arrayVoltage, true
+		}
+		return st.battery * sim.noise(), true
+	case strings.Contains(units, "amp"):
+		if strings.Contains(label, "load") {
+			return st.load, true
+		}
+		return st.charge, true
+	case strings.Contains(units, "watt"):
+		return st.charge * st.battery, true
+	case strings.Contains(units, "deg"):
+		if strings.Contains(label, "heatsink") {
+			return st.heatsink, true
+		}
+		return st.ambient, true
+	case strings.Contains(units, "hour"):
+		return st.runtime, true
+	}
+	return 0, false
+}
+
+// mapValue picks the charge or load state from the model
+func (sim *Simulator) mapValue(info *config.OidInfo, st *state) int {
+
+	var name string
+	if strings.Contains(strings.ToLower(info.Label), "load") {
+		switch {
+		case sim.soc < 0.2:
+			name = "LVD"
+		case sim.soc < 0.3:
+			name = "LVDWarning"
+		default:
+			name = "NORMAL"
+		}
+	} else {
+		switch {
+		case st.sun == 0:
+			name = "night"
+		case sim.soc > 0.98:
+			name = "float"
+		case sim.soc > 0.9:
+			name = "absorption"
+		default:
+			name = "mppt"
+		}
+	}
+	if ndx := valueIndex(info.Values, name); ndx >= 0 {
+		return ndx
+	}
+	return 0
+}
+
+// bits returns the scripted and random bits set in a bitmap
+func (sim *Simulator) bits(now time.Time, info *config.OidInfo) int {
+
+	var bits int
+	for _, ev := range sim.events {
+		if ev.active && ev.oid == info.Oid && ev.bit >= 0 {
+			bits |= 1 << uint(ev.bit)
+		}
+	}
+	for bit := range info.Values {
+		if _, ok := sim.alarms[fmt.Sprintf("%s %d", info.Oid, bit)]; ok {
+			bits |= 1 << uint(bit)
+		}
+	}
+	return bits
+}
+
+// noise returns a random factor around 1
+func (sim *Simulator) noise() float64 {
+	return 1 + sim.scfg.Noise*sim.rng.NormFloat64()
+}
+
+// valueIndex returns the index of name in values, case insensitive, or -1
+func valueIndex(values []string, name string) int {
+	for ndx, value := range values {
+		if strings.EqualFold(value, name) {
+			return ndx
+		}
+	}
+	return -1
+}
+
+func seconds(secs float64) time.Duration {
+	return time.Duration(secs * float64(time.Second))
+}
@@ -0,0 +1,98 @@
+package simulator
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"tsm/config"
+	"tsm/snmp"
+)
+
+func testConfig() *config.TSMConfig {
+
+	cfg := config.NewConfig()
+	cfg.Oids.DeviceGroups = []config.DeviceInfo{
+		{
+			GroupOid:   "1.3.6.1.4.1.33333.2.0",
+			ModelGroup: "TS-MPPT",
+			Modellist:  []string{"TS-MPPT-45", "TS-MPPT-60"},
+			Static: []config.OidInfo{
+				{Oid: "1.3.6.1.4.1.33333.2.1.0", Chancode: "serial", Label: "Serial number", Type: "string"},
+			},
+			Measurements: []config.OidInfo{
+				{Oid: "1.3.6.1.4.1.33333.2.2.0", Chancode: "vbat", Label: "Battery voltage", Units: "Volts", Type: "number", Scaling: 0.01},
+			},
+		},
+		{
+			GroupOid:   "1.3.6.1.4.1.33333.3.0",
+			ModelGroup: "TS",
+			Modellist:  []string{"TS-45", "TS-60"},
+		},
+	}
+	startHour := 12.0
+	cfg.Simulate.StartHour = &startHour
+	cfg.Simulate.Seed = 1
+	return cfg
+}
+
+func TestSimulatorQuery(t *testing.T) {
+
+	cfg := testConfig()
+	if err := cfg.Simulate.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	sim, err := NewSimulator(cfg, "ts-mppt", "127.0.0.1:0", "public")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sim.Close()
+
+	host, port, _ := net.SplitHostPort(sim.Addr())
+	svc := snmp.NewSnmpService()
+	if err := svc.InitAndConnect(host, port, "public"); err != nil {
+		t.Fatal(err)
+	}
+	defer svc.Close()
+
+	oids := []string{"1.3.6.1.4.1.33333.2.0", "1.3.6.1.4.1.33333.2.1.0", "1.3.6.1.4.1.33333.2.2.0"}
+	_, results, err := svc.QueryOids(&oids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if model := results[oids[0]]; model != "TS-MPPT-45" {
+		t.Errorf("group oid = %q, want the first model of the group TS-MPPT-45", model)
+	}
+	if serial := results[oids[1]]; serial != "SIM00001" {
+		t.Errorf("serial number = %q, want SIM00001", serial)
+	}
+	raw, err := strconv.ParseFloat(results[oids[2]], 64)
+	if volts := raw * 0.01; err != nil || volts < 10 || volts > 16 {
+		t.Errorf("battery voltage = %q, want a 12V battery", results[oids[2]])
+	}
+}
+
+func TestSimulatorModel(t *testing.T) {
+
+	cfg := testConfig()
+	sim, err := NewSimulator(cfg, "TS-MPPT-60", "127.0.0.1:0", "public")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sim.Model() != "TS-MPPT-60" || sim.group.ModelGroup != "TS-MPPT" {
+		t.Errorf("simulating %s of %s, want TS-MPPT-60 of TS-MPPT", sim.Model(), sim.group.ModelGroup)
+	}
+
+	if _, err := NewSimulator(cfg, "TS-80", "127.0.0.1:0", "public"); err == nil {
+		t.Error("unknown model simulated")
+	}
+
+	// a model listed in two groups is ambiguous
+	cfg.Oids.DeviceGroups[1].Modellist = append(cfg.Oids.DeviceGroups[1].Modellist, "TS-MPPT-60")
+	if _, err := NewSimulator(cfg, "TS-MPPT-60", "127.0.0.1:0", "public"); err == nil {
+		t.Error("model in two groups simulated")
+	}
+}
@@ -0,0 +1,121 @@
+package snmp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	g "github.com/gosnmp/gosnmp"
+)
+
+// recordEntry is one query and its response in a recorded session
+type recordEntry struct {
+	Time  time.Time   `json:"time"` // when the query was sent
+	TS    time.Time   `json:"ts"`   // when the response was received
+	Oids  []string    `json:"oids"`
+	Vars  []recordVar `json:"vars,omitempty"`
+	Error string      `json:"error,omitempty"`
+
+	results map[string]string // the response converted on replay
+}
+
+// recordVar is one variable of a response with its type and value as
+// received, so replay converts it the same way as a live response
+type recordVar struct {
+	Name  string          `json:"name"`
+	Type  g.Asn1BER       `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+func newRecordVar(variable g.SnmpPDU) (recordVar, error) {
+
+	value, err := json.Marshal(variable.Value)
+	if err != nil {
+		return recordVar{}, fmt.Errorf("%s %s: %w", variable.Name, variable.Type, err)
+	}
+	return recordVar{Name: variable.Name, Type: variable.Type, Value: value}, nil
+}
+
+// pdu returns the variable with its value decoded to the Go type gosnmp
+// gives a value of its type
+func (rv *recordVar) pdu() (g.SnmpPDU, error) {
+
+	var value interface{}
+	switch rv.Type {
+	case g.OctetString:
+		value = new([]byte)
+	case g.Integer:
+		value = new(int)
+	case g.Counter32, g.Gauge32:
+		value = new(uint)
+	case g.TimeTicks, g.Uinteger32:
+		value = new(uint32)
+	case g.Counter64:
+		value = new(uint64)
+	case g.OpaqueFloat:
+		value = new(float32)
+	case g.OpaqueDouble:
+		value = new(float64)
+	case g.ObjectIdentifier, g.IPAddress:
+		value = new(string)
+	default:
+		value = new(interface{})
+	}
+	if err := json.Unmarshal(rv.Value, value); err != nil {
+		return g.SnmpPDU{}, fmt.Errorf("%s %s: %w", rv.Name, rv.Type, err)
+	}
+	return g.SnmpPDU{Name: rv.Name, Type: rv.Type, Value: reflect.ValueOf(value).Elem().Interface()}, nil
+}
+
+// Recorder writes every query and response of a session to a file, one
+// JSON object per line, for replay with NewReplayService
+type Recorder struct {
+	mutex  sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+}
+
+// NewRecorder creates or truncates the recording at path
+func NewRecorder(path string) (*Recorder, error) {
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{file: file, writer: bufio.NewWriter(file)}, nil
+}
+
+// record appends a query to the recording, flushing it so the recording is
+// usable even if tsm is killed
+func (rec *Recorder) record(entry *recordEntry) error {
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+
+	if _, err = rec.writer.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("recording %s: %w", rec.file.Name(), err)
+	}
+	return rec.writer.Flush()
+}
+
+// Close the recording
+func (rec *Recorder) Close() error {
+
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+
+	if err := rec.writer.Flush(); err != nil {
+		rec.file.Close()
+		return err
+	}
+	return rec.file.Close()
+}
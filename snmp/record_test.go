@@ -0,0 +1,98 @@
+package snmp
+
+import (
+	"net"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"tsm/agent"
+
+	g "github.com/gosnmp/gosnmp"
+)
+
+func TestRecordReplay(t *testing.T) {
+
+	ag := agent.NewAgent("127.0.0.1:0", "public")
+	ag.Update([]agent.Variable{
+		{Oid: "1.3.6.1.4.1.33333.2.1.0", Type: g.OctetString, Value: "TS-MPPT-60"},
+		{Oid: "1.3.6.1.4.1.33333.2.2.0", Type: g.Integer, Value: -42},
+		{Oid: "1.3.6.1.4.1.33333.2.3.0", Type: g.Counter32, Value: uint32(4000000000)},
+		{Oid: "1.3.6.1.4.1.33333.2.4.0", Type: g.Gauge32, Value: uint32(7)},
+		{Oid: "1.3.6.1.4.1.33333.2.5.0", Type: g.Counter64, Value: uint64(1<<60 + 1)},
+		{Oid: "1.3.6.1.4.1.33333.2.6.0", Type: g.TimeTicks, Value: uint32(12345)},
+	})
+	if err := ag.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer ag.Close()
+
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	rec, err := NewRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, _ := net.SplitHostPort(ag.Addr().String())
+	live := NewSnmpService()
+	live.SetRecorder(rec)
+	if err := live.InitAndConnect(host, port, "public"); err != nil {
+		t.Fatal(err)
+	}
+	defer live.Close()
+
+	oids := []string{
+		"1.3.6.1.4.1.33333.2.1.0", "1.3.6.1.4.1.33333.2.2.0", "1.3.6.1.4.1.33333.2.3.0",
+		"1.3.6.1.4.1.33333.2.4.0", "1.3.6.1.4.1.33333.2.5.0", "1.3.6.1.4.1.33333.2.6.0",
+		"1.3.6.1.4.1.33333.2.99.0", // not served
+	}
+	_, want, err := live.QueryOids(&oids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if want[oids[4]] != strconv.FormatUint(1<<60+1, 10) {
+		t.Errorf("live counter64 = %s", want[oids[4]])
+	}
+
+	rs, err := NewReplayService(path, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.InitAndConnect(host, port, "public"); err != nil {
+		t.Fatal(err)
+	}
+	_, got, err := rs.QueryOids(&oids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed %v, want %v", got, want)
+	}
+}
+
+func TestRecordVar(t *testing.T) {
+
+	for _, variable := range []g.SnmpPDU{
+		{Name: ".1.3.6.1.2.1.1.1.0", Type: g.OctetString, Value: []byte{'a', 0, 0xff}},
+		{Name: ".1.3.6.1.2.1.1.2.0", Type: g.ObjectIdentifier, Value: ".1.3.6.1.4.1.33333"},
+		{Name: ".1.3.6.1.2.1.1.3.0", Type: g.TimeTicks, Value: uint32(100)},
+		{Name: ".1.3.6.1.4.1.33333.1.0", Type: g.IPAddress, Value: "192.0.2.1"},
+		{Name: ".1.3.6.1.4.1.33333.2.0", Type: g.Counter64, Value: uint64(1<<63 + 1)},
+		{Name: ".1.3.6.1.4.1.33333.3.0", Type: g.NoSuchObject, Value: nil},
+	} {
+		rv, err := newRecordVar(variable)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := rv.pdu()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, variable) {
+			t.Errorf("%s replayed as %#v, recorded %#v", variable.Type, got, variable)
+		}
+	}
+}
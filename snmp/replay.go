@@ -0,0 +1,296 @@
+package snmp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"tsm/clock"
+	rlog "tsm/log"
+
+	g "github.com/gosnmp/gosnmp"
+)
+
+// replayService plays back a session recorded with a Recorder in place of a
+// device. Recorded times are divided by speed and shifted to the present by
+// a whole number of poll intervals, so scans keep the same phase and lag
+// relative to the poll loop's target times as when they were recorded.
+type replayService struct {
+	path    string
+	speed   float64
+	entries []*recordEntry
+	clock   clock.Clock
+
+	mutex       sync.Mutex
+	offset      int64 // nanoseconds added to a scaled recorded time to give the replay time
+	started     bool
+	finished    bool
+	CurrentScan *snmpScan
+	rates       map[string]time.Duration
+}
+
+// NewReplayService loads the recording at path to replay at speed times real time
+func NewReplayService(path string, speed float64) (*replayService, error) {
+
+	if speed <= 0 {
+		return nil, fmt.Errorf("invalid replay speed %g", speed)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	rs := &replayService{path: path, speed: speed, clock: clock.New()}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		entry := &recordEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", path, line, err)
+		}
+		if err := entry.convert(); err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", path, line, err)
+		}
+		rs.entries = append(rs.entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(rs.entries) == 0 {
+		return nil, fmt.Errorf("%s: no recorded queries", path)
+	}
+
+	return rs, nil
+}
+
+// convert decodes the recorded response into the results a live query
+// would have returned
+func (entry *recordEntry) convert() error {
+
+	if entry.Error != "" {
+		return nil
+	}
+	if len(entry.Vars) > len(entry.Oids) {
+		return fmt.Errorf("%d variables in the response to a query for %d oids", len(entry.Vars), len(entry.Oids))
+	}
+	variables := make([]g.SnmpPDU, 0, len(entry.Vars))
+	for ndx := range entry.Vars {
+		variable, err := entry.Vars[ndx].pdu()
+		if err != nil {
+			return err
+		}
+		variables = append(variables, variable)
+	}
+	entry.results = queryResults(entry.Oids, variables)
+	return nil
+}
+
+// SetClock replays in the time of clk
+func (rs *replayService) SetClock(clk clock.Clock) {
+	rs.clock = clk
+}
+
+// scaled returns a recorded time divided by the replay speed in nanoseconds
+func (rs *replayService) scaled(t time.Time) int64 {
+	return int64(float64(t.UnixNano()) / rs.speed)
+}
+
+// replayTime maps a recorded time to the present
+func (rs *replayService) replayTime(t time.Time) time.Time {
+	return time.Unix(0, rs.scaled(t)+rs.offset).UTC()
+}
+
+// position returns the recorded time being replayed now
+func (rs *replayService) position() time.Time {
+	return time.Unix(0, int64(float64(rs.clock.Now().UnixNano()-rs.offset)*rs.speed))
+}
+
+// InitAndConnect starts the replay at the first recorded query
+func (rs *replayService) InitAndConnect(host, port, snmpCommunity string) error {
+
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	if !rs.started {
+		rs.started = true
+		rs.offset = rs.clock.Now().UnixNano() - rs.scaled(rs.entries[0].Time)
+		rlog.NoticeMsg("replaying %d queries from %s at %gx speed in place of %s:%s",
+			len(rs.entries), rs.path, rs.speed, host, port)
+	}
+	return nil
+}
+
+func sameOids(a, b []string) bool {
+
+	if len(a) != len(b) {
+		return false
+	}
+	for ndx := range a {
+		if a[ndx] != b[ndx] {
+			return false
+		}
+	}
+	return true
+}
+
+// subsetOids reports whether a query for oids polled only some of pollOids,
+// as when some are polled less often than the sample interval
+func subsetOids(oids, pollOids []string) bool {
+
+	if len(oids) == 0 {
+		return false
+	}
+	polled := make(map[string]bool, len(pollOids))
+	for _, oid := range pollOids {
+		polled[oid] = true
+	}
+	for _, oid := range oids {
+		if !polled[oid] {
+			return false
+		}
+	}
+	return true
+}
+
+// QueryOids returns the last recorded response to the same query at the
+// current replay position
+func (rs *replayService) QueryOids(oids *[]string) (time.Time, map[string]string, error) {
+
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	pos := rs.position()
+	var found *recordEntry
+	for _, entry := range rs.entries {
+		if !sameOids(entry.Oids, *oids) {
+			continue
+		}
+		if found != nil && entry.Time.After(pos) {
+			break
+		}
+		found = entry
+	}
+
+	if found == nil {
+		return rs.clock.Now(), nil, fmt.Errorf("%s: no recorded response to query for %d oids", rs.path, len(*oids))
+	}
+	if pos.After(rs.entries[len(rs.entries)-1].TS) {
+		return rs.clock.Now(), nil, io.EOF
+	}
+	if found.Error != "" {
+		return rs.clock.Now(), nil, errors.New(found.Error)
+	}
+	return rs.replayTime(found.TS), copyResults(found.results), nil
+}
+
+func copyResults(results map[string]string) map[string]string {
+	newresults := make(map[string]string, len(results))
+	for key, val := range results {
+		newresults[key] = val
+	}
+	return newresults
+}
+
+// SetPollRates sets the OIDs polled less often than the sample interval when
+// the session was recorded, for scans of the queries of some of the OIDs
+func (rs *replayService) SetPollRates(rates map[string]time.Duration) {
+	rs.rates = rates
+}
+
+// PollStart replays the recorded responses to queries of pollOids, or of
+// some of them, from the current position, each at the time it was received
+func (rs *replayService) PollStart(
+	ctx context.Context,
+	wg *sync.WaitGroup,
+	pollOids *[]string,
+	sampleInterval time.Duration) error {
+
+	rs.mutex.Lock()
+	pos := rs.position()
+	var entries []*recordEntry
+	for _, entry := range rs.entries {
+		if !entry.Time.Before(pos) && subsetOids(entry.Oids, *pollOids) {
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) == 0 {
+		rs.mutex.Unlock()
+		return fmt.Errorf("%s: no recorded polls of %d oids", rs.path, len(*pollOids))
+	}
+
+	// shift by whole intervals so the scans keep their recorded phase
+	interval := sampleInterval.Nanoseconds()
+	shift := rs.clock.Now().UnixNano() - rs.scaled(entries[0].Time)
+	rs.offset = (shift/interval + 1) * interval
+	rs.finished = false
+	rs.mutex.Unlock()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		// a query of some of the OIDs scans those sampled for its target
+		samples := newSampleSet(rs.rates, sampleInterval)
+		for _, entry := range entries {
+			replayed := rs.replayTime(entry.TS)
+			timer := rs.clock.NewTimer(replayed.Sub(rs.clock.Now()))
+			select {
+			case <-timer.C():
+				if entry.Error != "" {
+					rlog.Keyed("snmp-query").ErrMsg(entry.Error)
+					continue
+				}
+				samples.add(replayed, entry.results)
+				rs.mutex.Lock()
+				rs.CurrentScan = &snmpScan{replayed, samples.scan(replayed)}
+				rs.mutex.Unlock()
+			case <-ctx.Done():
+				timer.Stop()
+				rlog.DebugMsg("debug: context.Done message received, shutting down replay")
+				return
+			}
+		}
+
+		rs.mutex.Lock()
+		rs.finished = true
+		rs.mutex.Unlock()
+		rlog.NoticeMsg("end of recording %s", rs.path)
+	}()
+
+	return nil
+}
+
+// GetScan returns the most recent replayed scan, or io.EOF once the
+// recording has been replayed
+func (rs *replayService) GetScan() (time.Time, *map[string]string, error) {
+
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	if rs.CurrentScan == nil {
+		if rs.finished {
+			return rs.clock.Now().UTC(), nil, io.EOF
+		}
+		return rs.clock.Now().UTC(), nil, errors.New("scan unavailable")
+	}
+	scan := rs.CurrentScan
+	rs.CurrentScan = nil
+
+	return scan.TS, &(scan.Data), nil
+}
+
+// Close does nothing, the replay continues across sessions
+func (rs *replayService) Close() {
+}
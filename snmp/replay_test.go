@@ -0,0 +1,148 @@
+package snmp
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"tsm/clock"
+
+	g "github.com/gosnmp/gosnmp"
+)
+
+// writeRecording records a model query and three polls, the second of
+// which timed out, each answered 300ms after it was sent
+func writeRecording(t *testing.T, modelOids, pollOids []string) (string, []*recordEntry) {
+
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	entry := func(secs float64, oids []string, value interface{}, errstr string) *recordEntry {
+		sent := base.Add(time.Duration(secs * float64(time.Second)))
+		e := &recordEntry{Time: sent, TS: sent.Add(300 * time.Millisecond), Oids: oids, Error: errstr}
+		switch value := value.(type) {
+		case string:
+			rv, _ := newRecordVar(g.SnmpPDU{Name: "." + oids[0], Type: g.OctetString, Value: []byte(value)})
+			e.Vars = append(e.Vars, rv)
+		case int:
+			rv, _ := newRecordVar(g.SnmpPDU{Name: "." + oids[0], Type: g.Integer, Value: value})
+			e.Vars = append(e.Vars, rv)
+		}
+		return e
+	}
+	entries := []*recordEntry{
+		entry(0, modelOids, "TS-MPPT-60", ""),
+		entry(2, pollOids, 100, ""),
+		entry(4, pollOids, nil, "request timeout (after 0 retries)"),
+		entry(6, pollOids, 102, ""),
+	}
+
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	rec, err := NewRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if err := rec.record(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path, entries
+}
+
+func TestReplay(t *testing.T) {
+
+	modelOids := []string{"1.3.6.1.4.1.33333.2.1.0"}
+	pollOids := []string{"1.3.6.1.4.1.33333.2.38.0"}
+	path, entries := writeRecording(t, modelOids, pollOids)
+
+	rs, err := NewReplayService(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 3, 1, 12, 0, 0, 700000000, time.UTC)
+	clk := clock.NewFake(start)
+	rs.SetClock(clk)
+
+	if err := rs.InitAndConnect("127.0.0.1", "161", "public"); err != nil {
+		t.Fatal(err)
+	}
+	_, results, err := rs.QueryOids(&modelOids)
+	if err != nil || results[modelOids[0]] != "TS-MPPT-60" {
+		t.Fatalf("model query = %v, %v", results, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	if err := rs.PollStart(ctx, &wg, &pollOids, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, scan, err := rs.GetScan(); scan != nil || err == io.EOF {
+		t.Fatalf("scan before the first poll was replayed: %v, %v", scan, err)
+	}
+
+	// first poll
+	first := rs.replayTime(entries[1].TS)
+	clk.WaitForTimers(1)
+	clk.Set(first)
+	clk.WaitForTimers(1)
+	ts, scan, err := rs.GetScan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (*scan)[pollOids[0]] != "100" || !ts.Equal(first) {
+		t.Errorf("first scan %v at %s, want 100 at %s", *scan, ts, first)
+	}
+	if first.Before(start) {
+		t.Errorf("first scan at %s is before the replay started at %s", first, start)
+	}
+	// the recorded 300ms lag on a 2s interval is 150ms on a 1s interval at 2x
+	if phase := first.Sub(first.Truncate(time.Second)); phase != 150*time.Millisecond {
+		t.Errorf("first scan %s after the second, want 150ms", phase)
+	}
+
+	// timed out poll leaves no scan
+	clk.Set(rs.replayTime(entries[2].TS))
+	clk.WaitForTimers(1)
+	if _, scan, err := rs.GetScan(); scan != nil || err == nil || err == io.EOF {
+		t.Errorf("timed out poll returned %v, %v", scan, err)
+	}
+
+	// last poll, then the end of the recording
+	clk.Set(rs.replayTime(entries[3].TS))
+	wg.Wait()
+	ts, scan, err = rs.GetScan()
+	if err != nil || (*scan)[pollOids[0]] != "102" {
+		t.Fatalf("last scan %v, %v", scan, err)
+	}
+	if got := ts.Sub(first); got != 2*time.Second {
+		t.Errorf("last scan %s after the first, want 2s", got)
+	}
+	if _, _, err := rs.GetScan(); err != io.EOF {
+		t.Errorf("GetScan after the recording = %v, want io.EOF", err)
+	}
+}
+
+func TestSubsetOids(t *testing.T) {
+
+	pollOids := []string{"1", "2", "3"}
+	tests := []struct {
+		oids []string
+		want bool
+	}{
+		{[]string{"1", "2", "3"}, true},
+		{[]string{"2"}, true},
+		{[]string{"2", "4"}, false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := subsetOids(tt.oids, pollOids); got != tt.want {
+			t.Errorf("subsetOids(%v) = %v, want %v", tt.oids, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package snmp
+
+import "time"
+
+// sampleSet holds the last value of each polled OID and the target time it
+// was sampled for, so a scan holds only the values sampled for its target
+// time when some OIDs are polled less often than the sample interval
+type sampleSet struct {
+	interval time.Duration
+	rates    map[string]time.Duration
+	values   map[string]string
+	targets  map[string]time.Time
+}
+
+func newSampleSet(rates map[string]time.Duration, interval time.Duration) *sampleSet {
+	return &sampleSet{
+		interval: interval,
+		rates:    rates,
+		values:   make(map[string]string),
+		targets:  make(map[string]time.Time),
+	}
+}
+
+// target returns the target time of a query at ts, the nearest multiple of
+// the sample interval
+func (s *sampleSet) target(ts time.Time) time.Time {
+	return ts.Add(s.interval / 2).Truncate(s.interval)
+}
+
+// due returns the OIDs of pollOids to query at now. An OID polled every rate
+// is due in the first query for a target time in each interval of its rate,
+// so the scan for the target time starting the interval has a fresh value,
+// and an OID with a rate of 0 only in the first query.
+func (s *sampleSet) due(pollOids []string, now time.Time) []string {
+
+	target := s.target(now)
+	due := make([]string, 0, len(pollOids))
+	for _, oid := range pollOids {
+		rate, ok := s.rates[oid]
+		if !ok {
+			due = append(due, oid)
+			continue
+		}
+		last, sampled := s.targets[oid]
+		if !sampled || (rate > 0 && !target.Truncate(rate).Equal(last.Truncate(rate))) {
+			due = append(due, oid)
+		}
+	}
+	return due
+}
+
+// add saves results as sampled for the target time of ts
+func (s *sampleSet) add(ts time.Time, results map[string]string) {
+
+	target := s.target(ts)
+	for oid, val := range results {
+		s.values[oid] = val
+		s.targets[oid] = target
+	}
+}
+
+// scan returns the values sampled for the target time of ts, and those of
+// the OIDs read once, which do not change
+func (s *sampleSet) scan(ts time.Time) map[string]string {
+
+	target := s.target(ts)
+	scan := make(map[string]string, len(s.values))
+	for oid, val := range s.values {
+		if rate, ok := s.rates[oid]; (ok && rate == 0) || s.targets[oid].Equal(target) {
+			scan[oid] = val
+		}
+	}
+	return scan
+}
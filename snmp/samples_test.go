@@ -0,0 +1,57 @@
+package snmp
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSampleSet(t *testing.T) {
+
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(secs float64) time.Time {
+		return base.Add(time.Duration(secs * float64(time.Second)))
+	}
+
+	samples := newSampleSet(map[string]time.Duration{"static": 0, "slow": time.Minute}, 10*time.Second)
+	pollOids := []string{"static", "fast", "slow"}
+
+	// the internal loop runs every third of the 10s sample interval
+	tests := []struct {
+		secs float64
+		due  []string
+		scan []string
+	}{
+		{51, []string{"static", "fast", "slow"}, []string{"static", "fast", "slow"}},
+		{54.3, []string{"fast"}, []string{"static", "fast", "slow"}},
+		// within half an interval of the minute, for the scan at 60
+		{57.6, []string{"fast", "slow"}, []string{"static", "fast", "slow"}},
+		{60.9, []string{"fast"}, []string{"static", "fast", "slow"}},
+		{64.2, []string{"fast"}, []string{"static", "fast", "slow"}},
+		// the slow OID was sampled for the target at 60, not this one
+		{67.5, []string{"fast"}, []string{"static", "fast"}},
+		{114, []string{"fast"}, []string{"static", "fast"}},
+		{117.3, []string{"fast", "slow"}, []string{"static", "fast", "slow"}},
+	}
+	for _, tt := range tests {
+		due := samples.due(pollOids, at(tt.secs))
+		if !reflect.DeepEqual(due, tt.due) {
+			t.Errorf("due at %gs = %v, want %v", tt.secs, due, tt.due)
+		}
+		results := make(map[string]string)
+		for _, oid := range due {
+			results[oid] = "1"
+		}
+		samples.add(at(tt.secs), results)
+
+		scan := samples.scan(at(tt.secs))
+		for _, oid := range tt.scan {
+			if _, ok := scan[oid]; !ok {
+				t.Errorf("scan at %gs missing %s", tt.secs, oid)
+			}
+		}
+		if len(scan) != len(tt.scan) {
+			t.Errorf("scan at %gs = %v, want %v", tt.secs, scan, tt.scan)
+		}
+	}
+}
@@ -5,9 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"tsm/clock"
 	rlog "tsm/log"
 
 	g "github.com/gosnmp/gosnmp"
@@ -39,6 +41,9 @@ func (scan *snmpScan) copy() *snmpScan {
 	return &newscan
 }
 
+// DefaultTimeout is how long to wait for a response from the device
+const DefaultTimeout = 2 * time.Second
+
 // snmpService struct object
 type snmpService struct {
 	host             string
@@ -50,6 +55,15 @@ type snmpService struct {
 	mutex            sync.Mutex
 	// SampleInterval   time.Duration
 	CurrentScan *snmpScan
+	recorder    *Recorder
+	clock       clock.Clock
+	timeout     time.Duration
+	version     g.SnmpVersion
+
+	// rates are the OIDs polled less often than the sample interval, see
+	// SetPollRates, and samples what the internal polling loop read of them
+	rates   map[string]time.Duration
+	samples *sampleSet
 }
 
 // NewSnmpService constructor
@@ -58,10 +72,51 @@ func NewSnmpService() *snmpService {
 
 	tsdev := snmpService{}
 	tsdev.ready = false
+	tsdev.clock = clock.New()
+	tsdev.timeout = DefaultTimeout
+	tsdev.version = g.Version2c
 	return &tsdev
 
 }
 
+// SetClock times queries and the internal polling loop with clk
+func (tsdev *snmpService) SetClock(clk clock.Clock) {
+	tsdev.clock = clk
+}
+
+// SetTimeout sets how long to wait for each response, the default is
+// DefaultTimeout
+func (tsdev *snmpService) SetTimeout(timeout time.Duration) {
+	tsdev.timeout = timeout
+}
+
+// SetVersion sets the SNMP version of the next connection, "1" or "2c", the
+// default
+func (tsdev *snmpService) SetVersion(version string) error {
+	switch version {
+	case "1":
+		tsdev.version = g.Version1
+	case "", "2c":
+		tsdev.version = g.Version2c
+	default:
+		return fmt.Errorf("SNMP version %q not supported", version)
+	}
+	return nil
+}
+
+// SetPollRates polls each OID in rates at its interval, a multiple of the
+// sample interval, or only once after PollStart for an interval of 0. Other
+// OIDs are polled every cycle. A scan holds the OIDs sampled for its target
+// time and those read once.
+func (tsdev *snmpService) SetPollRates(rates map[string]time.Duration) {
+	tsdev.rates = rates
+}
+
+// SetRecorder records every query and response to rec
+func (tsdev *snmpService) SetRecorder(rec *Recorder) {
+	tsdev.recorder = rec
+}
+
 // initialize TPDin2 object
 func (tsdev *snmpService) initialize(host, port string) error {
 
@@ -95,9 +150,9 @@ func (tsdev *snmpService) Connect(community string) error {
 			Port:      uint16(tsdev.port),
 			Transport: "udp4",
 			Community: community,
-			Version:   g.Version2c,
+			Version:   tsdev.version,
 			Retries:   0,
-			Timeout:   time.Duration(2) * time.Second,
+			Timeout:   tsdev.timeout,
 		}
 
 		if err := snmpParams.Connect(); err != nil {
@@ -118,32 +173,97 @@ func (tsdev *snmpService) Connect(community string) error {
 // QueryOids to get values for all device oids
 func (tsdev *snmpService) QueryOids(oids *[]string) (time.Time, map[string]string, error) {
 
+	sent := tsdev.clock.Now().UTC()
 	snmpVals, err := tsdev.SNMPParams.Get(*oids)
 	if err != nil {
-		return time.Now(), nil, err
+		tsdev.record(sent, tsdev.clock.Now().UTC(), oids, nil, err)
+		return tsdev.clock.Now(), nil, err
 	}
 
+	results := queryResults(*oids, snmpVals.Variables)
+
+	ts := tsdev.clock.Now().UTC()
+	tsdev.record(sent, ts, oids, snmpVals.Variables, nil)
+
+	return ts, results, nil
+}
+
+// queryResults returns the values of the variables of a response by the
+// OIDs queried
+func queryResults(oids []string, variables []g.SnmpPDU) map[string]string {
+
 	results := make(map[string]string)
-	for i, variable := range snmpVals.Variables {
-
-		// the Value of each variable returned by Get() implements
-		// interface{}. You could do a type switch...
-		switch variable.Type {
-		case g.OctetString:
-			// fmt.Printf("string: %s\n", string(variable.Value.([]byte)))
-			results[(*oids)[i]] = string(variable.Value.([]byte))
-		default:
-			// ... or often you're just interested in numeric values.
-			// ToBigInt() will return the Value as a BigInt, for plugging
-			// into your calculations.
-			// fmt.Printf("number: %d\n", g.ToBigInt(variable.Value))
-			results[(*oids)[i]] = g.ToBigInt(variable.Value).String()
+	for i, variable := range variables {
+		results[oids[i]] = pduString(variable)
+	}
+	return results
+}
+
+// pduString returns the value of a variable as text. Numbers, and the
+// exceptions returned for missing OIDs, are returned in decimal, so a
+// missing OID reads as "0".
+func pduString(variable g.SnmpPDU) string {
+
+	switch variable.Type {
+	case g.OctetString:
+		return string(variable.Value.([]byte))
+	case g.ObjectIdentifier, g.IPAddress:
+		return strings.TrimPrefix(variable.Value.(string), ".")
+	}
+	return g.ToBigInt(variable.Value).String()
+}
+
+// record saves a query to the recording, if any
+func (tsdev *snmpService) record(sent, ts time.Time, oids *[]string, variables []g.SnmpPDU, qerr error) {
+
+	if tsdev.recorder == nil {
+		return
+	}
+	entry := &recordEntry{Time: sent, TS: ts, Oids: *oids}
+	if qerr != nil {
+		entry.Error = qerr.Error()
+	}
+	for _, variable := range variables {
+		rv, err := newRecordVar(variable)
+		if err != nil {
+			rlog.Keyed("snmp-record").ErrMsg(err.Error())
+			return
 		}
+		entry.Vars = append(entry.Vars, rv)
+	}
+	if err := tsdev.recorder.record(entry); err != nil {
+		rlog.Keyed("snmp-record").ErrMsg(err.Error())
 	}
+}
 
-	ts := time.Now().UTC()
+// Walk passes each OID under root with its type and value to fn, using
+// GETBULK requests
+func (tsdev *snmpService) Walk(root string, fn func(oid, kind, value string) error) error {
 
-	return ts, results, nil
+	return tsdev.SNMPParams.BulkWalk(root, func(variable g.SnmpPDU) error {
+		return fn(strings.TrimPrefix(variable.Name, "."), variable.Type.String(), pduString(variable))
+	})
+}
+
+// Get passes each of oids with its type and value to fn
+func (tsdev *snmpService) Get(oids []string, fn func(oid, kind, value string) error) error {
+
+	for start := 0; start < len(oids); start += g.MaxOids {
+		end := start + g.MaxOids
+		if end > len(oids) {
+			end = len(oids)
+		}
+		snmpVals, err := tsdev.SNMPParams.Get(oids[start:end])
+		if err != nil {
+			return err
+		}
+		for _, variable := range snmpVals.Variables {
+			if err := fn(strings.TrimPrefix(variable.Name, "."), variable.Type.String(), pduString(variable)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 // queryDeviceVars queries device for TPDin2 OID values
@@ -158,6 +278,30 @@ func (tsdev *snmpService) queryDeviceVars(oids *[]string) error {
 	return nil
 }
 
+// queryDueVars queries the device for the OIDs of pollOids that are due,
+// saving a scan of the values sampled for the target time. With nothing due
+// the last scan stands.
+func (tsdev *snmpService) queryDueVars(pollOids *[]string) error {
+
+	if tsdev.samples == nil {
+		return tsdev.queryDeviceVars(pollOids)
+	}
+
+	due := tsdev.samples.due(*pollOids, tsdev.clock.Now())
+	if len(due) == 0 {
+		return nil
+	}
+	ts, results, err := tsdev.QueryOids(&due)
+	if err != nil {
+		return err
+	}
+	tsdev.samples.add(ts, results)
+	scan := tsdev.samples.scan(ts)
+	tsdev.saveScan(ts, &scan)
+
+	return nil
+}
+
 // func (tp *TPDin2Device) saveScan(scan *TPDin2Scan) {
 func (tsdev *snmpService) saveScan(ts time.Time, results *map[string]string) {
 
@@ -170,7 +314,7 @@ func (tsdev *snmpService) saveScan(ts time.Time, results *map[string]string) {
 func (tsdev *snmpService) GetScan() (time.Time, *map[string]string, error) {
 
 	if tsdev.CurrentScan == nil {
-		return time.Now().UTC(), nil, errors.New("scan unavailable")
+		return tsdev.clock.Now().UTC(), nil, errors.New("scan unavailable")
 	}
 
 	tsdev.mutex.Lock()
@@ -189,6 +333,10 @@ func (tsdev *snmpService) PollStart(
 	sampleInterval time.Duration) error {
 
 	tsdev.internalInterval = sampleInterval / 3.0
+	tsdev.samples = nil
+	if len(tsdev.rates) > 0 {
+		tsdev.samples = newSampleSet(tsdev.rates, sampleInterval)
+	}
 
 	wg.Add(1)
 	defer wg.Done()
@@ -199,23 +347,25 @@ func (tsdev *snmpService) PollStart(
 	}
 
 	// kick off internval polling loop
+	wg.Add(1)
 	go func(ctx context.Context, wg *sync.WaitGroup) {
-		wg.Add(1)
 		defer wg.Done()
 
-		trigtime := time.Now()
+		trigtime := tsdev.clock.Now()
 
 		for {
 			trigtime = trigtime.Add(tsdev.internalInterval)
+			timer := tsdev.clock.NewTimer(trigtime.Sub(tsdev.clock.Now()))
 
 			select {
-			case <-time.After(time.Until(trigtime)):
-				err := tsdev.queryDeviceVars(pollOids)
+			case <-timer.C():
+				err := tsdev.queryDueVars(pollOids)
 				if err != nil {
-					rlog.ErrMsg(err.Error())
+					rlog.Keyed("snmp-query").ErrMsg(err.Error())
 					continue
 				}
 			case <-ctx.Done():
+				timer.Stop()
 				rlog.DebugMsg("debug: context.Done message received, shutting down internal polling loop")
 				return
 			}
@@ -245,5 +395,8 @@ func (tsdev *snmpService) InitAndConnect(host, port, snmpCommunity string) error
 }
 
 func (tsdev *snmpService) Close() {
-	tsdev.SNMPParams.Conn.Close()
+	// a failed connect leaves no connection to close
+	if tsdev.SNMPParams != nil && tsdev.SNMPParams.Conn != nil {
+		tsdev.SNMPParams.Conn.Close()
+	}
 }
@@ -0,0 +1,229 @@
+// Package snmptest provides a fake SNMP service for tests
+package snmptest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scan is one scripted result of GetScan
+type Scan struct {
+	TS   time.Time
+	Data map[string]string
+	Err  error
+}
+
+// Service is a fake SNMP service. QueryOids, Walk and Get answer from
+// Values, and GetScan returns Scans in order, then io.EOF.
+type Service struct {
+	mutex sync.Mutex
+
+	// Values answers QueryOids. OIDs without a value are returned as "0",
+	// as a device returns for an OID it does not support.
+	Values map[string]string
+	// QueryErr is returned by QueryOids when set
+	QueryErr error
+	// QueryTime is the time returned by QueryOids
+	QueryTime time.Time
+	// Scans are returned by GetScan in order
+	Scans []Scan
+	// ConnectErrs is returned by InitAndConnect to the host it is keyed by
+	ConnectErrs map[string]error
+
+	// Connects counts the calls to InitAndConnect, Closes the calls to Close
+	Connects int
+	Closes   int
+	// Host, Port and Community are the arguments of the last InitAndConnect,
+	// Version the last SetVersion
+	Host      string
+	Port      string
+	Community string
+	Version   string
+	// Queries holds the OIDs passed to each QueryOids call
+	Queries [][]string
+	// PollOids and PollInterval are the arguments to PollStart
+	PollOids     []string
+	PollInterval time.Duration
+	// PollRates is the argument to SetPollRates
+	PollRates map[string]time.Duration
+}
+
+// NewService returns a fake SNMP service answering QueryOids from values
+func NewService(values map[string]string) *Service {
+	return &Service{Values: values}
+}
+
+// InitAndConnect counts connections
+func (s *Service) InitAndConnect(host, port, community string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.Connects++
+	s.Host, s.Port, s.Community = host, port, community
+	return s.ConnectErrs[host]
+}
+
+// SetVersion records the SNMP version
+func (s *Service) SetVersion(version string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.Version = version
+	return nil
+}
+
+// QueryOids returns the Values of oids
+func (s *Service) QueryOids(oids *[]string) (time.Time, map[string]string, error) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.Queries = append(s.Queries, append([]string(nil), *oids...))
+	if s.QueryErr != nil {
+		return s.QueryTime, nil, s.QueryErr
+	}
+	results := make(map[string]string, len(*oids))
+	for _, oid := range *oids {
+		val, ok := s.Values[oid]
+		if !ok {
+			val = "0"
+		}
+		results[oid] = val
+	}
+	return s.QueryTime, results, nil
+}
+
+// PollStart records its arguments, the scans are scripted
+func (s *Service) PollStart(ctx context.Context, wg *sync.WaitGroup, oids *[]string, interval time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.PollOids = append([]string(nil), *oids...)
+	s.PollInterval = interval
+	return nil
+}
+
+// SetPollRates records the rates, the scans are scripted
+func (s *Service) SetPollRates(rates map[string]time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.PollRates = rates
+}
+
+// GetScan returns the next scripted scan. A Scan without Data returns its
+// Err, or "scan unavailable".
+func (s *Service) GetScan() (time.Time, *map[string]string, error) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.Scans) == 0 {
+		return time.Time{}, nil, io.EOF
+	}
+	scan := s.Scans[0]
+	s.Scans = s.Scans[1:]
+	if scan.Data == nil {
+		if scan.Err == nil {
+			scan.Err = errors.New("scan unavailable")
+		}
+		return scan.TS, nil, scan.Err
+	}
+	data := make(map[string]string, len(scan.Data))
+	for key, val := range scan.Data {
+		data[key] = val
+	}
+	return scan.TS, &data, scan.Err
+}
+
+// Close counts closes
+func (s *Service) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.Closes++
+}
+
+// kind returns the SNMP type a device would return for val
+func kind(val string) string {
+	if _, err := strconv.ParseInt(val, 10, 64); err == nil {
+		return "Integer"
+	}
+	return "OctetString"
+}
+
+// oidLess orders OIDs numerically, as a walk returns them
+func oidLess(a, b string) bool {
+
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for ndx := 0; ndx < len(as) && ndx < len(bs); ndx++ {
+		an, _ := strconv.Atoi(as[ndx])
+		bn, _ := strconv.Atoi(bs[ndx])
+		if an != bn {
+			return an < bn
+		}
+	}
+	return len(as) < len(bs)
+}
+
+// Walk passes the Values under root to fn in OID order
+func (s *Service) Walk(root string, fn func(oid, kind, value string) error) error {
+
+	s.mutex.Lock()
+	if s.QueryErr != nil {
+		s.mutex.Unlock()
+		return s.QueryErr
+	}
+	var oids []string
+	for oid := range s.Values {
+		if oid == root || strings.HasPrefix(oid, root+".") {
+			oids = append(oids, oid)
+		}
+	}
+	values := make(map[string]string, len(oids))
+	for _, oid := range oids {
+		values[oid] = s.Values[oid]
+	}
+	s.mutex.Unlock()
+
+	sort.Slice(oids, func(i, j int) bool { return oidLess(oids[i], oids[j]) })
+	for _, oid := range oids {
+		if err := fn(oid, kind(values[oid]), values[oid]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get passes each of oids to fn with its value, or as NoSuchObject when it
+// has none
+func (s *Service) Get(oids []string, fn func(oid, kind, value string) error) error {
+
+	s.mutex.Lock()
+	if s.QueryErr != nil {
+		s.mutex.Unlock()
+		return s.QueryErr
+	}
+	values := make(map[string]string, len(oids))
+	for _, oid := range oids {
+		if val, ok := s.Values[oid]; ok {
+			values[oid] = val
+		}
+	}
+	s.mutex.Unlock()
+
+	for _, oid := range oids {
+		val, ok := values[oid]
+		if !ok {
+			if err := fn(oid, "NoSuchObject", "0"); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fn(oid, kind(val), val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package snmp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"tsm/config"
+	"tsm/events"
+	rlog "tsm/log"
+
+	g "github.com/gosnmp/gosnmp"
+)
+
+// snmpTrapOid is the varbind holding the notification OID of a v2c/v3 trap
+const snmpTrapOid = "1.3.6.1.6.3.1.1.4.1.0"
+
+// trapQueueSize is the number of received traps that can wait for the handler
+const trapQueueSize = 100
+
+// trapReceiver listens for traps and informs
+type trapReceiver struct {
+	tcfg *config.TrapsConfig
+}
+
+// NewTrapReceiver constructor
+func NewTrapReceiver(tcfg *config.TrapsConfig) *trapReceiver {
+	return &trapReceiver{tcfg: tcfg}
+}
+
+// v3Params builds the USM parameters for the configured v3 user
+func (tr *trapReceiver) v3Params() *g.UsmSecurityParameters {
+
+	usm := &g.UsmSecurityParameters{
+		UserName:                 tr.tcfg.V3User,
+		AuthenticationPassphrase: tr.tcfg.AuthPassphrase,
+		PrivacyPassphrase:        tr.tcfg.PrivPassphrase,
+		AuthenticationProtocol:   g.NoAuth,
+		PrivacyProtocol:          g.NoPriv,
+	}
+	switch strings.ToUpper(tr.tcfg.AuthProtocol) {
+	case "MD5":
+		usm.AuthenticationProtocol = g.MD5
+	case "SHA":
+		usm.AuthenticationProtocol = g.SHA
+	case "SHA224":
+		usm.AuthenticationProtocol = g.SHA224
+	case "SHA256":
+		usm.AuthenticationProtocol = g.SHA256
+	case "SHA384":
+		usm.AuthenticationProtocol = g.SHA384
+	case "SHA512":
+		usm.AuthenticationProtocol = g.SHA512
+	}
+	switch strings.ToUpper(tr.tcfg.PrivProtocol) {
+	case "DES":
+		usm.PrivacyProtocol = g.DES
+	case "AES":
+		usm.PrivacyProtocol = g.AES
+	case "AES192":
+		usm.PrivacyProtocol = g.AES192
+	case "AES256":
+		usm.PrivacyProtocol = g.AES256
+	}
+	return usm
+}
+
+// ListenTraps receives traps and informs, calling handler for each one, until
+// ctx is done. The handler runs in its own goroutine, so a slow one does not
+// delay receiving or acknowledging informs; traps arriving while the queue
+// is full are dropped.
+func (tr *trapReceiver) ListenTraps(ctx context.Context, handler func(events.Trap)) error {
+
+	params := &g.GoSNMP{
+		Version:   g.Version2c,
+		Community: tr.tcfg.Community,
+		Timeout:   2 * time.Second,
+	}
+	if tr.tcfg.V3User != "" {
+		params.Version = g.Version3
+		params.SecurityModel = g.UserSecurityModel
+		params.SecurityParameters = tr.v3Params()
+		params.MsgFlags = g.NoAuthNoPriv
+		if tr.tcfg.AuthProtocol != "" {
+			params.MsgFlags = g.AuthNoPriv
+		}
+		if tr.tcfg.PrivProtocol != "" {
+			params.MsgFlags = g.AuthPriv
+		}
+	}
+
+	queue := make(chan events.Trap, trapQueueSize)
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case trap := <-queue:
+				handler(trap)
+			case <-stop:
+				return
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-stopped
+	}()
+
+	listener := g.NewTrapListener()
+	listener.Params = params
+	listener.OnNewTrap = func(pkt *g.SnmpPacket, addr *net.UDPAddr) {
+		trap, ok := tr.decode(pkt, addr)
+		if !ok {
+			return
+		}
+		select {
+		case queue <- trap:
+		default:
+			rlog.WarningMsg("trap from %s dropped, %d traps waiting to be handled", trap.Source, trapQueueSize)
+		}
+	}
+
+	errchan := make(chan error, 1)
+	go func() {
+		errchan <- listener.Listen(tr.tcfg.Listen)
+	}()
+
+	select {
+	case err := <-errchan:
+		return fmt.Errorf("trap listener on %s: %w", tr.tcfg.Listen, err)
+	case <-listener.Listening():
+		rlog.NoticeMsg("listening for traps on %s", tr.tcfg.Listen)
+	}
+
+	select {
+	case err := <-errchan:
+		return fmt.Errorf("trap listener on %s: %w", tr.tcfg.Listen, err)
+	case <-ctx.Done():
+		listener.Close()
+	}
+
+	return nil
+}
+
+// decode converts a received packet to a Trap, rejecting wrong communities
+func (tr *trapReceiver) decode(pkt *g.SnmpPacket, addr *net.UDPAddr) (events.Trap, bool) {
+
+	trap := events.Trap{
+		Time:      time.Now().UTC(),
+		Source:    addr.IP.String(),
+		Version:   pkt.Version.String(),
+		Community: pkt.Community,
+		Inform:    pkt.PDUType == g.InformRequest,
+	}
+
+	if pkt.Version != g.Version3 && tr.tcfg.Community != "" && pkt.Community != tr.tcfg.Community {
+		rlog.Keyed("trap-community").WarningMsg("trap from %s ignored, wrong community %q", trap.Source, pkt.Community)
+		return trap, false
+	}
+	if pkt.Version == g.Version3 && tr.tcfg.V3User == "" {
+		rlog.WarningMsg("v3 trap from %s ignored, no v3user configured", trap.Source)
+		return trap, false
+	}
+
+	if pkt.PDUType == g.Trap {
+		// v1 traps carry the notification in the header
+		trap.TrapOid = fmt.Sprintf("%s.0.%d", strings.TrimPrefix(pkt.Enterprise, "."), pkt.SpecificTrap)
+		if pkt.GenericTrap != 6 {
+			trap.TrapOid = fmt.Sprintf("1.3.6.1.6.3.1.1.5.%d", pkt.GenericTrap+1)
+		}
+	}
+
+	for _, variable := range pkt.Variables {
+		oid := strings.TrimPrefix(variable.Name, ".")
+		var value string
+		switch variable.Type {
+		case g.OctetString:
+			value = string(variable.Value.([]byte))
+		case g.ObjectIdentifier:
+			value = strings.TrimPrefix(variable.Value.(string), ".")
+		case g.IPAddress:
+			value = fmt.Sprint(variable.Value)
+		default:
+			value = g.ToBigInt(variable.Value).String()
+		}
+		if oid == snmpTrapOid {
+			trap.TrapOid = value
+			continue
+		}
+		trap.Varbinds = append(trap.Varbinds, events.Varbind{Oid: oid, Value: value})
+	}
+
+	return trap, true
+}
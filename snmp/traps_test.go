@@ -0,0 +1,90 @@
+package snmp
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"tsm/config"
+	"tsm/events"
+
+	g "github.com/gosnmp/gosnmp"
+)
+
+// freeUDPPort returns a local UDP port that is not in use
+func freeUDPPort(t *testing.T) int {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func TestListenTraps(t *testing.T) {
+
+	port := freeUDPPort(t)
+	tr := NewTrapReceiver(&config.TrapsConfig{Listen: "127.0.0.1:" + strconv.Itoa(port), Community: "public"})
+
+	// a handler that is still busy when the inform arrives
+	release := make(chan struct{})
+	traps := make(chan events.Trap, 2)
+	handler := func(trap events.Trap) {
+		<-release
+		traps <- trap
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errchan := make(chan error, 1)
+	go func() { errchan <- tr.ListenTraps(ctx, handler) }()
+	time.Sleep(100 * time.Millisecond)
+
+	sender := &g.GoSNMP{
+		Target:    "127.0.0.1",
+		Port:      uint16(port),
+		Community: "public",
+		Version:   g.Version2c,
+		Timeout:   time.Second,
+		Retries:   0,
+	}
+	if err := sender.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Conn.Close()
+	trap := g.SnmpTrap{Variables: []g.SnmpPDU{
+		{Name: snmpTrapOid, Type: g.ObjectIdentifier, Value: ".1.3.6.1.4.1.33333.2.0.1"},
+		{Name: ".1.3.6.1.4.1.33333.1.2.0", Type: g.IPAddress, Value: "10.0.0.1"},
+	}}
+	if _, err := sender.SendTrap(trap); err != nil {
+		t.Fatal(err)
+	}
+	trap.IsInform = true
+	if _, err := sender.SendTrap(trap); err != nil {
+		t.Fatalf("inform not acknowledged while the handler is busy: %s", err)
+	}
+
+	close(release)
+	for ndx := 0; ndx < 2; ndx++ {
+		select {
+		case got := <-traps:
+			addr := ""
+			for _, vb := range got.Varbinds {
+				if vb.Oid == "1.3.6.1.4.1.33333.1.2.0" {
+					addr = vb.Value
+				}
+			}
+			if got.TrapOid != "1.3.6.1.4.1.33333.2.0.1" || addr != "10.0.0.1" {
+				t.Errorf("trap %s %v, want 1.3.6.1.4.1.33333.2.0.1 with address 10.0.0.1", got.TrapOid, got.Varbinds)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("trap not handled")
+		}
+	}
+
+	cancel()
+	if err := <-errchan; err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,352 @@
+// Package spool is a disk-backed FIFO queue with a size cap. When the cap
+// is exceeded the oldest records are dropped.
+package spool
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	rlog "tsm/log"
+	"tsm/statefile"
+)
+
+const (
+	segmentSuffix = ".seg"
+	cursorFile    = "cursor.json"
+	// maxSegmentSize is the size a segment is rotated at
+	maxSegmentSize int64 = 1024 * 1024
+	// maxRecordSize guards against reading a corrupt length prefix
+	maxRecordSize uint32 = 16 * 1024 * 1024
+	// cursorSaveInterval is how often Ack persists the read cursor. Records
+	// acked since the last save are delivered again after a crash.
+	cursorSaveInterval = 5 * time.Second
+)
+
+// ErrEmpty is returned by Peek when there are no queued records
+var ErrEmpty = errors.New("spool: queue is empty")
+
+// cursor is the position of the next record to be read
+type cursor struct {
+	Segment uint64
+	Offset  int64
+}
+
+// Queue of records stored in numbered segment files. Each record is a 4
+// byte big-endian length followed by the record bytes.
+type Queue struct {
+	dir     string
+	maxSize int64
+	mutex   sync.Mutex
+
+	segs    []uint64 // segment numbers present, oldest first
+	sizes   map[uint64]int64
+	wfile   *os.File
+	rfile   *os.File
+	rseg    uint64
+	cur     cursor
+	pending int64 // size of the record returned by the last Peek
+	dropped uint64
+	saved   time.Time // when the cursor was last persisted
+	dirty   bool      // cursor moved since it was persisted
+}
+
+// Open the queue in dir, creating it if needed. Records left from a previous
+// run are kept and will be read first.
+func Open(dir string, maxSize int64) (*Queue, error) {
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	q := &Queue{
+		dir:     dir,
+		maxSize: maxSize,
+		sizes:   make(map[uint64]int64),
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), segmentSuffix) {
+			continue
+		}
+		num, err := strconv.ParseUint(strings.TrimSuffix(entry.Name(), segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		q.segs = append(q.segs, num)
+		q.sizes[num] = info.Size()
+	}
+	sort.Slice(q.segs, func(i, j int) bool { return q.segs[i] < q.segs[j] })
+
+	if _, err := statefile.Load(filepath.Join(dir, cursorFile), &q.cur); err != nil {
+		return nil, err
+	}
+	if len(q.segs) == 0 {
+		q.segs = []uint64{q.cur.Segment}
+		q.cur.Offset = 0
+	} else if q.cur.Segment < q.segs[0] || q.cur.Segment > q.segs[len(q.segs)-1] {
+		q.cur = cursor{q.segs[0], 0}
+	}
+
+	if err := q.openWriter(q.segs[len(q.segs)-1]); err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+func (q *Queue) segPath(num uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%010d%s", num, segmentSuffix))
+}
+
+func (q *Queue) openWriter(num uint64) error {
+
+	file, err := os.OpenFile(q.segPath(num), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if q.wfile != nil {
+		q.wfile.Close()
+	}
+	q.wfile = file
+	if _, ok := q.sizes[num]; !ok {
+		q.sizes[num] = 0
+	}
+	return nil
+}
+
+// Push appends a record to the queue, dropping the oldest segments if the
+// queue is over its size cap
+func (q *Queue) Push(rec []byte) error {
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	wseg := q.segs[len(q.segs)-1]
+	if q.sizes[wseg] >= maxSegmentSize {
+		wseg++
+		if err := q.openWriter(wseg); err != nil {
+			return err
+		}
+		q.segs = append(q.segs, wseg)
+	}
+
+	buf := make([]byte, 4+len(rec))
+	binary.BigEndian.PutUint32(buf, uint32(len(rec)))
+	copy(buf[4:], rec)
+	if _, err := q.wfile.Write(buf); err != nil {
+		return err
+	}
+	q.sizes[wseg] += int64(len(buf))
+
+	q.enforceCap()
+
+	return nil
+}
+
+// enforceCap removes the oldest segments, never the one being written, until
+// the queue fits in maxSize
+func (q *Queue) enforceCap() {
+
+	var total int64
+	for _, size := range q.sizes {
+		total += size
+	}
+
+	for q.maxSize > 0 && total > q.maxSize && len(q.segs) > 1 {
+		oldest := q.segs[0]
+		lost := q.countRecords(oldest)
+		total -= q.sizes[oldest]
+		q.removeSegment(oldest)
+		q.dropped += lost
+		rlog.WarningMsg("spool %s: over %d byte cap, dropped %d oldest record(s)", q.dir, q.maxSize, lost)
+	}
+}
+
+// countRecords counts the unread records in segment num
+func (q *Queue) countRecords(num uint64) uint64 {
+
+	file, err := os.Open(q.segPath(num))
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	offset := int64(0)
+	if num == q.cur.Segment {
+		offset = q.cur.Offset
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return 0
+	}
+
+	var count uint64
+	rdr := bufio.NewReader(file)
+	hdr := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(rdr, hdr); err != nil {
+			return count
+		}
+		if _, err := rdr.Discard(int(binary.BigEndian.Uint32(hdr))); err != nil {
+			return count
+		}
+		count++
+	}
+}
+
+// removeSegment deletes segment num, which must not be the write segment,
+// moving the read cursor forward if it was in it
+func (q *Queue) removeSegment(num uint64) {
+
+	if q.rfile != nil && q.rseg == num {
+		q.rfile.Close()
+		q.rfile = nil
+	}
+	if err := os.Remove(q.segPath(num)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		rlog.ErrMsg("spool %s: %s", q.dir, err.Error())
+	}
+	delete(q.sizes, num)
+	for ndx, seg := range q.segs {
+		if seg == num {
+			q.segs = append(q.segs[:ndx], q.segs[ndx+1:]...)
+			break
+		}
+	}
+	if q.cur.Segment <= num {
+		q.cur = cursor{q.segs[0], 0}
+		q.pending = 0
+		q.saveCursor()
+	}
+}
+
+// Peek returns the oldest record without removing it. ErrEmpty is returned
+// when the queue has no records.
+func (q *Queue) Peek() ([]byte, error) {
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for {
+		if q.cur.Offset >= q.sizes[q.cur.Segment] {
+			if q.cur.Segment == q.segs[len(q.segs)-1] {
+				if q.dirty {
+					if err := q.saveCursor(); err != nil {
+						rlog.ErrMsg("spool %s: %s", q.dir, err.Error())
+					}
+				}
+				return nil, ErrEmpty
+			}
+			// finished with this segment
+			q.removeSegment(q.cur.Segment)
+			continue
+		}
+
+		if q.rfile == nil || q.rseg != q.cur.Segment {
+			if q.rfile != nil {
+				q.rfile.Close()
+			}
+			file, err := os.Open(q.segPath(q.cur.Segment))
+			if err != nil {
+				return nil, err
+			}
+			q.rfile, q.rseg = file, q.cur.Segment
+		}
+
+		hdr := make([]byte, 4)
+		if _, err := q.rfile.ReadAt(hdr, q.cur.Offset); err != nil {
+			return nil, q.skipCorrupt(err)
+		}
+		size := binary.BigEndian.Uint32(hdr)
+		if size > maxRecordSize {
+			return nil, q.skipCorrupt(fmt.Errorf("record size %d too large", size))
+		}
+		rec := make([]byte, size)
+		if _, err := q.rfile.ReadAt(rec, q.cur.Offset+4); err != nil {
+			return nil, q.skipCorrupt(err)
+		}
+		q.pending = int64(4 + size)
+
+		return rec, nil
+	}
+}
+
+// skipCorrupt abandons the rest of the current segment after a read error
+func (q *Queue) skipCorrupt(err error) error {
+
+	rlog.ErrMsg("spool %s: segment %d offset %d unreadable, skipping rest of segment: %s",
+		q.dir, q.cur.Segment, q.cur.Offset, err.Error())
+	q.cur.Offset = q.sizes[q.cur.Segment]
+	q.pending = 0
+	q.saveCursor()
+
+	return err
+}
+
+// Ack removes the record returned by the last Peek. The cursor is persisted
+// at most every cursorSaveInterval, when the queue drains and on Close.
+func (q *Queue) Ack() error {
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.pending == 0 {
+		return nil
+	}
+	q.cur.Offset += q.pending
+	q.pending = 0
+	q.dirty = true
+
+	if time.Since(q.saved) < cursorSaveInterval {
+		return nil
+	}
+	return q.saveCursor()
+}
+
+func (q *Queue) saveCursor() error {
+	q.saved, q.dirty = time.Now(), false
+	return statefile.Save(filepath.Join(q.dir, cursorFile), &q.cur)
+}
+
+// Dropped returns the number of records dropped to stay under the size cap
+func (q *Queue) Dropped() uint64 {
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return q.dropped
+}
+
+// Close the queue files. Unread records remain on disk.
+func (q *Queue) Close() error {
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.rfile != nil {
+		q.rfile.Close()
+		q.rfile = nil
+	}
+	if q.wfile != nil {
+		q.wfile.Close()
+		q.wfile = nil
+	}
+
+	return q.saveCursor()
+}
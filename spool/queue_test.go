@@ -0,0 +1,143 @@
+package spool_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"tsm/spool"
+)
+
+const recordSize = 64 * 1024
+
+// push queues count records numbered from first
+func push(t *testing.T, q *spool.Queue, first, count int) {
+
+	t.Helper()
+	for ndx := first; ndx < first+count; ndx++ {
+		rec := bytes.Repeat([]byte{byte(ndx)}, recordSize)
+		if err := q.Push(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// drain reads and acks every queued record, returning how many there were
+func drain(t *testing.T, q *spool.Queue) int {
+
+	t.Helper()
+	count := 0
+	for {
+		_, err := q.Peek()
+		if errors.Is(err, spool.ErrEmpty) {
+			return count
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := q.Ack(); err != nil {
+			t.Fatal(err)
+		}
+		count++
+	}
+}
+
+func TestQueueOrder(t *testing.T) {
+
+	dir := t.TempDir()
+	q, err := spool.Open(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	push(t, q, 0, 40)
+
+	// unread records survive reopening
+	for ndx := 0; ndx < 10; ndx++ {
+		if _, err := q.Peek(); err != nil {
+			t.Fatal(err)
+		}
+		q.Ack()
+	}
+	q.Close()
+	if q, err = spool.Open(dir, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	rec, err := q.Peek()
+	if err != nil || len(rec) != recordSize || rec[0] != 10 {
+		t.Fatalf("first record after reopening = %d bytes of %v, %v, want record 10", len(rec), rec[:1], err)
+	}
+	if got := drain(t, q); got != 30 {
+		t.Errorf("drained %d records, want 30", got)
+	}
+}
+
+func TestQueueCap(t *testing.T) {
+
+	q, err := spool.Open(t.TempDir(), 3*1024*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	// drained segments no longer count against the cap
+	push(t, q, 0, 80)
+	drain(t, q)
+	dropped := q.Dropped()
+	push(t, q, 0, 32)
+	if got := drain(t, q); got != 32 {
+		t.Errorf("drained %d of 32 records pushed into an empty queue", got)
+	}
+	if q.Dropped() != dropped {
+		t.Errorf("%d records dropped from a queue under its cap", q.Dropped()-dropped)
+	}
+
+	// a full queue drops the oldest records
+	push(t, q, 0, 80)
+	rec, err := q.Peek()
+	if err != nil || rec[0] == 0 {
+		t.Errorf("oldest record kept over the cap: %v", err)
+	}
+	if q.Dropped() == dropped {
+		t.Error("no records dropped over the cap")
+	}
+}
+
+func TestQueueCursor(t *testing.T) {
+
+	dir := t.TempDir()
+	q, err := spool.Open(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	push(t, q, 0, 3)
+
+	// the first ack persists the cursor, the next is only held in memory
+	for ndx := 0; ndx < 2; ndx++ {
+		if _, err := q.Peek(); err != nil {
+			t.Fatal(err)
+		}
+		q.Ack()
+	}
+	crashed, err := spool.Open(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := crashed.Peek()
+	crashed.Close()
+	if err != nil || rec[0] != 1 {
+		t.Fatalf("reopened at %v, %v, want record 1 as of the first ack", rec[:1], err)
+	}
+
+	// draining the queue persists the cursor
+	drain(t, q)
+	if q, err = spool.Open(dir, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if got := drain(t, q); got != 0 {
+		t.Errorf("%d records replayed after the queue drained", got)
+	}
+}
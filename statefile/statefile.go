@@ -0,0 +1,54 @@
+// Package statefile saves and restores small JSON state files, such as the
+// running totals kept by ScanProcessors between restarts
+package statefile
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Load reads the JSON state in path into v. It returns false with no error
+// if the file does not exist.
+func Load(path string, v interface{}) (bool, error) {
+
+	buf, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if err := json.Unmarshal(buf, v); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Save writes v to path as JSON, replacing any existing file atomically
+func Save(path string, v interface{}) error {
+
+	buf, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,268 @@
+// Package store keeps a local history of polled scans in append-only daily
+// segment files so SOH data survives telemetry outages
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"tsm/config"
+	rlog "tsm/log"
+)
+
+const (
+	segmentPrefix = "tsm-"
+	segmentSuffix = ".jsonl"
+	segmentLayout = "20060102"
+)
+
+// record is one line of a segment file
+type record struct {
+	TS      time.Time               `json:"ts"`
+	Model   string                  `json:"model,omitempty"`
+	Quality string                  `json:"q"`
+	Oids    map[string]string       `json:"oids,omitempty"`
+	Derived []config.DerivedChannel `json:"derived,omitempty"`
+}
+
+// Store appends scans to the current day's segment and enforces retention
+type Store struct {
+	dir     string
+	maxAge  time.Duration
+	maxSize int64
+
+	day   string
+	file  *os.File
+	buf   *bufio.Writer
+	total int64 // bytes in all segments, as of the last prune
+}
+
+// NewStore constructor. dir is created if it does not exist.
+func NewStore(scfg *config.StoreConfig) (*Store, error) {
+
+	if err := os.MkdirAll(scfg.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	st := &Store{
+		dir:     scfg.Dir,
+		maxAge:  time.Duration(scfg.MaxAge * 24 * float64(time.Hour)),
+		maxSize: int64(scfg.MaxSize * 1024 * 1024),
+	}
+
+	return st, nil
+}
+
+// segmentName returns the file name of the segment holding scans from ts
+func segmentName(ts time.Time) string {
+	return segmentPrefix + ts.UTC().Format(segmentLayout) + segmentSuffix
+}
+
+// Append writes a scan to the store. scan may be nil for a missing scan.
+func (st *Store) Append(ts time.Time, model, quality string, scan *map[string]string, derived []config.DerivedChannel) error {
+
+	if day := ts.UTC().Format(segmentLayout); day != st.day {
+		if err := st.openSegment(ts); err != nil {
+			return err
+		}
+	}
+
+	rec := record{
+		TS:      ts.UTC(),
+		Model:   model,
+		Quality: quality,
+		Derived: derived,
+	}
+	if scan != nil {
+		rec.Oids = *scan
+	}
+
+	line, err := json.Marshal(&rec)
+	if err != nil {
+		return err
+	}
+	if _, err := st.buf.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := st.buf.Flush(); err != nil {
+		return err
+	}
+
+	st.total += int64(len(line) + 1)
+	if st.maxSize > 0 && st.total > st.maxSize {
+		st.prune(ts)
+	}
+
+	return nil
+}
+
+// openSegment closes the current segment, opens the one for ts and applies retention
+func (st *Store) openSegment(ts time.Time) error {
+
+	st.closeSegment()
+
+	fn := filepath.Join(st.dir, segmentName(ts))
+	file, err := os.OpenFile(fn, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	st.file = file
+	st.buf = bufio.NewWriter(file)
+	st.day = ts.UTC().Format(segmentLayout)
+	rlog.InfoMsg("store: writing scans to %s", fn)
+
+	st.prune(ts)
+
+	return nil
+}
+
+func (st *Store) closeSegment() {
+	if st.file == nil {
+		return
+	}
+	if err := st.buf.Flush(); err != nil {
+		rlog.ErrMsg("store: %s", err.Error())
+	}
+	st.file.Close()
+	st.file = nil
+	st.day = ""
+}
+
+// segment is a segment file with its day
+type segment struct {
+	path string
+	day  time.Time
+	size int64
+}
+
+// segments returns the segment files in dir, oldest first
+func segments(dir string) ([]segment, error) {
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	segs := make([]segment, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, segmentPrefix) || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		day, err := time.Parse(segmentLayout, strings.TrimSuffix(strings.TrimPrefix(name, segmentPrefix), segmentSuffix))
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		segs = append(segs, segment{filepath.Join(dir, name), day, info.Size()})
+	}
+	sort.Slice(segs, func(i, j int) bool { return segs[i].day.Before(segs[j].day) })
+
+	return segs, nil
+}
+
+// prune removes the oldest segments that exceed the age or size limits.
+// The segment currently being written is never removed. It runs when a
+// segment is opened and whenever an append takes the store over maxsize.
+func (st *Store) prune(now time.Time) {
+
+	segs, err := segments(st.dir)
+	if err != nil {
+		rlog.ErrMsg("store: %s", err.Error())
+		return
+	}
+
+	var total int64
+	for _, seg := range segs {
+		total += seg.size
+	}
+	defer func() { st.total = total }()
+
+	for _, seg := range segs[:len(segs)-1] {
+		tooOld := st.maxAge > 0 && now.Sub(seg.day.Add(24*time.Hour)) > st.maxAge
+		tooBig := st.maxSize > 0 && total > st.maxSize
+		if !tooOld && !tooBig {
+			break
+		}
+		if err := os.Remove(seg.path); err != nil {
+			rlog.ErrMsg("store: %s", err.Error())
+			continue
+		}
+		total -= seg.size
+		rlog.NoticeMsg("store: removed %s (retention)", seg.path)
+	}
+}
+
+// Close the current segment
+func (st *Store) Close() error {
+	st.closeSegment()
+	return nil
+}
+
+// Query calls fn for each stored scan between from and to inclusive, in time order
+func (st *Store) Query(from, to time.Time,
+	fn func(time.Time, string, string, *map[string]string, []config.DerivedChannel) error) error {
+
+	if st.buf != nil {
+		if err := st.buf.Flush(); err != nil {
+			return err
+		}
+	}
+
+	segs, err := segments(st.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, seg := range segs {
+		if seg.day.Add(24*time.Hour).Before(from) || seg.day.After(to) {
+			continue
+		}
+		if err := querySegment(seg.path, from, to, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func querySegment(path string, from, to time.Time,
+	fn func(time.Time, string, string, *map[string]string, []config.DerivedChannel) error) error {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		var rec record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			// a partial last line is left by a crash mid write
+			rlog.WarningMsg("store: %s line %d: %s", path, lineNo, err.Error())
+			continue
+		}
+		if rec.TS.Before(from) || rec.TS.After(to) {
+			continue
+		}
+		if err := fn(rec.TS, rec.Model, rec.Quality, &rec.Oids, rec.Derived); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("store: %s: %w", path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tsm/config"
+)
+
+func newStore(t *testing.T, maxAge, maxSize float64) *Store {
+	st, err := NewStore(&config.StoreConfig{Enabled: true, Dir: t.TempDir(), MaxAge: maxAge, MaxSize: maxSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+	return st
+}
+
+// segmentDays returns the days of the segments in the store, oldest first
+func segmentDays(t *testing.T, st *Store) []string {
+	segs, err := segments(st.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	days := make([]string, 0, len(segs))
+	for _, seg := range segs {
+		days = append(days, seg.day.Format(segmentLayout))
+	}
+	return days
+}
+
+func TestAppendQuery(t *testing.T) {
+
+	st := newStore(t, 0, 0)
+	ts := time.Date(2024, 5, 1, 23, 58, 0, 0, time.UTC)
+	derived := []config.DerivedChannel{{Chancode: "SOC", Label: "State of charge", Units: "%", Value: 80}}
+
+	for ndx := 0; ndx < 4; ndx++ {
+		scan := &map[string]string{"1.3.6.1.4.1.33333.2.38.0": fmt.Sprint(2300 + ndx)}
+		if ndx == 2 {
+			if err := st.Append(ts, "TS-MPPT", config.QualityMissing, nil, nil); err != nil {
+				t.Fatal(err)
+			}
+		} else if err := st.Append(ts, "TS-MPPT", config.QualityOK, scan, derived); err != nil {
+			t.Fatal(err)
+		}
+		ts = ts.Add(time.Minute)
+	}
+	if days := segmentDays(t, st); len(days) != 2 {
+		t.Fatalf("segments %v, want one per day", days)
+	}
+
+	// the range spans midnight and leaves out the first scan
+	var got []string
+	err := st.Query(time.Date(2024, 5, 1, 23, 59, 0, 0, time.UTC), time.Date(2024, 5, 2, 1, 0, 0, 0, time.UTC),
+		func(ts time.Time, model, quality string, scan *map[string]string, derived []config.DerivedChannel) error {
+			got = append(got, fmt.Sprintf("%s %s %s %s %d", ts.Format("15:04"), model, quality, (*scan)["1.3.6.1.4.1.33333.2.38.0"], len(derived)))
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"23:59 TS-MPPT ok 2301 1", "00:00 TS-MPPT missing  0", "00:01 TS-MPPT ok 2303 1"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("query returned %q, want %q", got, want)
+	}
+}
+
+func TestPruneAge(t *testing.T) {
+
+	st := newStore(t, 2, 0)
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	for day := 0; day < 5; day++ {
+		if err := st.Append(ts.AddDate(0, 0, day), "TS-MPPT", config.QualityMissing, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{"20240503", "20240504", "20240505"}
+	if days := segmentDays(t, st); fmt.Sprint(days) != fmt.Sprint(want) {
+		t.Errorf("segments %v after pruning, want %v", days, want)
+	}
+}
+
+func TestPruneSize(t *testing.T) {
+
+	// about 1 kB
+	st := newStore(t, 0, 0.001)
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	scan := &map[string]string{"1.3.6.1.4.1.33333.2.38.0": "2300", "1.3.6.1.4.1.33333.2.43.0": "1200"}
+
+	for day := 0; day < 2; day++ {
+		if err := st.Append(ts.AddDate(0, 0, day), "TS-MPPT", config.QualityOK, scan, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if days := segmentDays(t, st); len(days) != 2 {
+		t.Fatalf("segments %v, want 2 before the store is full", days)
+	}
+
+	// the limit is enforced within the day, not only when the next segment opens
+	ts = ts.AddDate(0, 0, 1)
+	for ndx := 0; ndx < 20; ndx++ {
+		ts = ts.Add(time.Minute)
+		if err := st.Append(ts, "TS-MPPT", config.QualityOK, scan, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{"20240502"}
+	if days := segmentDays(t, st); fmt.Sprint(days) != fmt.Sprint(want) {
+		t.Errorf("segments %v after filling the store, want %v", days, want)
+	}
+	if _, err := os.Stat(filepath.Join(st.dir, segmentName(ts))); err != nil {
+		t.Errorf("current segment removed: %s", err)
+	}
+}